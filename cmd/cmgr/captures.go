@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ArmyCyberInstitute/cmgr/cmgr"
+)
+
+func showCaptures(mgr *cmgr.Manager, args []string) int {
+	parser := flag.NewFlagSet("captures", flag.ExitOnError)
+	updateUsage(parser, "<instance> [<capture>]")
+	parser.Parse(args)
+
+	if parser.NArg() < 1 || parser.NArg() > 2 {
+		parser.Usage()
+		return USAGE_ERROR
+	}
+	id, err := strconv.Atoi(parser.Arg(0))
+	if err != nil {
+		fmt.Fprintf(parser.Output(), "error: could not interpret '%s' as an instance id: %s\n", parser.Arg(0), err)
+		parser.Usage()
+		return USAGE_ERROR
+	}
+	instance := cmgr.InstanceId(id)
+
+	if parser.NArg() == 2 {
+		capture, err := mgr.OpenInstanceCapture(instance, parser.Arg(1))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: could not open capture: %s\n", err)
+			return RUNTIME_ERROR
+		}
+		defer capture.Close()
+		if _, err := io.Copy(os.Stdout, capture); err != nil {
+			fmt.Fprintf(os.Stderr, "error: could not read capture: %s\n", err)
+			return RUNTIME_ERROR
+		}
+		return NO_ERROR
+	}
+
+	captures, err := mgr.ListInstanceCaptures(instance)
+	if err != nil {
+		fmt.Printf("error: %s\n", err)
+		return RUNTIME_ERROR
+	}
+	table := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "CAPTURE\tSIZE\tMODIFIED")
+	for _, capture := range captures {
+		fmt.Fprintf(
+			table,
+			"%s\t%s\t%s\n",
+			capture.Name,
+			formatBytes(uint64(capture.Size)),
+			time.Unix(capture.Modified, 0).Format(time.RFC3339),
+		)
+	}
+	table.Flush()
+	return NO_ERROR
+}
@@ -1,15 +1,20 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
+	"os"
 	"strconv"
+	"text/tabwriter"
+	"time"
 
 	"github.com/ArmyCyberInstitute/cmgr/cmgr"
 )
 
 func startInstance(mgr *cmgr.Manager, args []string) int {
 	parser := flag.NewFlagSet("start", flag.ExitOnError)
+	ttl := parser.Duration("ttl", 0, "time after which cmgrd stops the instance (default: never)")
 	updateUsage(parser, "<build>")
 	parser.Parse(args)
 
@@ -25,7 +30,11 @@ func startInstance(mgr *cmgr.Manager, args []string) int {
 		return USAGE_ERROR
 	}
 
-	instance, err := mgr.Start(cmgr.BuildId(build))
+	var ttlArgs []time.Duration
+	if *ttl != 0 {
+		ttlArgs = append(ttlArgs, *ttl)
+	}
+	instance, err := mgr.Start(cmgr.BuildId(build), ttlArgs...)
 	if err != nil {
 		fmt.Printf("error: could not start instance: %s\n", err)
 		return RUNTIME_ERROR
@@ -92,3 +101,192 @@ func stopInstance(mgr *cmgr.Manager, args []string) int {
 
 	return NO_ERROR
 }
+
+func extendInstance(mgr *cmgr.Manager, args []string) int {
+	parser := flag.NewFlagSet("extend", flag.ExitOnError)
+	updateUsage(parser, "<instance> <duration>")
+	parser.Parse(args)
+
+	if parser.NArg() != 2 {
+		parser.Usage()
+		return USAGE_ERROR
+	}
+
+	instance, err := strconv.Atoi(parser.Arg(0))
+	if err != nil {
+		fmt.Fprintf(parser.Output(), "error: could not interpret '%s' as an instance id: %s\n", parser.Arg(0), err)
+		parser.Usage()
+		return USAGE_ERROR
+	}
+	ttl, err := time.ParseDuration(parser.Arg(1))
+	if err != nil {
+		fmt.Fprintf(parser.Output(), "error: could not interpret '%s' as a duration: %s\n", parser.Arg(1), err)
+		parser.Usage()
+		return USAGE_ERROR
+	}
+
+	if err := mgr.ExtendInstance(cmgr.InstanceId(instance), ttl); err != nil {
+		fmt.Printf("error: could not extend instance: %s\n", err)
+		return RUNTIME_ERROR
+	}
+
+	meta, err := mgr.GetInstanceMetadata(cmgr.InstanceId(instance))
+	if err != nil {
+		fmt.Printf("error: could not read instance: %s\n", err)
+		return RUNTIME_ERROR
+	}
+	fmt.Printf("Expires: %s\n", time.Unix(meta.Expires, 0).Format(time.RFC3339))
+	return NO_ERROR
+}
+
+func pauseInstance(mgr *cmgr.Manager, args []string) int {
+	return changeInstanceState(mgr, "pause", mgr.Pause, args)
+}
+
+func resumeInstance(mgr *cmgr.Manager, args []string) int {
+	return changeInstanceState(mgr, "resume", mgr.Resume, args)
+}
+
+func changeInstanceState(
+	mgr *cmgr.Manager,
+	command string,
+	action func(cmgr.InstanceId) error,
+	args []string,
+) int {
+	parser := flag.NewFlagSet(command, flag.ExitOnError)
+	updateUsage(parser, "<instance>")
+	parser.Parse(args)
+
+	if parser.NArg() != 1 {
+		parser.Usage()
+		return USAGE_ERROR
+	}
+
+	instance, err := strconv.Atoi(parser.Arg(0))
+	if err != nil {
+		fmt.Fprintf(parser.Output(), "error: could not interpret '%s' as an instance id: %s\n", parser.Arg(0), err)
+		parser.Usage()
+		return USAGE_ERROR
+	}
+
+	if err := action(cmgr.InstanceId(instance)); err != nil {
+		fmt.Printf("error: could not %s instance: %s\n", command, err)
+		return RUNTIME_ERROR
+	}
+	return NO_ERROR
+}
+
+func showInstanceStats(mgr *cmgr.Manager, args []string) int {
+	parser := flag.NewFlagSet("stats", flag.ExitOnError)
+	updateUsage(parser, "[<instance> ...]")
+	verbose := parser.Bool("verbose", false, "print the usage of each container")
+	jsonout := parser.Bool("json", false, "print information as json")
+	parser.Parse(args)
+
+	instances := []cmgr.InstanceId{}
+	for _, instanceStr := range parser.Args() {
+		instanceInt, err := strconv.Atoi(instanceStr)
+		if err != nil {
+			fmt.Fprintf(parser.Output(), "error: could not interpret '%s' as an instance id: %s\n", instanceStr, err)
+			parser.Usage()
+			return USAGE_ERROR
+		}
+		instances = append(instances, cmgr.InstanceId(instanceInt))
+	}
+	if len(instances) == 0 {
+		state, err := mgr.DumpState(nil)
+		if err != nil {
+			fmt.Printf("error: %s\n", err)
+			return RUNTIME_ERROR
+		}
+		for _, challenge := range state {
+			for _, build := range challenge.Builds {
+				for _, instance := range build.Instances {
+					instances = append(instances, instance.Id)
+				}
+			}
+		}
+	}
+
+	retCode := NO_ERROR
+	stats := []*cmgr.InstanceStats{}
+	for _, instance := range instances {
+		instanceStats, err := mgr.InstanceStats(instance)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: could not sample instance %d: %s\n", instance, err)
+			retCode = RUNTIME_ERROR
+			continue
+		}
+		stats = append(stats, instanceStats)
+	}
+
+	if *jsonout {
+		data, err := json.MarshalIndent(stats, "", "    ")
+		if err != nil {
+			fmt.Printf("error: %s\n", err)
+			return RUNTIME_ERROR
+		}
+		fmt.Println(string(data))
+		return retCode
+	}
+
+	table := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "INSTANCE\tCHALLENGE\tCPU\tMEMORY\tPIDS\tNET RX / TX\tBLOCK READ / WRITE")
+	for _, instanceStats := range stats {
+		printResourceUsage(
+			table,
+			strconv.FormatInt(int64(instanceStats.Instance), 10),
+			string(instanceStats.Challenge),
+			instanceStats.Total,
+		)
+		if !*verbose {
+			continue
+		}
+		for _, container := range instanceStats.Containers {
+			name := container.Name
+			if name == "" {
+				name = container.Id
+			}
+			printResourceUsage(table, "", "  "+name, container.ResourceUsage)
+		}
+	}
+	table.Flush()
+	return retCode
+}
+
+func printResourceUsage(w *tabwriter.Writer, instance string, name string, usage cmgr.ResourceUsage) {
+	pids := strconv.FormatUint(usage.Pids, 10)
+	if usage.PidsLimit > 0 {
+		pids += " / " + strconv.FormatUint(usage.PidsLimit, 10)
+	}
+	fmt.Fprintf(
+		w,
+		"%s\t%s\t%.1f%%\t%s / %s (%.1f%%)\t%s\t%s / %s\t%s / %s\n",
+		instance,
+		name,
+		usage.CPUPercent,
+		formatBytes(usage.MemoryBytes),
+		formatBytes(usage.MemoryLimitBytes),
+		usage.MemoryPercent,
+		pids,
+		formatBytes(usage.NetworkRxBytes),
+		formatBytes(usage.NetworkTxBytes),
+		formatBytes(usage.BlockReadBytes),
+		formatBytes(usage.BlockWriteBytes),
+	)
+}
+
+func formatBytes(count uint64) string {
+	const unit = 1024
+	if count < unit {
+		return fmt.Sprintf("%dB", count)
+	}
+	value := float64(count)
+	suffixes := []string{"KiB", "MiB", "GiB", "TiB", "PiB", "EiB"}
+	suffix := -1
+	for value >= unit && suffix < len(suffixes)-1 {
+		value /= unit
+		suffix++
+	}
+	return fmt.Sprintf("%.1f%s", value, suffixes[suffix])
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/ArmyCyberInstitute/cmgr/cmgr"
+)
+
+func showLogs(mgr *cmgr.Manager, args []string) int {
+	parser := flag.NewFlagSet("logs", flag.ExitOnError)
+	updateUsage(parser, "build <build> | check <instance> [<check>] | <instance> [<host>]")
+	follow := parser.Bool("follow", false, "wait for more output until the build or check finishes")
+	tail := parser.Int("tail", 0, "print only the last lines of each instance container (default: all)")
+	since := parser.Duration("since", 0, "print only instance container output from the given duration ago")
+	parser.Parse(args)
+
+	if parser.NArg() >= 1 && parser.NArg() <= 2 {
+		if instance, err := strconv.Atoi(parser.Arg(0)); err == nil {
+			var sinceTime time.Time
+			if *since > 0 {
+				sinceTime = time.Now().Add(-*since)
+			}
+			output, err := mgr.InstanceLogs(
+				context.Background(),
+				cmgr.InstanceId(instance),
+				parser.Arg(1),
+				*tail,
+				sinceTime,
+				*follow,
+			)
+			return printLog(output, err)
+		}
+	}
+
+	if parser.NArg() < 2 {
+		parser.Usage()
+		return USAGE_ERROR
+	}
+
+	id, err := strconv.Atoi(parser.Arg(1))
+	if err != nil {
+		fmt.Fprintf(parser.Output(), "error: could not interpret '%s' as an identifier: %s\n", parser.Arg(1), err)
+		parser.Usage()
+		return USAGE_ERROR
+	}
+
+	ctx := context.Background()
+	var output io.ReadCloser
+	switch parser.Arg(0) {
+	case "build":
+		if parser.NArg() != 2 {
+			parser.Usage()
+			return USAGE_ERROR
+		}
+		output, err = mgr.BuildLog(ctx, cmgr.BuildId(id), *follow)
+	case "check":
+		if parser.NArg() > 3 {
+			parser.Usage()
+			return USAGE_ERROR
+		}
+		instance := cmgr.InstanceId(id)
+		var check int
+		if parser.NArg() == 3 {
+			check, err = strconv.Atoi(parser.Arg(2))
+			if err != nil {
+				fmt.Fprintf(parser.Output(), "error: could not interpret '%s' as a check number: %s\n", parser.Arg(2), err)
+				parser.Usage()
+				return USAGE_ERROR
+			}
+		} else {
+			// Default to the most recent check of the instance.
+			var checks []cmgr.InstanceCheck
+			checks, err = mgr.ListInstanceChecks(instance)
+			if err == nil && len(checks) == 0 {
+				err = fmt.Errorf("no checks of instance %d have been logged", instance)
+			}
+			if err == nil {
+				check = checks[len(checks)-1].Number
+			}
+		}
+		if err == nil {
+			output, err = mgr.CheckLog(ctx, instance, check, *follow)
+		}
+	default:
+		fmt.Fprintf(parser.Output(), "error: unrecognized log type '%s'\n", parser.Arg(0))
+		parser.Usage()
+		return USAGE_ERROR
+	}
+	return printLog(output, err)
+}
+
+func printLog(output io.ReadCloser, err error) int {
+	if err != nil {
+		fmt.Printf("error: could not open log: %s\n", err)
+		return RUNTIME_ERROR
+	}
+	defer output.Close()
+
+	if _, err := io.Copy(os.Stdout, output); err != nil {
+		fmt.Printf("error: could not read log: %s\n", err)
+		return RUNTIME_ERROR
+	}
+	return NO_ERROR
+}
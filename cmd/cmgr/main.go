@@ -69,6 +69,18 @@ func main() {
 		exitCode = checkInstances(mgr, cmdArgs)
 	case "stop":
 		exitCode = stopInstance(mgr, cmdArgs)
+	case "extend":
+		exitCode = extendInstance(mgr, cmdArgs)
+	case "pause":
+		exitCode = pauseInstance(mgr, cmdArgs)
+	case "resume":
+		exitCode = resumeInstance(mgr, cmdArgs)
+	case "stats":
+		exitCode = showInstanceStats(mgr, cmdArgs)
+	case "logs":
+		exitCode = showLogs(mgr, cmdArgs)
+	case "captures":
+		exitCode = showCaptures(mgr, cmdArgs)
 	case "destroy":
 		exitCode = destroyBuilds(mgr, cmdArgs)
 	case "reset":
@@ -81,6 +93,8 @@ func main() {
 		exitCode = convertToCustom(mgr, cmdArgs)
 	case "system-dump":
 		exitCode = dumpSystemState(mgr, cmdArgs)
+	case "capacity":
+		exitCode = showCapacity(mgr, cmdArgs)
 	case "list-schemas":
 		exitCode = listSchemas(mgr, cmdArgs)
 	case "add-schema":
@@ -91,6 +105,8 @@ func main() {
 		exitCode = removeSchema(mgr, cmdArgs)
 	case "show-schema":
 		exitCode = showSchema(mgr, cmdArgs)
+	case "assign":
+		exitCode = assignInstance(mgr, cmdArgs)
 	case "playtest":
 		exitCode = playtestChallenge(mgr, cmdArgs)
 	case "help":
@@ -102,6 +118,11 @@ func main() {
 		exitCode = USAGE_ERROR
 	}
 
+	// Claiming an instance refills its warm pool in the background, which
+	// must finish before the process exits.
+	if err := mgr.Close(); err != nil {
+		log.Printf("error: %s", err)
+	}
 	os.Exit(exitCode)
 }
 
@@ -153,8 +174,9 @@ Available commands:
       format for each seed provided; the flag format defaults to 'flag{%%s}'
       if not provided; prints a list of Build IDs that were created
 
-  start <build identfier>
-      creates a new running instance of the build and prints its ID to stdout
+  start [--ttl <duration>] <build identfier>
+      creates a new running instance of the build and prints its ID to stdout;
+      with '--ttl' (e.g., '2h'), cmgrd stops the instance once it expires
 
   check <instance identifier>
       runs the associated solve script against the instance
@@ -162,6 +184,37 @@ Available commands:
   stop <instance identifier>
       stops the given instance
 
+  extend <instance identifier> <duration>
+      pushes back the expiry of the instance to at least the given duration
+      from now, giving it an expiry if it had none
+
+  pause <instance identifier>
+      stops the instance's containers without removing them, keeping their
+      files and port assignments; a paused instance cannot be checked
+
+  resume <instance identifier>
+      restarts the containers of a paused instance
+
+  stats [<instance identifier> ...]
+      samples the CPU, memory, PID, network, and block I/O usage of the
+      instances (defaults to every instance); '--verbose' adds a row for each
+      container and '--json' prints the full report
+
+  logs build <build identifier>
+  logs check <instance identifier> [<check number>]
+      prints the output captured from the most recent attempt at the build or
+      from a solver check of the instance (defaults to the latest check);
+      '--follow' waits for more output until the build or check finishes
+
+  logs <instance identifier> [<host>]
+      prints what the instance's containers have written, or only the named
+      host's container; '--tail' and '--since' limit the output and
+      '--follow' waits for more
+
+  captures <instance identifier> [<capture>]
+      lists the packet capture files recorded for an instance whose challenge
+      sets 'packet_capture', or writes the named capture to stdout
+
   destroy <build identifier> [...]
       destroys the given build if no instances are running, otherwise it exits
       with a non-zero exit code and does nothing; reclaims disk space used by
@@ -190,8 +243,15 @@ Available commands:
       Returns all of the associated challenge, build, and instance metadata for
       the named schema in JSON format.
 
-  reset
-      stops all known instances and destroys all known builds
+  assign <schema name> <challenge> <user>
+      Prints the instance of the schema's challenge assigned to the user,
+      assigning them one that has room under the challenge's MaxUsers if they
+      have none; '--release' releases the assignment instead.
+
+  reset [<instance identifier>]
+      stops all known instances and destroys all known builds; given an
+      instance, instead recreates just its containers from the build's images
+      while keeping its identifier and ports
 
   test [<path>]
       Shortcut for calling 'update' on the given path followed by build,
@@ -214,6 +274,11 @@ Available commands:
       Lists the challenges along with their builds and instances; all
       challenges are listed if no challenge IDs are provided.
 
+  capacity
+      Prints the CPUs, memory, and processes reserved by every instance along
+      with the host budget and the headroom left in it; '--json' prints the
+      full report.
+
   version
       Prints version information and exits.
 
@@ -224,6 +289,22 @@ Relevant environment variables:
 
   CMGR_ARTIFACT_DIR - directory for storing artifact bundles (defaults to '.')
 
+  CMGR_LOG_DIR - directory for storing captured build and solver output
+      (defaults to 'logs' inside the artifact directory)
+
+  CMGR_MAX_CHECK_LOGS - how many of the most recent solver check logs are
+      kept for each instance (defaults to 20)
+
+  CMGR_CAPTURE_DIR - directory for storing packet captures of challenge
+      networks (defaults to 'captures' inside the artifact directory)
+
+  CMGR_CAPTURE_IMAGE - the image run as the packet capture sidecar, which
+      must provide 'sh' and 'tcpdump' (defaults to 'nicolaka/netshoot')
+
+  CMGR_MAX_CAPTURE_FILE_BYTES, CMGR_MAX_CAPTURE_FILES - the size at which a
+      packet capture file is rotated and the number of files kept for each
+      instance across restarts of its capture (default to '10m' and 10)
+
   CMGR_LOGGING - controls the verbosity of the internal logging infrastructure
       and should be one of the following: debug, info, warn, error, or disabled
       (defaults to 'disabled')
@@ -231,7 +312,26 @@ Relevant environment variables:
   CMGR_INTERFACE - the host interface/address to which published challenge
       ports should be bound (defaults to '0.0.0.0'); if the specified interface
       does not exist on the host running the Docker daemon, Docker will silently
-      ignore this value and instead bind to the loopback address
+      ignore this value and instead bind to the loopback address; a
+      comma-separated list of IPv4 and IPv6 addresses publishes each port on
+      all of them and requires CMGR_PORTS
+
+  CMGR_ENABLE_IPV6 - when set, challenge networks are created with IPv6
+      enabled, which requires IPv6 address pools in the Docker daemon
+
+  CMGR_KUBERNETES_CONFIG - when set, instances run as Pods on the Kubernetes
+      cluster of this kubeconfig file, or of the cluster cmgr runs in when
+      empty; Docker still builds the images, which are pushed to the
+      required CMGR_REGISTRY for the nodes to pull; PID limits are not
+      available, so CMGR_DEFAULT_PIDS_LIMIT and CMGR_HOST_PIDS cannot be set
+
+  CMGR_DOCKER_NODES - when set, instances are spread across the Docker
+      daemons of this comma-separated list of 'name=host[=address]' entries,
+      such as 'a=tcp://10.0.0.2:2376,b=ssh://docker@10.0.0.3'; the first
+      node, which should be the local daemon, builds the images and runs
+      the instances that are captured, have an egress allowlist, or are
+      proxied, and players reach an instance at the address of its node,
+      which defaults to its host; requires CMGR_PORTS
 
   CMGR_PORTS - the range of ports that are dedicated for serving challenges;
       cmgr will assume that it fully owns these ports and nothing else will
@@ -247,6 +347,23 @@ Relevant environment variables:
   CMGR_REGISTRY_TOKEN - the token/password to use to authenticate with the
       registry
 
+  CMGR_HOST_CPUS, CMGR_HOST_MEMORY, CMGR_HOST_PIDS - the host budget that the
+      CPU, memory, and PID limits of all instances' containers together must
+      fit within; a container without a limit counts the CMGR_DEFAULT_*
+      value instead; starting an instance that would exceed it fails (each
+      defaults to no budget)
+
+  CMGR_PROXY_URL - the public base URL of cmgrd's reverse proxy, such as
+      'https://ctf.example.com'; when set, the ports that challenges list in
+      'http_ports' are not published on the host and are instead reached at
+      '<instance>-<port>' below its host (defaults to publishing every port)
+
+  CMGR_TLS_GATEWAY - the public address of cmgrd's TLS gateway, such as
+      'ctf.example.com:443'; when set, the ports that challenges list in
+      'tls_ports' are not published on the host and are instead reached by
+      the SNI hostname '<instance>-<port>' below its host (defaults to
+      publishing every port)
+
   Note: The Docker client is configured via Docker's standard environment
       variables.  See https://docs.docker.com/engine/reference/commandline/cli/
       for specific details.
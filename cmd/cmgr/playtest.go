@@ -9,7 +9,9 @@ import (
 	"html/template"
 	"io"
 	"mime"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -106,14 +108,51 @@ func expandPlaytestText(
 ) template.HTML {
 	artifactURL := fmt.Sprintf("http://%s:%d/artifact/$1", iface, port)
 	value = urlRe.ReplaceAllString(value, artifactURL)
-	value = serverRe.ReplaceAllString(value, iface)
-	value = httpBaseRe.ReplaceAllString(value, fmt.Sprintf("http://%s", iface))
+	value = opensslRe.ReplaceAllStringFunc(value, func(match string) string {
+		portName := opensslRe.FindStringSubmatch(match)[1]
+		if host, port, ok := gatewayEndpoint(instance, portName); ok {
+			return fmt.Sprintf("openssl s_client -quiet -connect %s:%s -servername %s", host, port, host)
+		}
+		return fmt.Sprintf("nc %s %d", iface, instance.Ports[portName])
+	})
+	value = ncatRe.ReplaceAllStringFunc(value, func(match string) string {
+		portName := ncatRe.FindStringSubmatch(match)[1]
+		if host, port, ok := gatewayEndpoint(instance, portName); ok {
+			return fmt.Sprintf("ncat --ssl %s %s", host, port)
+		}
+		return fmt.Sprintf("nc %s %d", iface, instance.Ports[portName])
+	})
+	value = serverRe.ReplaceAllStringFunc(value, func(match string) string {
+		portName := serverRe.FindStringSubmatch(match)[1]
+		if proxied := proxiedURL(instance, portName); proxied != nil {
+			return proxied.Hostname()
+		}
+		if host, _, ok := gatewayEndpoint(instance, portName); ok {
+			return host
+		}
+		return iface
+	})
+	value = httpBaseRe.ReplaceAllStringFunc(value, func(match string) string {
+		if proxied := proxiedURL(instance, httpBaseRe.FindStringSubmatch(match)[1]); proxied != nil {
+			return proxied.String()
+		}
+		return fmt.Sprintf("http://%s", iface)
+	})
 	for portRe.MatchString(value) {
 		match := portRe.FindStringSubmatch(value)
 		mappedPort, ok := instance.Ports[match[1]]
 		replacement := ""
 		if ok {
 			replacement = fmt.Sprintf("%d", mappedPort)
+		} else if _, gatewayPort, ok := gatewayEndpoint(instance, match[1]); ok {
+			replacement = gatewayPort
+		} else if proxied := proxiedURL(instance, match[1]); proxied != nil {
+			replacement = proxied.Port()
+			if replacement == "" && proxied.Scheme == "https" {
+				replacement = "443"
+			} else if replacement == "" {
+				replacement = "80"
+			}
 		}
 		value = strings.ReplaceAll(value, match[0], replacement)
 	}
@@ -125,6 +164,30 @@ func expandPlaytestText(
 	return template.HTML(policy.Sanitize(value))
 }
 
+// Returns the URL through which the reverse proxy serves the port, or nil
+// when the port is published on the host.
+func proxiedURL(instance *cmgr.InstanceMetadata, portName string) *url.URL {
+	proxied, ok := instance.URLs[portName]
+	if !ok {
+		return nil
+	}
+	parsed, err := url.Parse(proxied)
+	if err != nil {
+		return nil
+	}
+	return parsed
+}
+
+// Returns the host and port through which the TLS gateway serves the port.
+func gatewayEndpoint(instance *cmgr.InstanceMetadata, portName string) (string, string, bool) {
+	endpoint, ok := instance.TLSEndpoints[portName]
+	if !ok {
+		return "", "", false
+	}
+	host, port, err := net.SplitHostPort(endpoint)
+	return host, port, err == nil
+}
+
 func launchPortal(mgr *cmgr.Manager, iface string, port int, cid cmgr.ChallengeId, bid cmgr.BuildId, iid cmgr.InstanceId) int {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
@@ -301,5 +364,11 @@ var portRe *regexp.Regexp = regexp.MustCompile(`\{\{\s*port\(["'](\w+)["']\)\s*\
 // {{server("port_name")}}
 var serverRe *regexp.Regexp = regexp.MustCompile(`\{\{\s*server\(["'](\w+)["']\)\s*\}\}`)
 
+// {{openssl("port_name")}}
+var opensslRe *regexp.Regexp = regexp.MustCompile(`\{\{\s*openssl\(["'](\w+)["']\)\s*\}\}`)
+
+// {{ncat("port_name")}}
+var ncatRe *regexp.Regexp = regexp.MustCompile(`\{\{\s*ncat\(["'](\w+)["']\)\s*\}\}`)
+
 // {{lookup("key")}}
 var lookupRe *regexp.Regexp = regexp.MustCompile(`\{\{\s*lookup\(["'](\w+)["']\)\s*\}\}`)
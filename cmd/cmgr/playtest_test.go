@@ -40,3 +40,39 @@ func TestExpandPlaytestTextSanitizesChallengeAndLookupHTML(t *testing.T) {
 		}
 	}
 }
+
+func TestExpandPlaytestTextRendersProxiedPorts(t *testing.T) {
+	rendered := string(expandPlaytestText(
+		`<a href='{{http_base("http")}}:{{port("http")}}/'>web</a> `+
+			`{{server("ssh")}}:{{port("ssh")}}`,
+		"localhost",
+		4242,
+		&cmgr.BuildMetadata{},
+		&cmgr.InstanceMetadata{
+			Ports: map[string]int{"ssh": 31022},
+			URLs:  map[string]string{"http": "https://17-http.ctf.example.com"},
+		},
+	))
+	want := `<a href="https://17-http.ctf.example.com:443/" rel="nofollow">web</a> localhost:31022`
+	if rendered != want {
+		t.Fatalf("rendered %q, expected %q", rendered, want)
+	}
+}
+
+func TestExpandPlaytestTextRendersGatewayConnections(t *testing.T) {
+	rendered := string(expandPlaytestText(
+		`{{openssl("pwn")}} | {{ncat("pwn")}} | {{server("pwn")}}:{{port("pwn")}} | {{ncat("ssh")}}`,
+		"localhost",
+		4242,
+		&cmgr.BuildMetadata{},
+		&cmgr.InstanceMetadata{
+			Ports:        map[string]int{"ssh": 31022},
+			TLSEndpoints: map[string]string{"pwn": "17-pwn.ctf.example.com:443"},
+		},
+	))
+	want := "openssl s_client -quiet -connect 17-pwn.ctf.example.com:443 -servername 17-pwn.ctf.example.com | " +
+		"ncat --ssl 17-pwn.ctf.example.com 443 | 17-pwn.ctf.example.com:443 | nc localhost 31022"
+	if rendered != want {
+		t.Fatalf("rendered %q, expected %q", rendered, want)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"path/filepath"
+	"time"
 
 	"go.yaml.in/yaml/v3"
 
@@ -130,6 +131,43 @@ func showSchema(mgr *cmgr.Manager, args []string) int {
 	return NO_ERROR
 }
 
+func assignInstance(mgr *cmgr.Manager, args []string) int {
+	parser := flag.NewFlagSet("assign", flag.ExitOnError)
+	ttl := parser.Duration("ttl", 0, "time after which cmgrd stops a newly claimed instance (default: never)")
+	release := parser.Bool("release", false, "release the user's assignment instead")
+	updateUsage(parser, "<schema name> <challenge> <user>")
+	parser.Parse(args)
+
+	if parser.NArg() != 3 {
+		parser.Usage()
+		return USAGE_ERROR
+	}
+	schema := parser.Arg(0)
+	challenge := cmgr.ChallengeId(parser.Arg(1))
+	user := parser.Arg(2)
+
+	if *release {
+		if err := mgr.Unassign(schema, challenge, user); err != nil {
+			fmt.Printf("error: %s\n", err)
+			return RUNTIME_ERROR
+		}
+		return NO_ERROR
+	}
+
+	var ttlArgs []time.Duration
+	if *ttl != 0 {
+		ttlArgs = append(ttlArgs, *ttl)
+	}
+	assignment, err := mgr.Assign(schema, challenge, user, ttlArgs...)
+	if err != nil {
+		fmt.Printf("error: could not assign instance: %s\n", err)
+		return RUNTIME_ERROR
+	}
+
+	fmt.Printf("Instance ID: %d\n", assignment.Instance)
+	return NO_ERROR
+}
+
 func loadSchema(fname string) (*cmgr.Schema, int) {
 	data, err := ioutil.ReadFile(fname)
 	if err != nil {
@@ -4,16 +4,23 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
 
 	"github.com/ArmyCyberInstitute/cmgr/cmgr"
 )
 
 func resetSystemState(mgr *cmgr.Manager, args []string) int {
 	parser := flag.NewFlagSet("reset", flag.ExitOnError)
-	updateUsage(parser, "")
+	updateUsage(parser, "[<instance>]")
 	verbose := parser.Bool("verbose", false, "print more information")
 	parser.Parse(args)
 
+	if parser.NArg() == 1 {
+		return changeInstanceState(mgr, "reset", mgr.Reset, parser.Args())
+	}
 	if parser.NArg() != 0 {
 		parser.Usage()
 		return USAGE_ERROR
@@ -87,15 +94,32 @@ func dumpSystemState(mgr *cmgr.Manager, args []string) int {
 		fmt.Printf("error: %s\n", err)
 		return RUNTIME_ERROR
 	}
+	for _, challenge := range state {
+		for _, build := range challenge.Builds {
+			mgr.InspectInstanceRuntime(build.Instances...)
+		}
+	}
 
 	if *summary {
 		for _, challenge := range state {
 			nInstances := 0
+			nCrashLooping := 0
 			for _, build := range challenge.Builds {
 				nInstances += len(build.Instances)
+				for _, instance := range build.Instances {
+					if instance.CrashLooping {
+						nCrashLooping++
+					}
+				}
 			}
 
-			if len(challenge.Builds) > 0 {
+			if nCrashLooping > 0 {
+				fmt.Printf("%s: %d builds (%d instances, %d crash looping)\n",
+					challenge.Id,
+					len(challenge.Builds),
+					nInstances,
+					nCrashLooping)
+			} else if len(challenge.Builds) > 0 {
 				fmt.Printf("%s: %d builds (%d instances)\n",
 					challenge.Id,
 					len(challenge.Builds),
@@ -116,7 +140,7 @@ func dumpSystemState(mgr *cmgr.Manager, args []string) int {
 			for _, build := range challenge.Builds {
 				fmt.Printf("    Build ID: %d\n", build.Id)
 				for _, instance := range build.Instances {
-					fmt.Printf("        %d\n", instance.Id)
+					fmt.Printf("        %d%s\n", instance.Id, instanceStatus(instance))
 				}
 			}
 		}
@@ -124,3 +148,89 @@ func dumpSystemState(mgr *cmgr.Manager, args []string) int {
 
 	return NO_ERROR
 }
+
+// Summarizes the runtime state of an instance for the text system dump.  A
+// healthy instance that has never been restarted has nothing to report.
+func instanceStatus(instance *cmgr.InstanceMetadata) string {
+	var notes []string
+	if instance.Paused {
+		notes = append(notes, "paused")
+	}
+	if instance.CrashLooping {
+		notes = append(notes, "CRASH LOOPING")
+	}
+	if instance.Health != "" && instance.Health != "healthy" {
+		notes = append(notes, instance.Health)
+	}
+	if instance.RestartCount > 0 {
+		notes = append(notes, fmt.Sprintf("%d restarts", instance.RestartCount))
+	}
+	if len(notes) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(notes, ", ") + ")"
+}
+
+func showCapacity(mgr *cmgr.Manager, args []string) int {
+	parser := flag.NewFlagSet("capacity", flag.ExitOnError)
+	updateUsage(parser, "")
+	jsonout := parser.Bool("json", false, "print information as json")
+	parser.Parse(args)
+
+	if parser.NArg() != 0 {
+		parser.Usage()
+		return USAGE_ERROR
+	}
+
+	report, err := mgr.CapacityReport()
+	if err != nil {
+		fmt.Printf("error: %s\n", err)
+		return RUNTIME_ERROR
+	}
+
+	if *jsonout {
+		data, err := json.MarshalIndent(report, "", "    ")
+		if err != nil {
+			fmt.Printf("error: JSON encoding failed: %s", err)
+			return RUNTIME_ERROR
+		}
+		fmt.Println(string(data))
+		return NO_ERROR
+	}
+
+	formatCPUs := func(nanoCPUs int64) string {
+		return strconv.FormatFloat(float64(nanoCPUs)/1e9, 'f', -1, 64)
+	}
+	formatMemory := func(bytes int64) string {
+		if bytes < 0 {
+			return "-" + formatBytes(uint64(-bytes))
+		}
+		return formatBytes(uint64(bytes))
+	}
+	formatPids := func(pids int64) string {
+		return strconv.FormatInt(pids, 10)
+	}
+
+	fmt.Printf("Instances: %d\n", report.Instances)
+	table := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "RESOURCE\tRESERVED\tBUDGET\tHEADROOM")
+	printResourceCapacity(table, "cpus", report.NanoCPUs, formatCPUs)
+	printResourceCapacity(table, "memory", report.Memory, formatMemory)
+	printResourceCapacity(table, "pids", report.Pids, formatPids)
+	table.Flush()
+	return NO_ERROR
+}
+
+func printResourceCapacity(
+	w *tabwriter.Writer,
+	name string,
+	capacity cmgr.ResourceCapacity,
+	format func(int64) string,
+) {
+	budget, headroom := "unlimited", "-"
+	if capacity.Budget != nil {
+		budget = format(*capacity.Budget)
+		headroom = format(*capacity.Headroom)
+	}
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", name, format(capacity.Used), budget, headroom)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ArmyCyberInstitute/cmgr/cmgr"
+)
+
+// An assignment along with the metadata of its instance, which tells the
+// front end how the user connects to it.
+type assignmentResponse struct {
+	*cmgr.Assignment
+	InstanceMetadata *cmgr.InstanceMetadata `json:"instance"`
+}
+
+// Serves /schemas/{schema}/assignments/{user}/{challenge}.  The user is taken
+// from the escaped path so that it may contain a slash.
+func (s state) assignmentHandler(w http.ResponseWriter, r *http.Request) {
+	path := strings.Split(r.URL.EscapedPath(), "/")
+	if len(path) < 6 || path[1] != "schemas" || path[3] != "assignments" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	segments := make([]string, len(path)-2)
+	for i, segment := range path[2:] {
+		unescaped, err := url.PathUnescape(segment)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		segments[i] = unescaped
+	}
+	schema, user := segments[0], segments[2]
+	challenge := cmgr.ChallengeId(strings.Trim(strings.Join(segments[3:], "/"), "/"))
+	if challenge == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var assignment *cmgr.Assignment
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		assignment, err = s.mgr.GetAssignment(schema, challenge, user)
+	case http.MethodPost:
+		ttl, ttlErr := requestTTL(r)
+		if ttlErr != nil {
+			writeError(w, http.StatusBadRequest, ttlErr)
+			return
+		}
+		assignment, err = s.mgr.Assign(schema, challenge, user, ttl...)
+	case http.MethodDelete:
+		if err = s.mgr.Unassign(schema, challenge, user); err == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var response *assignmentResponse
+	if err == nil {
+		response, err = s.assignmentResponse(assignment)
+	}
+	if err != nil {
+		writeError(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (s state) v2GetAssignment(w http.ResponseWriter, r *http.Request) {
+	assignment, err := s.mgr.GetAssignment(
+		r.PathValue("schema"),
+		cmgr.ChallengeId(r.PathValue("challenge")),
+		r.PathValue("user"),
+	)
+	s.writeV2Assignment(w, assignment, err)
+}
+
+func (s state) v2Assign(w http.ResponseWriter, r *http.Request) {
+	ttl, err := requestTTL(r)
+	if err != nil {
+		writeV2Error(w, http.StatusBadRequest, err)
+		return
+	}
+	assignment, err := s.mgr.Assign(
+		r.PathValue("schema"),
+		cmgr.ChallengeId(r.PathValue("challenge")),
+		r.PathValue("user"),
+		ttl...,
+	)
+	s.writeV2Assignment(w, assignment, err)
+}
+
+func (s state) v2Unassign(w http.ResponseWriter, r *http.Request) {
+	err := s.mgr.Unassign(
+		r.PathValue("schema"),
+		cmgr.ChallengeId(r.PathValue("challenge")),
+		r.PathValue("user"),
+	)
+	if err != nil {
+		writeV2Error(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s state) writeV2Assignment(w http.ResponseWriter, assignment *cmgr.Assignment, err error) {
+	var response *assignmentResponse
+	if err == nil {
+		response, err = s.assignmentResponse(assignment)
+	}
+	if err != nil {
+		writeV2Error(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("%s/instances/%d", v2Prefix, assignment.Instance))
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (s state) assignmentResponse(assignment *cmgr.Assignment) (*assignmentResponse, error) {
+	meta, err := s.mgr.GetInstanceMetadata(assignment.Instance)
+	if err != nil {
+		return nil, err
+	}
+	return &assignmentResponse{Assignment: assignment, InstanceMetadata: meta}, nil
+}
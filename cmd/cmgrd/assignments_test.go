@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAssignmentHandlerRejectsIncompletePaths(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		want   int
+	}{
+		{http.MethodGet, "/schemas/event/assignments/team", http.StatusNotFound},
+		{http.MethodGet, "/schemas/event/assignments/team/", http.StatusNotFound},
+		{http.MethodPut, "/schemas/event/assignments/team/foo", http.StatusMethodNotAllowed},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest(test.method, test.path, nil)
+		response := httptest.NewRecorder()
+		state{}.existingSchemaHandler(response, request)
+		if response.Code != test.want {
+			t.Errorf("%s %s: status %d, expected %d", test.method, test.path, response.Code, test.want)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Scopes are ordered so that each one grants everything permitted by the
+// scopes before it.
+type scope int
+
+const (
+	scopeNone scope = iota
+	scopeReadOnly
+	scopeInstanceOperator
+	scopeAdmin
+)
+
+func (s scope) String() string {
+	switch s {
+	case scopeReadOnly:
+		return "read-only"
+	case scopeInstanceOperator:
+		return "instance-operator"
+	case scopeAdmin:
+		return "admin"
+	}
+	return "none"
+}
+
+func parseScope(name string) (scope, error) {
+	for _, candidate := range []scope{
+		scopeReadOnly,
+		scopeInstanceOperator,
+		scopeAdmin,
+	} {
+		if name == candidate.String() {
+			return candidate, nil
+		}
+	}
+	return scopeNone, fmt.Errorf("unknown scope %q", name)
+}
+
+// Bearer tokens are only held as SHA-256 digests so that the lookup does not
+// depend on comparing secret bytes directly.
+type tokenAuth struct {
+	tokens map[[sha256.Size]byte]scope
+}
+
+// Reads a token file in which every non-empty, non-comment line has the form
+// "<scope> <token>".
+func loadTokenFile(path string) (*tokenAuth, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open token file: %w", err)
+	}
+	defer f.Close()
+
+	auth := &tokenAuth{tokens: make(map[[sha256.Size]byte]scope)}
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf(
+				"token file line %d: expected '<scope> <token>'",
+				lineNo,
+			)
+		}
+		tokenScope, err := parseScope(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("token file line %d: %w", lineNo, err)
+		}
+		digest := sha256.Sum256([]byte(fields[1]))
+		if _, exists := auth.tokens[digest]; exists {
+			return nil, fmt.Errorf("token file line %d: duplicate token", lineNo)
+		}
+		auth.tokens[digest] = tokenScope
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read token file: %w", err)
+	}
+	if len(auth.tokens) == 0 {
+		return nil, errors.New("token file does not contain any tokens")
+	}
+	return auth, nil
+}
+
+func (a *tokenAuth) lookup(token string) scope {
+	return a.tokens[sha256.Sum256([]byte(token))]
+}
+
+// Determines the minimum scope needed to serve the request.  Anything not
+// explicitly recognized as a read or an instance operation requires admin.
+// Both API versions share the same paths below their prefix.
+func requiredScope(r *http.Request) scope {
+	path := strings.Trim(r.URL.Path, "/")
+	path = strings.TrimPrefix(path, "v2/")
+	if isCapturePath(path) {
+		// Packet captures hold the raw traffic of competitors.
+		return scopeAdmin
+	}
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return scopeReadOnly
+	}
+
+	switch {
+	case strings.HasPrefix(path, "challenges/") && r.Method == http.MethodPost:
+		return scopeInstanceOperator
+	case strings.HasPrefix(path, "builds/") && r.Method == http.MethodPost:
+		return scopeInstanceOperator
+	case strings.HasPrefix(path, "instances/") &&
+		(r.Method == http.MethodPost || r.Method == http.MethodDelete):
+		return scopeInstanceOperator
+	case isAssignmentPath(path) &&
+		(r.Method == http.MethodPost || r.Method == http.MethodDelete):
+		return scopeInstanceOperator
+	}
+	return scopeAdmin
+}
+
+// Reports whether the path is instances/{id}/captures or below it.
+func isCapturePath(path string) bool {
+	parts := strings.SplitN(path, "/", 4)
+	return len(parts) >= 3 && parts[0] == "instances" && parts[2] == "captures"
+}
+
+// Reports whether the path is below schemas/{schema}/assignments/.
+func isAssignmentPath(path string) bool {
+	parts := strings.SplitN(path, "/", 4)
+	return len(parts) == 4 && parts[0] == "schemas" && parts[2] == "assignments"
+}
+
+// Rejects requests that lack a sufficiently privileged bearer token before
+// they reach the wrapped handler.
+func (a *tokenAuth) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fail := writeError
+		if isV2Request(r) {
+			fail = writeV2Error
+		}
+		header := r.Header.Get("Authorization")
+		token, ok := strings.CutPrefix(header, "Bearer ")
+		granted := scopeNone
+		if ok {
+			granted = a.lookup(strings.TrimSpace(token))
+		}
+		if granted == scopeNone {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="cmgrd"`)
+			fail(w, http.StatusUnauthorized, errors.New("missing or invalid bearer token"))
+			return
+		}
+		if needed := requiredScope(r); granted < needed {
+			fail(
+				w,
+				http.StatusForbidden,
+				fmt.Errorf("token scope %q is insufficient; %q required", granted, needed),
+			)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestTokenFile(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "tokens")
+	if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadTokenFileRejectsMalformedEntries(t *testing.T) {
+	for name, contents := range map[string]string{
+		"empty":         "# only a comment\n",
+		"unknown scope": "superuser abc\n",
+		"missing token": "admin\n",
+		"extra field":   "admin abc def\n",
+		"duplicate":     "admin abc\nread-only abc\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := loadTokenFile(writeTestTokenFile(t, contents)); err == nil {
+				t.Fatal("malformed token file was accepted")
+			}
+		})
+	}
+}
+
+func TestTokenAuthEnforcesScopesBeforeHandler(t *testing.T) {
+	auth, err := loadTokenFile(writeTestTokenFile(t, `
+# front end
+read-only reader
+instance-operator operator
+admin root
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		token  string
+		status int
+	}{
+		{http.MethodGet, "/challenges", "", http.StatusUnauthorized},
+		{http.MethodGet, "/challenges", "wrong", http.StatusUnauthorized},
+		{http.MethodGet, "/challenges", "reader", http.StatusOK},
+		{http.MethodGet, "/builds/1/artifacts.tar.gz", "reader", http.StatusOK},
+		{http.MethodPost, "/builds/1", "reader", http.StatusForbidden},
+		{http.MethodPost, "/builds/1", "operator", http.StatusOK},
+		{http.MethodPost, "/challenges/foo", "operator", http.StatusOK},
+		{http.MethodPost, "/instances/1", "operator", http.StatusOK},
+		{http.MethodDelete, "/instances/1", "operator", http.StatusOK},
+		{http.MethodPost, "/instances/1/pause", "reader", http.StatusForbidden},
+		{http.MethodPost, "/v2/instances/1/resume", "operator", http.StatusOK},
+		{http.MethodDelete, "/builds/1", "operator", http.StatusForbidden},
+		{http.MethodPost, "/schemas", "operator", http.StatusForbidden},
+		{http.MethodDelete, "/schemas/event", "operator", http.StatusForbidden},
+		{http.MethodDelete, "/builds/1", "root", http.StatusOK},
+		{http.MethodPost, "/schemas", "root", http.StatusOK},
+		{http.MethodGet, "/v2/schemas/event", "reader", http.StatusOK},
+		{http.MethodGet, "/v2/solvability/foo", "reader", http.StatusOK},
+		{http.MethodGet, "/v2/capacity", "reader", http.StatusOK},
+		{http.MethodGet, "/instances/1/captures", "reader", http.StatusForbidden},
+		{http.MethodGet, "/v2/instances/1/captures/1.pcap", "operator", http.StatusForbidden},
+		{http.MethodGet, "/v2/instances/1/captures/1.pcap", "root", http.StatusOK},
+		{http.MethodPost, "/schemas/event/assignments/team/foo", "reader", http.StatusForbidden},
+		{http.MethodPost, "/v2/schemas/event/assignments/team/foo", "operator", http.StatusOK},
+		{http.MethodDelete, "/schemas/event/assignments/team/foo", "operator", http.StatusOK},
+		{http.MethodPost, "/v2/schemas/event/assignments", "operator", http.StatusForbidden},
+		{http.MethodPost, "/v2/builds/1", "operator", http.StatusOK},
+		{http.MethodPost, "/v2/builds/1/claim", "reader", http.StatusForbidden},
+		{http.MethodPost, "/v2/builds/1/claim", "operator", http.StatusOK},
+		{http.MethodDelete, "/v2/builds/1", "operator", http.StatusForbidden},
+		{http.MethodPost, "/v2/schemas", "root", http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.method+" "+test.path+" "+test.token, func(t *testing.T) {
+			called := false
+			handler := auth.wrap(http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					called = true
+					w.WriteHeader(http.StatusOK)
+				},
+			))
+			request := httptest.NewRequest(test.method, test.path, nil)
+			if test.token != "" {
+				request.Header.Set("Authorization", "Bearer "+test.token)
+			}
+			response := httptest.NewRecorder()
+			handler.ServeHTTP(response, request)
+			if response.Code != test.status {
+				t.Fatalf("unexpected status %d", response.Code)
+			}
+			if called != (test.status == http.StatusOK) {
+				t.Fatalf("handler called=%v for status %d", called, test.status)
+			}
+			if test.status == http.StatusUnauthorized &&
+				response.Header().Get("WWW-Authenticate") == "" {
+				t.Fatal("missing WWW-Authenticate challenge")
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net/http"
+)
+
+func (s state) capacityHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	report, err := s.mgr.CapacityReport()
+	if err != nil {
+		writeError(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+func (s state) v2GetCapacity(w http.ResponseWriter, r *http.Request) {
+	report, err := s.mgr.CapacityReport()
+	if err != nil {
+		writeV2Error(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
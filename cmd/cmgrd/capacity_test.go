@@ -0,0 +1,23 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCapacityHandlersRejectOtherMethods(t *testing.T) {
+	request := httptest.NewRequest(http.MethodPost, "/capacity", nil)
+	response := httptest.NewRecorder()
+	state{}.capacityHandler(response, request)
+	if response.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("unexpected status %d", response.Code)
+	}
+
+	request = httptest.NewRequest(http.MethodDelete, "/v2/capacity", nil)
+	response = httptest.NewRecorder()
+	state{}.v2Handler().ServeHTTP(response, request)
+	if response.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("unexpected status %d", response.Code)
+	}
+}
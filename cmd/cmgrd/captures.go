@@ -0,0 +1,96 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ArmyCyberInstitute/cmgr/cmgr"
+)
+
+// Serves GET /instances/{id}/captures and /instances/{id}/captures/{name}.
+func (s state) captureHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	path := strings.Split(r.URL.Path, "/")
+	pathLen := len(path)
+	if (pathLen != 4 && pathLen != 5) || path[1] != "instances" || path[3] != "captures" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	instInt, err := strconv.Atoi(path[2])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	instance := cmgr.InstanceId(instInt)
+
+	if pathLen == 4 {
+		captures, err := s.mgr.ListInstanceCaptures(instance)
+		if err != nil {
+			writeError(w, errorStatus(err, http.StatusInternalServerError), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, captures)
+		return
+	}
+
+	capture, err := s.mgr.OpenInstanceCapture(instance, path[4])
+	if err != nil {
+		writeError(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	writeCapture(w, capture)
+}
+
+var captureListing = listing[cmgr.InstanceCapture]{
+	key: func(c cmgr.InstanceCapture) string { return c.Name },
+}
+
+func (s state) v2ListCaptures(w http.ResponseWriter, r *http.Request) {
+	instance, err := pathInt(r, "instance")
+	if err != nil {
+		writeV2Error(w, http.StatusBadRequest, err)
+		return
+	}
+	captures, err := s.mgr.ListInstanceCaptures(cmgr.InstanceId(instance))
+	if err != nil {
+		writeV2Error(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	page, err := captureListing.page(r.URL.Query(), captures)
+	if err != nil {
+		writeV2Error(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+func (s state) v2Capture(w http.ResponseWriter, r *http.Request) {
+	instance, err := pathInt(r, "instance")
+	if err != nil {
+		writeV2Error(w, http.StatusBadRequest, err)
+		return
+	}
+	capture, err := s.mgr.OpenInstanceCapture(cmgr.InstanceId(instance), r.PathValue("capture"))
+	if err != nil {
+		writeV2Error(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	writeCapture(w, capture)
+}
+
+// Sends the capture as a download.  A capture that is still being written is
+// sent as far as it has been flushed.
+func writeCapture(w http.ResponseWriter, capture io.ReadCloser) {
+	defer capture.Close()
+	w.Header().Set("Content-Type", "application/vnd.tcpdump.pcap")
+	if _, err := io.Copy(w, capture); err != nil {
+		log.Printf("capture response failed: %v", err)
+	}
+}
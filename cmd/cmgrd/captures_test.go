@@ -0,0 +1,51 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCaptureHandlersRejectInvalidRequests(t *testing.T) {
+	v2 := state{}.v2Handler()
+	for path, status := range map[string]int{
+		"/instances/1/captures/a/b":       http.StatusNotFound,
+		"/instances/x/captures":           http.StatusBadRequest,
+		"/instances/x/captures/1.pcap0":   http.StatusBadRequest,
+		"/v2/instances/x/captures":        http.StatusBadRequest,
+		"/v2/instances/x/captures/1.pcap": http.StatusBadRequest,
+	} {
+		t.Run(path, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, path, nil)
+			response := httptest.NewRecorder()
+			if strings.HasPrefix(path, "/v2/") {
+				v2.ServeHTTP(response, request)
+			} else {
+				state{}.instanceHandler(response, request)
+			}
+			if response.Code != status {
+				t.Fatalf("expected status %d, got %d", status, response.Code)
+			}
+		})
+	}
+
+	request := httptest.NewRequest(http.MethodDelete, "/instances/1/captures", nil)
+	response := httptest.NewRecorder()
+	state{}.instanceHandler(response, request)
+	if response.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("unexpected status %d", response.Code)
+	}
+}
+
+func TestWriteCaptureSendsPcap(t *testing.T) {
+	response := httptest.NewRecorder()
+	writeCapture(response, io.NopCloser(strings.NewReader("pcap data")))
+	if contentType := response.Header().Get("Content-Type"); contentType != "application/vnd.tcpdump.pcap" {
+		t.Fatalf("unexpected content type %q", contentType)
+	}
+	if body := response.Body.String(); body != "pcap data" {
+		t.Fatalf("unexpected body %q", body)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ArmyCyberInstitute/cmgr/cmgr"
+	"github.com/ArmyCyberInstitute/cmgr/internal/metrics"
+)
+
+// Reads the optional "ttl" query parameter, a Go duration such as "90m".
+func requestTTL(r *http.Request) ([]time.Duration, error) {
+	value := r.URL.Query().Get("ttl")
+	if value == "" {
+		return nil, nil
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil {
+		return nil, &cmgr.InvalidInputError{Err: fmt.Errorf("invalid ttl: %w", err)}
+	}
+	return []time.Duration{ttl}, nil
+}
+
+func requiredTTL(r *http.Request) (time.Duration, error) {
+	ttl, err := requestTTL(r)
+	if err != nil {
+		return 0, err
+	}
+	if len(ttl) == 0 {
+		return 0, &cmgr.InvalidInputError{Err: errors.New("the ttl query parameter is required")}
+	}
+	return ttl[0], nil
+}
+
+func (s state) extendHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	path := strings.Split(r.URL.Path, "/")
+	instInt, err := strconv.Atoi(path[2])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	instance := cmgr.InstanceId(instInt)
+
+	ttl, err := requiredTTL(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := s.mgr.ExtendInstance(instance, ttl); err != nil {
+		writeError(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	meta, err := s.mgr.GetInstanceMetadata(instance)
+	if err != nil {
+		writeError(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, meta)
+}
+
+func (s state) v2ExtendInstance(w http.ResponseWriter, r *http.Request) {
+	instance, err := pathInt(r, "instance")
+	if err != nil {
+		writeV2Error(w, http.StatusBadRequest, err)
+		return
+	}
+	ttl, err := requiredTTL(r)
+	if err != nil {
+		writeV2Error(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := s.mgr.ExtendInstance(cmgr.InstanceId(instance), ttl); err != nil {
+		writeV2Error(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	meta, err := s.mgr.GetInstanceMetadata(cmgr.InstanceId(instance))
+	if err != nil {
+		writeV2Error(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, meta)
+}
+
+// The parts of the manager used by the reaper.
+type expiringInstances interface {
+	ExpiredInstances() ([]cmgr.InstanceId, error)
+	Stop(instance cmgr.InstanceId) error
+}
+
+// Periodically stops instances whose TTL has run out.  Stopping goes through
+// the ordinary Stop call so that expiry behaves exactly like a front end
+// stopping the instance itself.
+type reaper struct {
+	mgr      expiringInstances
+	interval time.Duration
+	stops    *metrics.CounterVec
+}
+
+func newReaper(mgr expiringInstances, registry *metrics.Registry, interval time.Duration) *reaper {
+	return &reaper{
+		mgr:      mgr,
+		interval: interval,
+		stops: registry.NewCounterVec(
+			"cmgrd_expired_instance_stops_total",
+			"Attempts by the reaper to stop an expired instance, by result.",
+			"result",
+		),
+	}
+}
+
+func (rp *reaper) run() {
+	ticker := time.NewTicker(rp.interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		rp.reap()
+	}
+}
+
+func (rp *reaper) reap() {
+	expired, err := rp.mgr.ExpiredInstances()
+	if err != nil {
+		log.Printf("reaper: could not list expired instances: %v", err)
+		return
+	}
+	for _, instance := range expired {
+		err := rp.mgr.Stop(instance)
+		var unknown *cmgr.UnknownIdentifierError
+		switch {
+		case err == nil:
+			log.Printf("reaper: stopped expired instance %d", instance)
+			rp.stops.Inc("stopped")
+		case errors.As(err, &unknown):
+			// Stopped by someone else since it was listed.
+		default:
+			log.Printf("reaper: could not stop expired instance %d: %v", instance, err)
+			rp.stops.Inc("failed")
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ArmyCyberInstitute/cmgr/cmgr"
+	"github.com/ArmyCyberInstitute/cmgr/internal/metrics"
+)
+
+type fakeExpiringInstances struct {
+	expired []cmgr.InstanceId
+	stopErr map[cmgr.InstanceId]error
+	stopped []cmgr.InstanceId
+}
+
+func (f *fakeExpiringInstances) ExpiredInstances() ([]cmgr.InstanceId, error) {
+	return f.expired, nil
+}
+
+func (f *fakeExpiringInstances) Stop(instance cmgr.InstanceId) error {
+	f.stopped = append(f.stopped, instance)
+	return f.stopErr[instance]
+}
+
+func TestReaperStopsExpiredInstancesAndCountsResults(t *testing.T) {
+	mgr := &fakeExpiringInstances{
+		expired: []cmgr.InstanceId{1, 2, 3},
+		stopErr: map[cmgr.InstanceId]error{
+			2: errors.New("docker is unavailable"),
+			3: &cmgr.UnknownIdentifierError{Type: "instance", Name: "3"},
+		},
+	}
+	registry := metrics.NewRegistry()
+	newReaper(mgr, registry, 0).reap()
+
+	if len(mgr.stopped) != 3 {
+		t.Fatalf("not every expired instance was stopped: %v", mgr.stopped)
+	}
+	var output strings.Builder
+	if err := registry.WriteText(&output); err != nil {
+		t.Fatal(err)
+	}
+	expected := "cmgrd_expired_instance_stops_total{result=\"failed\"} 1\n" +
+		"cmgrd_expired_instance_stops_total{result=\"stopped\"} 1\n"
+	if !strings.Contains(output.String(), expected) {
+		t.Fatalf("unexpected metrics:\n%s", output.String())
+	}
+}
+
+func TestExtendHandlersRejectInvalidRequests(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		status int
+	}{
+		{http.MethodGet, "/instances/1/extend?ttl=1h", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/instances/one/extend?ttl=1h", http.StatusBadRequest},
+		{http.MethodPost, "/instances/1/extend", http.StatusBadRequest},
+		{http.MethodPost, "/instances/1/extend?ttl=soon", http.StatusBadRequest},
+		{http.MethodPost, "/v2/instances/1/extend", http.StatusBadRequest},
+		{http.MethodPost, "/v2/builds/1?ttl=soon", http.StatusBadRequest},
+	}
+	v2 := state{}.v2Handler()
+	for _, test := range tests {
+		t.Run(test.method+" "+test.path, func(t *testing.T) {
+			request := httptest.NewRequest(test.method, test.path, nil)
+			response := httptest.NewRecorder()
+			if strings.HasPrefix(test.path, "/v2/") {
+				v2.ServeHTTP(response, request)
+			} else {
+				state{}.instanceHandler(response, request)
+			}
+			if response.Code != test.status {
+				t.Fatalf("unexpected status %d: %s", response.Code, response.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"time"
+
+	"github.com/ArmyCyberInstitute/cmgr/cmgr"
+)
+
+// How long a client has to complete its TLS handshake, or when passing TLS
+// through, to send its ClientHello.
+const gatewayHandshakeTimeout = 10 * time.Second
+
+// How long the gateway waits to connect to an instance's container.
+const gatewayDialTimeout = 10 * time.Second
+
+// The parts of the manager used by the TLS gateway.
+type gatewayTargets interface {
+	GatewayTarget(host string) (string, error)
+}
+
+var errClientHelloRead = errors.New("client hello read")
+
+// Routes TLS connections for `<instance>-<port>.<domain>` to the TCP port of
+// the instance by their SNI hostname, so that every raw TCP challenge can
+// share one public port.  With a certificate, the gateway terminates TLS and
+// the challenge sees plain TCP; without one, the TLS stream is passed through
+// unchanged for the challenge to terminate itself.
+type tlsGateway struct {
+	targets *targetCache
+	config  *tls.Config
+	dialer  net.Dialer
+}
+
+func newTLSGateway(mgr gatewayTargets, config *tls.Config) *tlsGateway {
+	return &tlsGateway{
+		targets: newTargetCache(mgr.GatewayTarget),
+		config:  config,
+		dialer:  net.Dialer{Timeout: gatewayDialTimeout},
+	}
+}
+
+func (g *tlsGateway) serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return err
+		}
+		if err != nil {
+			log.Printf("gateway: could not accept connection: %v", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		go g.handle(conn)
+	}
+}
+
+func (g *tlsGateway) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(gatewayHandshakeTimeout))
+
+	var serverName, address string
+	var resolveErr error
+	resolve := func(hello *tls.ClientHelloInfo) {
+		serverName = hello.ServerName
+		address, resolveErr = g.targets.lookup(serverName)
+	}
+
+	client := conn
+	var hello []byte
+	if g.config != nil {
+		config := g.config.Clone()
+		config.GetConfigForClient = func(info *tls.ClientHelloInfo) (*tls.Config, error) {
+			// Unknown hostnames fail the handshake before a certificate is
+			// offered for them.
+			resolve(info)
+			return nil, resolveErr
+		}
+		tlsConn := tls.Server(conn, config)
+		if err := tlsConn.Handshake(); err != nil {
+			g.logResolveError(serverName, resolveErr)
+			return
+		}
+		client = tlsConn
+	} else {
+		recorder := &helloRecorder{Conn: conn}
+		err := tls.Server(recorder, &tls.Config{
+			GetConfigForClient: func(info *tls.ClientHelloInfo) (*tls.Config, error) {
+				resolve(info)
+				return nil, errClientHelloRead
+			},
+		}).Handshake()
+		if serverName == "" && err != nil {
+			return
+		}
+		hello = recorder.read.Bytes()
+	}
+	if resolveErr != nil {
+		g.logResolveError(serverName, resolveErr)
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	backend, err := g.dialer.Dial("tcp", address)
+	if err != nil {
+		// The instance may have been reset or restarted with a new address.
+		g.targets.forget(serverName)
+		log.Printf("gateway: could not reach %q at %s: %v", serverName, address, err)
+		return
+	}
+	defer backend.Close()
+	if _, err := backend.Write(hello); err != nil {
+		return
+	}
+	pipeConnections(client, backend)
+}
+
+func (g *tlsGateway) logResolveError(serverName string, err error) {
+	var unknown *cmgr.UnknownIdentifierError
+	var conflict *cmgr.ConflictError
+	if err != nil && !errors.As(err, &unknown) && !errors.As(err, &conflict) {
+		log.Printf("gateway: could not resolve %q: %v", serverName, err)
+	}
+}
+
+// Keeps a copy of what the client sends so that a ClientHello parsed to find
+// its SNI hostname can still be forwarded to the instance.  Nothing is ever
+// written back to the client.
+type helloRecorder struct {
+	net.Conn
+	read bytes.Buffer
+}
+
+func (r *helloRecorder) Read(p []byte) (int, error) {
+	n, err := r.Conn.Read(p)
+	r.read.Write(p[:n])
+	return n, err
+}
+
+func (r *helloRecorder) Write(p []byte) (int, error) {
+	return 0, io.ErrClosedPipe
+}
+
+// Copies data both ways until each side has finished sending.
+func pipeConnections(client net.Conn, backend net.Conn) {
+	done := make(chan struct{})
+	go func() {
+		io.Copy(backend, client)
+		closeWrite(backend)
+		close(done)
+	}()
+	io.Copy(client, backend)
+	closeWrite(client)
+	<-done
+}
+
+func closeWrite(conn net.Conn) {
+	if halfCloser, ok := conn.(interface{ CloseWrite() error }); ok {
+		halfCloser.CloseWrite()
+		return
+	}
+	conn.Close()
+}
+
+func serveTLSGateway(address string, gateway *tlsGateway) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Fatal(gateway.serve(listener))
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/ArmyCyberInstitute/cmgr/cmgr"
+)
+
+type fakeGatewayTargets map[string]string
+
+func (f fakeGatewayTargets) GatewayTarget(host string) (string, error) {
+	if address, ok := f[host]; ok {
+		return address, nil
+	}
+	return "", &cmgr.UnknownIdentifierError{Type: "gateway host", Name: host}
+}
+
+func testServerConfig(t *testing.T) *tls.Config {
+	t.Helper()
+	certPath, keyPath := writeTestCertificate(t, t.TempDir())
+	config, err := listenerOptions{tlsCert: certPath, tlsKey: keyPath}.tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+// Serves a line-echo service, optionally over TLS, and returns its address.
+func startEchoBackend(t *testing.T, config *tls.Config) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config != nil {
+		listener = tls.NewListener(listener, config)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func startTestGateway(t *testing.T, gateway *tlsGateway) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go gateway.serve(listener)
+	return listener.Addr().String()
+}
+
+func requireEcho(t *testing.T, address string, serverName string) {
+	t.Helper()
+	conn, err := tls.Dial("tcp", address, &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		t.Fatalf("%s: %v", serverName, err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("flag?\n")); err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || line != "flag?\n" {
+		t.Fatalf("%s: echoed %q, %v", serverName, line, err)
+	}
+}
+
+func TestTLSGatewayTerminatesTLS(t *testing.T) {
+	backend := startEchoBackend(t, nil)
+	config := testServerConfig(t)
+	gateway := startTestGateway(t, newTLSGateway(
+		fakeGatewayTargets{"1-pwn.ctf.test": backend},
+		config,
+	))
+
+	requireEcho(t, gateway, "1-pwn.ctf.test")
+	conn, err := tls.Dial("tcp", gateway, &tls.Config{
+		ServerName:         "2-pwn.ctf.test",
+		InsecureSkipVerify: true,
+	})
+	if err == nil {
+		conn.Close()
+		t.Fatal("handshake for an unknown instance succeeded")
+	}
+}
+
+func TestTLSGatewayPassesTLSThrough(t *testing.T) {
+	backend := startEchoBackend(t, testServerConfig(t))
+	gateway := startTestGateway(t, newTLSGateway(
+		fakeGatewayTargets{"1-crypto.ctf.test": backend},
+		nil,
+	))
+
+	requireEcho(t, gateway, "1-crypto.ctf.test")
+	if _, err := tls.Dial("tcp", gateway, &tls.Config{
+		ServerName:         "1-pwn.ctf.test",
+		InsecureSkipVerify: true,
+	}); err == nil {
+		t.Fatal("connection to an unknown instance succeeded")
+	}
+}
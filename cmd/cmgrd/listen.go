@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strconv"
+)
+
+type listenerOptions struct {
+	address      string
+	port         int
+	unixSocket   string
+	socketMode   string
+	tlsCert      string
+	tlsKey       string
+	tlsClientCAs string
+}
+
+func (opts listenerOptions) validate() error {
+	if (opts.tlsCert == "") != (opts.tlsKey == "") {
+		return errors.New("--tls-cert and --tls-key must be given together")
+	}
+	if opts.tlsClientCAs != "" && opts.tlsCert == "" {
+		return errors.New("--tls-client-ca requires --tls-cert and --tls-key")
+	}
+	if opts.unixSocket != "" && opts.address != "" {
+		return errors.New("--unix-socket and --address are mutually exclusive")
+	}
+	return nil
+}
+
+// Builds the server TLS configuration or returns nil when TLS is disabled.
+// Supplying client CAs turns on mandatory client-certificate verification.
+func (opts listenerOptions) tlsConfig() (*tls.Config, error) {
+	if opts.tlsCert == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(opts.tlsCert, opts.tlsKey)
+	if err != nil {
+		return nil, fmt.Errorf("could not load TLS key pair: %w", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if opts.tlsClientCAs != "" {
+		pemData, err := os.ReadFile(opts.tlsClientCAs)
+		if err != nil {
+			return nil, fmt.Errorf("could not read client CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pemData) {
+			return nil, fmt.Errorf(
+				"no PEM certificates found in client CA file %q",
+				opts.tlsClientCAs,
+			)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// Opens either the TCP listener or the Unix-domain socket and wraps it with
+// TLS when it is configured.
+func (opts listenerOptions) listen() (net.Listener, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	config, err := opts.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	var listener net.Listener
+	if opts.unixSocket != "" {
+		listener, err = listenUnix(opts.unixSocket, opts.socketMode)
+	} else {
+		listener, err = net.Listen(
+			"tcp",
+			net.JoinHostPort(opts.address, strconv.Itoa(opts.port)),
+		)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if config != nil {
+		listener = tls.NewListener(listener, config)
+	}
+	return listener, nil
+}
+
+// Creates a Unix-domain socket with the requested permissions.  A stale
+// socket left behind by a previous cmgrd is replaced, but any other kind of
+// file at the path is left alone.
+func listenUnix(path string, mode string) (net.Listener, error) {
+	perm, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || perm > 0777 {
+		return nil, fmt.Errorf("invalid socket mode %q: expected octal permissions", mode)
+	}
+
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode().Type() != fs.ModeSocket {
+			return nil, fmt.Errorf("refusing to replace non-socket file %q", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("could not remove stale socket: %w", err)
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, fs.FileMode(perm)); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("could not set socket permissions: %w", err)
+	}
+	return listener, nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTestCertificate(t *testing.T, dir string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "cmgrd-test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage: []x509.ExtKeyUsage{
+			x509.ExtKeyUsageServerAuth,
+			x509.ExtKeyUsageClientAuth,
+		},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "key.pem")
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err := os.WriteFile(certPath, certPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyPath, keyPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certPath, keyPath
+}
+
+func TestListenerOptionsRejectInconsistentFlags(t *testing.T) {
+	for name, opts := range map[string]listenerOptions{
+		"cert without key": {tlsCert: "cert.pem"},
+		"key without cert": {tlsKey: "key.pem"},
+		"client ca only":   {tlsClientCAs: "ca.pem"},
+		"socket and addr":  {unixSocket: "cmgrd.sock", address: "127.0.0.1"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if err := opts.validate(); err == nil {
+				t.Fatal("inconsistent listener options were accepted")
+			}
+		})
+	}
+}
+
+func TestListenUnixAppliesModeAndReplacesOnlyStaleSockets(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cmgrd.sock")
+
+	first, err := listenUnix(path, "0600")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Fatalf("socket has mode %o", perm)
+	}
+	// Simulate a socket left behind by an unclean shutdown.
+	first.(*net.UnixListener).SetUnlinkOnClose(false)
+	first.Close()
+
+	second, err := listenUnix(path, "0660")
+	if err != nil {
+		t.Fatalf("stale socket was not replaced: %v", err)
+	}
+	second.Close()
+
+	regular := filepath.Join(dir, "regular")
+	if err := os.WriteFile(regular, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listenUnix(regular, "0660"); err == nil {
+		t.Fatal("regular file was replaced by a socket")
+	}
+	if _, err := listenUnix(filepath.Join(dir, "bad"), "rw"); err == nil {
+		t.Fatal("invalid socket mode was accepted")
+	}
+}
+
+func TestTLSListenerRequiresClientCertificateWhenConfigured(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := writeTestCertificate(t, dir)
+	opts := listenerOptions{
+		address:      "127.0.0.1",
+		tlsCert:      certPath,
+		tlsKey:       keyPath,
+		tlsClientCAs: certPath,
+	}
+	listener, err := opts.listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	pemData, err := os.ReadFile(certPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(pemData)
+	clientCert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dial := func(certs []tls.Certificate) error {
+		conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{
+			RootCAs:      roots,
+			Certificates: certs,
+		})
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		// TLS 1.3 reports client certificate rejection on the first read.
+		_, err = conn.Read(make([]byte, 1))
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		return err
+	}
+
+	if err := dial(nil); err == nil {
+		t.Fatal("connection without a client certificate was accepted")
+	}
+	if err := dial([]tls.Certificate{clientCert}); err != nil {
+		t.Fatalf("connection with a client certificate failed: %v", err)
+	}
+}
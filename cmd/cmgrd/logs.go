@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ArmyCyberInstitute/cmgr/cmgr"
+)
+
+// Comments sent while a log is idle keep proxies from closing the stream
+// during long, quiet build steps.
+const logKeepaliveInterval = 15 * time.Second
+
+// Logs are followed unless the client asks for only the current contents
+// with "follow=false".
+func followLog(r *http.Request) (bool, error) {
+	value := r.URL.Query().Get("follow")
+	if value == "" {
+		return true, nil
+	}
+	return strconv.ParseBool(value)
+}
+
+func (s state) buildLogHandler(w http.ResponseWriter, r *http.Request, build cmgr.BuildId) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	follow, err := followLog(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	output, err := s.mgr.BuildLog(r.Context(), build, follow)
+	if err != nil {
+		writeError(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	writeLogEvents(w, r, output)
+}
+
+func (s state) checkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	path := strings.Split(r.URL.Path, "/")
+	pathLen := len(path)
+	if (pathLen != 4 && pathLen != 6) ||
+		path[1] != "instances" ||
+		path[3] != "checks" ||
+		(pathLen == 6 && path[5] != "log") {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	instInt, err := strconv.Atoi(path[2])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	instance := cmgr.InstanceId(instInt)
+
+	if pathLen == 4 {
+		checks, err := s.mgr.ListInstanceChecks(instance)
+		if err != nil {
+			writeError(w, errorStatus(err, http.StatusInternalServerError), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, checks)
+		return
+	}
+
+	check, err := strconv.Atoi(path[4])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	follow, err := followLog(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	output, err := s.mgr.CheckLog(r.Context(), instance, check, follow)
+	if err != nil {
+		writeError(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	writeLogEvents(w, r, output)
+}
+
+// The options of a request for an instance's container logs.
+type instanceLogRequest struct {
+	host   string
+	tail   int
+	since  time.Time
+	follow bool
+}
+
+// Reads the "host", "tail", "since", and "follow" query parameters.  Since is
+// either an RFC 3339 time or a Go duration such as "10m" before now.
+func parseInstanceLogRequest(r *http.Request) (instanceLogRequest, error) {
+	query := r.URL.Query()
+	request := instanceLogRequest{host: query.Get("host")}
+	var err error
+	if value := query.Get("tail"); value != "" {
+		request.tail, err = strconv.Atoi(value)
+		if err != nil || request.tail < 0 {
+			return request, invalidQuery("tail must be a non-negative integer")
+		}
+	}
+	if value := query.Get("since"); value != "" {
+		if ago, err := time.ParseDuration(value); err == nil {
+			request.since = time.Now().Add(-ago)
+		} else if request.since, err = time.Parse(time.RFC3339, value); err != nil {
+			return request, invalidQuery("since must be an RFC 3339 time or a duration")
+		}
+	}
+	if request.follow, err = followLog(r); err != nil {
+		return request, invalidQuery("follow must be a boolean")
+	}
+	return request, nil
+}
+
+func (s state) instanceLogHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	path := strings.Split(r.URL.Path, "/")
+	instInt, err := strconv.Atoi(path[2])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	request, err := parseInstanceLogRequest(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	output, err := s.mgr.InstanceLogs(
+		r.Context(),
+		cmgr.InstanceId(instInt),
+		request.host,
+		request.tail,
+		request.since,
+		request.follow,
+	)
+	if err != nil {
+		writeError(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	writeLogEvents(w, r, output)
+}
+
+func (s state) v2InstanceLog(w http.ResponseWriter, r *http.Request) {
+	instance, err := pathInt(r, "instance")
+	if err != nil {
+		writeV2Error(w, http.StatusBadRequest, err)
+		return
+	}
+	request, err := parseInstanceLogRequest(r)
+	if err != nil {
+		writeV2Error(w, http.StatusBadRequest, err)
+		return
+	}
+	output, err := s.mgr.InstanceLogs(
+		r.Context(),
+		cmgr.InstanceId(instance),
+		request.host,
+		request.tail,
+		request.since,
+		request.follow,
+	)
+	if err != nil {
+		writeV2Error(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	writeLogEvents(w, r, output)
+}
+
+// Streams a captured log as Server-Sent Events with one event per line of
+// output.  An "end" event marks the completed log so that clients know not
+// to reconnect.
+func writeLogEvents(w http.ResponseWriter, r *http.Request, output io.ReadCloser) {
+	defer output.Close()
+	flusher, _ := w.(http.Flusher)
+	flush := func() {
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flush()
+
+	lines := make(chan string)
+	done := make(chan error, 1)
+	go func() {
+		reader := bufio.NewReader(output)
+		for {
+			line, err := reader.ReadString('\n')
+			if line != "" {
+				select {
+				case lines <- line:
+				case <-r.Context().Done():
+					done <- r.Context().Err()
+					return
+				}
+			}
+			if err != nil {
+				done <- err
+				return
+			}
+		}
+	}()
+
+	keepalive := time.NewTicker(logKeepaliveInterval)
+	defer keepalive.Stop()
+	for {
+		select {
+		case line := <-lines:
+			writeLogEvent(w, line)
+		case err := <-done:
+			if !errors.Is(err, io.EOF) {
+				if r.Context().Err() == nil {
+					log.Printf("log stream failed: %v", err)
+				}
+				return
+			}
+			fmt.Fprint(w, "event: end\ndata: \n\n")
+			flush()
+			return
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case <-r.Context().Done():
+			return
+		}
+		flush()
+	}
+}
+
+// Event streams treat a carriage return as a line break, so the progress
+// output that uses them is split into separate data lines.
+func writeLogEvent(w io.Writer, line string) {
+	line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+	var event strings.Builder
+	for _, part := range strings.Split(line, "\r") {
+		event.WriteString("data: ")
+		event.WriteString(part)
+		event.WriteString("\n")
+	}
+	event.WriteString("\n")
+	_, _ = io.WriteString(w, event.String())
+}
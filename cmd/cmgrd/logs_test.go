@@ -0,0 +1,118 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteLogEventsSendsLinesAndEndEvent(t *testing.T) {
+	request := httptest.NewRequest(http.MethodGet, "/builds/1/log", nil)
+	response := httptest.NewRecorder()
+	output := io.NopCloser(strings.NewReader("step one\nprogress 50%\rprogress 100%\nno newline"))
+	writeLogEvents(response, request, output)
+
+	if contentType := response.Header().Get("Content-Type"); contentType != "text/event-stream" {
+		t.Fatalf("unexpected content type %q", contentType)
+	}
+	expected := "data: step one\n\n" +
+		"data: progress 50%\ndata: progress 100%\n\n" +
+		"data: no newline\n\n" +
+		"event: end\ndata: \n\n"
+	if body := response.Body.String(); body != expected {
+		t.Fatalf("unexpected event stream %q", body)
+	}
+}
+
+func TestCheckHandlerRejectsInvalidRequests(t *testing.T) {
+	for path, status := range map[string]int{
+		"/instances/1/checks/1/tail":             http.StatusNotFound,
+		"/instances/1/checks/1":                  http.StatusNotFound,
+		"/instances/x/checks":                    http.StatusBadRequest,
+		"/instances/1/checks/x/log":              http.StatusBadRequest,
+		"/instances/1/checks/1/log?follow=maybe": http.StatusBadRequest,
+	} {
+		t.Run(path, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, path, nil)
+			response := httptest.NewRecorder()
+			state{}.instanceHandler(response, request)
+			if response.Code != status {
+				t.Fatalf("expected status %d, got %d", status, response.Code)
+			}
+		})
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/instances/1/checks", nil)
+	response := httptest.NewRecorder()
+	state{}.instanceHandler(response, request)
+	if response.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("unexpected status %d", response.Code)
+	}
+}
+
+func TestParseInstanceLogRequest(t *testing.T) {
+	before := time.Now()
+	request, err := parseInstanceLogRequest(httptest.NewRequest(
+		http.MethodGet,
+		"/instances/1/logs?host=web&tail=50&since=10m&follow=false",
+		nil,
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if request.host != "web" || request.tail != 50 || request.follow {
+		t.Fatalf("unexpected request: %#v", request)
+	}
+	if request.since.Before(before.Add(-10*time.Minute)) ||
+		request.since.After(time.Now().Add(-10*time.Minute)) {
+		t.Fatalf("since %s is not ten minutes ago", request.since)
+	}
+
+	request, err = parseInstanceLogRequest(httptest.NewRequest(
+		http.MethodGet,
+		"/instances/1/logs?since=2024-05-01T12:00:00Z",
+		nil,
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !request.since.Equal(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)) || !request.follow {
+		t.Fatalf("unexpected request: %#v", request)
+	}
+}
+
+func TestInstanceLogHandlersRejectInvalidRequests(t *testing.T) {
+	v2 := state{}.v2Handler()
+	for path, status := range map[string]int{
+		"/instances/x/logs":              http.StatusBadRequest,
+		"/instances/1/logs?tail=-1":      http.StatusBadRequest,
+		"/instances/1/logs?tail=many":    http.StatusBadRequest,
+		"/instances/1/logs?since=later":  http.StatusBadRequest,
+		"/instances/1/logs?follow=maybe": http.StatusBadRequest,
+		"/v2/instances/x/logs":           http.StatusBadRequest,
+		"/v2/instances/1/logs?since=y":   http.StatusBadRequest,
+	} {
+		t.Run(path, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, path, nil)
+			response := httptest.NewRecorder()
+			if strings.HasPrefix(path, "/v2/") {
+				v2.ServeHTTP(response, request)
+			} else {
+				state{}.instanceHandler(response, request)
+			}
+			if response.Code != status {
+				t.Fatalf("expected status %d, got %d", status, response.Code)
+			}
+		})
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/instances/1/logs", nil)
+	response := httptest.NewRecorder()
+	state{}.instanceHandler(response, request)
+	if response.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("unexpected status %d", response.Code)
+	}
+}
@@ -22,17 +22,42 @@ import (
 type state struct {
 	mgr             *cmgr.Manager
 	maxRequestBytes int64
+	httpMetrics     *httpMetrics
 }
 
 var artifact_dir string
 
 func main() {
-	var iface string
-	var port int
+	var listenOpts listenerOptions
+	var tokenFile string
+	var reapInterval time.Duration
+	var poolInterval time.Duration
+	var checkInterval time.Duration
+	var checkSample int
+	var checkConcurrency int
+	var proxyAddress string
+	var gatewayAddress string
+	var gatewayCert string
+	var gatewayKey string
 	var help bool
 	var version bool
-	flag.IntVar(&port, "port", 4200, "listening port for cmgrd")
-	flag.StringVar(&iface, "address", "", "listening address for cmgrd")
+	flag.IntVar(&listenOpts.port, "port", 4200, "listening port for cmgrd")
+	flag.StringVar(&listenOpts.address, "address", "", "listening address for cmgrd")
+	flag.StringVar(&listenOpts.unixSocket, "unix-socket", "", "path of a Unix-domain socket to listen on instead of TCP")
+	flag.StringVar(&listenOpts.socketMode, "socket-mode", "0660", "octal permissions for the Unix-domain socket")
+	flag.StringVar(&listenOpts.tlsCert, "tls-cert", "", "PEM certificate chain for serving TLS")
+	flag.StringVar(&listenOpts.tlsKey, "tls-key", "", "PEM private key for serving TLS")
+	flag.StringVar(&listenOpts.tlsClientCAs, "tls-client-ca", "", "PEM CA bundle used to require client certificates")
+	flag.StringVar(&tokenFile, "token-file", "", "file of scoped bearer tokens")
+	flag.DurationVar(&reapInterval, "reap-interval", time.Minute, "how often to stop expired instances (0 disables)")
+	flag.DurationVar(&poolInterval, "pool-interval", time.Minute, "how often to top up warm pools (0 disables)")
+	flag.DurationVar(&checkInterval, "check-interval", 0, "how often to run solvers against running instances (0 disables)")
+	flag.IntVar(&checkSample, "check-sample", 0, "instances checked each round (0 checks all)")
+	flag.IntVar(&checkConcurrency, "check-concurrency", 2, "solvers run at once by scheduled checks")
+	flag.StringVar(&proxyAddress, "proxy-address", "", "listening address for the HTTP reverse proxy to challenge instances (empty disables)")
+	flag.StringVar(&gatewayAddress, "gateway-address", "", "listening address for the TLS gateway to challenge instances (empty disables)")
+	flag.StringVar(&gatewayCert, "gateway-cert", "", "PEM certificate chain with which the TLS gateway terminates TLS")
+	flag.StringVar(&gatewayKey, "gateway-key", "", "PEM private key matching --gateway-cert")
 	flag.BoolVar(&help, "help", false, "display usage information")
 	flag.BoolVar(&version, "version", false, "display version information")
 	flag.Parse()
@@ -47,16 +72,67 @@ func main() {
 		os.Exit(0)
 	}
 
+	if err := listenOpts.validate(); err != nil {
+		log.Fatal(err)
+	}
+	if (gatewayCert == "") != (gatewayKey == "") {
+		log.Fatal("--gateway-cert and --gateway-key must be given together")
+	}
+	gatewayConfig, err := listenerOptions{tlsCert: gatewayCert, tlsKey: gatewayKey}.tlsConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if reapInterval < 0 {
+		log.Fatal("--reap-interval must not be negative")
+	}
+	if poolInterval < 0 {
+		log.Fatal("--pool-interval must not be negative")
+	}
+	if checkInterval < 0 {
+		log.Fatal("--check-interval must not be negative")
+	}
+	if checkSample < 0 {
+		log.Fatal("--check-sample must not be negative")
+	}
+	if checkConcurrency < 1 {
+		log.Fatal("--check-concurrency must be at least 1")
+	}
+
+	var auth *tokenAuth
+	if tokenFile != "" {
+		var err error
+		auth, err = loadTokenFile(tokenFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		log.Print("warning: no --token-file given; the API is unauthenticated")
+	}
+
 	artifact_dir, _ = os.LookupEnv(cmgr.ARTIFACT_DIR_ENV)
 	if artifact_dir == "" {
 		artifact_dir = "."
 	}
-	mgr := cmgr.NewManager(cmgr.INFO)
+	mgr := cmgr.NewDaemonManager(cmgr.INFO)
 	if mgr == nil {
 		log.Fatal("failed to initialize cmgr library")
 	}
+	if checkInterval > 0 && !mgr.ChecksAvailable() {
+		log.Fatal("--check-interval cannot be used on Kubernetes, which cannot run solvers")
+	}
+
+	if proxyAddress != "" && mgr.ProxyURL() == nil {
+		log.Fatal("--proxy-address requires CMGR_PROXY_URL")
+	}
+	if gatewayAddress != "" && mgr.TLSGateway() == "" {
+		log.Fatal("--gateway-address requires CMGR_TLS_GATEWAY")
+	}
 
-	s := state{mgr: mgr, maxRequestBytes: mgr.MaxRequestBytes()}
+	s := state{
+		mgr:             mgr,
+		maxRequestBytes: mgr.MaxRequestBytes(),
+		httpMetrics:     newHTTPMetrics(),
+	}
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/challenges", s.listHandler)
@@ -65,17 +141,48 @@ func main() {
 	mux.HandleFunc("/instances/", s.instanceHandler)
 	mux.HandleFunc("/schemas", s.schemaHandler)
 	mux.HandleFunc("/schemas/", s.existingSchemaHandler)
+	mux.HandleFunc("/jobs/", s.jobHandler)
+	mux.HandleFunc("/solvability/", s.solvabilityHandler)
+	mux.HandleFunc("/capacity", s.capacityHandler)
+	mux.HandleFunc("/metrics", s.metricsHandler)
+	mux.Handle("/v2/", s.v2Handler())
+
+	var handler http.Handler = mux
+	if auth != nil {
+		handler = auth.wrap(mux)
+	}
+	handler = s.httpMetrics.instrument(mux, handler)
+
+	if reapInterval > 0 {
+		go newReaper(mgr, s.httpMetrics.registry, reapInterval).run()
+	}
+	if poolInterval > 0 {
+		go newPoolRefiller(mgr, poolInterval).run()
+	}
+	if checkInterval > 0 {
+		go newCheckScheduler(mgr, checkInterval, checkSample, checkConcurrency).run()
+	}
+
+	if proxyAddress != "" {
+		go serveInstanceProxy(proxyAddress, newInstanceProxy(mgr))
+	}
+	if gatewayAddress != "" {
+		go serveTLSGateway(gatewayAddress, newTLSGateway(mgr, gatewayConfig))
+	}
+
+	listener, err := listenOpts.listen()
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	connStr := fmt.Sprintf("%s:%d", iface, port)
 	server := &http.Server{
-		Addr:              connStr,
-		Handler:           mux,
+		Handler:           handler,
 		ReadHeaderTimeout: 10 * time.Second,
 		ReadTimeout:       30 * time.Second,
 		IdleTimeout:       60 * time.Second,
 		MaxHeaderBytes:    64 * 1024,
 	}
-	log.Fatal(server.ListenAndServe())
+	log.Fatal(server.Serve(listener))
 }
 
 func (s state) decodeJSON(
@@ -132,10 +239,55 @@ func errorStatus(err error, fallback int) int {
 func printUsage() {
 	fmt.Printf(`
 Usage: %s [<options>]
-  --address  the network address to listen on (default: 0.0.0.0)
-  --port     the port to listen on (default: 4200)
-  --help     display this message
-  --version  display version information and exit
+  --address     the network address to listen on (default: 0.0.0.0)
+  --port        the port to listen on (default: 4200)
+  --unix-socket listen on the given Unix-domain socket path instead of TCP
+  --socket-mode octal permissions applied to the Unix-domain socket
+                (default: 0660)
+  --tls-cert    PEM certificate chain used to serve HTTPS
+  --tls-key     PEM private key matching --tls-cert
+  --tls-client-ca
+                PEM CA bundle; when given, clients must present a
+                certificate signed by one of these CAs (mutual TLS)
+  --token-file  file of bearer tokens, one '<scope> <token>' pair per line;
+                scopes are 'read-only', 'instance-operator', and 'admin'
+                (default: no authentication)
+  --reap-interval
+                how often to stop instances whose TTL has run out, as a
+                duration such as '30s' or '5m'; '0' disables expiry
+                (default: 1m)
+  --pool-interval
+                how often to top up the warm pools of dynamic builds, which
+                claims otherwise refill as they empty them; '0' leaves pools
+                to be refilled only by claims (default: 1m)
+  --check-interval
+                how often to run the solvers of running instances in the
+                background, as a duration such as '15m'; results are
+                reported by the solvability endpoints; not available with
+                CMGR_KUBERNETES_CONFIG (default: 0, disabled)
+  --check-sample
+                the number of randomly chosen instances checked each round;
+                '0' checks every instance (default: 0)
+  --check-concurrency
+                the most solvers that scheduled checks run at once
+                (default: 2)
+  --proxy-address
+                the address, such as ':8080', on which to serve the HTTP
+                reverse proxy that routes '<instance>-<port>.<domain>' to
+                the challenge ports listed in 'http_ports'; requires
+                CMGR_PROXY_URL (default: disabled)
+  --gateway-address
+                the address, such as ':443', on which to serve the TLS
+                gateway that routes connections by their SNI hostname,
+                '<instance>-<port>.<domain>', to the challenge ports listed
+                in 'tls_ports'; requires CMGR_TLS_GATEWAY (default: disabled)
+  --gateway-cert
+                PEM certificate chain, normally for '*.<domain>', with which
+                the TLS gateway terminates TLS and forwards plain TCP; without
+                it, TLS is passed through for challenges to terminate
+  --gateway-key PEM private key matching --gateway-cert
+  --help        display this message
+  --version     display version information and exit
 
 Relevant environment variables:
   CMGR_DB - path to cmgr's database file (defaults to 'cmgr.db')
@@ -144,6 +296,22 @@ Relevant environment variables:
 
   CMGR_ARTIFACT_DIR - directory for storing artifact bundles (defaults to '.')
 
+  CMGR_LOG_DIR - directory for storing captured build and solver output
+      (defaults to 'logs' inside the artifact directory)
+
+  CMGR_MAX_CHECK_LOGS - how many of the most recent solver check logs are
+      kept for each instance (defaults to 20)
+
+  CMGR_CAPTURE_DIR - directory for storing packet captures of challenge
+      networks (defaults to 'captures' inside the artifact directory)
+
+  CMGR_CAPTURE_IMAGE - the image run as the packet capture sidecar, which
+      must provide 'sh' and 'tcpdump' (defaults to 'nicolaka/netshoot')
+
+  CMGR_MAX_CAPTURE_FILE_BYTES, CMGR_MAX_CAPTURE_FILES - the size at which a
+      packet capture file is rotated and the number of files kept for each
+      instance across restarts of its capture (default to '10m' and 10)
+
   CMGR_LOGGING - controls the verbosity of the internal logging infrastructure
       and should be one of the following: debug, info, warn, error, or disabled
       (defaults to 'info')
@@ -156,7 +324,36 @@ Relevant environment variables:
   CMGR_INTERFACE - the host interface/address to which published challenge
       ports should be bound (defaults to '0.0.0.0'); if the specified interface
       does not exist on the host running the Docker daemon, Docker will silently
-      ignore this value and instead bind to the loopback address
+      ignore this value and instead bind to the loopback address; a
+      comma-separated list of IPv4 and IPv6 addresses publishes each port on
+      all of them and requires CMGR_PORTS
+
+  CMGR_ENABLE_IPV6 - when set, challenge networks are created with IPv6
+      enabled, which requires IPv6 address pools in the Docker daemon
+
+  CMGR_KUBERNETES_CONFIG - when set, instances run as Pods on the Kubernetes
+      cluster of this kubeconfig file, or of the cluster cmgr runs in when
+      empty; Docker still builds the images, which are pushed to the
+      required CMGR_REGISTRY for the nodes to pull; PID limits are not
+      available, so CMGR_DEFAULT_PIDS_LIMIT and CMGR_HOST_PIDS cannot be set
+
+  CMGR_DOCKER_NODES - when set, instances are spread across the Docker
+      daemons of this comma-separated list of 'name=host[=address]' entries,
+      such as 'a=tcp://10.0.0.2:2376,b=ssh://docker@10.0.0.3'; the first
+      node, which should be the local daemon, builds the images and runs
+      the instances that are captured, have an egress allowlist, or are
+      proxied, and players reach an instance at the address of its node,
+      which defaults to its host; requires CMGR_PORTS
+
+  CMGR_PROXY_URL - the public base URL of the reverse proxy, such as
+      'https://ctf.example.com'; when set, the ports that challenges list in
+      'http_ports' are not published on the host and are instead reached at
+      '<instance>-<port>' below its host, which needs a wildcard DNS record
+
+  CMGR_TLS_GATEWAY - the public address of the TLS gateway, such as
+      'ctf.example.com:443'; when set, the ports that challenges list in
+      'tls_ports' are not published on the host and are instead reached by
+      the SNI hostname '<instance>-<port>' below its host
 
   Note: The Docker client is configured via Docker's standard environment
       variables.  See https://docs.docker.com/engine/reference/commandline/cli/
@@ -194,6 +391,10 @@ func (s state) listHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	writeJSON(w, http.StatusOK, challengeListElements(challenges))
+}
+
+func challengeListElements(challenges []*cmgr.ChallengeMetadata) []ChallengeListElement {
 	respList := make([]ChallengeListElement, len(challenges))
 	for i, challenge := range challenges {
 		respList[i].Id = challenge.Id
@@ -203,7 +404,7 @@ func (s state) listHandler(w http.ResponseWriter, r *http.Request) {
 		respList[i].MetadataDigest = challenge.MetadataDigest
 		respList[i].SolveScript = challenge.SolveScript
 	}
-	writeJSON(w, http.StatusOK, respList)
+	return respList
 }
 
 type BuildChallengeRequest struct {
@@ -250,11 +451,21 @@ func (s state) challengeHandler(w http.ResponseWriter, r *http.Request) {
 			respCode = http.StatusBadRequest
 		}
 
+		if err == nil && buildReq.FlagFormat == "" {
+			buildReq.FlagFormat = "flag{%s}"
+		}
+
+		if err == nil && isAsync(r) {
+			var job cmgr.JobId
+			job, err = s.mgr.SubmitBuild(challenge, buildReq.Seeds, buildReq.FlagFormat)
+			if err == nil {
+				writeJobAccepted(w, "", job)
+				return
+			}
+		}
+
 		var builds []*cmgr.BuildMetadata
 		if err == nil {
-			if buildReq.FlagFormat == "" {
-				buildReq.FlagFormat = "flag{%s}"
-			}
 			builds, err = s.mgr.Build(challenge, buildReq.Seeds, buildReq.FlagFormat)
 		}
 
@@ -284,6 +495,27 @@ func (s state) buildHandler(w http.ResponseWriter, r *http.Request) {
 	path := strings.Split(r.URL.Path, "/")
 	pathLen := len(path)
 
+	if pathLen == 4 && path[1] == "builds" && path[3] == "log" {
+		buildInt, err := strconv.Atoi(path[2])
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		s.buildLogHandler(w, r, cmgr.BuildId(buildInt))
+		return
+	}
+
+	if pathLen == 4 && path[1] == "builds" && path[3] == "claim" &&
+		r.Method == http.MethodPost {
+		buildInt, err := strconv.Atoi(path[2])
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		s.claimHandler(w, r, cmgr.BuildId(buildInt))
+		return
+	}
+
 	if pathLen == 4 {
 		s.artifactsHandler(w, r)
 		return
@@ -313,8 +545,12 @@ func (s state) buildHandler(w http.ResponseWriter, r *http.Request) {
 			body, err = json.Marshal(meta)
 		}
 	case "POST":
+		var ttl []time.Duration
+		ttl, err = requestTTL(r)
 		var instance cmgr.InstanceId
-		instance, err = s.mgr.Start(build)
+		if err == nil {
+			instance, err = s.mgr.Start(build, ttl...)
+		}
 		respCode = http.StatusCreated
 
 		var iMeta *cmgr.InstanceMetadata
@@ -364,28 +600,40 @@ func (s state) artifactsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	build := cmgr.BuildId(buildInt)
+	s.serveArtifact(w, cmgr.BuildId(buildInt), path[pathLen-1], writeError)
+}
+
+// Reports a failed request; the v1 and v2 APIs format errors differently.
+type errorWriter func(w http.ResponseWriter, status int, err error)
+
+// Sends either the whole artifact bundle ("artifacts.tar.gz") or a single
+// file from within it.
+func (s state) serveArtifact(
+	w http.ResponseWriter,
+	build cmgr.BuildId,
+	name string,
+	fail errorWriter,
+) {
 	meta, err := s.mgr.GetBuildMetadata(build)
 	if err != nil {
-		writeError(w, errorStatus(err, http.StatusInternalServerError), err)
+		fail(w, errorStatus(err, http.StatusInternalServerError), err)
 		return
 	}
 	if !meta.HasArtifacts {
-		w.WriteHeader(http.StatusNotFound)
+		fail(w, http.StatusNotFound, fmt.Errorf("build %d has no artifacts", build))
 		return
 	}
 
 	f, err := os.Open(filepath.Join(artifact_dir, fmt.Sprintf("%d.tar.gz", build)))
 
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(err.Error()))
+		fail(w, http.StatusInternalServerError, err)
 		return
 	}
 
 	defer f.Close()
 
-	if path[pathLen-1] == "artifacts.tar.gz" {
+	if name == "artifacts.tar.gz" {
 		w.Header().Set("Content-Type", "application/gzip")
 		if _, err := io.Copy(w, f); err != nil {
 			log.Printf("artifact response failed: %v", err)
@@ -394,8 +642,7 @@ func (s state) artifactsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	srcGz, err := gzip.NewReader(f)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(err.Error()))
+		fail(w, http.StatusInternalServerError, err)
 		return
 	}
 
@@ -404,7 +651,7 @@ func (s state) artifactsHandler(w http.ResponseWriter, r *http.Request) {
 
 	var h *tar.Header
 	for h, err = srcTar.Next(); err == nil; h, err = srcTar.Next() {
-		if h.Name == path[pathLen-1] {
+		if h.Name == name {
 			w.Header().Set("Content-Type", "application/octet-stream")
 			if _, err := io.Copy(w, srcTar); err != nil {
 				log.Printf("artifact response failed: %v", err)
@@ -414,17 +661,41 @@ func (s state) artifactsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err == io.EOF {
-		w.WriteHeader(http.StatusNotFound)
+		fail(w, http.StatusNotFound, fmt.Errorf("build %d has no artifact %q", build, name))
 		return
 	}
 
-	w.WriteHeader(http.StatusInternalServerError)
-	w.Write([]byte(err.Error()))
+	fail(w, http.StatusInternalServerError, err)
 }
 
 func (s state) instanceHandler(w http.ResponseWriter, r *http.Request) {
 	path := strings.Split(r.URL.Path, "/")
 	pathLen := len(path)
+	if pathLen >= 4 && path[3] == "checks" {
+		s.checkHandler(w, r)
+		return
+	}
+	if pathLen >= 4 && path[3] == "captures" {
+		s.captureHandler(w, r)
+		return
+	}
+	if pathLen == 4 && path[1] == "instances" && path[3] == "extend" {
+		s.extendHandler(w, r)
+		return
+	}
+	if pathLen == 4 && path[1] == "instances" && path[3] == "stats" {
+		s.instanceStatsHandler(w, r)
+		return
+	}
+	if pathLen == 4 && path[1] == "instances" && path[3] == "logs" {
+		s.instanceLogHandler(w, r)
+		return
+	}
+	if pathLen == 4 && path[1] == "instances" &&
+		(path[3] == "pause" || path[3] == "resume" || path[3] == "reset") {
+		s.instanceActionHandler(w, r)
+		return
+	}
 	if len(path) < 2 || path[pathLen-2] != "instances" {
 		w.WriteHeader(http.StatusNotFound)
 		return
@@ -447,6 +718,7 @@ func (s state) instanceHandler(w http.ResponseWriter, r *http.Request) {
 		meta, err = s.mgr.GetInstanceMetadata(instance)
 		respCode = http.StatusOK
 		if err == nil {
+			s.mgr.InspectInstanceRuntime(meta)
 			body, err = json.Marshal(meta)
 		}
 	case "POST":
@@ -468,9 +740,61 @@ func (s state) instanceHandler(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write(body)
 }
 
+// Serves POST /instances/{id}/pause, /instances/{id}/resume, and
+// /instances/{id}/reset.
+func (s state) instanceActionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	path := strings.Split(r.URL.Path, "/")
+	instInt, err := strconv.Atoi(path[2])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	action := s.mgr.Pause
+	switch path[3] {
+	case "resume":
+		action = s.mgr.Resume
+	case "reset":
+		action = s.mgr.Reset
+	}
+	if err := action(cmgr.InstanceId(instInt)); err != nil {
+		writeError(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s state) instanceStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	path := strings.Split(r.URL.Path, "/")
+	instInt, err := strconv.Atoi(path[2])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	stats, err := s.mgr.InstanceStats(cmgr.InstanceId(instInt))
+	if err != nil {
+		writeError(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
 func (s state) existingSchemaHandler(w http.ResponseWriter, r *http.Request) {
 	path := strings.Split(r.URL.Path, "/")
 	pathLen := len(path)
+	if pathLen > 3 && path[3] == "assignments" {
+		s.assignmentHandler(w, r)
+		return
+	}
 	if len(path) < 2 || path[pathLen-2] != "schemas" {
 		w.WriteHeader(http.StatusNotFound)
 		return
@@ -501,6 +825,13 @@ func (s state) existingSchemaHandler(w http.ResponseWriter, r *http.Request) {
 			if schemaDef.Name != schema {
 				respCode = http.StatusBadRequest // Bad Request
 				err = errors.New("mismatch between endpoint and schema name")
+			} else if isAsync(r) {
+				var job cmgr.JobId
+				job, err = s.mgr.SubmitUpdateSchema(&schemaDef)
+				if err == nil {
+					writeJobAccepted(w, "", job)
+					return
+				}
 			} else {
 				errs := s.mgr.UpdateSchema(&schemaDef)
 				if len(errs) > 0 {
@@ -545,7 +876,14 @@ func (s state) schemaHandler(w http.ResponseWriter, r *http.Request) {
 			respCode = http.StatusBadRequest
 		}
 
-		if err == nil {
+		if err == nil && isAsync(r) {
+			var job cmgr.JobId
+			job, err = s.mgr.SubmitCreateSchema(&schemaDef)
+			if err == nil {
+				writeJobAccepted(w, "", job)
+				return
+			}
+		} else if err == nil {
 			errs := s.mgr.CreateSchema(&schemaDef)
 			if len(errs) > 0 {
 				err = errors.Join(errs...)
@@ -568,3 +906,46 @@ func (s state) schemaHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(respCode)
 	_, _ = w.Write(body)
 }
+
+type JobAcceptedResponse struct {
+	Id cmgr.JobId `json:"id"`
+}
+
+// Long-running requests run as background jobs when the client asks for it
+// with the "async" query parameter.
+func isAsync(r *http.Request) bool {
+	async, err := strconv.ParseBool(r.URL.Query().Get("async"))
+	return err == nil && async
+}
+
+// The prefix selects the API version used in the Location header.
+func writeJobAccepted(w http.ResponseWriter, prefix string, job cmgr.JobId) {
+	w.Header().Set("Location", fmt.Sprintf("%s/jobs/%d", prefix, job))
+	writeJSON(w, http.StatusAccepted, JobAcceptedResponse{Id: job})
+}
+
+func (s state) jobHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	path := strings.Split(r.URL.Path, "/")
+	pathLen := len(path)
+	if len(path) < 2 || path[pathLen-2] != "jobs" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	jobInt, err := strconv.Atoi(path[pathLen-1])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	job, err := s.mgr.GetJob(cmgr.JobId(jobInt))
+	if err != nil {
+		writeError(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
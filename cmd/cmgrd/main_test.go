@@ -75,3 +75,67 @@ func TestErrorStatusPreservesTypedErrors(t *testing.T) {
 		t.Fatalf("conflict mapped to %d", status)
 	}
 }
+
+func TestJobHandlerRejectsInvalidRequests(t *testing.T) {
+	request := httptest.NewRequest(http.MethodGet, "/jobs/not-a-number", nil)
+	response := httptest.NewRecorder()
+	state{}.jobHandler(response, request)
+	if response.Code != http.StatusBadRequest {
+		t.Fatalf("unexpected status %d", response.Code)
+	}
+
+	request = httptest.NewRequest(http.MethodDelete, "/jobs/1", nil)
+	response = httptest.NewRecorder()
+	state{}.jobHandler(response, request)
+	if response.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("unexpected status %d", response.Code)
+	}
+}
+
+func TestInstanceActionHandlersRejectInvalidRequests(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		status int
+	}{
+		{http.MethodGet, "/instances/1/pause", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/instances/one/pause", http.StatusBadRequest},
+		{http.MethodPost, "/instances/one/resume", http.StatusBadRequest},
+		{http.MethodPost, "/v2/instances/one/pause", http.StatusBadRequest},
+		{http.MethodGet, "/v2/instances/1/resume", http.StatusMethodNotAllowed},
+		{http.MethodDelete, "/instances/1/reset", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/v2/instances/one/reset", http.StatusBadRequest},
+		{http.MethodPost, "/instances/1/stats", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/instances/one/stats", http.StatusBadRequest},
+		{http.MethodGet, "/v2/instances/one/stats", http.StatusBadRequest},
+	}
+	v2 := state{}.v2Handler()
+	for _, test := range tests {
+		t.Run(test.method+" "+test.path, func(t *testing.T) {
+			request := httptest.NewRequest(test.method, test.path, nil)
+			response := httptest.NewRecorder()
+			if strings.HasPrefix(test.path, "/v2/") {
+				v2.ServeHTTP(response, request)
+			} else {
+				state{}.instanceHandler(response, request)
+			}
+			if response.Code != test.status {
+				t.Fatalf("unexpected status %d: %s", response.Code, response.Body.String())
+			}
+		})
+	}
+}
+
+func TestIsAsyncRequiresTrueValue(t *testing.T) {
+	for target, expected := range map[string]bool{
+		"/schemas":             false,
+		"/schemas?async=true":  true,
+		"/schemas?async=1":     true,
+		"/schemas?async=false": false,
+		"/schemas?async=maybe": false,
+	} {
+		if actual := isAsync(httptest.NewRequest(http.MethodPost, target, nil)); actual != expected {
+			t.Fatalf("%s: got async=%v", target, actual)
+		}
+	}
+}
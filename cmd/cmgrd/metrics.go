@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ArmyCyberInstitute/cmgr/internal/metrics"
+)
+
+type httpMetrics struct {
+	registry *metrics.Registry
+	requests *metrics.HistogramVec
+}
+
+func newHTTPMetrics() *httpMetrics {
+	r := metrics.NewRegistry()
+	return &httpMetrics{
+		registry: r,
+		requests: r.NewHistogramVec(
+			"cmgrd_http_request_duration_seconds",
+			"Time taken to serve HTTP requests, by handler, method, and status code.",
+			metrics.DefaultBuckets,
+			"handler",
+			"method",
+			"code",
+		),
+	}
+}
+
+// Records every request, including those rejected before reaching a
+// handler, under the mux pattern that matches its path.  Using the pattern
+// rather than the path keeps identifiers out of the label values.  Requests
+// that reached a handler carry the pattern of the innermost mux that routed
+// them, such as the one serving the v2 API.
+func (hm *httpMetrics) instrument(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+
+		pattern := r.Pattern
+		if pattern == "" {
+			_, pattern = mux.Handler(r)
+		}
+		if pattern == "" {
+			pattern = "unmatched"
+		}
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		hm.requests.Observe(
+			time.Since(started).Seconds(),
+			pattern,
+			r.Method,
+			strconv.Itoa(recorder.status),
+		)
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(body []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(body)
+}
+
+// Log streams must still be able to flush through the recorder.
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+func (s state) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	// Render everything before responding so that a failure is reported
+	// with an error status rather than as a truncated scrape.
+	var body bytes.Buffer
+	if s.httpMetrics != nil {
+		if err := s.httpMetrics.registry.WriteText(&body); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	if err := s.mgr.WriteMetrics(&body); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body.Bytes())
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestInstrumentRecordsRequestsByPattern(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/builds/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	hm := newHTTPMetrics()
+	handler := hm.instrument(mux, mux)
+
+	for _, path := range []string{"/builds/1", "/builds/2", "/unknown"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	var output strings.Builder
+	if err := hm.registry.WriteText(&output); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		`cmgrd_http_request_duration_seconds_count{handler="/builds/",method="GET",code="404"} 2`,
+		`cmgrd_http_request_duration_seconds_count{handler="unmatched",method="GET",code="404"} 1`,
+	} {
+		if !strings.Contains(output.String(), expected) {
+			t.Fatalf("metrics are missing %q:\n%s", expected, output.String())
+		}
+	}
+}
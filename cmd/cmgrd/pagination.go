@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"github.com/ArmyCyberInstitute/cmgr/cmgr"
+)
+
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
+// A page of a v2 list response.  NextCursor is omitted on the last page.
+type listPage[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// A fieldFilter reports whether an item matches one value given for the
+// filter's query parameter.
+type fieldFilter[T any] func(item T, value string) (bool, error)
+
+// Describes how a v2 list endpoint orders, filters, and pages its items.
+// Items are ordered by a key that is unique to each item, and a cursor is
+// the key of the last item on the previous page.  Resuming after a key
+// rather than an offset keeps later pages consistent when items are added or
+// removed between requests.
+type listing[T any] struct {
+	key     func(T) string
+	filters map[string]fieldFilter[T]
+	// Query parameters that the endpoint handles itself.
+	reserved []string
+}
+
+func invalidQuery(format string, args ...any) error {
+	return &cmgr.InvalidInputError{Err: fmt.Errorf(format, args...)}
+}
+
+func encodeCursor(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+func decodeCursor(cursor string) (string, error) {
+	key, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", invalidQuery("invalid cursor %q", cursor)
+	}
+	return string(key), nil
+}
+
+func pageLimit(query url.Values) (int, error) {
+	value := query.Get("limit")
+	if value == "" {
+		return defaultPageLimit, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 || limit > maxPageLimit {
+		return 0, invalidQuery("limit must be an integer from 1 to %d", maxPageLimit)
+	}
+	return limit, nil
+}
+
+// Applies the filters in the query and returns the requested page.  Every
+// filter given must match, and a filter repeated with several values matches
+// any of them.
+func (l listing[T]) page(query url.Values, items []T) (*listPage[T], error) {
+	limit, err := pageLimit(query)
+	if err != nil {
+		return nil, err
+	}
+	after := ""
+	if cursor := query.Get("cursor"); cursor != "" {
+		if after, err = decodeCursor(cursor); err != nil {
+			return nil, err
+		}
+	}
+
+	params := make([]string, 0, len(query))
+	for param := range query {
+		if param == "limit" || param == "cursor" || l.isReserved(param) {
+			continue
+		}
+		if _, ok := l.filters[param]; !ok {
+			return nil, invalidQuery("unsupported filter %q", param)
+		}
+		params = append(params, param)
+	}
+	sort.Strings(params)
+
+	matched := make([]T, 0, len(items))
+	for _, item := range items {
+		ok, err := l.matches(item, query, params)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			matched = append(matched, item)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		return l.key(matched[i]) < l.key(matched[j])
+	})
+
+	start := 0
+	if after != "" {
+		start = sort.Search(len(matched), func(i int) bool {
+			return l.key(matched[i]) > after
+		})
+	}
+	end := min(start+limit, len(matched))
+
+	page := &listPage[T]{Items: matched[start:end]}
+	if end < len(matched) {
+		page.NextCursor = encodeCursor(l.key(matched[end-1]))
+	}
+	return page, nil
+}
+
+func (l listing[T]) isReserved(param string) bool {
+	for _, reserved := range l.reserved {
+		if param == reserved {
+			return true
+		}
+	}
+	return false
+}
+
+func (l listing[T]) matches(item T, query url.Values, params []string) (bool, error) {
+	for _, param := range params {
+		filter := l.filters[param]
+		matched := false
+		for _, value := range query[param] {
+			ok, err := filter(item, value)
+			if err != nil {
+				return false, err
+			}
+			if ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func matchString[T any](field func(T) string) fieldFilter[T] {
+	return func(item T, value string) (bool, error) {
+		return field(item) == value, nil
+	}
+}
+
+func matchBool[T any](name string, field func(T) bool) fieldFilter[T] {
+	return func(item T, value string) (bool, error) {
+		want, err := strconv.ParseBool(value)
+		if err != nil {
+			return false, invalidQuery("%s must be a boolean", name)
+		}
+		return field(item) == want, nil
+	}
+}
+
+func matchAny[T any](field func(T) []string) fieldFilter[T] {
+	return func(item T, value string) (bool, error) {
+		for _, candidate := range field(item) {
+			if candidate == value {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+}
+
+// Integer keys are zero-padded so that they sort numerically.
+func integerKey(value int64) string {
+	return fmt.Sprintf("%020d", value)
+}
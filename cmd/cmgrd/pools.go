@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/ArmyCyberInstitute/cmgr/cmgr"
+)
+
+func (s state) claimHandler(w http.ResponseWriter, r *http.Request, build cmgr.BuildId) {
+	ttl, err := requestTTL(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	instance, err := s.mgr.Claim(build, ttl...)
+	if err != nil {
+		writeError(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	meta, err := s.mgr.GetInstanceMetadata(instance)
+	if err != nil {
+		writeError(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, meta)
+}
+
+func (s state) v2ClaimInstance(w http.ResponseWriter, r *http.Request) {
+	build, err := pathInt(r, "build")
+	if err != nil {
+		writeV2Error(w, http.StatusBadRequest, err)
+		return
+	}
+	ttl, err := requestTTL(r)
+	if err != nil {
+		writeV2Error(w, http.StatusBadRequest, err)
+		return
+	}
+	instance, err := s.mgr.Claim(cmgr.BuildId(build), ttl...)
+	if err != nil {
+		writeV2Error(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	meta, err := s.mgr.GetInstanceMetadata(instance)
+	if err != nil {
+		writeV2Error(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("%s/instances/%d", v2Prefix, instance))
+	writeJSON(w, http.StatusCreated, meta)
+}
+
+// The parts of the manager used by the pool refiller.
+type warmPools interface {
+	RefillWarmPools() error
+}
+
+// Periodically tops up the warm pools of dynamic builds.  Each claim refills
+// its own pool right away, so this catches pools that were emptied while
+// cmgrd was down and refills that failed.
+type poolRefiller struct {
+	mgr      warmPools
+	interval time.Duration
+}
+
+func newPoolRefiller(mgr warmPools, interval time.Duration) *poolRefiller {
+	return &poolRefiller{mgr: mgr, interval: interval}
+}
+
+func (pr *poolRefiller) run() {
+	ticker := time.NewTicker(pr.interval)
+	defer ticker.Stop()
+	pr.refill()
+	for range ticker.C {
+		pr.refill()
+	}
+}
+
+func (pr *poolRefiller) refill() {
+	if err := pr.mgr.RefillWarmPools(); err != nil {
+		log.Printf("pools: could not refill warm pools: %v", err)
+	}
+}
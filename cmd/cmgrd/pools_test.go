@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type fakeWarmPools struct {
+	refills int
+}
+
+func (f *fakeWarmPools) RefillWarmPools() error {
+	f.refills++
+	return errors.New("docker is unavailable")
+}
+
+func TestPoolRefillerKeepsRunningAfterFailures(t *testing.T) {
+	mgr := &fakeWarmPools{}
+	refiller := newPoolRefiller(mgr, 0)
+	refiller.refill()
+	refiller.refill()
+	if mgr.refills != 2 {
+		t.Fatalf("pools were refilled %d times", mgr.refills)
+	}
+}
+
+func TestClaimHandlersRejectInvalidRequests(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		status int
+	}{
+		{http.MethodPost, "/builds/one/claim", http.StatusBadRequest},
+		{http.MethodPost, "/builds/1/claim?ttl=soon", http.StatusBadRequest},
+		{http.MethodPost, "/v2/builds/one/claim", http.StatusBadRequest},
+		{http.MethodPost, "/v2/builds/1/claim?ttl=soon", http.StatusBadRequest},
+		{http.MethodDelete, "/v2/builds/1/claim", http.StatusMethodNotAllowed},
+	}
+	v2 := state{}.v2Handler()
+	for _, test := range tests {
+		t.Run(test.method+" "+test.path, func(t *testing.T) {
+			request := httptest.NewRequest(test.method, test.path, nil)
+			response := httptest.NewRecorder()
+			if strings.HasPrefix(test.path, "/v2/") {
+				v2.ServeHTTP(response, request)
+			} else {
+				state{}.buildHandler(response, request)
+			}
+			if response.Code != test.status {
+				t.Fatalf("unexpected status %d: %s", response.Code, response.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/ArmyCyberInstitute/cmgr/cmgr"
+)
+
+// How long a resolved proxy target is reused before the instance's containers
+// are inspected again.
+const proxyTargetTTL = 30 * time.Second
+
+// The parts of the manager used by the reverse proxy.
+type proxyTargets interface {
+	ProxyTarget(host string) (string, error)
+}
+
+type cachedProxyTarget struct {
+	address string
+	expires time.Time
+}
+
+// Remembers the container address each hostname resolved to so that the
+// instance's containers are not inspected for every request or connection.
+type targetCache struct {
+	resolve func(host string) (string, error)
+	mu      sync.Mutex
+	entries map[string]cachedProxyTarget
+}
+
+func newTargetCache(resolve func(host string) (string, error)) *targetCache {
+	return &targetCache{
+		resolve: resolve,
+		entries: make(map[string]cachedProxyTarget),
+	}
+}
+
+func (c *targetCache) lookup(host string) (string, error) {
+	now := time.Now()
+	c.mu.Lock()
+	cached, ok := c.entries[host]
+	c.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.address, nil
+	}
+
+	address, err := c.resolve(host)
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	for name, target := range c.entries {
+		if !now.Before(target.expires) {
+			delete(c.entries, name)
+		}
+	}
+	c.entries[host] = cachedProxyTarget{address: address, expires: now.Add(proxyTargetTTL)}
+	c.mu.Unlock()
+	return address, nil
+}
+
+func (c *targetCache) forget(host string) {
+	c.mu.Lock()
+	delete(c.entries, host)
+	c.mu.Unlock()
+}
+
+// Routes requests for `<instance>-<port>.<domain>` to the HTTP port of the
+// instance over its challenge network.  The Host header is passed through so
+// that challenges see the hostname players use.
+type instanceProxy struct {
+	targets   *targetCache
+	transport http.RoundTripper
+}
+
+func newInstanceProxy(mgr proxyTargets) *instanceProxy {
+	return &instanceProxy{
+		targets:   newTargetCache(mgr.ProxyTarget),
+		transport: http.DefaultTransport,
+	}
+}
+
+func (p *instanceProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	address, err := p.targets.lookup(r.Host)
+	if err != nil {
+		var unknown *cmgr.UnknownIdentifierError
+		var conflict *cmgr.ConflictError
+		switch {
+		case errors.As(err, &unknown):
+			http.Error(w, "unknown challenge instance", http.StatusNotFound)
+		case errors.As(err, &conflict):
+			http.Error(w, "challenge instance is unavailable", http.StatusServiceUnavailable)
+		default:
+			log.Printf("proxy: could not resolve %q: %v", r.Host, err)
+			http.Error(w, "challenge instance is unavailable", http.StatusBadGateway)
+		}
+		return
+	}
+
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(&url.URL{Scheme: "http", Host: address})
+			pr.Out.Host = pr.In.Host
+			pr.SetXForwarded()
+		},
+		Transport: p.transport,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			// The instance may have been reset or restarted with a new
+			// address.
+			p.targets.forget(r.Host)
+			log.Printf("proxy: could not reach %q at %s: %v", r.Host, address, err)
+			http.Error(w, "challenge instance is unavailable", http.StatusBadGateway)
+		},
+	}
+	proxy.ServeHTTP(w, r)
+}
+
+func serveInstanceProxy(address string, proxy *instanceProxy) {
+	server := &http.Server{
+		Addr:              address,
+		Handler:           proxy,
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       60 * time.Second,
+		MaxHeaderBytes:    64 * 1024,
+	}
+	log.Fatal(server.ListenAndServe())
+}
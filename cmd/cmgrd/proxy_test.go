@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ArmyCyberInstitute/cmgr/cmgr"
+)
+
+type fakeProxyTargets struct {
+	targets map[string]string
+	lookups int
+}
+
+func (f *fakeProxyTargets) ProxyTarget(host string) (string, error) {
+	f.lookups++
+	if address, ok := f.targets[host]; ok {
+		return address, nil
+	}
+	if host == "2-http.ctf.test" {
+		return "", &cmgr.ConflictError{Err: http.ErrServerClosed}
+	}
+	return "", &cmgr.UnknownIdentifierError{Type: "proxied host", Name: host}
+}
+
+func TestInstanceProxyForwardsByHostname(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host + " " + r.URL.Path + " " + r.Header.Get("X-Forwarded-Host")))
+	}))
+	defer backend.Close()
+
+	mgr := &fakeProxyTargets{targets: map[string]string{
+		"1-http.ctf.test": strings.TrimPrefix(backend.URL, "http://"),
+	}}
+	proxy := newInstanceProxy(mgr)
+	for range 2 {
+		request := httptest.NewRequest(http.MethodGet, "http://1-http.ctf.test/login", nil)
+		response := httptest.NewRecorder()
+		proxy.ServeHTTP(response, request)
+		body := response.Body.String()
+		if response.Code != http.StatusOK || body != "1-http.ctf.test /login 1-http.ctf.test" {
+			t.Fatalf("unexpected response %d: %s", response.Code, body)
+		}
+	}
+	if mgr.lookups != 1 {
+		t.Fatalf("target was resolved %d times", mgr.lookups)
+	}
+
+	backend.Close()
+	request := httptest.NewRequest(http.MethodGet, "http://1-http.ctf.test/", nil)
+	response := httptest.NewRecorder()
+	proxy.ServeHTTP(response, request)
+	if response.Code != http.StatusBadGateway {
+		t.Fatalf("unreachable instance returned %d", response.Code)
+	}
+	if _, cached := proxy.targets.entries["1-http.ctf.test"]; cached {
+		t.Fatal("unreachable target stayed cached")
+	}
+}
+
+func TestInstanceProxyReportsUnknownAndPausedInstances(t *testing.T) {
+	proxy := newInstanceProxy(&fakeProxyTargets{})
+	tests := map[string]int{
+		"9-http.ctf.test": http.StatusNotFound,
+		"2-http.ctf.test": http.StatusServiceUnavailable,
+	}
+	for host, status := range tests {
+		request := httptest.NewRequest(http.MethodGet, "http://"+host+"/", nil)
+		response := httptest.NewRecorder()
+		proxy.ServeHTTP(response, request)
+		if response.Code != status {
+			t.Errorf("%s: status %d, expected %d", host, response.Code, status)
+		}
+	}
+}
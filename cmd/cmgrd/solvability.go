@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ArmyCyberInstitute/cmgr/cmgr"
+)
+
+func (s state) solvabilityHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	challenge := strings.Trim(strings.TrimPrefix(r.URL.Path, "/solvability/"), "/")
+	if challenge == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	status, err := s.mgr.GetChallengeSolvability(cmgr.ChallengeId(challenge))
+	if err != nil {
+		writeError(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (s state) v2GetSolvability(w http.ResponseWriter, r *http.Request) {
+	challenge := cmgr.ChallengeId(r.PathValue("challenge"))
+	status, err := s.mgr.GetChallengeSolvability(challenge)
+	if err != nil {
+		writeV2Error(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// The parts of the manager used by the check scheduler.
+type checkableInstances interface {
+	CheckableInstances() ([]cmgr.InstanceId, error)
+	CheckInstance(instance cmgr.InstanceId) error
+}
+
+// Periodically runs the solver against running instances so that a challenge
+// that stops being solvable is noticed without anyone asking.  Each round
+// checks every checkable instance, or a random sample of at most `sample` of
+// them, with no more than `concurrency` solvers running at once.  Results are
+// recorded by the manager just as for a check requested through the API.
+type checkScheduler struct {
+	mgr         checkableInstances
+	interval    time.Duration
+	sample      int
+	concurrency int
+}
+
+func newCheckScheduler(
+	mgr checkableInstances,
+	interval time.Duration,
+	sample int,
+	concurrency int,
+) *checkScheduler {
+	return &checkScheduler{
+		mgr:         mgr,
+		interval:    interval,
+		sample:      sample,
+		concurrency: concurrency,
+	}
+}
+
+func (cs *checkScheduler) run() {
+	ticker := time.NewTicker(cs.interval)
+	defer ticker.Stop()
+	// A round that outlasts the interval delays the next one rather than
+	// overlapping it.
+	for range ticker.C {
+		cs.checkRound()
+	}
+}
+
+func (cs *checkScheduler) checkRound() {
+	instances, err := cs.mgr.CheckableInstances()
+	if err != nil {
+		log.Printf("scheduler: could not list checkable instances: %v", err)
+		return
+	}
+	if cs.sample > 0 && len(instances) > cs.sample {
+		rand.Shuffle(len(instances), func(i, j int) {
+			instances[i], instances[j] = instances[j], instances[i]
+		})
+		instances = instances[:cs.sample]
+	}
+
+	queue := make(chan cmgr.InstanceId)
+	var workers sync.WaitGroup
+	for range min(cs.concurrency, len(instances)) {
+		workers.Go(func() {
+			for instance := range queue {
+				cs.check(instance)
+			}
+		})
+	}
+	for _, instance := range instances {
+		queue <- instance
+	}
+	close(queue)
+	workers.Wait()
+}
+
+func (cs *checkScheduler) check(instance cmgr.InstanceId) {
+	err := cs.mgr.CheckInstance(instance)
+	var unknown *cmgr.UnknownIdentifierError
+	var conflict *cmgr.ConflictError
+	switch {
+	case err == nil:
+	case errors.As(err, &unknown), errors.As(err, &conflict):
+		// Stopped or paused by someone else since it was listed.
+	default:
+		log.Printf("scheduler: check of instance %d failed: %v", instance, err)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ArmyCyberInstitute/cmgr/cmgr"
+)
+
+type fakeCheckableInstances struct {
+	instances []cmgr.InstanceId
+	checkErr  map[cmgr.InstanceId]error
+
+	lock       sync.Mutex
+	checked    map[cmgr.InstanceId]int
+	running    int
+	maxRunning int
+}
+
+func (f *fakeCheckableInstances) CheckableInstances() ([]cmgr.InstanceId, error) {
+	return append([]cmgr.InstanceId(nil), f.instances...), nil
+}
+
+func (f *fakeCheckableInstances) CheckInstance(instance cmgr.InstanceId) error {
+	f.lock.Lock()
+	f.checked[instance]++
+	f.running++
+	f.maxRunning = max(f.maxRunning, f.running)
+	f.lock.Unlock()
+
+	time.Sleep(5 * time.Millisecond)
+
+	f.lock.Lock()
+	f.running--
+	f.lock.Unlock()
+	return f.checkErr[instance]
+}
+
+func TestCheckSchedulerChecksEveryInstanceWithinConcurrencyCap(t *testing.T) {
+	mgr := &fakeCheckableInstances{
+		instances: []cmgr.InstanceId{1, 2, 3, 4, 5, 6},
+		checkErr: map[cmgr.InstanceId]error{
+			2: errors.New("solve script returned incorrect flag"),
+			3: &cmgr.UnknownIdentifierError{Type: "instance", Name: "3"},
+		},
+		checked: make(map[cmgr.InstanceId]int),
+	}
+	newCheckScheduler(mgr, 0, 0, 2).checkRound()
+
+	if len(mgr.checked) != len(mgr.instances) {
+		t.Fatalf("not every instance was checked: %v", mgr.checked)
+	}
+	for instance, count := range mgr.checked {
+		if count != 1 {
+			t.Fatalf("instance %d was checked %d times", instance, count)
+		}
+	}
+	if mgr.maxRunning > 2 {
+		t.Fatalf("%d checks ran at once despite a cap of 2", mgr.maxRunning)
+	}
+}
+
+func TestCheckSchedulerSamplesInstances(t *testing.T) {
+	mgr := &fakeCheckableInstances{
+		instances: []cmgr.InstanceId{1, 2, 3, 4, 5, 6},
+		checked:   make(map[cmgr.InstanceId]int),
+	}
+	newCheckScheduler(mgr, 0, 3, 4).checkRound()
+
+	if len(mgr.checked) != 3 {
+		t.Fatalf("expected a sample of 3 instances, checked %v", mgr.checked)
+	}
+	for instance, count := range mgr.checked {
+		if count != 1 {
+			t.Fatalf("instance %d was checked %d times", instance, count)
+		}
+	}
+}
+
+func TestSolvabilityHandlersRejectInvalidRequests(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		status int
+	}{
+		{http.MethodPost, "/solvability/challenge", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/solvability/", http.StatusNotFound},
+		{http.MethodPost, "/v2/solvability/challenge", http.StatusMethodNotAllowed},
+	}
+	v2 := state{}.v2Handler()
+	for _, test := range tests {
+		t.Run(test.method+" "+test.path, func(t *testing.T) {
+			request := httptest.NewRequest(test.method, test.path, nil)
+			response := httptest.NewRecorder()
+			if strings.HasPrefix(test.path, "/v2/") {
+				v2.ServeHTTP(response, request)
+			} else {
+				state{}.solvabilityHandler(response, request)
+			}
+			if response.Code != test.status {
+				t.Fatalf("unexpected status %d: %s", response.Code, response.Body.String())
+			}
+		})
+	}
+}
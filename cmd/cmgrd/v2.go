@@ -0,0 +1,610 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ArmyCyberInstitute/cmgr/cmgr"
+)
+
+const v2Prefix = "/v2"
+
+// Machine-readable codes carried by the v2 error envelope.
+const (
+	codeUnknownIdentifier = "unknown_identifier"
+	codeInvalidInput      = "invalid_input"
+	codeConflict          = "conflict"
+	codeNotFound          = "not_found"
+	codeMethodNotAllowed  = "method_not_allowed"
+	codeUnauthorized      = "unauthorized"
+	codeForbidden         = "forbidden"
+	codeRequestTooLarge   = "request_too_large"
+	codeInternal          = "internal"
+)
+
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Set along with the "unknown_identifier" code.
+	IdentifierType string `json:"identifier_type,omitempty"`
+	Identifier     string `json:"identifier,omitempty"`
+}
+
+type ErrorResponse struct {
+	Error APIError `json:"error"`
+}
+
+type SchemaListElement struct {
+	Name string `json:"name"`
+}
+
+func isV2Request(r *http.Request) bool {
+	return r.URL.Path == v2Prefix || strings.HasPrefix(r.URL.Path, v2Prefix+"/")
+}
+
+// Describes err for the v2 error envelope.  The code comes from the cmgr
+// error type when there is one and otherwise from the status.
+func apiError(status int, err error) APIError {
+	result := APIError{Message: err.Error()}
+
+	var unknown *cmgr.UnknownIdentifierError
+	var invalid *cmgr.InvalidInputError
+	var conflict *cmgr.ConflictError
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &unknown):
+		result.Code = codeUnknownIdentifier
+		result.IdentifierType = unknown.Type
+		result.Identifier = unknown.Name
+	case errors.As(err, &invalid):
+		result.Code = codeInvalidInput
+	case errors.As(err, &conflict):
+		result.Code = codeConflict
+	case errors.As(err, &tooLarge):
+		result.Code = codeRequestTooLarge
+	default:
+		switch status {
+		case http.StatusBadRequest:
+			result.Code = codeInvalidInput
+		case http.StatusUnauthorized:
+			result.Code = codeUnauthorized
+		case http.StatusForbidden:
+			result.Code = codeForbidden
+		case http.StatusNotFound:
+			result.Code = codeNotFound
+		case http.StatusMethodNotAllowed:
+			result.Code = codeMethodNotAllowed
+		case http.StatusConflict:
+			result.Code = codeConflict
+		case http.StatusRequestEntityTooLarge:
+			result.Code = codeRequestTooLarge
+		default:
+			result.Code = codeInternal
+		}
+	}
+	return result
+}
+
+func writeV2Error(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, ErrorResponse{Error: apiError(status, err)})
+}
+
+// Request bodies that cannot be decoded are the client's fault, except that
+// an oversized body is reported as such.
+func requestBodyStatus(err error) int {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// Routes requests with method and path patterns.  The router answers
+// unmatched requests itself so that they also receive the error envelope.
+type v2Router struct {
+	mux *http.ServeMux
+}
+
+func (s state) v2Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v2/challenges", s.v2ListChallenges)
+	mux.HandleFunc("GET /v2/challenges/{challenge...}", s.v2GetChallenge)
+	mux.HandleFunc("POST /v2/challenges/{challenge...}", s.v2BuildChallenge)
+	mux.HandleFunc("GET /v2/builds/{build}", s.v2GetBuild)
+	mux.HandleFunc("POST /v2/builds/{build}", s.v2StartInstance)
+	mux.HandleFunc("DELETE /v2/builds/{build}", s.v2DestroyBuild)
+	mux.HandleFunc("POST /v2/builds/{build}/claim", s.v2ClaimInstance)
+	mux.HandleFunc("GET /v2/builds/{build}/log", s.v2BuildLog)
+	mux.HandleFunc("GET /v2/builds/{build}/{artifact}", s.v2BuildArtifact)
+	mux.HandleFunc("GET /v2/instances/{instance}", s.v2GetInstance)
+	mux.HandleFunc("POST /v2/instances/{instance}", s.v2CheckInstance)
+	mux.HandleFunc("DELETE /v2/instances/{instance}", s.v2StopInstance)
+	mux.HandleFunc("POST /v2/instances/{instance}/extend", s.v2ExtendInstance)
+	mux.HandleFunc("POST /v2/instances/{instance}/pause", s.v2PauseInstance)
+	mux.HandleFunc("POST /v2/instances/{instance}/resume", s.v2ResumeInstance)
+	mux.HandleFunc("POST /v2/instances/{instance}/reset", s.v2ResetInstance)
+	mux.HandleFunc("GET /v2/instances/{instance}/stats", s.v2InstanceStats)
+	mux.HandleFunc("GET /v2/instances/{instance}/logs", s.v2InstanceLog)
+	mux.HandleFunc("GET /v2/instances/{instance}/checks", s.v2ListChecks)
+	mux.HandleFunc("GET /v2/instances/{instance}/checks/{check}/log", s.v2CheckLog)
+	mux.HandleFunc("GET /v2/instances/{instance}/captures", s.v2ListCaptures)
+	mux.HandleFunc("GET /v2/instances/{instance}/captures/{capture}", s.v2Capture)
+	mux.HandleFunc("GET /v2/schemas", s.v2ListSchemas)
+	mux.HandleFunc("POST /v2/schemas", s.v2CreateSchema)
+	mux.HandleFunc("GET /v2/schemas/{schema}", s.v2GetSchema)
+	mux.HandleFunc("POST /v2/schemas/{schema}", s.v2UpdateSchema)
+	mux.HandleFunc("DELETE /v2/schemas/{schema}", s.v2DeleteSchema)
+	mux.HandleFunc("GET /v2/schemas/{schema}/assignments/{user}/{challenge...}", s.v2GetAssignment)
+	mux.HandleFunc("POST /v2/schemas/{schema}/assignments/{user}/{challenge...}", s.v2Assign)
+	mux.HandleFunc("DELETE /v2/schemas/{schema}/assignments/{user}/{challenge...}", s.v2Unassign)
+	mux.HandleFunc("GET /v2/jobs/{job}", s.v2GetJob)
+	mux.HandleFunc("GET /v2/solvability/{challenge...}", s.v2GetSolvability)
+	mux.HandleFunc("GET /v2/capacity", s.v2GetCapacity)
+	return v2Router{mux: mux}
+}
+
+func (v v2Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if _, pattern := v.mux.Handler(r); pattern != "" {
+		v.mux.ServeHTTP(w, r)
+		return
+	}
+
+	allowed := []string{}
+	for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodDelete} {
+		probe := r.Clone(r.Context())
+		probe.Method = method
+		if _, pattern := v.mux.Handler(probe); pattern != "" {
+			allowed = append(allowed, method)
+		}
+	}
+	if len(allowed) == 0 {
+		writeV2Error(w, http.StatusNotFound, fmt.Errorf("no endpoint matches %s", r.URL.Path))
+		return
+	}
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeV2Error(
+		w,
+		http.StatusMethodNotAllowed,
+		fmt.Errorf("%s is not allowed for %s", r.Method, r.URL.Path),
+	)
+}
+
+func pathInt(r *http.Request, name string) (int64, error) {
+	value, err := strconv.ParseInt(r.PathValue(name), 10, 64)
+	if err != nil {
+		return 0, invalidQuery("%s must be an integer", name)
+	}
+	return value, nil
+}
+
+// Tags are matched by the database search, as in the v1 API, rather than as a
+// field filter.
+var challengeListing = listing[ChallengeListElement]{
+	key: func(c ChallengeListElement) string { return string(c.Id) },
+	filters: map[string]fieldFilter[ChallengeListElement]{
+		"solve_script": matchBool("solve_script", func(c ChallengeListElement) bool {
+			return c.SolveScript
+		}),
+	},
+	reserved: []string{"tags"},
+}
+
+func (s state) v2ListChallenges(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var challenges []*cmgr.ChallengeMetadata
+	var err error
+	if tags, ok := query["tags"]; ok {
+		challenges, err = s.mgr.SearchChallengesWithError(tags)
+	} else {
+		challenges, err = s.mgr.ListChallengesWithError()
+	}
+	if err != nil {
+		writeV2Error(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	page, err := challengeListing.page(query, challengeListElements(challenges))
+	if err != nil {
+		writeV2Error(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+func (s state) v2GetChallenge(w http.ResponseWriter, r *http.Request) {
+	challenge := cmgr.ChallengeId(r.PathValue("challenge"))
+	meta, err := s.mgr.GetChallengeMetadata(challenge)
+	if err != nil {
+		writeV2Error(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, meta)
+}
+
+func (s state) v2BuildChallenge(w http.ResponseWriter, r *http.Request) {
+	challenge := cmgr.ChallengeId(r.PathValue("challenge"))
+	var buildReq BuildChallengeRequest
+	if err := s.decodeJSON(w, r, &buildReq); err != nil {
+		writeV2Error(w, requestBodyStatus(err), err)
+		return
+	}
+	if buildReq.FlagFormat == "" {
+		buildReq.FlagFormat = "flag{%s}"
+	}
+
+	if isAsync(r) {
+		job, err := s.mgr.SubmitBuild(challenge, buildReq.Seeds, buildReq.FlagFormat)
+		if err != nil {
+			writeV2Error(w, errorStatus(err, http.StatusInternalServerError), err)
+			return
+		}
+		writeJobAccepted(w, v2Prefix, job)
+		return
+	}
+
+	builds, err := s.mgr.Build(challenge, buildReq.Seeds, buildReq.FlagFormat)
+	if err != nil {
+		writeV2Error(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, builds)
+}
+
+func (s state) v2GetBuild(w http.ResponseWriter, r *http.Request) {
+	build, err := pathInt(r, "build")
+	if err != nil {
+		writeV2Error(w, http.StatusBadRequest, err)
+		return
+	}
+	meta, err := s.mgr.GetBuildMetadata(cmgr.BuildId(build))
+	if err != nil {
+		writeV2Error(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, meta)
+}
+
+func (s state) v2StartInstance(w http.ResponseWriter, r *http.Request) {
+	build, err := pathInt(r, "build")
+	if err != nil {
+		writeV2Error(w, http.StatusBadRequest, err)
+		return
+	}
+	ttl, err := requestTTL(r)
+	if err != nil {
+		writeV2Error(w, http.StatusBadRequest, err)
+		return
+	}
+	instance, err := s.mgr.Start(cmgr.BuildId(build), ttl...)
+	if err != nil {
+		writeV2Error(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	meta, err := s.mgr.GetInstanceMetadata(instance)
+	if err != nil {
+		writeV2Error(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("%s/instances/%d", v2Prefix, instance))
+	writeJSON(w, http.StatusCreated, meta)
+}
+
+func (s state) v2DestroyBuild(w http.ResponseWriter, r *http.Request) {
+	build, err := pathInt(r, "build")
+	if err != nil {
+		writeV2Error(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := s.mgr.Destroy(cmgr.BuildId(build)); err != nil {
+		writeV2Error(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s state) v2BuildLog(w http.ResponseWriter, r *http.Request) {
+	build, err := pathInt(r, "build")
+	if err != nil {
+		writeV2Error(w, http.StatusBadRequest, err)
+		return
+	}
+	follow, err := followLog(r)
+	if err != nil {
+		writeV2Error(w, http.StatusBadRequest, invalidQuery("follow must be a boolean"))
+		return
+	}
+	output, err := s.mgr.BuildLog(r.Context(), cmgr.BuildId(build), follow)
+	if err != nil {
+		writeV2Error(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	writeLogEvents(w, r, output)
+}
+
+func (s state) v2BuildArtifact(w http.ResponseWriter, r *http.Request) {
+	build, err := pathInt(r, "build")
+	if err != nil {
+		writeV2Error(w, http.StatusBadRequest, err)
+		return
+	}
+	s.serveArtifact(w, cmgr.BuildId(build), r.PathValue("artifact"), writeV2Error)
+}
+
+func (s state) v2GetInstance(w http.ResponseWriter, r *http.Request) {
+	instance, err := pathInt(r, "instance")
+	if err != nil {
+		writeV2Error(w, http.StatusBadRequest, err)
+		return
+	}
+	meta, err := s.mgr.GetInstanceMetadata(cmgr.InstanceId(instance))
+	if err != nil {
+		writeV2Error(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	s.mgr.InspectInstanceRuntime(meta)
+	writeJSON(w, http.StatusOK, meta)
+}
+
+func (s state) v2InstanceStats(w http.ResponseWriter, r *http.Request) {
+	instance, err := pathInt(r, "instance")
+	if err != nil {
+		writeV2Error(w, http.StatusBadRequest, err)
+		return
+	}
+	stats, err := s.mgr.InstanceStats(cmgr.InstanceId(instance))
+	if err != nil {
+		writeV2Error(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+func (s state) v2CheckInstance(w http.ResponseWriter, r *http.Request) {
+	instance, err := pathInt(r, "instance")
+	if err != nil {
+		writeV2Error(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := s.mgr.CheckInstance(cmgr.InstanceId(instance)); err != nil {
+		writeV2Error(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s state) v2StopInstance(w http.ResponseWriter, r *http.Request) {
+	instance, err := pathInt(r, "instance")
+	if err != nil {
+		writeV2Error(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := s.mgr.Stop(cmgr.InstanceId(instance)); err != nil {
+		writeV2Error(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s state) v2PauseInstance(w http.ResponseWriter, r *http.Request) {
+	s.v2InstanceAction(w, r, s.mgr.Pause)
+}
+
+func (s state) v2ResumeInstance(w http.ResponseWriter, r *http.Request) {
+	s.v2InstanceAction(w, r, s.mgr.Resume)
+}
+
+func (s state) v2ResetInstance(w http.ResponseWriter, r *http.Request) {
+	s.v2InstanceAction(w, r, s.mgr.Reset)
+}
+
+func (s state) v2InstanceAction(
+	w http.ResponseWriter,
+	r *http.Request,
+	action func(cmgr.InstanceId) error,
+) {
+	instance, err := pathInt(r, "instance")
+	if err != nil {
+		writeV2Error(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := action(cmgr.InstanceId(instance)); err != nil {
+		writeV2Error(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+var checkListing = listing[cmgr.InstanceCheck]{
+	key: func(c cmgr.InstanceCheck) string { return integerKey(int64(c.Number)) },
+	filters: map[string]fieldFilter[cmgr.InstanceCheck]{
+		"running": matchBool("running", func(c cmgr.InstanceCheck) bool {
+			return c.Running
+		}),
+	},
+}
+
+func (s state) v2ListChecks(w http.ResponseWriter, r *http.Request) {
+	instance, err := pathInt(r, "instance")
+	if err != nil {
+		writeV2Error(w, http.StatusBadRequest, err)
+		return
+	}
+	checks, err := s.mgr.ListInstanceChecks(cmgr.InstanceId(instance))
+	if err != nil {
+		writeV2Error(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	page, err := checkListing.page(r.URL.Query(), checks)
+	if err != nil {
+		writeV2Error(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+func (s state) v2CheckLog(w http.ResponseWriter, r *http.Request) {
+	instance, err := pathInt(r, "instance")
+	if err != nil {
+		writeV2Error(w, http.StatusBadRequest, err)
+		return
+	}
+	check, err := pathInt(r, "check")
+	if err != nil {
+		writeV2Error(w, http.StatusBadRequest, err)
+		return
+	}
+	follow, err := followLog(r)
+	if err != nil {
+		writeV2Error(w, http.StatusBadRequest, invalidQuery("follow must be a boolean"))
+		return
+	}
+	output, err := s.mgr.CheckLog(r.Context(), cmgr.InstanceId(instance), int(check), follow)
+	if err != nil {
+		writeV2Error(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	writeLogEvents(w, r, output)
+}
+
+var schemaListing = listing[SchemaListElement]{
+	key: func(s SchemaListElement) string { return s.Name },
+}
+
+func (s state) v2ListSchemas(w http.ResponseWriter, r *http.Request) {
+	names, err := s.mgr.ListSchemas()
+	if err != nil {
+		writeV2Error(w, http.StatusInternalServerError, err)
+		return
+	}
+	schemas := make([]SchemaListElement, len(names))
+	for i, name := range names {
+		schemas[i].Name = name
+	}
+	page, err := schemaListing.page(r.URL.Query(), schemas)
+	if err != nil {
+		writeV2Error(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+func (s state) v2CreateSchema(w http.ResponseWriter, r *http.Request) {
+	var schemaDef cmgr.Schema
+	if err := s.decodeJSON(w, r, &schemaDef); err != nil {
+		writeV2Error(w, requestBodyStatus(err), err)
+		return
+	}
+
+	if isAsync(r) {
+		job, err := s.mgr.SubmitCreateSchema(&schemaDef)
+		if err != nil {
+			writeV2Error(w, errorStatus(err, http.StatusInternalServerError), err)
+			return
+		}
+		writeJobAccepted(w, v2Prefix, job)
+		return
+	}
+
+	if errs := s.mgr.CreateSchema(&schemaDef); len(errs) > 0 {
+		err := errors.Join(errs...)
+		writeV2Error(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("%s/schemas/%s", v2Prefix, schemaDef.Name))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// Schema state is listed one challenge at a time, each with the builds and
+// instances that the schema holds for it.
+var schemaStateListing = listing[*cmgr.ChallengeMetadata]{
+	key: func(c *cmgr.ChallengeMetadata) string { return string(c.Id) },
+	filters: map[string]fieldFilter[*cmgr.ChallengeMetadata]{
+		"id": matchString(func(c *cmgr.ChallengeMetadata) string {
+			return string(c.Id)
+		}),
+		"namespace": matchString(func(c *cmgr.ChallengeMetadata) string {
+			return c.Namespace
+		}),
+		"challenge_type": matchString(func(c *cmgr.ChallengeMetadata) string {
+			return c.ChallengeType
+		}),
+		"category": matchString(func(c *cmgr.ChallengeMetadata) string {
+			return c.Category
+		}),
+		"tag": matchAny(func(c *cmgr.ChallengeMetadata) []string {
+			return c.Tags
+		}),
+	},
+}
+
+func (s state) v2GetSchema(w http.ResponseWriter, r *http.Request) {
+	challenges, err := s.mgr.GetSchemaState(r.PathValue("schema"))
+	if err != nil {
+		writeV2Error(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	page, err := schemaStateListing.page(r.URL.Query(), challenges)
+	if err != nil {
+		writeV2Error(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+func (s state) v2UpdateSchema(w http.ResponseWriter, r *http.Request) {
+	var schemaDef cmgr.Schema
+	if err := s.decodeJSON(w, r, &schemaDef); err != nil {
+		writeV2Error(w, requestBodyStatus(err), err)
+		return
+	}
+	if schemaDef.Name != r.PathValue("schema") {
+		writeV2Error(
+			w,
+			http.StatusBadRequest,
+			invalidQuery("mismatch between endpoint and schema name"),
+		)
+		return
+	}
+
+	if isAsync(r) {
+		job, err := s.mgr.SubmitUpdateSchema(&schemaDef)
+		if err != nil {
+			writeV2Error(w, errorStatus(err, http.StatusInternalServerError), err)
+			return
+		}
+		writeJobAccepted(w, v2Prefix, job)
+		return
+	}
+
+	if errs := s.mgr.UpdateSchema(&schemaDef); len(errs) > 0 {
+		err := errors.Join(errs...)
+		writeV2Error(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s state) v2DeleteSchema(w http.ResponseWriter, r *http.Request) {
+	if err := s.mgr.DeleteSchema(r.PathValue("schema")); err != nil {
+		writeV2Error(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s state) v2GetJob(w http.ResponseWriter, r *http.Request) {
+	job, err := pathInt(r, "job")
+	if err != nil {
+		writeV2Error(w, http.StatusBadRequest, err)
+		return
+	}
+	meta, err := s.mgr.GetJob(cmgr.JobId(job))
+	if err != nil {
+		writeV2Error(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, meta)
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/ArmyCyberInstitute/cmgr/cmgr"
+)
+
+func TestAPIErrorDerivesCodeFromErrorType(t *testing.T) {
+	tests := []struct {
+		status int
+		err    error
+		code   string
+	}{
+		{
+			http.StatusNotFound,
+			fmt.Errorf("wrapped: %w", &cmgr.UnknownIdentifierError{Type: "build", Name: "7"}),
+			codeUnknownIdentifier,
+		},
+		{http.StatusBadRequest, &cmgr.InvalidInputError{Err: errors.New("bad")}, codeInvalidInput},
+		{http.StatusConflict, &cmgr.ConflictError{Err: errors.New("busy")}, codeConflict},
+		{http.StatusBadRequest, &http.MaxBytesError{Limit: 8}, codeRequestTooLarge},
+		{http.StatusForbidden, errors.New("denied"), codeForbidden},
+		{http.StatusInternalServerError, errors.New("broken"), codeInternal},
+	}
+	for _, test := range tests {
+		result := apiError(test.status, test.err)
+		if result.Code != test.code || result.Message != test.err.Error() {
+			t.Errorf("%v: unexpected error %+v", test.err, result)
+		}
+	}
+
+	result := apiError(http.StatusNotFound, tests[0].err)
+	if result.IdentifierType != "build" || result.Identifier != "7" {
+		t.Fatalf("identifier was not reported: %+v", result)
+	}
+}
+
+func decodeErrorResponse(t *testing.T, response *httptest.ResponseRecorder) APIError {
+	t.Helper()
+	if contentType := response.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Fatalf("unexpected content type %q", contentType)
+	}
+	var body ErrorResponse
+	if err := json.Unmarshal(response.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid error envelope %q: %v", response.Body.String(), err)
+	}
+	return body.Error
+}
+
+func TestV2RouterAnswersWithErrorEnvelope(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		status int
+		code   string
+		allow  string
+	}{
+		{http.MethodGet, "/v2/unknown", http.StatusNotFound, codeNotFound, ""},
+		{http.MethodPut, "/v2/builds/1", http.StatusMethodNotAllowed, codeMethodNotAllowed, "GET, POST, DELETE"},
+		{http.MethodDelete, "/v2/jobs/1", http.StatusMethodNotAllowed, codeMethodNotAllowed, "GET"},
+		{http.MethodGet, "/v2/builds/latest", http.StatusBadRequest, codeInvalidInput, ""},
+		{http.MethodGet, "/v2/instances/1/checks/last/log", http.StatusBadRequest, codeInvalidInput, ""},
+	}
+	handler := state{}.v2Handler()
+	for _, test := range tests {
+		t.Run(test.method+" "+test.path, func(t *testing.T) {
+			response := httptest.NewRecorder()
+			handler.ServeHTTP(response, httptest.NewRequest(test.method, test.path, nil))
+			if response.Code != test.status {
+				t.Fatalf("unexpected status %d", response.Code)
+			}
+			if result := decodeErrorResponse(t, response); result.Code != test.code {
+				t.Fatalf("unexpected code %q", result.Code)
+			}
+			if allow := response.Header().Get("Allow"); allow != test.allow {
+				t.Fatalf("unexpected Allow header %q", allow)
+			}
+		})
+	}
+}
+
+func TestV2RequestBodyErrorsUseEnvelope(t *testing.T) {
+	handler := state{maxRequestBytes: 16}.v2Handler()
+	for body, code := range map[string]string{
+		`{"seedz":[1]}`:               codeInvalidInput,
+		`{"seeds":[1,2,3,4,5,6,7,8]}`: codeRequestTooLarge,
+	} {
+		response := httptest.NewRecorder()
+		request := httptest.NewRequest(
+			http.MethodPost,
+			"/v2/challenges/example/challenge",
+			strings.NewReader(body),
+		)
+		handler.ServeHTTP(response, request)
+		if result := decodeErrorResponse(t, response); result.Code != code {
+			t.Fatalf("%s: unexpected code %q (status %d)", body, result.Code, response.Code)
+		}
+	}
+}
+
+func TestListingPagesWithCursorAndFilters(t *testing.T) {
+	checks := []cmgr.InstanceCheck{
+		{Number: 10, Running: true},
+		{Number: 2},
+		{Number: 9},
+		{Number: 1},
+	}
+
+	query := url.Values{"limit": {"2"}, "running": {"false"}}
+	page, err := checkListing.page(query, checks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Items) != 2 || page.Items[0].Number != 1 || page.Items[1].Number != 2 {
+		t.Fatalf("unexpected first page %+v", page.Items)
+	}
+	if page.NextCursor == "" {
+		t.Fatal("first page has no cursor")
+	}
+
+	query.Set("cursor", page.NextCursor)
+	page, err = checkListing.page(query, checks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Items) != 1 || page.Items[0].Number != 9 || page.NextCursor != "" {
+		t.Fatalf("unexpected last page %+v", page)
+	}
+
+	page, err = checkListing.page(url.Values{"running": {"true", "false"}}, checks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Items) != 4 || page.Items[3].Number != 10 {
+		t.Fatalf("repeated filter values did not match any value: %+v", page.Items)
+	}
+}
+
+func TestListingFiltersOnFieldsAndTags(t *testing.T) {
+	challenges := []*cmgr.ChallengeMetadata{
+		{Id: "b/two", Namespace: "b", Category: "web", Tags: []string{"easy"}},
+		{Id: "a/one", Namespace: "a", Category: "web", Tags: []string{"hard", "sqli"}},
+		{Id: "a/three", Namespace: "a", Category: "pwn", Tags: []string{"easy"}},
+	}
+	page, err := schemaStateListing.page(
+		url.Values{"category": {"web"}, "tag": {"easy", "sqli"}},
+		challenges,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Items) != 2 || page.Items[0].Id != "a/one" || page.Items[1].Id != "b/two" {
+		t.Fatalf("unexpected items %+v", page.Items)
+	}
+}
+
+func TestListingRejectsInvalidQueries(t *testing.T) {
+	for _, query := range []url.Values{
+		{"limit": {"0"}},
+		{"limit": {"many"}},
+		{"cursor": {"not base64!"}},
+		{"colour": {"red"}},
+		{"running": {"sometimes"}},
+	} {
+		_, err := checkListing.page(query, []cmgr.InstanceCheck{{Number: 1}})
+		var invalid *cmgr.InvalidInputError
+		if !errors.As(err, &invalid) {
+			t.Errorf("%v: expected invalid input, got %v", query, err)
+		}
+	}
+}
+
+func TestInstrumentRecordsV2RoutePatterns(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/v2/", state{}.v2Handler())
+	hm := newHTTPMetrics()
+	handler := hm.instrument(mux, mux)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v2/builds/x", nil))
+
+	var output strings.Builder
+	if err := hm.registry.WriteText(&output); err != nil {
+		t.Fatal(err)
+	}
+	expected := `cmgrd_http_request_duration_seconds_count{handler="GET /v2/builds/{build}",method="GET",code="400"} 1`
+	if !strings.Contains(output.String(), expected) {
+		t.Fatalf("metrics are missing %q:\n%s", expected, output.String())
+	}
+}
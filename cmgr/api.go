@@ -5,7 +5,9 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"net/url"
 	"strings"
+	"time"
 
 	"github.com/ArmyCyberInstitute/cmgr/cmgr/dockerfiles"
 )
@@ -25,11 +27,31 @@ func Version() string {
 
 // Creates a new instance of the challenge manager validating the appropriate
 // environment variables in the process.  A return value of `nil` indicates
-// a fatal error occurred during intitialization.
+// a fatal error occurred during intitialization.  Unfinished jobs of
+// processes that have exited are marked failed.
 func NewManager(logLevel LogLevel) *Manager {
+	return NewManagerWithRuntime(logLevel, nil)
+}
+
+// Creates a new instance of the challenge manager that builds and runs
+// challenges with the given container runtime rather than the Docker daemon
+// named by the environment.  A `nil` runtime is the same as `NewManager`.
+func NewManagerWithRuntime(logLevel LogLevel, runtime ContainerRuntime) *Manager {
+	return newManager(logLevel, runtime, false)
+}
+
+// Creates a challenge manager for a long-lived process such as cmgrd, which
+// resumes the unfinished jobs of processes that have exited rather than
+// marking them failed.  It must outlive the jobs it resumes.
+func NewDaemonManager(logLevel LogLevel) *Manager {
+	return newManager(logLevel, nil, true)
+}
+
+func newManager(logLevel LogLevel, runtime ContainerRuntime, resumeJobs bool) *Manager {
 	mgr := new(Manager)
 	mgr.log = newLogger(logLevel)
 	mgr.buildLocks = make(map[string]*buildLock)
+	mgr.metrics = newManagerMetrics()
 
 	mgr.log.infof("version: %s", Version())
 
@@ -42,7 +64,7 @@ func NewManager(logLevel LogLevel) *Manager {
 		return nil
 	}
 
-	if err := mgr.initDocker(); err != nil {
+	if err := mgr.initDocker(runtime); err != nil {
 		return nil
 	}
 
@@ -69,9 +91,27 @@ func NewManager(logLevel LogLevel) *Manager {
 		mgr.log.debug("skipping startup cleanup while another operation is active")
 	}
 
+	if err := mgr.initJobs(); err != nil {
+		mgr.log.error(err)
+		return nil
+	}
+	if err := mgr.recoverJobs(resumeJobs); err != nil {
+		mgr.log.errorf("could not recover unfinished jobs: %s", err)
+		return nil
+	}
+
 	return mgr
 }
 
+// Waits for the manager's background work, such as refilling warm pools and
+// running submitted jobs, and then closes its database.  A process that exits
+// without calling Close may interrupt that work partway through.
+func (m *Manager) Close() error {
+	m.poolWorkers.Wait()
+	m.jobs.Wait()
+	return m.db.Close()
+}
+
 func randomIdentifier() (string, error) {
 	var value [16]byte
 	if _, err := rand.Read(value[:]); err != nil {
@@ -134,6 +174,19 @@ func validateSchemaDefinition(schema *Schema) error {
 		if err := validateSeeds(spec.Seeds); err != nil {
 			return fmt.Errorf("challenge %q: %w", challenge, err)
 		}
+		if spec.WarmPool < 0 {
+			return fmt.Errorf(
+				"challenge %q has invalid warm_pool %d; it cannot be negative",
+				challenge,
+				spec.WarmPool,
+			)
+		}
+		if spec.WarmPool > 0 && spec.InstanceCount != DYNAMIC_INSTANCES {
+			return fmt.Errorf(
+				"challenge %q has a warm_pool but only dynamic builds (instance_count -1) can have one",
+				challenge,
+			)
+		}
 	}
 	return nil
 }
@@ -308,23 +361,48 @@ func (m *Manager) Build(challenge ChallengeId, seeds []int, flagFormat string) (
 	}
 	defer release()
 
+	if err := m.validateBuildRequest(seeds, flagFormat); err != nil {
+		return nil, err
+	}
+	randomSuffix, err := randomIdentifier()
+	if err != nil {
+		return nil, err
+	}
+	return m.buildInSchema(
+		manualSchemaPrefix+randomSuffix,
+		challenge,
+		seeds,
+		flagFormat,
+		nil,
+	)
+}
+
+func (m *Manager) validateBuildRequest(seeds []int, flagFormat string) error {
 	if len(seeds) == 0 {
-		return nil, invalidInput(errors.New("at least one seed is required"))
+		return invalidInput(errors.New("at least one seed is required"))
 	}
 	if err := validateFlagFormat(flagFormat); err != nil {
-		return nil, invalidInput(err)
+		return invalidInput(err)
 	}
 	if err := validateSeeds(seeds); err != nil {
-		return nil, invalidInput(err)
+		return invalidInput(err)
 	}
 	if err := m.validateSeedLimit(len(seeds)); err != nil {
-		return nil, invalidInput(err)
-	}
-	randomSuffix, err := randomIdentifier()
-	if err != nil {
-		return nil, err
+		return invalidInput(err)
 	}
-	schema := manualSchemaPrefix + randomSuffix
+	return nil
+}
+
+// Builds the seeds into the given manual schema.  The schema record is
+// created if it is missing so that an interrupted build job can resume into
+// the schema it originally reserved and reuse any seeds already built there.
+func (m *Manager) buildInSchema(
+	schema string,
+	challenge ChallengeId,
+	seeds []int,
+	flagFormat string,
+	progress buildProgress,
+) ([]*BuildMetadata, error) {
 	instanceCount := -1
 
 	builds := make([]*BuildMetadata, len(seeds))
@@ -337,11 +415,14 @@ func (m *Manager) Build(challenge ChallengeId, seeds []int, flagFormat string) (
 			InstanceCount: instanceCount,
 		}
 	}
-	err = m.createSchemaRecord(schema, true)
+	exists, err := m.schemaExists(schema)
+	if err == nil && !exists {
+		err = m.createSchemaRecord(schema, true)
+	}
 	if err != nil {
 		return nil, err
 	}
-	err = m.generateBuilds(builds)
+	err = m.generateBuilds(builds, progress)
 	if err != nil {
 		var cleanupErrors []error
 		buildIDs, lookupErr := m.getSchemaBuilds(schema)
@@ -363,8 +444,15 @@ func (m *Manager) Build(challenge ChallengeId, seeds []int, flagFormat string) (
 }
 
 // Creates a running "instance" of the given build and returns its identifier
-// on success otherwise an error.
-func (m *Manager) Start(build BuildId) (InstanceId, error) {
+// on success otherwise an error.  An optional TTL sets when the instance
+// expires; expired instances are listed by `ExpiredInstances` so that a
+// service such as cmgrd can stop them.
+func (m *Manager) Start(build BuildId, ttl ...time.Duration) (InstanceId, error) {
+	expires, err := optionalExpiry(ttl)
+	if err != nil {
+		return 0, err
+	}
+
 	release, err := m.acquireOperationLock(false)
 	if err != nil {
 		return 0, err
@@ -383,19 +471,269 @@ func (m *Manager) Start(build BuildId) (InstanceId, error) {
 		)}
 	}
 
-	return m.newInstance(bMeta)
+	return m.newInstance(bMeta, expires)
 }
 
-func (m *Manager) newInstance(build *BuildMetadata) (id InstanceId, err error) {
-	iMeta := &InstanceMetadata{
-		Build:      build.Id,
-		Ports:      make(map[string]int),
-		Containers: []string{},
+// Hands out an idle instance from the warm pool of a dynamic build, which
+// is immediate, and refills the pool in the background.  When the pool is
+// empty, a new instance is started just as `Start` would start it.  An
+// optional TTL counts from the time of the claim.
+func (m *Manager) Claim(build BuildId, ttl ...time.Duration) (InstanceId, error) {
+	expires, err := optionalExpiry(ttl)
+	if err != nil {
+		return 0, err
+	}
+
+	release, err := m.acquireOperationLock(false)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	bMeta, err := m.lookupBuildMetadata(build)
+	if err != nil {
+		return 0, err
 	}
-	err = m.openInstance(iMeta)
+	if bMeta.InstanceCount != DYNAMIC_INSTANCES {
+		return 0, &ConflictError{Err: errors.New(
+			"locked build: change the schema definition to start more instances",
+		)}
+	}
+	return m.claimInstance(bMeta, expires)
+}
+
+// The caller must hold the operation lock and have checked that the build is
+// dynamic.
+func (m *Manager) claimInstance(bMeta *BuildMetadata, expires int64) (InstanceId, error) {
+	instance, err := m.claimPooledInstance(bMeta.Id, expires)
 	if err != nil {
 		return 0, err
 	}
+	if bMeta.WarmPool > 0 {
+		m.refillWarmPoolInBackground(bMeta.Id)
+	}
+	if instance != 0 {
+		return instance, nil
+	}
+	m.log.debugf("warm pool of build %d is empty; starting a new instance", bMeta.Id)
+	return m.newInstance(bMeta, expires)
+}
+
+// Starts or stops pooled instances so that every warm pool holds the number
+// of idle instances that its schema asks for.  Services such as cmgrd call
+// it periodically so that pools recover after a restart or a failed refill.
+func (m *Manager) RefillWarmPools() error {
+	release, err := m.acquireOperationLock(false)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	builds, err := m.queryWarmPoolBuilds()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, build := range builds {
+		bMeta, err := m.lookupBuildMetadata(build)
+		if err == nil {
+			err = m.convergeWarmPool(bMeta)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func optionalExpiry(ttl []time.Duration) (int64, error) {
+	switch len(ttl) {
+	case 0:
+		return 0, nil
+	case 1:
+		return expiryAfter(ttl[0])
+	default:
+		return 0, invalidInput(errors.New("at most one TTL may be given"))
+	}
+}
+
+func expiryAfter(ttl time.Duration) (int64, error) {
+	if ttl < time.Second {
+		return 0, invalidInput(fmt.Errorf("TTL must be at least one second: %s", ttl))
+	}
+	return time.Now().Add(ttl).Unix(), nil
+}
+
+// Pushes back the expiry of a dynamic instance so that it is stopped no
+// sooner than `ttl` from now.  An instance that was started without a TTL
+// expires after `ttl`.
+func (m *Manager) ExtendInstance(instance InstanceId, ttl time.Duration) error {
+	expires, err := expiryAfter(ttl)
+	if err != nil {
+		return err
+	}
+
+	release, err := m.acquireOperationLock(false)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	iMeta, err := m.lookupDynamicInstance(instance, "expire")
+	if err != nil {
+		return err
+	}
+	if expires <= iMeta.Expires {
+		return nil
+	}
+	return m.setInstanceExpiry(instance, expires)
+}
+
+// Stops the containers of a dynamic instance without removing them so that
+// its filesystem state and port assignments are kept while it uses no CPU or
+// memory.  A paused instance cannot be checked until it is restarted with
+// `Resume`, but it can still be stopped.
+func (m *Manager) Pause(instance InstanceId) error {
+	release, err := m.acquireOperationLock(false)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	iMeta, err := m.lookupDynamicInstance(instance, "pause")
+	if err != nil {
+		return err
+	}
+	if iMeta.Paused {
+		return &ConflictError{Err: fmt.Errorf("instance %d is already paused", instance)}
+	}
+
+	// Record the state first so that startup recovery leaves the containers
+	// stopped if cmgr exits part way through.
+	if err := m.setInstancePaused(instance, true); err != nil {
+		return err
+	}
+	paused, err := m.pauseContainerProcesses(iMeta.Containers)
+	if err == nil {
+		return nil
+	}
+	if resumeErr := m.resumeContainerProcesses(paused); resumeErr != nil {
+		return fmt.Errorf(
+			"%w; partially paused containers could not be resumed: %v",
+			err,
+			resumeErr,
+		)
+	}
+	return errors.Join(err, m.setInstancePaused(instance, false))
+}
+
+// Restarts the containers of an instance paused by `Pause`.
+func (m *Manager) Resume(instance InstanceId) error {
+	release, err := m.acquireOperationLock(false)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	iMeta, err := m.lookupDynamicInstance(instance, "resume")
+	if err != nil {
+		return err
+	}
+	if !iMeta.Paused {
+		return &ConflictError{Err: fmt.Errorf("instance %d is not paused", instance)}
+	}
+	if err := m.resumeContainerProcesses(iMeta.Containers); err != nil {
+		return err
+	}
+	return m.setInstancePaused(instance, false)
+}
+
+// Recreates the containers of an instance from its build's images, discarding
+// any changes made to them, while keeping the instance's identifier and port
+// assignments.  If the new containers cannot be started, the old ones are
+// kept.  A paused instance is paused again once it has been reset.
+func (m *Manager) Reset(instance InstanceId) error {
+	release, err := m.acquireOperationLock(false)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	iMeta, err := m.lookupInstanceMetadata(instance)
+	if err != nil {
+		return err
+	}
+	bMeta, err := m.lookupBuildMetadata(iMeta.Build)
+	if err != nil {
+		return err
+	}
+	cMeta, err := m.lookupChallengeMetadata(bMeta.Challenge)
+	if err != nil {
+		return err
+	}
+
+	// The cutover used by challenge updates stops the old containers so that
+	// their host ports can be reused and restores them if the replacements
+	// fail.
+	cutover, err := m.prepareInstanceCutover(
+		bMeta,
+		iMeta,
+		cMeta.ChallengeOptions.Overrides,
+	)
+	if err != nil {
+		return err
+	}
+	return m.finishInstanceCutover(cutover)
+}
+
+func (m *Manager) lookupDynamicInstance(
+	instance InstanceId,
+	action string,
+) (*InstanceMetadata, error) {
+	iMeta, err := m.lookupInstanceMetadata(instance)
+	if err != nil {
+		return nil, err
+	}
+	bMeta, err := m.lookupBuildMetadata(iMeta.Build)
+	if err != nil {
+		return nil, err
+	}
+	if bMeta.InstanceCount != DYNAMIC_INSTANCES {
+		return nil, &ConflictError{Err: fmt.Errorf(
+			"locked build: instances controlled by a schema cannot %s",
+			action,
+		)}
+	}
+	return iMeta, nil
+}
+
+// Lists the instances whose TTL has run out, soonest expiry first.  They
+// keep running until they are stopped with `Stop`.
+func (m *Manager) ExpiredInstances() ([]InstanceId, error) {
+	return m.queryExpiredInstances(time.Now().Unix())
+}
+
+func (m *Manager) newInstance(build *BuildMetadata, expires int64) (InstanceId, error) {
+	return m.launchInstance(build, &InstanceMetadata{Expires: expires})
+}
+
+// Records the instance and starts its network and containers, removing
+// whatever was started if any step fails.
+func (m *Manager) launchInstance(
+	build *BuildMetadata,
+	iMeta *InstanceMetadata,
+) (id InstanceId, err error) {
+	started := time.Now()
+	defer func() { m.metrics.observeInstanceStart(build.Challenge, started, err) }()
+	iMeta.Build = build.Id
+	iMeta.Ports = make(map[string]int)
+	iMeta.Containers = []string{}
+	err = m.openInstanceWithinBudget(build, iMeta)
+	if err != nil {
+		return 0, err
+	}
+	m.removeInstanceLogs(iMeta.Id)
+	m.removeInstanceCaptures(iMeta.Id)
 	complete := false
 	defer func() {
 		if complete {
@@ -469,8 +807,17 @@ func (m *Manager) Stop(instance InstanceId) error {
 	return m.stopInstance(iMeta)
 }
 
-func (m *Manager) stopInstance(instance *InstanceMetadata) error {
-	err := m.stopContainers(instance)
+func (m *Manager) stopInstance(instance *InstanceMetadata) (err error) {
+	started := time.Now()
+	if m.metrics != nil {
+		challenge, lookupErr := m.lookupBuildChallenge(instance.Build)
+		if lookupErr != nil {
+			m.log.warnf("could not find challenge of instance %d: %v", instance.Id, lookupErr)
+		}
+		defer func() { m.metrics.observeInstanceStop(challenge, started, err) }()
+	}
+
+	err = m.stopContainers(instance)
 	if err != nil {
 		return err
 	}
@@ -480,7 +827,11 @@ func (m *Manager) stopInstance(instance *InstanceMetadata) error {
 		return err
 	}
 
-	return m.removeInstanceMetadata(instance.Id)
+	if err := m.removeInstanceMetadata(instance.Id); err != nil {
+		return err
+	}
+	m.removeInstanceLogs(instance.Id)
+	return nil
 }
 
 // Destroys the assoicated "build".
@@ -513,16 +864,79 @@ func (m *Manager) Destroy(build BuildId) error {
 	return m.deleteSchemaRecordIfEmpty(bMeta.Schema)
 }
 
-// Runs the automated solver against the designated instance.
+// Runs the automated solver against the designated instance.  Solvers cannot
+// run on Kubernetes, where every check is refused with a `ConflictError`.
 func (m *Manager) CheckInstance(instance InstanceId) error {
+	if !m.ChecksAvailable() {
+		return &ConflictError{Err: kubernetesUnsupported("instances cannot be checked")}
+	}
 	release, err := m.acquireOperationLock(false)
 	if err != nil {
 		return err
 	}
 	defer release()
+	iMeta, err := m.lookupInstanceMetadata(instance)
+	if err != nil {
+		return err
+	}
+	if iMeta.Paused {
+		return &ConflictError{Err: fmt.Errorf(
+			"instance %d is paused: resume it before checking it",
+			instance,
+		)}
+	}
 	return m.runSolver(instance)
 }
 
+// Lists the instances that `CheckInstance` can check: those that are not
+// paused and whose challenge has a solve script.
+func (m *Manager) CheckableInstances() ([]InstanceId, error) {
+	if !m.ChecksAvailable() {
+		return nil, nil
+	}
+	return m.queryCheckableInstances()
+}
+
+// Whether the runtime can run solvers, which Kubernetes cannot.
+func (m *Manager) ChecksAvailable() bool {
+	return !m.kubernetes
+}
+
+// Reports whether the challenge's solver passed the most recent check of
+// each of its instances.
+func (m *Manager) GetChallengeSolvability(challenge ChallengeId) (*ChallengeSolvability, error) {
+	cMeta, err := m.lookupChallengeMetadata(challenge)
+	if err != nil {
+		return nil, err
+	}
+	instances, err := m.queryChallengeInstanceChecks(challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ChallengeSolvability{
+		Challenge: challenge,
+		Status:    CHALLENGE_UNCHECKED,
+		Instances: instances,
+	}
+	if !cMeta.SolveScript {
+		result.Status = CHALLENGE_NO_SOLVER
+	}
+	for _, instance := range instances {
+		result.LastChecked = max(result.LastChecked, instance.LastChecked)
+		result.LastSolved = max(result.LastSolved, instance.LastSolved)
+		if result.Status == CHALLENGE_NO_SOLVER || instance.LastChecked == 0 {
+			continue
+		}
+		if instance.LastCheckError != "" {
+			result.Status = CHALLENGE_UNSOLVABLE
+		} else if result.Status == CHALLENGE_UNCHECKED {
+			result.Status = CHALLENGE_SOLVABLE
+		}
+	}
+	return result, nil
+}
+
 // Obtains a list of challenges with minimal version information filled into
 // the metadata object.
 func (m *Manager) ListChallenges() []*ChallengeMetadata {
@@ -560,6 +974,22 @@ func (m *Manager) MaxRequestBytes() int64 {
 	return 1024 * 1024
 }
 
+// Returns the public base URL of the reverse proxy, or nil when challenge
+// ports are published on the host.
+func (m *Manager) ProxyURL() *url.URL {
+	if m.policy.ProxyURL == nil {
+		return nil
+	}
+	proxyURL := *m.policy.ProxyURL
+	return &proxyURL
+}
+
+// Returns the public "host:port" of the TLS gateway, or an empty string when
+// challenge ports are published on the host.
+func (m *Manager) TLSGateway() string {
+	return m.policy.TLSGateway
+}
+
 // Lists all schemas as currently defined in the database.
 func (m *Manager) ListSchemas() ([]string, error) {
 	return m.queryForSchemas()
@@ -577,13 +1007,8 @@ func (m *Manager) CreateSchema(schema *Schema) []error {
 	}
 	defer release()
 
-	if err := validateSchemaDefinition(schema); err != nil {
-		return []error{invalidInput(err)}
-	}
-	for challenge, spec := range schema.Challenges {
-		if err := m.validateSeedLimit(len(spec.Seeds)); err != nil {
-			return []error{invalidInput(fmt.Errorf("challenge %q: %w", challenge, err))}
-		}
+	if err := m.validateSchemaRequest(schema); err != nil {
+		return []error{err}
 	}
 	exists, err := m.schemaExists(schema.Name)
 	if err != nil {
@@ -595,7 +1020,25 @@ func (m *Manager) CreateSchema(schema *Schema) []error {
 	if err := m.createSchemaRecord(schema.Name, false); err != nil {
 		return []error{err}
 	}
-	errs := m.convergeSchema(schema)
+	return m.convergeNewSchema(schema, nil)
+}
+
+func (m *Manager) validateSchemaRequest(schema *Schema) error {
+	if err := validateSchemaDefinition(schema); err != nil {
+		return invalidInput(err)
+	}
+	for challenge, spec := range schema.Challenges {
+		if err := m.validateSeedLimit(len(spec.Seeds)); err != nil {
+			return invalidInput(fmt.Errorf("challenge %q: %w", challenge, err))
+		}
+	}
+	return nil
+}
+
+// Converges a freshly recorded schema and removes it again if convergence
+// fails before any of its builds were activated.
+func (m *Manager) convergeNewSchema(schema *Schema, progress buildProgress) []error {
+	errs := m.convergeSchema(schema, progress)
 	if len(errs) != 0 {
 		m.schemaMu.Lock()
 		var activeBuilds int
@@ -630,13 +1073,8 @@ func (m *Manager) UpdateSchema(schema *Schema) []error {
 	}
 	defer release()
 
-	if err := validateSchemaDefinition(schema); err != nil {
-		return []error{invalidInput(err)}
-	}
-	for challenge, spec := range schema.Challenges {
-		if err := m.validateSeedLimit(len(spec.Seeds)); err != nil {
-			return []error{invalidInput(fmt.Errorf("challenge %q: %w", challenge, err))}
-		}
+	if err := m.validateSchemaRequest(schema); err != nil {
+		return []error{err}
 	}
 	exists, err := m.schemaExists(schema.Name)
 	if err != nil {
@@ -645,10 +1083,10 @@ func (m *Manager) UpdateSchema(schema *Schema) []error {
 		return []error{unknownSchemaIdError(schema.Name)}
 	}
 
-	return m.convergeSchema(schema)
+	return m.convergeSchema(schema, nil)
 }
 
-func (m *Manager) convergeSchema(schema *Schema) []error {
+func (m *Manager) convergeSchema(schema *Schema, progress buildProgress) []error {
 	m.schemaMu.Lock()
 	defer m.schemaMu.Unlock()
 
@@ -700,6 +1138,7 @@ func (m *Manager) convergeSchema(schema *Schema) []error {
 				Challenge:     challenge,
 				Schema:        schema.Name,
 				InstanceCount: spec.InstanceCount,
+				WarmPool:      spec.WarmPool,
 			}
 			if err := m.stageBuild(build); err != nil {
 				return failBeforeActivation(err)
@@ -710,7 +1149,7 @@ func (m *Manager) convergeSchema(schema *Schema) []error {
 		buildGroups = append(buildGroups, group)
 	}
 	for _, builds := range buildGroups {
-		if err := m.generateBuilds(builds); err != nil {
+		if err := m.generateBuilds(builds, progress); err != nil {
 			return failBeforeActivation(err)
 		}
 	}
@@ -718,6 +1157,9 @@ func (m *Manager) convergeSchema(schema *Schema) []error {
 	// Scale up before the atomic activation. This preserves the prior schema
 	// capacity throughout a replacement and avoids destructive convergence on
 	// a build or container failure.
+	if err := m.checkSchemaCapacity(state); err != nil {
+		return failBeforeActivation(err)
+	}
 	for _, build := range state {
 		target := build.InstanceCount
 		if target == DYNAMIC_INSTANCES {
@@ -728,7 +1170,7 @@ func (m *Manager) convergeSchema(schema *Schema) []error {
 			return failBeforeActivation(err)
 		}
 		for i := len(instances); i < target; i++ {
-			instanceID, err := m.newInstance(build)
+			instanceID, err := m.newInstance(build, 0)
 			if err != nil {
 				return failBeforeActivation(err)
 			}
@@ -736,9 +1178,12 @@ func (m *Manager) convergeSchema(schema *Schema) []error {
 		}
 	}
 
-	targets := make(map[BuildId]int, len(state))
+	targets := make(map[BuildId]buildTarget, len(state))
 	for _, build := range state {
-		targets[build.Id] = build.InstanceCount
+		targets[build.Id] = buildTarget{
+			instanceCount: build.InstanceCount,
+			warmPool:      build.WarmPool,
+		}
 	}
 	if err := m.activateSchemaBuilds(schema.Name, targets); err != nil {
 		return failBeforeActivation(err)
@@ -771,6 +1216,17 @@ func (m *Manager) convergeSchema(schema *Schema) []error {
 			}
 		}
 	}
+
+	// Warm pools are filled once the schema is active.  A pool that cannot be
+	// filled now is refilled later by `RefillWarmPools`.
+	for _, build := range state {
+		if build.InstanceCount != DYNAMIC_INSTANCES {
+			continue
+		}
+		if err := m.convergeWarmPool(build); err != nil {
+			errs = append(errs, err)
+		}
+	}
 	return errs
 }
 
@@ -912,6 +1368,40 @@ func (m *Manager) GetInstanceMetadata(instance InstanceId) (*InstanceMetadata, e
 	return m.lookupInstanceMetadata(instance)
 }
 
+// Fills in the health, restart count, and crash-loop state that the runtime
+// reports for the containers of each instance.  Every container is inspected,
+// so metadata reads leave these fields empty unless the caller asks for them.
+func (m *Manager) InspectInstanceRuntime(instances ...*InstanceMetadata) {
+	for _, instance := range instances {
+		m.inspectInstanceRuntime(instance)
+	}
+}
+
+// Samples the CPU, memory, PID, network, and block I/O usage of each of the
+// instance's containers.  Sampling takes about a second because Docker
+// measures CPU usage over that interval.
+func (m *Manager) InstanceStats(instance InstanceId) (*InstanceStats, error) {
+	iMeta, err := m.lookupInstanceMetadata(instance)
+	if err != nil {
+		return nil, err
+	}
+	bMeta, err := m.lookupBuildMetadata(iMeta.Build)
+	if err != nil {
+		return nil, err
+	}
+	containers, err := m.collectInstanceStats(iMeta)
+	if err != nil {
+		return nil, err
+	}
+	return &InstanceStats{
+		Instance:   instance,
+		Build:      bMeta.Id,
+		Challenge:  bMeta.Challenge,
+		Total:      totalResourceUsage(containers),
+		Containers: containers,
+	}, nil
+}
+
 func (m *Manager) DumpState(challenges []ChallengeId) ([]*ChallengeMetadata, error) {
 	allChallenges, err := m.dumpState()
 	if len(challenges) == 0 {
@@ -118,7 +118,7 @@ func TestConvergeSchemaRechecksOwnershipUnderLock(t *testing.T) {
 	errs := manager.convergeSchema(&Schema{
 		Name:       "deleted-before-convergence",
 		FlagFormat: "flag{%s}",
-	})
+	}, nil)
 	if len(errs) != 1 {
 		t.Fatalf("unexpected convergence errors: %v", errs)
 	}
@@ -127,3 +127,33 @@ func TestConvergeSchemaRechecksOwnershipUnderLock(t *testing.T) {
 		t.Fatalf("missing schema was not reported as an unknown identifier: %v", errs[0])
 	}
 }
+
+func TestValidateSchemaDefinitionRestrictsWarmPools(t *testing.T) {
+	tests := map[string]BuildSpecification{
+		"negative pool":       {Seeds: []int{1}, InstanceCount: DYNAMIC_INSTANCES, WarmPool: -1},
+		"pool of fixed build": {Seeds: []int{1}, InstanceCount: 1, WarmPool: 2},
+	}
+	for name, spec := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := validateSchemaDefinition(&Schema{
+				Name:       "pool",
+				FlagFormat: "flag{%s}",
+				Challenges: map[ChallengeId]BuildSpecification{"challenge": spec},
+			})
+			if err == nil {
+				t.Fatal("invalid warm pool was accepted")
+			}
+		})
+	}
+
+	err := validateSchemaDefinition(&Schema{
+		Name:       "pool",
+		FlagFormat: "flag{%s}",
+		Challenges: map[ChallengeId]BuildSpecification{
+			"challenge": {Seeds: []int{1}, InstanceCount: DYNAMIC_INSTANCES, WarmPool: 5},
+		},
+	})
+	if err != nil {
+		t.Fatalf("warm pool of a dynamic build was rejected: %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+package cmgr
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// How many times `Assign` starts an instance for a user before giving up
+// because other users keep filling each new instance first.
+const maxAssignmentAttempts = 3
+
+// Returns the user's assignment to an instance of the challenge among the
+// schema's builds, making one if they have none.  The user may be any
+// identifier chosen by the front end, such as a team's.  A new assignment
+// goes to the running instance with the fewest assignees that still has fewer
+// than the challenge's `MaxUsers` (any instance when it is zero).  When every
+// instance is full, an instance is claimed from the schema's dynamic build
+// with the fewest instances, and the optional TTL applies to it.  Without a
+// dynamic build, a full challenge is reported as a ConflictError.
+// Assignments are released when their instance stops or by `Unassign`.
+func (m *Manager) Assign(
+	schema string,
+	challenge ChallengeId,
+	user string,
+	ttl ...time.Duration,
+) (*Assignment, error) {
+	if user == "" {
+		return nil, invalidInput(errors.New("user cannot be empty"))
+	}
+	expires, err := optionalExpiry(ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := m.acquireOperationLock(false)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	assignment, err := m.lookupAssignment(schema, challenge, user)
+	if err != nil || assignment != nil {
+		return assignment, err
+	}
+	found, err := m.schemaHasChallenge(schema, challenge)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, unknownSchemaChallengeError(schema, challenge)
+	}
+	maxUsers, err := m.lookupChallengeMaxUsers(challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	for range maxAssignmentAttempts {
+		assignment, err := m.recordAssignment(schema, challenge, user, 0, maxUsers)
+		if err != nil || assignment != nil {
+			return assignment, err
+		}
+
+		builds, err := m.queryAssignableBuilds(schema, challenge)
+		if err != nil {
+			return nil, err
+		}
+		if len(builds) == 0 {
+			return nil, &ConflictError{Err: fmt.Errorf(
+				"no instance of %s in schema %q can take another user",
+				challenge,
+				schema,
+			)}
+		}
+		bMeta, err := m.lookupBuildMetadata(builds[0])
+		if err != nil {
+			return nil, err
+		}
+		instance, err := m.claimInstance(bMeta, expires)
+		if err != nil {
+			return nil, err
+		}
+		assignment, err = m.recordAssignment(schema, challenge, user, instance, maxUsers)
+		if err != nil || assignment != nil {
+			return assignment, err
+		}
+		// Other users filled the new instance first; it stays running for
+		// them.
+		m.log.debugf("instance %d was filled before user %q could be assigned", instance, user)
+	}
+	return nil, &ConflictError{Err: fmt.Errorf(
+		"could not assign an instance of %s in schema %q: new instances were filled by other users",
+		challenge,
+		schema,
+	)}
+}
+
+// Assigns the user to an eligible instance, or to the given one, and returns
+// the assignment.  A concurrent request for the same user may have won, in
+// which case its assignment is returned instead.  Returns nil when no
+// instance can take the user.
+func (m *Manager) recordAssignment(
+	schema string,
+	challenge ChallengeId,
+	user string,
+	instance InstanceId,
+	maxUsers int,
+) (*Assignment, error) {
+	assigned, err := m.assignInstance(schema, challenge, user, instance, maxUsers, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	if assigned != 0 {
+		m.log.debugf("assigned user %q to instance %d of %s", user, assigned, challenge)
+	}
+	return m.lookupAssignment(schema, challenge, user)
+}
+
+// Returns the user's assignment for the schema's challenge.
+func (m *Manager) GetAssignment(
+	schema string,
+	challenge ChallengeId,
+	user string,
+) (*Assignment, error) {
+	assignment, err := m.lookupAssignment(schema, challenge, user)
+	if err == nil && assignment == nil {
+		err = unknownAssignmentError(schema, challenge, user)
+	}
+	return assignment, err
+}
+
+// Releases the user's assignment so that their next `Assign` may choose a
+// different instance.  The instance keeps running.
+func (m *Manager) Unassign(schema string, challenge ChallengeId, user string) error {
+	removed, err := m.removeAssignment(schema, challenge, user)
+	if err == nil && !removed {
+		err = unknownAssignmentError(schema, challenge, user)
+	}
+	return err
+}
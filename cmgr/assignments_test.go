@@ -0,0 +1,118 @@
+package cmgr
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func newAssignmentTestManager(t *testing.T, maxUsers int) *Manager {
+	t.Helper()
+	manager := newDynamicInstanceTestManager(t)
+	requireExec(t, manager.db, "UPDATE challenges SET maxusers=? WHERE id='challenge';", maxUsers)
+	return manager
+}
+
+func requireAssignment(t *testing.T, manager *Manager, user string, want InstanceId) {
+	t.Helper()
+	assignment, err := manager.Assign("schema", "challenge", user)
+	if err != nil {
+		t.Fatalf("could not assign %s: %v", user, err)
+	}
+	if assignment.Instance != want || assignment.User != user {
+		t.Fatalf("assigned %s to %#v, expected instance %d", user, assignment, want)
+	}
+}
+
+func TestAssignSharesInstancesUpToMaxUsers(t *testing.T) {
+	manager := newAssignmentTestManager(t, 2)
+	requireExec(t, manager.db, "INSERT INTO instances(id, lastsolved, build, pooled) VALUES (2, 0, 1, 1);")
+	requireExec(t, manager.db, "INSERT INTO containers(instance, id) VALUES (2, 'pooled');")
+
+	requireAssignment(t, manager, "alice", 1)
+	requireAssignment(t, manager, "alice", 1)
+	requireAssignment(t, manager, "bob", 1)
+	// The first instance is full, so the pooled one is claimed.
+	before := time.Now().Unix()
+	assignment, err := manager.Assign("schema", "challenge", "carol", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assignment.Instance != 2 {
+		t.Fatalf("carol was assigned %#v instead of the pooled instance", assignment)
+	}
+	meta, err := manager.lookupInstanceMetadata(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Pooled || meta.Expires < before+3600 {
+		t.Fatalf("assigned instance was not claimed from the pool: %#v", meta)
+	}
+	manager.poolWorkers.Wait()
+
+	// A released place is offered again before a fuller instance.
+	if err := manager.Unassign("schema", "challenge", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	requireAssignment(t, manager, "dave", 1)
+	requireAssignment(t, manager, "alice", 2)
+}
+
+func TestAssignReportsFullFixedChallenges(t *testing.T) {
+	manager := newAssignmentTestManager(t, 1)
+	requireExec(t, manager.db, "UPDATE builds SET instancecount=1 WHERE id=1;")
+
+	requireAssignment(t, manager, "alice", 1)
+	var conflict *ConflictError
+	if _, err := manager.Assign("schema", "challenge", "bob"); !errors.As(err, &conflict) {
+		t.Fatalf("full challenge was not a conflict: %v", err)
+	}
+
+	// Stopping the instance releases its assignments.
+	if err := manager.removeInstanceMetadata(1); err != nil {
+		t.Fatal(err)
+	}
+	var unknown *UnknownIdentifierError
+	if _, err := manager.GetAssignment("schema", "challenge", "alice"); !errors.As(err, &unknown) {
+		t.Fatalf("assignment outlived its instance: %v", err)
+	}
+	if err := manager.Unassign("schema", "challenge", "alice"); !errors.As(err, &unknown) {
+		t.Fatalf("released assignment was released again: %v", err)
+	}
+}
+
+func TestAssignSkipsUnavailableInstances(t *testing.T) {
+	manager := newAssignmentTestManager(t, 0)
+	requireExec(t, manager.db, "UPDATE builds SET instancecount=1 WHERE id=1;")
+	requireExec(t, manager.db, "UPDATE instances SET paused=1 WHERE id=1;")
+
+	var conflict *ConflictError
+	if _, err := manager.Assign("schema", "challenge", "alice"); !errors.As(err, &conflict) {
+		t.Fatalf("paused instance was assigned: %v", err)
+	}
+	requireExec(
+		t,
+		manager.db,
+		"UPDATE instances SET paused=0, expires=? WHERE id=1;",
+		time.Now().Add(-time.Minute).Unix(),
+	)
+	if _, err := manager.Assign("schema", "challenge", "alice"); !errors.As(err, &conflict) {
+		t.Fatalf("expired instance was assigned: %v", err)
+	}
+}
+
+func TestAssignRejectsInvalidRequests(t *testing.T) {
+	manager := newAssignmentTestManager(t, 0)
+
+	var unknown *UnknownIdentifierError
+	if _, err := manager.Assign("other", "challenge", "alice"); !errors.As(err, &unknown) {
+		t.Fatalf("challenge outside the schema was assigned: %v", err)
+	}
+	var invalid *InvalidInputError
+	if _, err := manager.Assign("schema", "challenge", ""); !errors.As(err, &invalid) {
+		t.Fatalf("empty user was assigned: %v", err)
+	}
+	if rows := countRows(t, manager.db, "assignments"); rows != 0 {
+		t.Fatalf("rejected requests recorded %d assignments", rows)
+	}
+}
@@ -0,0 +1,279 @@
+package cmgr
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/docker/go-units"
+)
+
+// The CPUs, memory, and processes that containers are limited to.  cmgr
+// reserves these limits against the host budget whether or not the
+// containers use them.
+type resourceReservation struct {
+	nanoCPUs    int64
+	memoryBytes int64
+	pids        int64
+}
+
+func (r resourceReservation) plus(other resourceReservation) resourceReservation {
+	return resourceReservation{
+		nanoCPUs:    r.nanoCPUs + other.nanoCPUs,
+		memoryBytes: r.memoryBytes + other.memoryBytes,
+		pids:        r.pids + other.pids,
+	}
+}
+
+func (r resourceReservation) times(count int) resourceReservation {
+	return resourceReservation{
+		nanoCPUs:    r.nanoCPUs * int64(count),
+		memoryBytes: r.memoryBytes * int64(count),
+		pids:        r.pids * int64(count),
+	}
+}
+
+// Reports how much of the host budget the instances hold and how much is
+// left.  Every instance is counted, including paused and pooled ones and
+// those that are still starting.
+func (m *Manager) CapacityReport() (*CapacityReport, error) {
+	used, instances, err := m.reservedCapacity()
+	if err != nil {
+		return nil, err
+	}
+	budget := m.policy.HostBudget
+	return &CapacityReport{
+		Instances: instances,
+		NanoCPUs:  newResourceCapacity(budget.nanoCPUs, used.nanoCPUs),
+		Memory:    newResourceCapacity(budget.memoryBytes, used.memoryBytes),
+		Pids:      newResourceCapacity(budget.pids, used.pids),
+	}, nil
+}
+
+func newResourceCapacity(budget, used int64) ResourceCapacity {
+	capacity := ResourceCapacity{Used: used}
+	if budget > 0 {
+		headroom := budget - used
+		capacity.Budget = &budget
+		capacity.Headroom = &headroom
+	}
+	return capacity
+}
+
+// Records the new instance once the host has room for it, on the node with
+// the most free capacity when there is a pool of Docker nodes.  Instances
+// that rely on the host cmgr runs on stay on the first node.  Admission
+// holds the capacity lock until the instance is recorded so that instances
+// started at the same time are counted against each other.
+func (m *Manager) openInstanceWithinBudget(
+	build *BuildMetadata,
+	iMeta *InstanceMetadata,
+) error {
+	if m.policy.HostBudget == (resourceReservation{}) && m.pool == nil {
+		return m.openInstance(iMeta)
+	}
+	release, err := m.acquireCapacityLock()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	cMeta, err := m.lookupChallengeMetadata(build.Challenge)
+	if err != nil {
+		return err
+	}
+	need, err := m.instanceReservation(build, cMeta.ChallengeOptions.Overrides)
+	if err != nil {
+		return err
+	}
+	if m.policy.HostBudget != (resourceReservation{}) {
+		if err := m.checkCapacity(need, fmt.Sprintf("an instance of build %d", build.Id)); err != nil {
+			return err
+		}
+	}
+	if m.pool != nil {
+		pinned, err := m.needsPrimaryNode(cMeta)
+		if err != nil {
+			return err
+		}
+		if pinned {
+			iMeta.Node = m.pool.primary().name
+		} else if iMeta.Node, err = m.placeInstance(need); err != nil {
+			return err
+		}
+	}
+	return m.openInstance(iMeta)
+}
+
+// Refuses the instances that the schema's fixed builds are missing when they
+// cannot all fit, before any of them are started.  Each instance is still
+// admitted on its own as it starts.
+func (m *Manager) checkSchemaCapacity(builds []*BuildMetadata) error {
+	if m.policy.HostBudget == (resourceReservation{}) {
+		return nil
+	}
+	var need resourceReservation
+	missing := 0
+	for _, build := range builds {
+		if build.InstanceCount == DYNAMIC_INSTANCES {
+			continue
+		}
+		instances, err := m.getBuildInstances(build.Id)
+		if err != nil {
+			return err
+		}
+		if len(instances) >= build.InstanceCount {
+			continue
+		}
+		cMeta, err := m.lookupChallengeMetadata(build.Challenge)
+		if err != nil {
+			return err
+		}
+		reservation, err := m.instanceReservation(build, cMeta.ChallengeOptions.Overrides)
+		if err != nil {
+			return err
+		}
+		count := build.InstanceCount - len(instances)
+		need = need.plus(reservation.times(count))
+		missing += count
+	}
+	if missing == 0 {
+		return nil
+	}
+	return m.checkCapacity(need, fmt.Sprintf("%d new schema instances", missing))
+}
+
+// Returns a ConflictError naming each budget that the reservation would
+// exceed on top of the existing instances.
+func (m *Manager) checkCapacity(need resourceReservation, what string) error {
+	used, _, err := m.reservedCapacity()
+	if err != nil {
+		return err
+	}
+	budget := m.policy.HostBudget
+	var exceeded []string
+	if budget.nanoCPUs > 0 && used.nanoCPUs+need.nanoCPUs > budget.nanoCPUs {
+		exceeded = append(exceeded, fmt.Sprintf(
+			"%s CPUs but %s of %s remain (%s)",
+			formatNanoCPUs(need.nanoCPUs),
+			formatNanoCPUs(budget.nanoCPUs-used.nanoCPUs),
+			formatNanoCPUs(budget.nanoCPUs),
+			hostCPUsEnv,
+		))
+	}
+	if budget.memoryBytes > 0 && used.memoryBytes+need.memoryBytes > budget.memoryBytes {
+		exceeded = append(exceeded, fmt.Sprintf(
+			"%s of memory but %s of %s remain (%s)",
+			units.BytesSize(float64(need.memoryBytes)),
+			units.BytesSize(float64(budget.memoryBytes-used.memoryBytes)),
+			units.BytesSize(float64(budget.memoryBytes)),
+			hostMemoryEnv,
+		))
+	}
+	if budget.pids > 0 && used.pids+need.pids > budget.pids {
+		exceeded = append(exceeded, fmt.Sprintf(
+			"%d processes but %d of %d remain (%s)",
+			need.pids,
+			budget.pids-used.pids,
+			budget.pids,
+			hostPidsEnv,
+		))
+	}
+	if len(exceeded) != 0 {
+		return &ConflictError{Err: fmt.Errorf(
+			"host capacity exceeded: %s needs %s",
+			what,
+			strings.Join(exceeded, "; "),
+		)}
+	}
+	return nil
+}
+
+// Totals the reservations of every instance along with their number.
+func (m *Manager) reservedCapacity() (resourceReservation, int, error) {
+	var used resourceReservation
+	counts, err := m.queryInstanceCounts()
+	if err != nil {
+		return used, 0, err
+	}
+	instances := 0
+	for _, count := range counts {
+		reservation, err := m.buildReservation(count.Build)
+		if err != nil {
+			return used, 0, err
+		}
+		used = used.plus(reservation.times(count.Count))
+		instances += count.Count
+	}
+	return used, instances, nil
+}
+
+// Sums the limits of an instance of the build.
+func (m *Manager) buildReservation(build BuildId) (resourceReservation, error) {
+	bMeta, err := m.lookupBuildMetadata(build)
+	if err != nil {
+		return resourceReservation{}, err
+	}
+	cMeta, err := m.lookupChallengeMetadata(bMeta.Challenge)
+	if err != nil {
+		return resourceReservation{}, err
+	}
+	return m.instanceReservation(bMeta, cMeta.ChallengeOptions.Overrides)
+}
+
+// Sums the effective limits of the build's runtime containers the same way
+// that `startContainers` applies them.  A container that lifts a limit, such
+// as with a "pidslimit" of -1, still reserves the runtime default for that
+// resource so that challenges without limits cannot bypass the budget.
+func (m *Manager) instanceReservation(
+	build *BuildMetadata,
+	options map[string]ContainerOptions,
+) (resourceReservation, error) {
+	defaults, err := m.containerReservation(m.runtimeDefaults)
+	if err != nil {
+		return resourceReservation{}, err
+	}
+	var total resourceReservation
+	for _, image := range build.Images {
+		if image.Host == "builder" {
+			continue
+		}
+		cOpts, _ := effectiveContainerOptions(options, strings.ToLower(image.Host))
+		reservation, err := m.containerReservation(mergeRuntimeDefaults(m.runtimeDefaults, cOpts))
+		if err != nil {
+			return total, err
+		}
+		if reservation.nanoCPUs == 0 {
+			reservation.nanoCPUs = defaults.nanoCPUs
+		}
+		if reservation.memoryBytes == 0 {
+			reservation.memoryBytes = defaults.memoryBytes
+		}
+		if reservation.pids == 0 {
+			reservation.pids = defaults.pids
+		}
+		total = total.plus(reservation)
+	}
+	return total, nil
+}
+
+// Returns the limits of a container with the options, which are zero for the
+// resources that it does not limit.
+func (m *Manager) containerReservation(cOpts ContainerOptions) (resourceReservation, error) {
+	var reservation resourceReservation
+	if cOpts.Cpus != "" {
+		nanoCPUs, err := parseNanoCPUs(cOpts.Cpus)
+		if err != nil {
+			return reservation, err
+		}
+		reservation.nanoCPUs = max(nanoCPUs, 0)
+	}
+	if cOpts.Memory != "" {
+		memoryBytes, err := units.RAMInBytes(cOpts.Memory)
+		if err != nil {
+			return reservation, err
+		}
+		reservation.memoryBytes = max(memoryBytes, 0)
+	}
+	reservation.pids = max(cOpts.PidsLimit, 0)
+	return reservation, nil
+}
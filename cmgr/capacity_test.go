@@ -0,0 +1,160 @@
+package cmgr
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func newCapacityTestManager(t *testing.T, budget resourceReservation) *Manager {
+	t.Helper()
+	manager := newDynamicInstanceTestManager(t)
+	requireExec(
+		t,
+		manager.db,
+		`UPDATE containerOptions
+		 SET cpus='1', memory='256m', pidslimit=100, ulimits='[]', droppedcaps='[]'
+		 WHERE challenge='challenge';`,
+	)
+	manager.runtimeDefaults = ContainerOptions{Cpus: "2", Memory: "512m", PidsLimit: 256}
+	manager.policy.HostBudget = budget
+	return manager
+}
+
+func TestInstanceReservationAppliesRuntimeDefaults(t *testing.T) {
+	manager := &Manager{
+		runtimeDefaults: ContainerOptions{Cpus: "1", Memory: "512m", PidsLimit: 256},
+	}
+	build := &BuildMetadata{Images: []Image{
+		{Host: "web"},
+		{Host: "db"},
+		{Host: "builder"},
+	}}
+	options := map[string]ContainerOptions{
+		"":    {Memory: "1g"},
+		"web": {Cpus: "0.5", PidsLimit: -1},
+	}
+
+	reservation, err := manager.instanceReservation(build, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The web container lifts its PID limit but still reserves the default.
+	want := resourceReservation{
+		nanoCPUs:    1_500_000_000,
+		memoryBytes: 512*1024*1024 + 1024*1024*1024,
+		pids:        512,
+	}
+	if reservation != want {
+		t.Fatalf("reserved %+v, expected %+v", reservation, want)
+	}
+}
+
+func TestStartRefusesInstancesBeyondHostBudget(t *testing.T) {
+	manager := newCapacityTestManager(t, resourceReservation{
+		nanoCPUs: 1_500_000_000,
+		pids:     1000,
+	})
+
+	_, err := manager.Start(1)
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("instance beyond the budget was not a conflict: %v", err)
+	}
+	if !strings.Contains(err.Error(), "1 CPUs but 0.5 of 1.5 remain") ||
+		strings.Contains(err.Error(), "processes") {
+		t.Fatalf("conflict does not describe the exceeded budget: %v", err)
+	}
+	if rows := countRows(t, manager.db, "instances"); rows != 1 {
+		t.Fatalf("refused instance was recorded: %d instances", rows)
+	}
+}
+
+func TestStartRefusesUnlimitedInstancesBeyondHostBudget(t *testing.T) {
+	manager := newCapacityTestManager(t, resourceReservation{
+		nanoCPUs:    4_000_000_000,
+		memoryBytes: 1024 * 1024 * 1024,
+		pids:        300,
+	})
+	requireExec(
+		t,
+		manager.db,
+		`UPDATE containerOptions SET cpus='0', memory='0', pidslimit=-1
+		 WHERE challenge='challenge';`,
+	)
+
+	// The existing instance already holds the defaults of 2 CPUs, 512m of
+	// memory, and 256 processes, so there is no room for another one.
+	_, err := manager.Start(1)
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) || !strings.Contains(err.Error(), "256 processes but 44 of 300 remain") {
+		t.Fatalf("unlimited instance beyond the budget was not refused: %v", err)
+	}
+	if rows := countRows(t, manager.db, "instances"); rows != 1 {
+		t.Fatalf("refused instance was recorded: %d instances", rows)
+	}
+}
+
+func TestCapacityReportShowsUsageAndHeadroom(t *testing.T) {
+	manager := newCapacityTestManager(t, resourceReservation{pids: 150})
+	requireExec(t, manager.db, "INSERT INTO instances(id, lastsolved, build) VALUES (2, 0, 1);")
+
+	report, err := manager.CapacityReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Instances != 2 {
+		t.Fatalf("counted %d instances", report.Instances)
+	}
+	if report.NanoCPUs.Used != 2_000_000_000 || report.NanoCPUs.Budget != nil {
+		t.Fatalf("unexpected CPU capacity %+v", report.NanoCPUs)
+	}
+	if report.Memory.Used != 512*1024*1024 || report.Memory.Headroom != nil {
+		t.Fatalf("unexpected memory capacity %+v", report.Memory)
+	}
+	if report.Pids.Used != 200 || *report.Pids.Budget != 150 || *report.Pids.Headroom != -50 {
+		t.Fatalf("unexpected PID capacity %+v", report.Pids)
+	}
+}
+
+func TestSchemaCapacityCountsMissingFixedInstances(t *testing.T) {
+	manager := newCapacityTestManager(t, resourceReservation{memoryBytes: 700 * 1024 * 1024})
+	build, err := manager.lookupBuildMetadata(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	build.InstanceCount = 2
+	if err := manager.checkSchemaCapacity([]*BuildMetadata{build}); err != nil {
+		t.Fatalf("room for one more instance was refused: %v", err)
+	}
+	build.InstanceCount = 3
+	var conflict *ConflictError
+	err = manager.checkSchemaCapacity([]*BuildMetadata{build})
+	if !errors.As(err, &conflict) || !strings.Contains(err.Error(), "2 new schema instances") {
+		t.Fatalf("schema beyond the budget was not refused: %v", err)
+	}
+	build.InstanceCount = DYNAMIC_INSTANCES
+	if err := manager.checkSchemaCapacity([]*BuildMetadata{build}); err != nil {
+		t.Fatalf("dynamic build was counted: %v", err)
+	}
+}
+
+func TestHostBudgetFromEnv(t *testing.T) {
+	t.Setenv(hostCPUsEnv, "7.5")
+	t.Setenv(hostMemoryEnv, "16g")
+	t.Setenv(hostPidsEnv, "")
+	budget, err := hostBudgetFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := resourceReservation{nanoCPUs: 7_500_000_000, memoryBytes: 16 << 30}
+	if budget != want {
+		t.Fatalf("read budget %+v, expected %+v", budget, want)
+	}
+
+	t.Setenv(hostPidsEnv, "0")
+	if _, err := hostBudgetFromEnv(); err == nil {
+		t.Fatal("zero PID budget was accepted")
+	}
+}
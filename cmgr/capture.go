@@ -0,0 +1,233 @@
+package cmgr
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/containerd/errdefs"
+	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/client"
+)
+
+// The image run as a packet capture sidecar unless `CMGR_CAPTURE_IMAGE`
+// names another one, which must provide `sh` and `tcpdump`.
+const defaultCaptureImage = "nicolaka/netshoot"
+
+const captureDirectoryMode = 0700
+
+// tcpdump numbers each file of a rotation after the name given to it.
+var captureNameRe = regexp.MustCompile(`^[0-9]+\.pcap[0-9]*$`)
+
+func (m *Manager) instanceCaptureDir(instance InstanceId) string {
+	return filepath.Join(m.captureDir, strconv.FormatInt(int64(instance), 10))
+}
+
+// The shell command that captures the bridge's traffic into a rotation of
+// files.  Each run of the sidecar, including restarts, names its files after
+// the time it started so that it never overwrites an earlier capture.  The
+// oldest files in the directory are removed when the sidecar starts and
+// whenever tcpdump moves on to a new file, so that the directory never holds
+// more than the given number of files no matter how often the sidecar
+// restarts.  Capturing as root lets tcpdump keep creating files in a
+// directory that is not writable by its own user, and tcpdump counts file
+// sizes in millions of bytes.
+func captureCommand(bridge string, fileBytes int64, files int) []string {
+	megabytes := max(fileBytes/1_000_000, 1)
+	// Room is left for the file that tcpdump is about to write.
+	prune := fmt.Sprintf(
+		`ls -1t /captures/*.pcap* 2>/dev/null | tail -n +%d | xargs -r rm -f`,
+		max(files-1, 1)+1,
+	)
+	return []string{
+		"sh",
+		"-c",
+		fmt.Sprintf(
+			`printf '#!/bin/sh\n%%s\n' '%s' > /tmp/prune && chmod +x /tmp/prune && /tmp/prune && `+
+				`exec tcpdump -i %s -n -U -Z root -C %d -W %d -z /tmp/prune -w "/captures/$(date +%%s).pcap"`,
+			prune,
+			bridge,
+			megabytes,
+			files,
+		),
+	}
+}
+
+// Starts a sidecar that records the traffic on the instance's bridge.  It
+// shares the host's network so that it sees every packet crossing the bridge
+// rather than only those addressed to itself, and so runs on the instance's
+// node.  Its captures are bound from cmgr's own disk, so in a pool of Docker
+// nodes only the first node, which shares that disk, can run it.
+func (m *Manager) startCapture(instance *InstanceMetadata, bridge string) error {
+	if m.captureDir == "" {
+		return errors.New("no capture directory is configured")
+	}
+	if !m.onPrimaryNode(instance) {
+		return fmt.Errorf(
+			"traffic of instances on Docker node %s cannot be captured",
+			instance.Node,
+		)
+	}
+	dir := m.instanceCaptureDir(instance.Id)
+	if err := os.MkdirAll(dir, captureDirectoryMode); err != nil {
+		return fmt.Errorf("could not create capture directory: %w", err)
+	}
+
+	fileBytes := m.policy.MaxCaptureFileBytes
+	if fileBytes == 0 {
+		fileBytes = 10 * 1024 * 1024
+	}
+	files := m.policy.MaxCaptureFiles
+	if files == 0 {
+		files = 10
+	}
+	createOptions := client.ContainerCreateOptions{
+		Name: bridge + "-capture",
+		Config: &container.Config{
+			Image:      m.captureImage,
+			Entrypoint: captureCommand(bridge, fileBytes, files),
+			Labels:     instance.nodeLabels(),
+		},
+		HostConfig: &container.HostConfig{
+			NetworkMode:   "host",
+			Binds:         []string{dir + ":/captures"},
+			CapDrop:       []string{"ALL"},
+			CapAdd:        []string{"NET_ADMIN", "NET_RAW"},
+			RestartPolicy: container.RestartPolicy{Name: "always"},
+		},
+	}
+	created, err := m.cli.ContainerCreate(m.ctx, createOptions)
+	if errdefs.IsNotFound(err) {
+		var pull client.ImagePullResponse
+		pull, err = m.cli.ImagePull(m.ctx, m.captureImage, client.ImagePullOptions{})
+		if err == nil {
+			err = consumeDockerProgress(pull, "capture image pull", nil)
+		}
+		if err == nil {
+			created, err = m.cli.ContainerCreate(m.ctx, createOptions)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("could not create capture container: %w", err)
+	}
+
+	// Recorded before it starts so that stopping the network removes it.
+	if _, err := m.db.Exec(
+		"INSERT INTO captureSidecars(network, container) VALUES (?, ?);",
+		bridge,
+		created.ID,
+	); err != nil {
+		return errors.Join(
+			fmt.Errorf("could not record capture container %s: %w", created.ID, err),
+			m.removeRetiredContainerIDs([]string{created.ID}),
+		)
+	}
+	m.log.infof("capturing traffic of %s into %s", bridge, dir)
+	_, err = m.cli.ContainerStart(m.ctx, created.ID, client.ContainerStartOptions{})
+	return err
+}
+
+// Removes the network's capture sidecar, if it has one.  The files it wrote
+// are kept.  A container that cannot be removed now is retired so that
+// startup recovery removes it later.
+func (m *Manager) stopCapture(network string) error {
+	var containers []string
+	if err := m.db.Select(
+		&containers,
+		"SELECT container FROM captureSidecars WHERE network=?;",
+		network,
+	); err != nil || len(containers) == 0 {
+		return err
+	}
+	for _, containerID := range containers {
+		if err := m.retireContainer(containerID); err != nil {
+			return err
+		}
+	}
+	if _, err := m.db.Exec("DELETE FROM captureSidecars WHERE network=?;", network); err != nil {
+		return err
+	}
+	if err := m.removeRetiredContainerIDs(containers); err != nil {
+		m.log.warnf("deferred removal of capture container for %s: %s", network, err)
+	}
+	return nil
+}
+
+// Removes the packet captures of the instance.  Instance identifiers are
+// reused, so the captures of a stopped instance must not carry over to the
+// next one.
+func (m *Manager) removeInstanceCaptures(instance InstanceId) {
+	if m.captureDir == "" {
+		return
+	}
+	if err := os.RemoveAll(m.instanceCaptureDir(instance)); err != nil {
+		m.log.warnf("could not remove captures of instance %d: %v", instance, err)
+	}
+}
+
+// Lists the packet capture files recorded for the instance, oldest first.
+// Captures are kept after the instance stops until a new instance takes its
+// identifier.
+func (m *Manager) ListInstanceCaptures(instance InstanceId) ([]InstanceCapture, error) {
+	captures := []InstanceCapture{}
+	if m.captureDir == "" {
+		return captures, nil
+	}
+	entries, err := os.ReadDir(m.instanceCaptureDir(instance))
+	if errors.Is(err, os.ErrNotExist) {
+		return captures, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !captureNameRe.MatchString(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if errors.Is(err, os.ErrNotExist) {
+			// Rotated away since the directory was read.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		captures = append(captures, InstanceCapture{
+			Name:     entry.Name(),
+			Size:     info.Size(),
+			Modified: info.ModTime().Unix(),
+		})
+	}
+	sort.Slice(captures, func(i, j int) bool {
+		if captures[i].Modified != captures[j].Modified {
+			return captures[i].Modified < captures[j].Modified
+		}
+		return captures[i].Name < captures[j].Name
+	})
+	return captures, nil
+}
+
+// Opens a packet capture file of the instance.  A file that is still being
+// written holds whatever tcpdump has flushed so far.
+func (m *Manager) OpenInstanceCapture(instance InstanceId, name string) (io.ReadCloser, error) {
+	unknown := &UnknownIdentifierError{
+		Type: "capture",
+		Name: fmt.Sprintf("%d/%s", instance, name),
+	}
+	if m.captureDir == "" || !captureNameRe.MatchString(name) {
+		return nil, unknown
+	}
+	file, err := os.Open(filepath.Join(m.instanceCaptureDir(instance), name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, unknown
+	}
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
@@ -0,0 +1,189 @@
+package cmgr
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCaptureCommandRotatesSizeCappedFiles(t *testing.T) {
+	command := captureCommand("cmgr-7", 25_000_000, 4)
+	want := []string{
+		"sh",
+		"-c",
+		`printf '#!/bin/sh\n%s\n' 'ls -1t /captures/*.pcap* 2>/dev/null | tail -n +4 | xargs -r rm -f' > /tmp/prune && ` +
+			`chmod +x /tmp/prune && /tmp/prune && ` +
+			`exec tcpdump -i cmgr-7 -n -U -Z root -C 25 -W 4 -z /tmp/prune -w "/captures/$(date +%s).pcap"`,
+	}
+	if !reflect.DeepEqual(command, want) {
+		t.Fatalf("capture command %q, expected %q", command, want)
+	}
+	if command := captureCommand("cmgr-7", 1024, 1); !strings.Contains(command[2], "-C 1 ") {
+		t.Fatalf("small capture files were not rounded up: %q", command)
+	}
+}
+
+func TestListAndOpenInstanceCaptures(t *testing.T) {
+	manager := &Manager{captureDir: t.TempDir()}
+	captures, err := manager.ListInstanceCaptures(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(captures) != 0 {
+		t.Fatalf("listed captures of an instance without any: %v", captures)
+	}
+
+	dir := manager.instanceCaptureDir(3)
+	if err := os.MkdirAll(filepath.Join(dir, "nested.pcap"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	older := time.Unix(1_700_000_000, 0)
+	for name, modified := range map[string]time.Time{
+		"1700000000.pcap1": older.Add(time.Minute),
+		"1700000000.pcap0": older,
+		"notes.txt":        older,
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(name), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	captures, err = manager.ListInstanceCaptures(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []InstanceCapture{
+		{Name: "1700000000.pcap0", Size: 16, Modified: older.Unix()},
+		{Name: "1700000000.pcap1", Size: 16, Modified: older.Add(time.Minute).Unix()},
+	}
+	if !reflect.DeepEqual(captures, want) {
+		t.Fatalf("listed captures %v, expected %v", captures, want)
+	}
+
+	capture, err := manager.OpenInstanceCapture(3, "1700000000.pcap1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, err := io.ReadAll(capture)
+	capture.Close()
+	if err != nil || string(contents) != "1700000000.pcap1" {
+		t.Fatalf("read capture %q: %v", contents, err)
+	}
+
+	var unknown *UnknownIdentifierError
+	for _, name := range []string{"notes.txt", "../3/1700000000.pcap0", "1700000001.pcap0"} {
+		if _, err := manager.OpenInstanceCapture(3, name); !errors.As(err, &unknown) {
+			t.Errorf("opened capture %q: %v", name, err)
+		}
+	}
+}
+
+func TestPacketCaptureFollowsChallengeNetwork(t *testing.T) {
+	manager := newSchemaTestManager(t)
+	manager.ctx = t.Context()
+	manager.captureDir = t.TempDir()
+	manager.captureImage = defaultCaptureImage
+	var requests []string
+	var networkCreate, containerCreate map[string]any
+	manager.cli = newDockerTestClient(t, func(request *http.Request) (*http.Response, error) {
+		path := request.URL.Path
+		if request.Method != http.MethodHead && !strings.HasSuffix(path, "/_ping") {
+			requests = append(requests, request.Method+" "+path[strings.Index(path[1:], "/")+1:])
+		}
+		switch {
+		case strings.HasSuffix(path, "/networks/create"):
+			json.NewDecoder(request.Body).Decode(&networkCreate)
+			return dockerTestResponse(request, http.StatusCreated, `{"Id":"network"}`)
+		case strings.HasSuffix(path, "/containers/create"):
+			if name := request.URL.Query().Get("name"); name != "cmgr-7-capture" {
+				t.Errorf("capture container named %q", name)
+			}
+			json.NewDecoder(request.Body).Decode(&containerCreate)
+			return dockerTestResponse(request, http.StatusCreated, `{"Id":"sidecar"}`)
+		}
+		return dockerTestResponse(request, http.StatusNoContent, "")
+	})
+
+	instance := &InstanceMetadata{Id: 7}
+	if err := manager.startNetwork(instance, NetworkOptions{PacketCapture: true}); err != nil {
+		t.Fatal(err)
+	}
+	options, _ := networkCreate["Options"].(map[string]any)
+	if options[bridgeNameOption] != "cmgr-7" {
+		t.Fatalf("captured network bridge is not named: %v", networkCreate)
+	}
+	hostConfig, _ := containerCreate["HostConfig"].(map[string]any)
+	binds, _ := hostConfig["Binds"].([]any)
+	if hostConfig["NetworkMode"] != "host" ||
+		len(binds) != 1 ||
+		binds[0] != manager.instanceCaptureDir(7)+":/captures" {
+		t.Fatalf("unexpected capture container: %v", containerCreate)
+	}
+	requireRowCount(t, manager.db, "captureSidecars", 1)
+
+	if err := manager.stopNetwork(instance); err != nil {
+		t.Fatal(err)
+	}
+	requireRowCount(t, manager.db, "captureSidecars", 0)
+	requireRowCount(t, manager.db, "retiredContainers", 0)
+	want := []string{
+		"POST /networks/create",
+		"POST /containers/create",
+		"POST /containers/sidecar/start",
+		"DELETE /containers/sidecar",
+		"DELETE /networks/cmgr-7",
+	}
+	if !reflect.DeepEqual(requests, want) {
+		t.Fatalf("Docker requests %v, expected %v", requests, want)
+	}
+}
+
+func TestPacketCaptureRoundTrip(t *testing.T) {
+	manager := newSchemaTestManager(t)
+	metadata := newAddChallengeTestMetadata("captured", nil)
+	metadata.ChallengeOptions.PacketCapture = true
+	if err := manager.addChallenge(metadata); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := manager.lookupChallengeMetadata(metadata.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.ChallengeOptions.PacketCapture {
+		t.Fatal("packet_capture was not persisted")
+	}
+}
+
+func TestNewInstanceClearsCapturesOfReusedIdentifier(t *testing.T) {
+	manager := newFakeRuntimeManager(t, newFakeRuntime())
+	stale := filepath.Join(manager.instanceCaptureDir(1), "1700000000.pcap0")
+	if err := os.MkdirAll(filepath.Dir(stale), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(stale, []byte("earlier instance"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, instance := startFakeRuntimeInstance(t, manager)
+	if instance.Id != 1 {
+		t.Fatalf("started instance %d", instance.Id)
+	}
+	captures, err := manager.ListInstanceCaptures(instance.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(captures) != 0 {
+		t.Fatalf("captures of an earlier instance were kept: %v", captures)
+	}
+}
@@ -3,6 +3,7 @@ package cmgr
 import (
 	"fmt"
 	"math/big"
+	"strconv"
 )
 
 const nanoCPUsPerCPU int64 = 1_000_000_000
@@ -25,3 +26,8 @@ func parseNanoCPUs(value string) (int64, error) {
 	}
 	return nanoCPUs.Num().Int64(), nil
 }
+
+// formatNanoCPUs renders NanoCPUs in the decimal form that --cpus accepts.
+func formatNanoCPUs(nanoCPUs int64) string {
+	return strconv.FormatFloat(float64(nanoCPUs)/float64(nanoCPUsPerCPU), 'f', -1, 64)
+}
@@ -83,6 +83,7 @@ const schemaQuery string = `
 		name TEXT NOT NULL,
 		host TEXT NOT NULL,
 		port INTEGER NOT NULL CHECK (port > 0 AND port < 65536),
+		protocol TEXT NOT NULL DEFAULT 'tcp' CHECK(protocol = 'tcp' OR protocol = 'udp'),
 		FOREIGN KEY (challenge) REFERENCES challenges (id)
 			ON UPDATE CASCADE ON DELETE CASCADE,
 		FOREIGN KEY (challenge, host) REFERENCES hosts (challenge, name)
@@ -91,7 +92,7 @@ const schemaQuery string = `
 	CREATE UNIQUE INDEX IF NOT EXISTS portNamesNameIndex
 		ON portNames(challenge, name);
 	CREATE UNIQUE INDEX IF NOT EXISTS portNamesEndpointIndex
-		ON portNames(challenge, host, port);
+		ON portNames(challenge, host, port, protocol);
 
 	CREATE TABLE IF NOT EXISTS schemas (
 		name TEXT NOT NULL PRIMARY KEY,
@@ -109,6 +110,7 @@ const schemaQuery string = `
 		schema TEXT NOT NULL,
 		instancecount INT NOT NULL,
 		requiredseccomptweaks TEXT NOT NULL DEFAULT '[]',
+		warmpool INTEGER NOT NULL DEFAULT 0 CHECK(warmpool >= 0),
 		UNIQUE(schema, format, challenge, seed),
 		FOREIGN KEY (challenge) REFERENCES challenges (id)
 			ON UPDATE RESTRICT ON DELETE RESTRICT
@@ -149,9 +151,16 @@ const schemaQuery string = `
 		id INTEGER PRIMARY KEY,
 		lastsolved INTEGER,
 		build INTEGER NOT NULL,
+		expires INTEGER NOT NULL DEFAULT 0,
+		paused INTEGER NOT NULL DEFAULT 0 CHECK(paused = 0 OR paused = 1),
+		lastchecked INTEGER NOT NULL DEFAULT 0,
+		lastcheckerror TEXT NOT NULL DEFAULT '',
+		pooled INTEGER NOT NULL DEFAULT 0 CHECK(pooled = 0 OR pooled = 1),
+		node TEXT NOT NULL DEFAULT '',
 		FOREIGN KEY (build) REFERENCES builds (id)
 			ON UPDATE RESTRICT ON DELETE RESTRICT
 	);
+	CREATE INDEX IF NOT EXISTS instancesExpiresIndex ON instances(expires);
 
 	CREATE TABLE IF NOT EXISTS portAssignments (
 		instance INTEGER NOT NULL,
@@ -180,9 +189,22 @@ const schemaQuery string = `
 		name TEXT NOT NULL PRIMARY KEY
 	);
 
+	CREATE TABLE IF NOT EXISTS egressRules (
+		network TEXT NOT NULL PRIMARY KEY
+	);
+
+	CREATE TABLE IF NOT EXISTS captureSidecars (
+		network TEXT NOT NULL PRIMARY KEY,
+		container TEXT NOT NULL
+	);
+
 	CREATE TABLE IF NOT EXISTS networkOptions (
 		challenge TEXT NOT NULL PRIMARY KEY,
 		allowegress INTEGER NOT NULL CHECK(allowegress = 0 OR allowegress = 1),
+		egress TEXT NOT NULL DEFAULT '[]',
+		httpports TEXT NOT NULL DEFAULT '[]',
+		tlsports TEXT NOT NULL DEFAULT '[]',
+		capture INTEGER NOT NULL DEFAULT 0 CHECK(capture = 0 OR capture = 1),
 		FOREIGN KEY (challenge) REFERENCES challenges (id)
 			ON UPDATE CASCADE ON DELETE CASCADE
 	);
@@ -201,14 +223,53 @@ const schemaQuery string = `
 		diskquota TEXT NOT NULL,
 		cgroupparent TEXT NOT NULL,
 		seccomp TEXT NOT NULL DEFAULT '',
+		healthcheck TEXT NOT NULL DEFAULT '',
 		FOREIGN KEY (challenge) REFERENCES challenges (id)
 			ON UPDATE CASCADE ON DELETE CASCADE
 	);
 	CREATE UNIQUE INDEX IF NOT EXISTS containerOptionsHostIndex
-		ON containerOptions(challenge, host);`
+		ON containerOptions(challenge, host);
+
+	CREATE TABLE IF NOT EXISTS jobs (
+		id INTEGER PRIMARY KEY,
+		kind TEXT NOT NULL CHECK(kind IN ('build', 'create-schema', 'update-schema')),
+		status TEXT NOT NULL CHECK(status IN ('queued', 'running', 'succeeded', 'failed')),
+		owner TEXT NOT NULL,
+		schema TEXT NOT NULL,
+		request TEXT NOT NULL,
+		error TEXT NOT NULL DEFAULT '',
+		created INTEGER NOT NULL,
+		updated INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS jobsStatusIndex ON jobs(status);
+
+	CREATE TABLE IF NOT EXISTS jobSeeds (
+		job INTEGER NOT NULL,
+		challenge TEXT NOT NULL,
+		seed INTEGER NOT NULL,
+		status TEXT NOT NULL CHECK(status IN ('pending', 'building', 'built', 'failed')),
+		build INTEGER,
+		error TEXT NOT NULL DEFAULT '',
+		PRIMARY KEY (job, challenge, seed),
+		FOREIGN KEY (job) REFERENCES jobs (id)
+			ON UPDATE RESTRICT ON DELETE CASCADE
+	);
+
+	CREATE TABLE IF NOT EXISTS assignments (
+		schema TEXT NOT NULL,
+		challenge TEXT NOT NULL,
+		assignee TEXT NOT NULL,
+		instance INTEGER NOT NULL,
+		assigned INTEGER NOT NULL,
+		PRIMARY KEY (schema, challenge, assignee),
+		FOREIGN KEY (instance) REFERENCES instances (id)
+			ON UPDATE RESTRICT ON DELETE CASCADE
+	);
+	CREATE INDEX IF NOT EXISTS assignmentsInstanceIndex
+		ON assignments(instance);`
 
 const (
-	currentDatabaseVersion          = 2
+	currentDatabaseVersion          = 15
 	sqliteBusyTimeoutMS             = 5000
 	databaseBackupTimestampFormat   = "20060102T150405.000000000Z"
 	databaseMigrationBackupFileMode = 0600
@@ -310,6 +371,49 @@ const (
 		CREATE TABLE IF NOT EXISTS retiredNetworks (
 			name TEXT NOT NULL PRIMARY KEY
 		);`
+
+	databaseV2ToV3Query = `
+		CREATE TABLE IF NOT EXISTS jobs (
+			id INTEGER PRIMARY KEY,
+			kind TEXT NOT NULL CHECK(kind IN ('build', 'create-schema', 'update-schema')),
+			status TEXT NOT NULL CHECK(status IN ('queued', 'running', 'succeeded', 'failed')),
+			owner TEXT NOT NULL,
+			schema TEXT NOT NULL,
+			request TEXT NOT NULL,
+			error TEXT NOT NULL DEFAULT '',
+			created INTEGER NOT NULL,
+			updated INTEGER NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS jobsStatusIndex ON jobs(status);
+
+		CREATE TABLE IF NOT EXISTS jobSeeds (
+			job INTEGER NOT NULL,
+			challenge TEXT NOT NULL,
+			seed INTEGER NOT NULL,
+			status TEXT NOT NULL CHECK(status IN ('pending', 'building', 'built', 'failed')),
+			build INTEGER,
+			error TEXT NOT NULL DEFAULT '',
+			PRIMARY KEY (job, challenge, seed),
+			FOREIGN KEY (job) REFERENCES jobs (id)
+				ON UPDATE RESTRICT ON DELETE CASCADE
+		);`
+
+	databaseV3ToV4Query = `
+		CREATE INDEX IF NOT EXISTS instancesExpiresIndex ON instances(expires);`
+
+	databaseV8ToV9Query = `
+		CREATE TABLE IF NOT EXISTS assignments (
+			schema TEXT NOT NULL,
+			challenge TEXT NOT NULL,
+			assignee TEXT NOT NULL,
+			instance INTEGER NOT NULL,
+			assigned INTEGER NOT NULL,
+			PRIMARY KEY (schema, challenge, assignee),
+			FOREIGN KEY (instance) REFERENCES instances (id)
+				ON UPDATE RESTRICT ON DELETE CASCADE
+		);
+		CREATE INDEX IF NOT EXISTS assignmentsInstanceIndex
+			ON assignments(instance);`
 )
 
 type databaseMigration struct {
@@ -341,6 +445,58 @@ var databaseMigrations = map[int]databaseMigration{
 		to:    2,
 		apply: migrateDatabaseV1ToV2,
 	},
+	2: {
+		to:    3,
+		apply: migrateDatabaseV2ToV3,
+	},
+	3: {
+		to:    4,
+		apply: migrateDatabaseV3ToV4,
+	},
+	4: {
+		to:    5,
+		apply: migrateDatabaseV4ToV5,
+	},
+	5: {
+		to:    6,
+		apply: migrateDatabaseV5ToV6,
+	},
+	6: {
+		to:    7,
+		apply: migrateDatabaseV6ToV7,
+	},
+	7: {
+		to:    8,
+		apply: migrateDatabaseV7ToV8,
+	},
+	8: {
+		to:    9,
+		apply: migrateDatabaseV8ToV9,
+	},
+	9: {
+		to:    10,
+		apply: migrateDatabaseV9ToV10,
+	},
+	10: {
+		to:    11,
+		apply: migrateDatabaseV10ToV11,
+	},
+	11: {
+		to:    12,
+		apply: migrateDatabaseV11ToV12,
+	},
+	12: {
+		to:    13,
+		apply: migrateDatabaseV12ToV13,
+	},
+	13: {
+		to:    14,
+		apply: migrateDatabaseV13ToV14,
+	},
+	14: {
+		to:    15,
+		apply: migrateDatabaseV14ToV15,
+	},
 }
 
 var databaseV1ConflictChecks = []databaseConflictCheck{
@@ -614,33 +770,231 @@ func migrateDatabaseV1ToV2(txn *sqlx.Tx) error {
 	return nil
 }
 
+func migrateDatabaseV2ToV3(txn *sqlx.Tx) error {
+	if _, err := txn.Exec(databaseV2ToV3Query); err != nil {
+		return fmt.Errorf("could not create version 3 database objects: %w", err)
+	}
+	return nil
+}
+
+func migrateDatabaseV3ToV4(txn *sqlx.Tx) error {
+	if err := addDatabaseColumnIfMissing(
+		txn,
+		"instances",
+		"expires",
+		"SELECT COUNT(*) FROM pragma_table_info('instances') WHERE name = 'expires';",
+		"ALTER TABLE instances ADD COLUMN expires INTEGER NOT NULL DEFAULT 0;",
+	); err != nil {
+		return err
+	}
+	if _, err := txn.Exec(databaseV3ToV4Query); err != nil {
+		return fmt.Errorf("could not create version 4 database objects: %w", err)
+	}
+	return nil
+}
+
+func migrateDatabaseV4ToV5(txn *sqlx.Tx) error {
+	return addDatabaseColumnIfMissing(
+		txn,
+		"instances",
+		"paused",
+		"SELECT COUNT(*) FROM pragma_table_info('instances') WHERE name = 'paused';",
+		"ALTER TABLE instances ADD COLUMN paused INTEGER NOT NULL DEFAULT 0 CHECK(paused = 0 OR paused = 1);",
+	)
+}
+
+func migrateDatabaseV5ToV6(txn *sqlx.Tx) error {
+	return addDatabaseColumnIfMissing(
+		txn,
+		"containerOptions",
+		"healthcheck",
+		"SELECT COUNT(*) FROM pragma_table_info('containerOptions') WHERE name = 'healthcheck';",
+		"ALTER TABLE containerOptions ADD COLUMN healthcheck TEXT NOT NULL DEFAULT '';",
+	)
+}
+
+func migrateDatabaseV6ToV7(txn *sqlx.Tx) error {
+	if err := addDatabaseColumnIfMissing(
+		txn,
+		"instances",
+		"lastchecked",
+		"SELECT COUNT(*) FROM pragma_table_info('instances') WHERE name = 'lastchecked';",
+		"ALTER TABLE instances ADD COLUMN lastchecked INTEGER NOT NULL DEFAULT 0;",
+	); err != nil {
+		return err
+	}
+	return addDatabaseColumnIfMissing(
+		txn,
+		"instances",
+		"lastcheckerror",
+		"SELECT COUNT(*) FROM pragma_table_info('instances') WHERE name = 'lastcheckerror';",
+		"ALTER TABLE instances ADD COLUMN lastcheckerror TEXT NOT NULL DEFAULT '';",
+	)
+}
+
+func migrateDatabaseV7ToV8(txn *sqlx.Tx) error {
+	if err := addDatabaseColumnIfMissing(
+		txn,
+		"builds",
+		"warmpool",
+		"SELECT COUNT(*) FROM pragma_table_info('builds') WHERE name = 'warmpool';",
+		"ALTER TABLE builds ADD COLUMN warmpool INTEGER NOT NULL DEFAULT 0 CHECK(warmpool >= 0);",
+	); err != nil {
+		return err
+	}
+	return addDatabaseColumnIfMissing(
+		txn,
+		"instances",
+		"pooled",
+		"SELECT COUNT(*) FROM pragma_table_info('instances') WHERE name = 'pooled';",
+		"ALTER TABLE instances ADD COLUMN pooled INTEGER NOT NULL DEFAULT 0 CHECK(pooled = 0 OR pooled = 1);",
+	)
+}
+
+func migrateDatabaseV8ToV9(txn *sqlx.Tx) error {
+	if _, err := txn.Exec(databaseV8ToV9Query); err != nil {
+		return fmt.Errorf("could not create version 9 database objects: %w", err)
+	}
+	return nil
+}
+
+func migrateDatabaseV9ToV10(txn *sqlx.Tx) error {
+	return addDatabaseColumnIfMissing(
+		txn,
+		"networkOptions",
+		"httpports",
+		"SELECT COUNT(*) FROM pragma_table_info('networkOptions') WHERE name = 'httpports';",
+		"ALTER TABLE networkOptions ADD COLUMN httpports TEXT NOT NULL DEFAULT '[]';",
+	)
+}
+
+func migrateDatabaseV10ToV11(txn *sqlx.Tx) error {
+	return addDatabaseColumnIfMissing(
+		txn,
+		"networkOptions",
+		"tlsports",
+		"SELECT COUNT(*) FROM pragma_table_info('networkOptions') WHERE name = 'tlsports';",
+		"ALTER TABLE networkOptions ADD COLUMN tlsports TEXT NOT NULL DEFAULT '[]';",
+	)
+}
+
+// Existing ports are TCP.  The same container port may now be published once
+// per protocol, so the endpoint index gains the protocol.
+func migrateDatabaseV11ToV12(txn *sqlx.Tx) error {
+	if err := addDatabaseColumnIfMissing(
+		txn,
+		"portNames",
+		"protocol",
+		"SELECT COUNT(*) FROM pragma_table_info('portNames') WHERE name = 'protocol';",
+		"ALTER TABLE portNames ADD COLUMN protocol TEXT NOT NULL DEFAULT 'tcp' CHECK(protocol = 'tcp' OR protocol = 'udp');",
+	); err != nil {
+		return err
+	}
+	if _, err := txn.Exec(`
+		DROP INDEX IF EXISTS portNamesEndpointIndex;
+		CREATE UNIQUE INDEX portNamesEndpointIndex
+			ON portNames(challenge, host, port, protocol);`,
+	); err != nil {
+		return fmt.Errorf("could not rebuild the published endpoint index: %w", err)
+	}
+	return nil
+}
+
+// Egress allowlists add the listed destinations to the network options and
+// track which challenge networks have firewall rules to remove.
+func migrateDatabaseV12ToV13(txn *sqlx.Tx) error {
+	if err := addDatabaseColumnIfMissing(
+		txn,
+		"networkOptions",
+		"egress",
+		"SELECT COUNT(*) FROM pragma_table_info('networkOptions') WHERE name = 'egress';",
+		"ALTER TABLE networkOptions ADD COLUMN egress TEXT NOT NULL DEFAULT '[]';",
+	); err != nil {
+		return err
+	}
+	if _, err := txn.Exec(`
+		CREATE TABLE IF NOT EXISTS egressRules (
+			network TEXT NOT NULL PRIMARY KEY
+		);`,
+	); err != nil {
+		return fmt.Errorf("could not create version 13 database objects: %w", err)
+	}
+	return nil
+}
+
+// Packet capture adds its challenge option and tracks the sidecar capturing
+// each challenge network.
+func migrateDatabaseV13ToV14(txn *sqlx.Tx) error {
+	if err := addDatabaseColumnIfMissing(
+		txn,
+		"networkOptions",
+		"capture",
+		"SELECT COUNT(*) FROM pragma_table_info('networkOptions') WHERE name = 'capture';",
+		"ALTER TABLE networkOptions ADD COLUMN capture INTEGER NOT NULL DEFAULT 0 CHECK(capture = 0 OR capture = 1);",
+	); err != nil {
+		return err
+	}
+	if _, err := txn.Exec(`
+		CREATE TABLE IF NOT EXISTS captureSidecars (
+			network TEXT NOT NULL PRIMARY KEY,
+			container TEXT NOT NULL
+		);`,
+	); err != nil {
+		return fmt.Errorf("could not create version 14 database objects: %w", err)
+	}
+	return nil
+}
+
+// Instances record the Docker node they run on.  Existing instances run on
+// the only Docker host there was, which the empty name stands for.
+func migrateDatabaseV14ToV15(txn *sqlx.Tx) error {
+	return addDatabaseColumnIfMissing(
+		txn,
+		"instances",
+		"node",
+		"SELECT COUNT(*) FROM pragma_table_info('instances') WHERE name = 'node';",
+		"ALTER TABLE instances ADD COLUMN node TEXT NOT NULL DEFAULT '';",
+	)
+}
+
 var currentDatabaseColumns = map[string][]string{
 	"challenges": {
 		"id", "name", "namespace", "challengetype", "description", "details",
 		"sourcechecksum", "metadatachecksum", "sourcedigest", "metadatadigest",
 		"path", "solvescript", "templatable", "maxusers", "category", "points",
 	},
-	"hints":             {"challenge", "idx", "hint"},
-	"tags":              {"challenge", "tag"},
-	"attributes":        {"challenge", "key", "value"},
-	"hosts":             {"challenge", "name", "idx", "target"},
-	"portNames":         {"challenge", "name", "host", "port"},
-	"schemas":           {"name", "manual"},
-	"builds":            {"id", "flag", "format", "seed", "hasartifacts", "lastsolved", "challenge", "schema", "instancecount", "requiredseccomptweaks"},
-	"images":            {"id", "build", "host"},
-	"imagePorts":        {"image", "port"},
-	"lookupData":        {"build", "key", "value"},
-	"instances":         {"id", "lastsolved", "build"},
+	"hints":      {"challenge", "idx", "hint"},
+	"tags":       {"challenge", "tag"},
+	"attributes": {"challenge", "key", "value"},
+	"hosts":      {"challenge", "name", "idx", "target"},
+	"portNames":  {"challenge", "name", "host", "port", "protocol"},
+	"schemas":    {"name", "manual"},
+	"builds":     {"id", "flag", "format", "seed", "hasartifacts", "lastsolved", "challenge", "schema", "instancecount", "requiredseccomptweaks", "warmpool"},
+	"images":     {"id", "build", "host"},
+	"imagePorts": {"image", "port"},
+	"lookupData": {"build", "key", "value"},
+	"instances": {
+		"id", "lastsolved", "build", "expires", "paused", "lastchecked",
+		"lastcheckerror", "pooled", "node",
+	},
 	"portAssignments":   {"instance", "name", "port"},
 	"containers":        {"instance", "id"},
 	"retiredContainers": {"id"},
 	"retiredNetworks":   {"name"},
-	"networkOptions":    {"challenge", "allowegress"},
+	"egressRules":       {"network"},
+	"captureSidecars":   {"network", "container"},
+	"networkOptions":    {"challenge", "allowegress", "egress", "httpports", "tlsports", "capture"},
 	"containerOptions": {
 		"challenge", "host", "init", "cpus", "memory", "ulimits", "pidslimit",
 		"readonlyrootfs", "droppedcaps", "nonewprivileges", "diskquota",
-		"cgroupparent", "seccomp",
+		"cgroupparent", "seccomp", "healthcheck",
+	},
+	"jobs": {
+		"id", "kind", "status", "owner", "schema", "request", "error",
+		"created", "updated",
 	},
+	"jobSeeds":    {"job", "challenge", "seed", "status", "build", "error"},
+	"assignments": {"schema", "challenge", "assignee", "instance", "assigned"},
 }
 
 var currentDatabaseIndexes = []string{
@@ -657,6 +1011,9 @@ var currentDatabaseIndexes = []string{
 	"portAssignmentsNameIndex",
 	"portAssignmentsPortIndex",
 	"containerOptionsHostIndex",
+	"jobsStatusIndex",
+	"instancesExpiresIndex",
+	"assignmentsInstanceIndex",
 }
 
 var currentDatabaseInvariants = []databaseConflictCheck{
@@ -1254,12 +1611,13 @@ func (m *Manager) getReversePortMap(id ChallengeId) (map[challengePortEndpoint]s
 	rpm := make(map[challengePortEndpoint]string)
 
 	res := []struct {
-		Name string
-		Host string
-		Port int
+		Name     string
+		Host     string
+		Port     int
+		Protocol string
 	}{}
 
-	err := m.db.Select(&res, `SELECT name, host, port FROM portNames WHERE challenge=?;`, id)
+	err := m.db.Select(&res, `SELECT name, host, port, protocol FROM portNames WHERE challenge=?;`, id)
 	if err != nil {
 		m.log.errorf("could not get challenge ports: %s", err)
 		return nil, err
@@ -1268,7 +1626,7 @@ func (m *Manager) getReversePortMap(id ChallengeId) (map[challengePortEndpoint]s
 	for _, entry := range res {
 		endpoint := challengePortEndpoint{
 			Host: entry.Host,
-			Port: fmt.Sprintf("%d/tcp", entry.Port),
+			Port: fmt.Sprintf("%d/%s", entry.Port, entry.Protocol),
 		}
 		rpm[endpoint] = entry.Name
 	}
@@ -0,0 +1,150 @@
+package cmgr
+
+import (
+	"fmt"
+)
+
+const lookupAssignmentQuery = `
+	SELECT schema, challenge, assignee, instance, assigned
+	FROM assignments
+	WHERE schema = ? AND challenge = ? AND assignee = ?;`
+
+func (m *Manager) lookupAssignment(
+	schema string,
+	challenge ChallengeId,
+	user string,
+) (*Assignment, error) {
+	assignment := new(Assignment)
+	err := m.db.Get(assignment, lookupAssignmentQuery, schema, challenge, user)
+	if isEmptyQueryError(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not look up assignment: %w", err)
+	}
+	return assignment, nil
+}
+
+// The assignment is recorded in the same statement that counts the
+// instance's assignees so that concurrent requests, even from separate
+// processes, cannot overfill it.  Only started, unpaused, unexpired instances
+// that are not waiting in a warm pool are eligible, and the one with the
+// fewest assignees is chosen.  A non-zero instance restricts the choice to
+// that instance and a zero maxUsers places no limit on sharing.  Nothing is
+// recorded, and zero is returned, when no instance is eligible or the user
+// already has an assignment.
+const assignInstanceQuery = `
+	INSERT OR IGNORE INTO assignments(schema, challenge, assignee, instance, assigned)
+	SELECT builds.schema, builds.challenge, ?, instances.id, ?
+	FROM instances
+	JOIN builds ON builds.id = instances.build
+	WHERE builds.schema = ?
+	  AND builds.challenge = ?
+	  AND (? = 0 OR instances.id = ?)
+	  AND instances.paused = 0
+	  AND instances.pooled = 0
+	  AND (instances.expires = 0 OR instances.expires > ?)
+	  AND EXISTS (SELECT 1 FROM containers WHERE containers.instance = instances.id)
+	  AND (? = 0 OR (
+		SELECT COUNT(*) FROM assignments AS existing
+		WHERE existing.instance = instances.id
+	  ) < ?)
+	ORDER BY (
+		SELECT COUNT(*) FROM assignments AS existing
+		WHERE existing.instance = instances.id
+	), instances.id
+	LIMIT 1
+	RETURNING instance;`
+
+func (m *Manager) assignInstance(
+	schema string,
+	challenge ChallengeId,
+	user string,
+	instance InstanceId,
+	maxUsers int,
+	now int64,
+) (InstanceId, error) {
+	var assigned InstanceId
+	err := m.db.Get(
+		&assigned,
+		assignInstanceQuery,
+		user,
+		now,
+		schema,
+		challenge,
+		instance,
+		instance,
+		now,
+		maxUsers,
+		maxUsers,
+	)
+	if isEmptyQueryError(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("could not record assignment: %w", err)
+	}
+	return assigned, nil
+}
+
+func (m *Manager) removeAssignment(schema string, challenge ChallengeId, user string) (bool, error) {
+	res, err := m.db.Exec(
+		"DELETE FROM assignments WHERE schema = ? AND challenge = ? AND assignee = ?;",
+		schema,
+		challenge,
+		user,
+	)
+	if err != nil {
+		return false, fmt.Errorf("could not remove assignment: %w", err)
+	}
+	removed, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("could not remove assignment: %w", err)
+	}
+	return removed != 0, nil
+}
+
+// Lists the schema's dynamic builds of the challenge, those with the fewest
+// instances first.
+const assignableBuildsQuery = `
+	SELECT builds.id
+	FROM builds
+	LEFT JOIN instances ON instances.build = builds.id
+	WHERE builds.schema = ? AND builds.challenge = ? AND builds.instancecount = ?
+	GROUP BY builds.id
+	ORDER BY COUNT(instances.id), builds.id;`
+
+func (m *Manager) queryAssignableBuilds(schema string, challenge ChallengeId) ([]BuildId, error) {
+	builds := []BuildId{}
+	err := m.db.Select(&builds, assignableBuildsQuery, schema, challenge, DYNAMIC_INSTANCES)
+	if err != nil {
+		return nil, fmt.Errorf("could not query assignable builds: %w", err)
+	}
+	return builds, nil
+}
+
+func (m *Manager) schemaHasChallenge(schema string, challenge ChallengeId) (bool, error) {
+	var count int
+	err := m.db.Get(
+		&count,
+		"SELECT COUNT(*) FROM builds WHERE schema = ? AND challenge = ?;",
+		schema,
+		challenge,
+	)
+	if err != nil {
+		return false, fmt.Errorf("could not look up schema builds: %w", err)
+	}
+	return count != 0, nil
+}
+
+func (m *Manager) lookupChallengeMaxUsers(challenge ChallengeId) (int, error) {
+	var maxUsers int
+	err := m.db.Get(&maxUsers, "SELECT maxusers FROM challenges WHERE id = ?;", challenge)
+	if isEmptyQueryError(err) {
+		return 0, unknownChallengeIdError(challenge)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("could not look up challenge %s: %w", challenge, err)
+	}
+	return maxUsers, nil
+}
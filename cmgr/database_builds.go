@@ -113,6 +113,7 @@ func (m *Manager) openBuild(build *BuildMetadata) error {
 // Newly inserted rows are locked until the entire schema plan is activated.
 func (m *Manager) stageBuild(build *BuildMetadata) error {
 	requestedCount := build.InstanceCount
+	requestedPool := build.WarmPool
 	staged := *build
 	staged.InstanceCount = LOCKED
 	if _, err := m.db.NamedExec(stageBuildQuery, &staged); err != nil {
@@ -151,16 +152,23 @@ func (m *Manager) stageBuild(build *BuildMetadata) error {
 		*build = *persisted
 	}
 	build.InstanceCount = requestedCount
+	build.WarmPool = requestedPool
 	return nil
 }
 
+// The instance count and warm pool size that a schema gives one of its builds.
+type buildTarget struct {
+	instanceCount int
+	warmPool      int
+}
+
 func (m *Manager) activateSchemaBuilds(
 	schema string,
-	targets map[BuildId]int,
+	targets map[BuildId]buildTarget,
 ) error {
 	return withTransaction(m.db, func(txn *sqlx.Tx) error {
 		if _, err := txn.Exec(
-			"UPDATE builds SET instancecount = ? WHERE schema = ?;",
+			"UPDATE builds SET instancecount = ?, warmpool = 0 WHERE schema = ?;",
 			LOCKED,
 			schema,
 		); err != nil {
@@ -169,9 +177,10 @@ func (m *Manager) activateSchemaBuilds(
 		for build, target := range targets {
 			result, err := txn.Exec(
 				`UPDATE builds
-				 SET instancecount = ?
+				 SET instancecount = ?, warmpool = ?
 				 WHERE id = ? AND schema = ?;`,
-				target,
+				target.instanceCount,
+				target.warmPool,
 				build,
 				schema,
 			)
@@ -185,6 +194,16 @@ func (m *Manager) activateSchemaBuilds(
 			if affected != 1 {
 				return fmt.Errorf("could not activate missing build %d", build)
 			}
+			// A build that stops being dynamic keeps its pooled instances
+			// as ordinary instances counted toward its fixed target.
+			if target.instanceCount != DYNAMIC_INSTANCES {
+				if _, err := txn.Exec(
+					"UPDATE instances SET pooled = 0 WHERE build = ?;",
+					build,
+				); err != nil {
+					return fmt.Errorf("could not release warm pool of build %d: %w", build, err)
+				}
+			}
 		}
 		return nil
 	})
@@ -285,6 +304,15 @@ func (m *Manager) removeBuildMetadata(build BuildId) error {
 	})
 }
 
+func (m *Manager) lookupBuildChallenge(build BuildId) (ChallengeId, error) {
+	var challenge ChallengeId
+	err := m.db.Get(&challenge, "SELECT challenge FROM builds WHERE id=?;", build)
+	if isEmptyQueryError(err) {
+		return "", unknownBuildIdError(build)
+	}
+	return challenge, err
+}
+
 func (m *Manager) lookupBuildMetadata(build BuildId) (*BuildMetadata, error) {
 	metadata := new(BuildMetadata)
 	txn, err := m.db.Beginx()
@@ -417,7 +445,7 @@ func (m *Manager) removedSchemaBuilds(schema string) ([]BuildId, error) {
 }
 
 func (m *Manager) lockSchema(schema string) error {
-	_, err := m.db.Exec("UPDATE builds SET instancecount = ? WHERE schema = ?;", LOCKED, schema)
+	_, err := m.db.Exec("UPDATE builds SET instancecount = ?, warmpool = 0 WHERE schema = ?;", LOCKED, schema)
 	return err
 }
 
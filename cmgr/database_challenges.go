@@ -64,17 +64,22 @@ func (m *Manager) lookupChallengeMetadata(challenge ChallengeId) (*ChallengeMeta
 	}
 
 	ports := []struct {
-		Name string
-		Host string
-		Port int
+		Name     string
+		Host     string
+		Port     int
+		Protocol string
 	}{}
 	if err == nil {
-		err = txn.Select(&ports, "SELECT name, host, port FROM portNames WHERE challenge=?", challenge)
+		err = txn.Select(&ports, "SELECT name, host, port, protocol FROM portNames WHERE challenge=?", challenge)
 	}
 
 	metadata.PortMap = make(map[string]PortInfo)
 	for _, port := range ports {
-		metadata.PortMap[port.Name] = PortInfo{port.Host, port.Port}
+		endpoint := PortInfo{Host: port.Host, Port: port.Port}
+		if port.Protocol != "tcp" {
+			endpoint.Protocol = port.Protocol
+		}
+		metadata.PortMap[port.Name] = endpoint
 	}
 
 	attributes := []struct {
@@ -90,20 +95,44 @@ func (m *Manager) lookupChallengeMetadata(challenge ChallengeId) (*ChallengeMeta
 		metadata.Attributes[attr.Key] = attr.Value
 	}
 
+	var networkOptions struct {
+		AllowEgress bool
+		Egress      string
+		HTTPPorts   string
+		TLSPorts    string
+		Capture     bool
+	}
 	if err == nil {
 		err = txn.Get(
-			&metadata.ChallengeOptions.NetworkOptions,
-			`SELECT COALESCE(
-				(SELECT allowegress FROM networkOptions WHERE challenge=?),
-				0
-			) AS allowegress;`,
+			&networkOptions,
+			`SELECT
+				COALESCE((SELECT allowegress FROM networkOptions WHERE challenge=?), 0) AS allowegress,
+				COALESCE((SELECT egress FROM networkOptions WHERE challenge=?), '[]') AS egress,
+				COALESCE((SELECT httpports FROM networkOptions WHERE challenge=?), '[]') AS httpports,
+				COALESCE((SELECT tlsports FROM networkOptions WHERE challenge=?), '[]') AS tlsports,
+				COALESCE((SELECT capture FROM networkOptions WHERE challenge=?), 0) AS capture;`,
+			challenge,
+			challenge,
+			challenge,
+			challenge,
 			challenge,
 		)
 	}
+	metadata.ChallengeOptions.AllowEgress.All = networkOptions.AllowEgress
+	metadata.ChallengeOptions.PacketCapture = networkOptions.Capture
+	if err == nil && networkOptions.Egress != "[]" {
+		err = json.Unmarshal([]byte(networkOptions.Egress), &metadata.ChallengeOptions.AllowEgress.Destinations)
+	}
+	if err == nil && networkOptions.HTTPPorts != "[]" {
+		err = json.Unmarshal([]byte(networkOptions.HTTPPorts), &metadata.ChallengeOptions.HTTPPorts)
+	}
+	if err == nil && networkOptions.TLSPorts != "[]" {
+		err = json.Unmarshal([]byte(networkOptions.TLSPorts), &metadata.ChallengeOptions.TLSPorts)
+	}
 
 	containerOptions := new([]dbContainerOptions)
 	if err == nil {
-		err = txn.Select(containerOptions, "SELECT host, init, cpus, memory, ulimits, pidslimit, readonlyrootfs, droppedcaps, nonewprivileges, diskquota, cgroupparent, seccomp FROM containerOptions WHERE challenge=?", challenge)
+		err = txn.Select(containerOptions, "SELECT host, init, cpus, memory, ulimits, pidslimit, readonlyrootfs, droppedcaps, nonewprivileges, diskquota, cgroupparent, seccomp, healthcheck FROM containerOptions WHERE challenge=?", challenge)
 	}
 	for _, dbOpts := range *containerOptions {
 		var cOpts ContainerOptions
@@ -187,19 +216,38 @@ func (m *Manager) insertChallengeRelations(
 	}
 	for name, endpoint := range metadata.PortMap {
 		if _, err := txn.Exec(
-			"INSERT INTO portNames(challenge, name, host, port) VALUES (?, ?, ?, ?);",
+			"INSERT INTO portNames(challenge, name, host, port, protocol) VALUES (?, ?, ?, ?, ?);",
 			metadata.Id,
 			name,
 			endpoint.Host,
 			endpoint.Port,
+			endpoint.protocol(),
 		); err != nil {
 			return fmt.Errorf("could not insert published port %q: %w", name, err)
 		}
 	}
+	httpPorts, err := encodePortNames(metadata.ChallengeOptions.HTTPPorts)
+	if err != nil {
+		return fmt.Errorf("could not serialize HTTP ports: %w", err)
+	}
+	tlsPorts, err := encodePortNames(metadata.ChallengeOptions.TLSPorts)
+	if err != nil {
+		return fmt.Errorf("could not serialize TLS ports: %w", err)
+	}
+	egress := []byte("[]")
+	if destinations := metadata.ChallengeOptions.AllowEgress.Destinations; len(destinations) != 0 {
+		if egress, err = json.Marshal(destinations); err != nil {
+			return fmt.Errorf("could not serialize egress destinations: %w", err)
+		}
+	}
 	if _, err := txn.Exec(
-		"INSERT INTO networkOptions(challenge, allowegress) VALUES (?, ?);",
+		"INSERT INTO networkOptions(challenge, allowegress, egress, httpports, tlsports, capture) VALUES (?, ?, ?, ?, ?, ?);",
 		metadata.Id,
-		metadata.ChallengeOptions.AllowEgress,
+		metadata.ChallengeOptions.AllowEgress.All,
+		string(egress),
+		httpPorts,
+		tlsPorts,
+		metadata.ChallengeOptions.PacketCapture,
 	); err != nil {
 		return fmt.Errorf("could not insert network options: %w", err)
 	}
@@ -216,8 +264,8 @@ func (m *Manager) insertChallengeRelations(
 			`INSERT INTO containerOptions(
 				challenge, host, init, cpus, memory, ulimits, pidslimit,
 				readonlyrootfs, droppedcaps, nonewprivileges, diskquota,
-				cgroupparent, seccomp
-			) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`,
+				cgroupparent, seccomp, healthcheck
+			) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`,
 			metadata.Id,
 			host,
 			dbOpts.Init,
@@ -231,6 +279,7 @@ func (m *Manager) insertChallengeRelations(
 			dbOpts.DiskQuota,
 			dbOpts.CgroupParent,
 			dbOpts.Seccomp,
+			dbOpts.HealthCheck,
 		); err != nil {
 			return fmt.Errorf(
 				"could not insert container options for host %q: %w",
@@ -576,6 +625,7 @@ type dbContainerOptions struct {
 	DiskQuota       string
 	CgroupParent    string
 	Seccomp         string
+	HealthCheck     string
 }
 
 func newFromDbContainerOptions(dbOpts dbContainerOptions) (ContainerOptions, error) {
@@ -616,6 +666,11 @@ func newFromDbContainerOptions(dbOpts dbContainerOptions) (ContainerOptions, err
 		return cOpts, err
 	}
 
+	cOpts.HealthCheck, err = unmarshalHealthCheckOptions(dbOpts.HealthCheck)
+	if err != nil {
+		return cOpts, err
+	}
+
 	return cOpts, nil
 }
 
@@ -657,6 +712,11 @@ func (cOpts ContainerOptions) toDbContainerOptions() (dbContainerOptions, error)
 		return dbOpts, err
 	}
 
+	dbOpts.HealthCheck, err = marshalHealthCheckOptions(cOpts.HealthCheck)
+	if err != nil {
+		return dbOpts, err
+	}
+
 	return dbOpts, nil
 }
 
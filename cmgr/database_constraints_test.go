@@ -30,15 +30,20 @@ func TestInitDatabaseCreatesExpectedSchema(t *testing.T) {
 		t.Fatalf("could not inspect database tables: %s", err)
 	}
 	expectedTables := []string{
+		"assignments",
 		"attributes",
 		"builds",
+		"captureSidecars",
 		"challenges",
 		"containerOptions",
 		"containers",
+		"egressRules",
 		"hints",
 		"imagePorts",
 		"images",
 		"instances",
+		"jobSeeds",
+		"jobs",
 		"lookupData",
 		"networkOptions",
 		"portAssignments",
@@ -62,12 +67,15 @@ func TestInitDatabaseCreatesExpectedSchema(t *testing.T) {
 		t.Fatalf("could not inspect database indexes: %s", err)
 	}
 	expectedIndexes := []string{
+		"assignmentsInstanceIndex",
 		"attributeIndex",
 		"containerOptionsHostIndex",
 		"hostsIndex",
 		"hostsOrderIndex",
 		"imagePortsPortIndex",
 		"imagesHostIndex",
+		"instancesExpiresIndex",
+		"jobsStatusIndex",
 		"lookupDataKeyIndex",
 		"portAssignmentsNameIndex",
 		"portAssignmentsPortIndex",
@@ -194,6 +202,15 @@ func TestDatabaseCheckAndNotNullConstraints(t *testing.T) {
 			query: `INSERT INTO portAssignments(instance, name, port)
 				VALUES (1, 'http', 65536);`,
 		},
+		{
+			name: "instance paused marker is boolean",
+			setup: func(t *testing.T, db *sqlx.DB) {
+				insertConstraintChallenge(t, db)
+				insertConstraintBuild(t, db)
+			},
+			query: `INSERT INTO instances(id, lastsolved, build, paused)
+				VALUES (1, 0, 1, 2);`,
+		},
 		{
 			name:  "container init is boolean",
 			setup: insertConstraintChallenge,
@@ -603,6 +620,48 @@ func TestReversePortMapDistinguishesHosts(t *testing.T) {
 	}
 }
 
+func TestPublishedPortsDistinguishProtocols(t *testing.T) {
+	manager := newSchemaTestManager(t)
+	insertConstraintChallenge(t, manager.db)
+	insertConstraintHost(t, manager.db)
+	requireExec(
+		t,
+		manager.db,
+		`INSERT INTO portNames(challenge, name, host, port, protocol) VALUES
+			('challenge', 'dns', 'web', 53, 'udp'),
+			('challenge', 'dns_tcp', 'web', 53, 'tcp');`,
+	)
+	requireConstraintFailure(
+		t,
+		manager.db,
+		"INSERT INTO portNames(challenge, name, host, port, protocol) VALUES ('challenge', 'quic', 'web', 443, 'sctp');",
+	)
+
+	actual, err := manager.getReversePortMap("challenge")
+	if err != nil {
+		t.Fatalf("could not load reverse port map: %s", err)
+	}
+	expected := map[challengePortEndpoint]string{
+		{Host: "web", Port: "53/udp"}: "dns",
+		{Host: "web", Port: "53/tcp"}: "dns_tcp",
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("unexpected reverse port map:\ngot:  %#v\nwant: %#v", actual, expected)
+	}
+
+	metadata, err := manager.lookupChallengeMetadata("challenge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedPorts := map[string]PortInfo{
+		"dns":     {Host: "web", Port: 53, Protocol: "udp"},
+		"dns_tcp": {Host: "web", Port: 53},
+	}
+	if !reflect.DeepEqual(metadata.PortMap, expectedPorts) {
+		t.Fatalf("unexpected port map:\ngot:  %#v\nwant: %#v", metadata.PortMap, expectedPorts)
+	}
+}
+
 func TestLookupChallengeMetadataOrdersHosts(t *testing.T) {
 	manager := newSchemaTestManager(t)
 	insertConstraintChallenge(t, manager.db)
@@ -8,7 +8,7 @@ import (
 )
 
 func (m *Manager) openInstance(meta *InstanceMetadata) error {
-	res, err := m.db.NamedExec("INSERT INTO instances(build, lastsolved) VALUES (:build, :lastsolved);", meta)
+	res, err := m.db.NamedExec("INSERT INTO instances(build, lastsolved, expires, pooled, node) VALUES (:build, :lastsolved, :expires, :pooled, :node);", meta)
 
 	if err != nil {
 		m.log.errorf("failed to create instance entry: %s", err)
@@ -147,11 +147,16 @@ func (m *Manager) lookupInstanceMetadata(instance InstanceId) (*InstanceMetadata
 	for _, kvPair := range ports {
 		metadata.Ports[kvPair.Name] = kvPair.Port
 	}
+	metadata.NodeAddress = m.nodeAddress(metadata.Node)
+	metadata.Addresses = m.portAddresses(metadata.NodeAddress, metadata.Ports)
 
 	metadata.Containers = []string{}
 	if err == nil {
 		err = txn.Select(&metadata.Containers, "SELECT id FROM containers WHERE instance=?", instance)
 	}
+	if err == nil {
+		err = m.addRoutedEndpoints(txn, metadata)
+	}
 	if err == nil {
 		err = txn.Commit()
 		if err != nil {
@@ -285,17 +290,55 @@ func (m *Manager) incompleteInstanceIDs() ([]InstanceId, error) {
 type trackedContainerRecord struct {
 	Instance InstanceId `db:"instance"`
 	ID       string     `db:"id"`
+	Paused   bool       `db:"paused"`
 }
 
 func (m *Manager) trackedContainers() ([]trackedContainerRecord, error) {
 	containers := []trackedContainerRecord{}
 	err := m.db.Select(
 		&containers,
-		"SELECT instance, id FROM containers ORDER BY instance, id;",
+		`SELECT containers.instance, containers.id, instances.paused
+		FROM containers JOIN instances ON instances.id = containers.instance
+		ORDER BY containers.instance, containers.id;`,
 	)
 	return containers, err
 }
 
+func (m *Manager) setInstanceExpiry(instance InstanceId, expires int64) error {
+	res, err := m.db.Exec("UPDATE instances SET expires=? WHERE id=?", expires, instance)
+	if err != nil {
+		return fmt.Errorf("could not update instance expiry: %w", err)
+	}
+	if count, err := res.RowsAffected(); err == nil && count == 0 {
+		return unknownInstanceIdError(instance)
+	}
+	return nil
+}
+
+func (m *Manager) setInstancePaused(instance InstanceId, paused bool) error {
+	res, err := m.db.Exec("UPDATE instances SET paused=? WHERE id=?", paused, instance)
+	if err != nil {
+		return fmt.Errorf("could not update instance paused state: %w", err)
+	}
+	if count, err := res.RowsAffected(); err == nil && count == 0 {
+		return unknownInstanceIdError(instance)
+	}
+	return nil
+}
+
+func (m *Manager) queryExpiredInstances(now int64) ([]InstanceId, error) {
+	instances := []InstanceId{}
+	err := m.db.Select(
+		&instances,
+		"SELECT id FROM instances WHERE expires > 0 AND expires <= ? ORDER BY expires, id;",
+		now,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not query expired instances: %w", err)
+	}
+	return instances, nil
+}
+
 const recordInstanceSolveQuery = `
 	UPDATE instances
 	SET lastsolved = :lastsolved
@@ -317,3 +360,138 @@ func (m *Manager) recordSolve(instance *InstanceMetadata) error {
 		return nil
 	})
 }
+
+func (m *Manager) recordCheck(instance InstanceId, checked int64, checkErr error) error {
+	message := ""
+	if checkErr != nil {
+		message = checkErr.Error()
+	}
+	_, err := m.db.Exec(
+		"UPDATE instances SET lastchecked=?, lastcheckerror=? WHERE id=?",
+		checked,
+		message,
+		instance,
+	)
+	if err != nil {
+		return fmt.Errorf("could not record instance check: %w", err)
+	}
+	return nil
+}
+
+const checkableInstancesQuery = `
+	SELECT instances.id
+	FROM instances
+	JOIN builds ON instances.build = builds.id
+	JOIN challenges ON builds.challenge = challenges.id
+	WHERE instances.paused = 0 AND challenges.solvescript = 1
+	ORDER BY instances.id;`
+
+func (m *Manager) queryCheckableInstances() ([]InstanceId, error) {
+	instances := []InstanceId{}
+	if err := m.db.Select(&instances, checkableInstancesQuery); err != nil {
+		return nil, fmt.Errorf("could not query checkable instances: %w", err)
+	}
+	return instances, nil
+}
+
+const challengeInstanceChecksQuery = `
+	SELECT instances.id AS instance,
+		instances.build,
+		COALESCE(instances.lastsolved, 0) AS lastsolved,
+		instances.lastchecked,
+		instances.lastcheckerror
+	FROM instances
+	JOIN builds ON instances.build = builds.id
+	WHERE builds.challenge = ?
+	ORDER BY instances.id;`
+
+func (m *Manager) queryChallengeInstanceChecks(
+	challenge ChallengeId,
+) ([]InstanceSolvability, error) {
+	instances := []InstanceSolvability{}
+	if err := m.db.Select(&instances, challengeInstanceChecksQuery, challenge); err != nil {
+		return nil, fmt.Errorf("could not query instance checks: %w", err)
+	}
+	return instances, nil
+}
+
+// Pooled instances are recorded before their containers start, so only those
+// whose containers have been recorded are ready to be claimed.
+const claimPooledInstanceQuery = `
+	UPDATE instances
+	SET pooled = 0, expires = ?
+	WHERE id = (
+		SELECT id
+		FROM instances
+		WHERE build = ? AND pooled = 1 AND paused = 0
+		  AND EXISTS (SELECT 1 FROM containers WHERE containers.instance = instances.id)
+		ORDER BY id
+		LIMIT 1
+	)
+	RETURNING id;`
+
+// Takes the oldest ready instance out of the build's warm pool, returning
+// zero if the pool has none.
+func (m *Manager) claimPooledInstance(build BuildId, expires int64) (InstanceId, error) {
+	var instance InstanceId
+	err := m.db.Get(&instance, claimPooledInstanceQuery, expires, build)
+	if isEmptyQueryError(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("could not claim pooled instance: %w", err)
+	}
+	return instance, nil
+}
+
+func (m *Manager) getPooledInstances(build BuildId) ([]InstanceId, error) {
+	instances := []InstanceId{}
+	err := m.db.Select(
+		&instances,
+		"SELECT id FROM instances WHERE build = ? AND pooled = 1 ORDER BY id;",
+		build,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not query pooled instances: %w", err)
+	}
+	return instances, nil
+}
+
+const warmPoolBuildsQuery = `
+	SELECT id
+	FROM builds
+	WHERE instancecount = ?
+	  AND (warmpool > 0 OR id IN (SELECT build FROM instances WHERE pooled = 1))
+	ORDER BY id;`
+
+// Lists the dynamic builds that have a warm pool or still have pooled
+// instances left over from a larger one.
+func (m *Manager) queryWarmPoolBuilds() ([]BuildId, error) {
+	builds := []BuildId{}
+	if err := m.db.Select(&builds, warmPoolBuildsQuery, DYNAMIC_INSTANCES); err != nil {
+		return nil, fmt.Errorf("could not query warm pools: %w", err)
+	}
+	return builds, nil
+}
+
+const instanceCountsQuery = `
+	SELECT node, build, COUNT(*) AS count
+	FROM instances
+	GROUP BY node, build
+	ORDER BY node, build;`
+
+type buildInstanceCount struct {
+	Node  string  `db:"node"`
+	Build BuildId `db:"build"`
+	Count int     `db:"count"`
+}
+
+// Counts the instances of each build that has any on each node, including
+// instances that are still starting.
+func (m *Manager) queryInstanceCounts() ([]buildInstanceCount, error) {
+	counts := []buildInstanceCount{}
+	if err := m.db.Select(&counts, instanceCountsQuery); err != nil {
+		return nil, fmt.Errorf("could not count instances: %w", err)
+	}
+	return counts, nil
+}
@@ -0,0 +1,263 @@
+package cmgr
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type jobSeedKey struct {
+	Challenge ChallengeId
+	Seed      int
+}
+
+// Records a queued job together with a pending row for every seed it will
+// build.
+func (m *Manager) openJob(
+	job *JobMetadata,
+	request []byte,
+	seeds []jobSeedKey,
+) error {
+	now := time.Now().Unix()
+	job.Status = JOB_QUEUED
+	job.Created = now
+	job.Updated = now
+	return withTransaction(m.db, func(txn *sqlx.Tx) error {
+		result, err := txn.Exec(
+			`INSERT INTO jobs(kind, status, owner, schema, request, created, updated)
+			 VALUES (?, ?, ?, ?, ?, ?, ?);`,
+			job.Kind,
+			job.Status,
+			m.jobOwner,
+			job.Schema,
+			string(request),
+			job.Created,
+			job.Updated,
+		)
+		if err != nil {
+			return fmt.Errorf("could not insert job: %w", err)
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			return fmt.Errorf("could not get job ID: %w", err)
+		}
+		job.Id = JobId(id)
+
+		job.Seeds = make([]JobSeed, 0, len(seeds))
+		for _, seed := range seeds {
+			if _, err := txn.Exec(
+				"INSERT INTO jobSeeds(job, challenge, seed, status) VALUES (?, ?, ?, ?);",
+				job.Id,
+				seed.Challenge,
+				seed.Seed,
+				SEED_PENDING,
+			); err != nil {
+				return fmt.Errorf(
+					"could not insert job seed %d for challenge %q: %w",
+					seed.Seed,
+					seed.Challenge,
+					err,
+				)
+			}
+			job.Seeds = append(job.Seeds, JobSeed{
+				Challenge: seed.Challenge,
+				Seed:      seed.Seed,
+				Status:    SEED_PENDING,
+			})
+		}
+		return nil
+	})
+}
+
+func (m *Manager) lookupJobMetadata(job JobId) (*JobMetadata, error) {
+	metadata := new(JobMetadata)
+	err := m.db.Get(
+		metadata,
+		"SELECT id, kind, status, schema, error, created, updated FROM jobs WHERE id=?;",
+		job,
+	)
+	if isEmptyQueryError(err) {
+		return nil, unknownJobIdError(job)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read job %d: %w", job, err)
+	}
+
+	metadata.Seeds = []JobSeed{}
+	err = m.db.Select(
+		&metadata.Seeds,
+		`SELECT challenge, seed, status, COALESCE(build, 0) AS build, error
+		 FROM jobSeeds WHERE job=? ORDER BY challenge, seed;`,
+		job,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not read seeds for job %d: %w", job, err)
+	}
+	return metadata, nil
+}
+
+func (m *Manager) lookupJobRequest(job JobId) (*JobMetadata, []byte, error) {
+	metadata, err := m.lookupJobMetadata(job)
+	if err != nil {
+		return nil, nil, err
+	}
+	var request string
+	if err := m.db.Get(&request, "SELECT request FROM jobs WHERE id=?;", job); err != nil {
+		return nil, nil, fmt.Errorf("could not read request for job %d: %w", job, err)
+	}
+	return metadata, []byte(request), nil
+}
+
+func (m *Manager) setJobStatus(job JobId, status JobStatus, message string) error {
+	_, err := m.db.Exec(
+		"UPDATE jobs SET status=?, error=?, updated=? WHERE id=?;",
+		status,
+		message,
+		time.Now().Unix(),
+		job,
+	)
+	if err != nil {
+		return fmt.Errorf("could not update job %d: %w", job, err)
+	}
+	return nil
+}
+
+func (m *Manager) setJobSeedStatus(
+	job JobId,
+	build *BuildMetadata,
+	status JobSeedStatus,
+	message string,
+) error {
+	// The build is recorded as soon as it is known so that clients can follow
+	// its log, which remains available even if the build fails.
+	var buildId any
+	if build.Id != 0 {
+		buildId = build.Id
+	}
+	return withTransaction(m.db, func(txn *sqlx.Tx) error {
+		if _, err := txn.Exec(
+			`UPDATE jobSeeds SET status=?, build=?, error=?
+			 WHERE job=? AND challenge=? AND seed=?;`,
+			status,
+			buildId,
+			message,
+			job,
+			build.Challenge,
+			build.Seed,
+		); err != nil {
+			return fmt.Errorf("could not update job %d seed %d: %w", job, build.Seed, err)
+		}
+		if _, err := txn.Exec(
+			"UPDATE jobs SET updated=? WHERE id=?;",
+			time.Now().Unix(),
+			job,
+		); err != nil {
+			return fmt.Errorf("could not update job %d: %w", job, err)
+		}
+		return nil
+	})
+}
+
+// Seeds that were mid-build when a job stopped did not finish building.
+func (m *Manager) failInterruptedJobSeeds(job JobId, message string) error {
+	_, err := m.db.Exec(
+		"UPDATE jobSeeds SET status=?, error=? WHERE job=? AND status=?;",
+		SEED_FAILED,
+		message,
+		job,
+		SEED_BUILDING,
+	)
+	return err
+}
+
+// Returns the owners of every job that has not yet finished.
+func (m *Manager) unfinishedJobOwners() ([]string, error) {
+	owners := []string{}
+	err := m.db.Select(
+		&owners,
+		"SELECT DISTINCT owner FROM jobs WHERE status IN (?, ?) AND owner != ?;",
+		JOB_QUEUED,
+		JOB_RUNNING,
+		m.jobOwner,
+	)
+	return owners, err
+}
+
+// Marks the unfinished jobs of a departed owner, and the seeds they had not
+// built, failed.
+func (m *Manager) failAbandonedJobs(owner string) error {
+	const message = "the process running this job exited before it finished"
+	return withTransaction(m.db, func(txn *sqlx.Tx) error {
+		abandoned := []JobId{}
+		if err := txn.Select(
+			&abandoned,
+			"SELECT id FROM jobs WHERE owner=? AND status IN (?, ?) ORDER BY id;",
+			owner,
+			JOB_QUEUED,
+			JOB_RUNNING,
+		); err != nil {
+			return fmt.Errorf("could not list jobs of owner %s: %w", owner, err)
+		}
+		for _, job := range abandoned {
+			if _, err := txn.Exec(
+				"UPDATE jobs SET status=?, error=?, updated=? WHERE id=?;",
+				JOB_FAILED,
+				message,
+				time.Now().Unix(),
+				job,
+			); err != nil {
+				return fmt.Errorf("could not fail job %d: %w", job, err)
+			}
+			if _, err := txn.Exec(
+				"UPDATE jobSeeds SET status=?, error=? WHERE job=? AND status IN (?, ?);",
+				SEED_FAILED,
+				message,
+				job,
+				SEED_PENDING,
+				SEED_BUILDING,
+			); err != nil {
+				return fmt.Errorf("could not fail seeds of job %d: %w", job, err)
+			}
+		}
+		return nil
+	})
+}
+
+// Transfers the unfinished jobs of a departed owner to this manager and
+// requeues them.
+func (m *Manager) adoptJobs(owner string) ([]JobId, error) {
+	adopted := []JobId{}
+	err := withTransaction(m.db, func(txn *sqlx.Tx) error {
+		if err := txn.Select(
+			&adopted,
+			"SELECT id FROM jobs WHERE owner=? AND status IN (?, ?) ORDER BY id;",
+			owner,
+			JOB_QUEUED,
+			JOB_RUNNING,
+		); err != nil {
+			return fmt.Errorf("could not list jobs of owner %s: %w", owner, err)
+		}
+		for _, job := range adopted {
+			if _, err := txn.Exec(
+				"UPDATE jobs SET owner=?, status=?, updated=? WHERE id=?;",
+				m.jobOwner,
+				JOB_QUEUED,
+				time.Now().Unix(),
+				job,
+			); err != nil {
+				return fmt.Errorf("could not adopt job %d: %w", job, err)
+			}
+			if _, err := txn.Exec(
+				"UPDATE jobSeeds SET status=? WHERE job=? AND status=?;",
+				SEED_PENDING,
+				job,
+				SEED_BUILDING,
+			); err != nil {
+				return fmt.Errorf("could not reset seeds of job %d: %w", job, err)
+			}
+		}
+		return nil
+	})
+	return adopted, err
+}
@@ -234,6 +234,22 @@ func TestDatabaseV0ToV1Migration(t *testing.T) {
 	}{
 		{table: "containerOptions", name: "seccomp"},
 		{table: "builds", name: "requiredseccomptweaks"},
+		{table: "instances", name: "expires"},
+		{table: "instances", name: "paused"},
+		{table: "containerOptions", name: "healthcheck"},
+		{table: "instances", name: "lastchecked"},
+		{table: "instances", name: "lastcheckerror"},
+		{table: "builds", name: "warmpool"},
+		{table: "instances", name: "pooled"},
+		{table: "assignments", name: "assignee"},
+		{table: "networkOptions", name: "httpports"},
+		{table: "networkOptions", name: "tlsports"},
+		{table: "portNames", name: "protocol"},
+		{table: "networkOptions", name: "egress"},
+		{table: "egressRules", name: "network"},
+		{table: "networkOptions", name: "capture"},
+		{table: "captureSidecars", name: "container"},
+		{table: "instances", name: "node"},
 	} {
 		var count int
 		query := fmt.Sprintf(
@@ -978,7 +994,7 @@ func TestRebuildUpdateRemainsDetectablyIncompleteUntilFinalCommit(t *testing.T)
 func TestNetworkOptionsRoundTrip(t *testing.T) {
 	manager := newSchemaTestManager(t)
 	metadata := newAddChallengeTestMetadata("egress", nil)
-	metadata.ChallengeOptions.AllowEgress = true
+	metadata.ChallengeOptions.AllowEgress.All = true
 	if err := manager.addChallenge(metadata); err != nil {
 		t.Fatal(err)
 	}
@@ -986,11 +1002,34 @@ func TestNetworkOptionsRoundTrip(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if !loaded.ChallengeOptions.AllowEgress {
+	if !loaded.ChallengeOptions.AllowEgress.All {
 		t.Fatal("allow_egress was not persisted")
 	}
 }
 
+func TestEgressAllowlistRoundTrip(t *testing.T) {
+	manager := newSchemaTestManager(t)
+	metadata := newAddChallengeTestMetadata("allowlist", nil)
+	metadata.ChallengeOptions.AllowEgress.Destinations = []string{
+		"10.0.5.10/32:8080/tcp",
+		"169.254.169.254/32",
+	}
+	if err := manager.addChallenge(metadata); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := manager.lookupChallengeMetadata(metadata.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.ChallengeOptions.AllowEgress, metadata.ChallengeOptions.AllowEgress) {
+		t.Fatalf(
+			"loaded egress policy %#v, expected %#v",
+			loaded.ChallengeOptions.AllowEgress,
+			metadata.ChallengeOptions.AllowEgress,
+		)
+	}
+}
+
 func TestEmptyManagedSchemaIsPersistedAndListed(t *testing.T) {
 	manager := newSchemaTestManager(t)
 	if err := manager.createSchemaRecord("empty", false); err != nil {
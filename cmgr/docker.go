@@ -10,6 +10,7 @@ import (
 	"io"
 	"io/ioutil"
 	"math/rand"
+	"net"
 	"net/netip"
 	"os"
 	"path/filepath"
@@ -29,17 +30,75 @@ import (
 	"github.com/moby/moby/client"
 )
 
-func (m *Manager) initDocker() error {
-	cli, err := client.New(client.FromEnv)
-	if err != nil {
-		m.log.errorf("could not create docker client: %s", err)
-		return err
+// Connects to the container runtime, which is the Docker daemon unless one
+// is given or a pool of Docker nodes or a Kubernetes cluster is configured,
+// and reads the Docker host settings from the environment.
+func (m *Manager) initDocker(runtime ContainerRuntime) error {
+	var err error
+	var isSet bool
+	m.challengeRegistry, isSet = os.LookupEnv(REGISTRY_ENV)
+	if isSet {
+		m.authString, err = authconfig.Encode(registry.AuthConfig{
+			Username:      os.Getenv(REGISTRY_USER_ENV),
+			Password:      os.Getenv(REGISTRY_TOKEN_ENV),
+			ServerAddress: strings.SplitN(m.challengeRegistry, "/", 2)[0],
+		})
+		if err != nil {
+			return fmt.Errorf("could not encode registry authentication: %w", err)
+		}
+	}
+
+	nodes, poolRequested := os.LookupEnv(DOCKER_NODES_ENV)
+	if runtime == nil && poolRequested {
+		if _, isSet := os.LookupEnv(KUBERNETES_ENV); isSet {
+			err = fmt.Errorf("%s and %s cannot both be set", DOCKER_NODES_ENV, KUBERNETES_ENV)
+			m.log.errorf("%s", err)
+			return err
+		}
+		runtime, err = newDockerPoolFromEnv(nodes, m.challengeRegistry, m.authString)
+		if err != nil {
+			m.log.errorf("%s", err)
+			return err
+		}
+	} else if runtime == nil {
+		runtime, err = newDockerRuntime()
+		if err != nil {
+			m.log.errorf("could not create docker client: %s", err)
+			return err
+		}
+		if kubeconfig, isSet := os.LookupEnv(KUBERNETES_ENV); isSet {
+			runtime, err = newKubernetesRuntime(
+				runtime,
+				kubeconfig,
+				m.challengeRegistry,
+				m.authString,
+			)
+			if err != nil {
+				m.log.errorf("%s", err)
+				return err
+			}
+		}
 	}
 
-	m.cli = cli
+	m.cli = runtime
 	m.ctx = context.Background()
+	if pool, ok := runtime.(*dockerPool); ok {
+		m.pool = pool
+	}
+	if _, ok := runtime.(*kubernetesRuntime); ok {
+		m.kubernetes = true
+		// Pods cannot limit their own processes, so neither the defaults
+		// nor the host budget may count on such a limit.
+		if _, isSet := os.LookupEnv(defaultPidsLimitEnv); isSet {
+			return fmt.Errorf("%s cannot be set with %s", defaultPidsLimitEnv, KUBERNETES_ENV)
+		}
+		if m.policy.HostBudget.pids > 0 {
+			return fmt.Errorf("%s cannot be set with %s", hostPidsEnv, KUBERNETES_ENV)
+		}
+		m.runtimeDefaults.PidsLimit = 0
+	}
 
-	ping, err := cli.Ping(
+	ping, err := runtime.Ping(
 		m.ctx,
 		client.PingOptions{NegotiateAPIVersion: true},
 	)
@@ -50,7 +109,7 @@ func (m *Manager) initDocker() error {
 
 	m.log.infof("connected to docker (API v%s)", ping.APIVersion)
 
-	hostInfoResult, infoErr := cli.Info(m.ctx, client.InfoOptions{})
+	hostInfoResult, infoErr := runtime.Info(m.ctx, client.InfoOptions{})
 	if infoErr != nil {
 		m.log.warnf(
 			"could not determine whether seccomp tweaks are available: %s",
@@ -93,21 +152,32 @@ func (m *Manager) initDocker() error {
 	if !isSet {
 		chalInterface = "0.0.0.0"
 	}
-	m.challengeInterface = chalInterface
+	m.challengeAddresses, err = parseBindAddresses(chalInterface)
+	if err != nil {
+		m.log.errorf("%s", err)
+		return err
+	}
 
-	m.challengeRegistry, isSet = os.LookupEnv(REGISTRY_ENV)
-	if isSet {
-		m.authString, err = authconfig.Encode(registry.AuthConfig{
-			Username:      os.Getenv(REGISTRY_USER_ENV),
-			Password:      os.Getenv(REGISTRY_TOKEN_ENV),
-			ServerAddress: strings.SplitN(m.challengeRegistry, "/", 2)[0],
-		})
-		if err != nil {
-			return fmt.Errorf("could not encode registry authentication: %w", err)
-		}
+	_, m.ipv6Enabled = os.LookupEnv(IPV6_ENV)
+	m.firewall = nftablesFirewall{command: "nft"}
+	if firewall, isFirewall := runtime.(egressFirewall); isFirewall {
+		m.firewall = firewall
 	}
+	m.captureImage = envString(CAPTURE_IMAGE_ENV, defaultCaptureImage)
 
 	m.portLow, m.portHigh, err = getPortRange()
+	if err == nil && m.portLow == 0 && len(m.challengeAddresses) > 1 {
+		// Docker picks a separate ephemeral port for every binding, so only
+		// ports cmgr assigns itself are the same on each address.
+		err = fmt.Errorf(
+			"%s must be set to publish ports on more than one address",
+			PORTS_ENV,
+		)
+	} else if err == nil && m.portLow == 0 && m.pool != nil {
+		// Each node would pick its own ephemeral ports, which can collide
+		// with those of instances on the other nodes.
+		err = fmt.Errorf("%s must be set to use more than one Docker node", PORTS_ENV)
+	}
 	if err != nil {
 		m.log.errorf("%s", err)
 	}
@@ -115,6 +185,53 @@ func (m *Manager) initDocker() error {
 	return err
 }
 
+// Lists every address and port on which each of the given host ports is
+// published.  Ports on a node with a public address are listed only at that
+// address.
+func (m *Manager) portAddresses(nodeAddress string, ports map[string]int) map[string][]string {
+	hosts := make([]string, 0, len(m.challengeAddresses))
+	if nodeAddress != "" {
+		hosts = append(hosts, nodeAddress)
+	} else {
+		for _, addr := range m.challengeAddresses {
+			hosts = append(hosts, addr.String())
+		}
+	}
+	if len(ports) == 0 || len(hosts) == 0 {
+		return nil
+	}
+	addresses := make(map[string][]string, len(ports))
+	for name, port := range ports {
+		for _, host := range hosts {
+			addresses[name] = append(
+				addresses[name],
+				net.JoinHostPort(host, strconv.Itoa(port)),
+			)
+		}
+	}
+	return addresses
+}
+
+// Parses the comma-separated list of addresses that published ports bind to.
+func parseBindAddresses(value string) ([]netip.Addr, error) {
+	var addresses []netip.Addr
+	seen := make(map[netip.Addr]bool)
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		addr, err := netip.ParseAddr(field)
+		if err != nil || addr.Zone() != "" {
+			return nil, fmt.Errorf("invalid challenge interface address %q in %s", field, IFACE_ENV)
+		}
+		addr = addr.Unmap()
+		if seen[addr] {
+			return nil, fmt.Errorf("duplicate challenge interface address %q in %s", field, IFACE_ENV)
+		}
+		seen[addr] = true
+		addresses = append(addresses, addr)
+	}
+	return addresses, nil
+}
+
 func getPortRange() (int, int, error) {
 	portRange := os.Getenv(PORTS_ENV)
 	if portRange == "" {
@@ -179,6 +296,19 @@ func (m *Manager) getFreePort() (string, error) {
 	return "", fmt.Errorf("All ports between %d and %d are in use", m.portLow, m.portHigh)
 }
 
+// Returns the port's transport protocol, which is "tcp" unless it is "udp".
+func (p PortInfo) protocol() string {
+	if p.Protocol == "" {
+		return "tcp"
+	}
+	return p.Protocol
+}
+
+// Returns the Docker port specification, such as "53/udp", of the port.
+func (p PortInfo) dockerPort() string {
+	return fmt.Sprintf("%d/%s", p.Port, p.protocol())
+}
+
 func (b *BuildMetadata) makeFlag() *string {
 	sum := sha256.Sum256([]byte(fmt.Sprintf("%s:%s:%d", b.Challenge, b.Format, b.Seed)))
 	sumStr := fmt.Sprintf("%x", sum)
@@ -242,7 +372,24 @@ func (m *Manager) acquireBuildLock(build *BuildMetadata) func() {
 	}
 }
 
-func (m *Manager) generateBuilds(builds []*BuildMetadata) error {
+// Reports per-seed build progress to an observer such as an asynchronous job.
+// A nil buildProgress ignores every report.
+type buildProgress func(build *BuildMetadata, status JobSeedStatus, err error)
+
+func (progress buildProgress) report(
+	build *BuildMetadata,
+	status JobSeedStatus,
+	err error,
+) {
+	if progress != nil {
+		progress(build, status, err)
+	}
+}
+
+func (m *Manager) generateBuilds(
+	builds []*BuildMetadata,
+	progress buildProgress,
+) error {
 	if len(builds) == 0 {
 		return nil
 	}
@@ -252,6 +399,9 @@ func (m *Manager) generateBuilds(builds []*BuildMetadata) error {
 		buildsComplete = buildsComplete && (build.Flag != "")
 	}
 	if buildsComplete {
+		for _, build := range builds {
+			progress.report(build, SEED_BUILT, nil)
+		}
 		return nil
 	}
 
@@ -289,6 +439,7 @@ func (m *Manager) generateBuilds(builds []*BuildMetadata) error {
 
 	for _, build := range builds {
 		if build.Flag != "" {
+			progress.report(build, SEED_BUILT, nil)
 			continue
 		}
 
@@ -315,9 +466,11 @@ func (m *Manager) generateBuilds(builds []*BuildMetadata) error {
 		// its keyed lock. openBuild reloads all persisted metadata on conflict.
 		if build.Flag != "" {
 			releaseBuildLock()
+			progress.report(build, SEED_BUILT, nil)
 			continue
 		}
 
+		progress.report(build, SEED_BUILDING, nil)
 		if m.buildSlots != nil {
 			m.buildSlots <- struct{}{}
 		}
@@ -340,6 +493,7 @@ func (m *Manager) generateBuilds(builds []*BuildMetadata) error {
 				)
 			}
 			releaseBuildLock()
+			progress.report(build, SEED_FAILED, err)
 			return err
 		}
 
@@ -361,8 +515,10 @@ func (m *Manager) generateBuilds(builds []*BuildMetadata) error {
 		}
 		releaseBuildLock()
 		if err != nil {
+			progress.report(build, SEED_FAILED, err)
 			return err
 		}
+		progress.report(build, SEED_BUILT, nil)
 	}
 
 	return nil
@@ -373,11 +529,15 @@ type dockerProgressMessage struct {
 	ErrorDetail *struct {
 		Message string `json:"message"`
 	} `json:"errorDetail"`
-	Stream string `json:"stream"`
-	Status string `json:"status"`
+	Stream   string `json:"stream"`
+	Status   string `json:"status"`
+	Progress string `json:"progress"`
 }
 
-func consumeDockerProgress(response io.ReadCloser, operation string) error {
+// Waits for a streamed Docker operation to complete.  Build output and status
+// messages are copied to output, if provided, without the progress bars that
+// accompany image transfers.
+func consumeDockerProgress(response io.ReadCloser, operation string, output io.Writer) error {
 	defer response.Close()
 
 	const outputTailLimit = 8 * 1024
@@ -420,6 +580,17 @@ func consumeDockerProgress(response io.ReadCloser, operation string) error {
 		if message.Status != "" {
 			appendOutput(message.Status + "\n")
 		}
+		if output != nil {
+			io.WriteString(output, message.Stream)
+			if message.Status != "" && message.Progress == "" {
+				io.WriteString(output, message.Status+"\n")
+			}
+			if message.ErrorDetail != nil && message.ErrorDetail.Message != "" {
+				io.WriteString(output, message.ErrorDetail.Message+"\n")
+			} else if message.Error != "" {
+				io.WriteString(output, message.Error+"\n")
+			}
+		}
 		if message.ErrorDetail != nil && message.ErrorDetail.Message != "" {
 			return responseError(message.ErrorDetail.Message)
 		}
@@ -485,7 +656,7 @@ func (m *Manager) freezeBaseImage(challenge ChallengeId, force bool) error {
 		return err
 	}
 
-	if err := consumeDockerProgress(resp.Body, "base image build"); err != nil {
+	if err := consumeDockerProgress(resp.Body, "base image build", nil); err != nil {
 		m.log.error(err)
 		return err
 	}
@@ -498,7 +669,7 @@ func (m *Manager) freezeBaseImage(challenge ChallengeId, force bool) error {
 		return err
 	}
 
-	if err := consumeDockerProgress(pushResp, "base image push"); err != nil {
+	if err := consumeDockerProgress(pushResp, "base image push", nil); err != nil {
 		m.log.error(err)
 		return err
 	}
@@ -995,7 +1166,13 @@ func (m *Manager) executeBuild(
 	bMeta *BuildMetadata,
 	buildCtxFile string,
 	qualifier string,
-) error {
+) (err error) {
+	started := time.Now()
+	output := m.openBuildLog(bMeta)
+	defer func() {
+		output.finish(err)
+		m.metrics.observeBuild(cMeta.Id, started, err)
+	}()
 
 	seedStr := fmt.Sprintf("%d", bMeta.Seed)
 
@@ -1009,7 +1186,7 @@ func (m *Manager) executeBuild(
 	var buildCache []string
 	pullResp, err := m.cli.ImagePull(m.ctx, baseName, pullOpts)
 	if err == nil {
-		if err := consumeDockerProgress(pullResp, "base image pull"); err == nil {
+		if err := consumeDockerProgress(pullResp, "base image pull", output); err == nil {
 			m.log.infof("Successfully pulled base image '%s'", baseName)
 			buildCache = append(buildCache, baseName)
 		}
@@ -1027,7 +1204,7 @@ func (m *Manager) executeBuild(
 
 		for _, portInfo := range cMeta.PortMap {
 			if portInfo.Host == image.Host {
-				image.Ports = append(image.Ports, fmt.Sprintf("%d/tcp", portInfo.Port))
+				image.Ports = append(image.Ports, portInfo.dockerPort())
 			}
 		}
 
@@ -1064,7 +1241,7 @@ func (m *Manager) executeBuild(
 			return fmt.Errorf("could not close build context: %w", closeErr)
 		}
 
-		if err := consumeDockerProgress(resp.Body, "challenge image build"); err != nil {
+		if err := consumeDockerProgress(resp.Body, "challenge image build", output); err != nil {
 			m.log.error(err)
 			return err
 		}
@@ -1262,32 +1439,74 @@ func (m *Manager) executeBuild(
 	return err
 }
 
-func challengeNetworkCreateOptions(opts NetworkOptions) client.NetworkCreateOptions {
+func challengeNetworkCreateOptions(
+	name string,
+	opts NetworkOptions,
+	enableIPv6 bool,
+) client.NetworkCreateOptions {
 	options := client.NetworkCreateOptions{Driver: "bridge"}
-	if !opts.AllowEgress {
+	if enableIPv6 {
+		// Docker allocates the IPv6 subnet from its default address pools.
+		options.EnableIPv6 = &enableIPv6
+	}
+	driverOptions := make(map[string]string)
+	if opts.AllowEgress.restricted() || opts.PacketCapture {
+		// Egress rules and packet captures find the bridge by its name.
+		driverOptions[bridgeNameOption] = name
+	}
+	// With an allowlist, masquerading stays enabled so that its destinations
+	// are reachable; the egress rules drop everything else.
+	if !opts.AllowEgress.All && !opts.AllowEgress.restricted() {
 		// Docker's Internal setting also suppresses host port publishing on
 		// current engines. Disabling masquerading instead preserves published
 		// ingress while preventing Internet egress in the standard bridge/NAT
 		// topology used by cmgr's Linux deployments.
-		options.Options = map[string]string{
-			"com.docker.network.bridge.enable_ip_masquerade": "false",
-		}
+		driverOptions[masqueradeOption] = "false"
+	}
+	if len(driverOptions) != 0 {
+		options.Options = driverOptions
 	}
 	return options
 }
 
 func (m *Manager) startNetwork(instance *InstanceMetadata, opts NetworkOptions) error {
-	netSpec := challengeNetworkCreateOptions(opts)
 	netname := instance.getNetworkName()
+	if opts.AllowEgress.restricted() && !m.onPrimaryNode(instance) {
+		// The firewall only filters the bridges of the host cmgr runs on.
+		return fmt.Errorf(
+			"egress of instances on Docker node %s cannot be restricted",
+			instance.Node,
+		)
+	}
+	netSpec := challengeNetworkCreateOptions(netname, opts, m.ipv6Enabled)
+	netSpec.Labels = instance.nodeLabels()
 	_, err := m.cli.NetworkCreate(m.ctx, netname, netSpec)
 	if err != nil {
 		m.log.errorf("could not create challenge network (%s): %s", netname, err)
+		return err
+	}
+	if opts.AllowEgress.restricted() {
+		err = m.startEgressRules(netname, opts.AllowEgress)
+		if err != nil {
+			m.log.errorf("could not restrict egress of challenge network (%s): %s", netname, err)
+			return err
+		}
+	}
+	if opts.PacketCapture {
+		err = m.startCapture(instance, netname)
+		if err != nil {
+			m.log.errorf("could not capture traffic of challenge network (%s): %s", netname, err)
+		}
 	}
 	return err
 }
 
 func (m *Manager) stopNetwork(instance *InstanceMetadata) error {
 	networkName := instance.getNetworkName()
+	if err := m.stopCapture(networkName); err != nil {
+		m.log.errorf("failed to stop packet capture of network %s: %s", networkName, err)
+		return err
+	}
 	_, err := m.cli.NetworkRemove(
 		m.ctx,
 		networkName,
@@ -1299,8 +1518,13 @@ func (m *Manager) stopNetwork(instance *InstanceMetadata) error {
 			err = nil
 		} else {
 			m.log.errorf("failed to remove network: %s", err)
+			return err
 		}
 	}
+	err = m.stopEgressRules(networkName)
+	if err != nil {
+		m.log.errorf("failed to remove egress rules of network %s: %s", networkName, err)
+	}
 	return err
 }
 
@@ -1323,6 +1547,13 @@ func (m *Manager) startContainersWithPersistence(
 	if err != nil {
 		return err
 	}
+	// Ports served through the reverse proxy or the TLS gateway are reached
+	// over the instance's network, so they are exposed without taking a host
+	// port.
+	routed, err := m.routedPorts(build.Challenge)
+	if err != nil {
+		return err
+	}
 
 	if len(revPortMap) != 0 {
 		// No need to lock the port mapping if we are not mapping any ports...
@@ -1359,9 +1590,8 @@ func (m *Manager) startContainersWithPersistence(
 			}
 		}()
 	}
-	hostIP, err := netip.ParseAddr(m.challengeInterface)
-	if err != nil {
-		return fmt.Errorf("invalid challenge interface %q: %v", m.challengeInterface, err)
+	if len(m.challengeAddresses) == 0 {
+		return errors.New("no challenge interface addresses are configured")
 	}
 	// Call create in docker
 	netname := instance.getNetworkName()
@@ -1389,21 +1619,30 @@ func (m *Manager) startContainersWithPersistence(
 					image.Host,
 				)
 			}
+			exposedPorts[port] = struct{}{}
+			if routed[portName] {
+				continue
+			}
 			expectedPorts[port] = portName
 			hostPort, err := m.selectHostPort(portName, preferredPorts)
 			if err != nil {
 				return err
 			}
-			exposedPorts[port] = struct{}{}
-			publishedPorts[port] = []network.PortBinding{
-				{HostIP: hostIP, HostPort: hostPort},
+			bindings := make([]network.PortBinding, 0, len(m.challengeAddresses))
+			for _, hostIP := range m.challengeAddresses {
+				bindings = append(bindings, network.PortBinding{
+					HostIP:   hostIP,
+					HostPort: hostPort,
+				})
 			}
+			publishedPorts[port] = bindings
 		}
 
 		cConfig := container.Config{
 			Image:        fmt.Sprintf("%s:%s", build.Challenge, build.dockerId(image)),
 			Hostname:     image.Host,
 			ExposedPorts: exposedPorts,
+			Labels:       instance.nodeLabels(),
 		}
 
 		hConfig := container.HostConfig{
@@ -1473,6 +1712,12 @@ func (m *Manager) startContainersWithPersistence(
 			if cOpts.CgroupParent != "" {
 				hConfig.CgroupParent = cOpts.CgroupParent
 			}
+			if cOpts.HealthCheck != nil {
+				cConfig.Healthcheck, err = cOpts.HealthCheck.healthConfig()
+				if err != nil {
+					return err
+				}
+			}
 		}
 
 		effectiveSeccomp, err := withRequiredSeccompTweaks(
@@ -1669,6 +1914,19 @@ func inspectedPortAssignments(
 				err,
 			)
 		}
+		// Only one host port is recorded per challenge port, so every bind
+		// address must have received the same one.
+		for _, binding := range hostPortInfo[1:] {
+			if binding.HostPort != hostPortInfo[0].HostPort {
+				return nil, false, fmt.Errorf(
+					"container %s published %s on different host ports (%s and %s)",
+					containerID,
+					containerPort,
+					hostPortInfo[0].HostPort,
+					binding.HostPort,
+				)
+			}
+		}
 		assignments[portName] = hostPort
 	}
 	return assignments, true, nil
@@ -1724,6 +1982,9 @@ func (m *Manager) prepareInstanceCutover(
 	oldContainerIDs := append([]string(nil), current.Containers...)
 	pausedContainerIDs, err := m.pauseContainerProcesses(oldContainerIDs)
 	if err != nil {
+		if current.Paused {
+			return nil, err
+		}
 		if resumeErr := m.resumeContainerProcesses(pausedContainerIDs); resumeErr != nil {
 			return nil, fmt.Errorf(
 				"could not pause old containers: %v; partially paused containers could not be resumed: %v",
@@ -1763,12 +2024,14 @@ func (m *Manager) prepareInstanceCutover(
 				fmt.Sprintf("remove replacement containers: %v", cleanupErr),
 			)
 		}
-		restartErr := m.resumeContainerProcesses(oldContainerIDs)
-		if restartErr != nil {
-			recoveryProblems = append(
-				recoveryProblems,
-				fmt.Sprintf("restart old containers: %v", restartErr),
-			)
+		if !current.Paused {
+			restartErr := m.resumeContainerProcesses(oldContainerIDs)
+			if restartErr != nil {
+				recoveryProblems = append(
+					recoveryProblems,
+					fmt.Sprintf("restart old containers: %v", restartErr),
+				)
+			}
 		}
 		if len(recoveryProblems) != 0 {
 			return nil, fmt.Errorf(
@@ -1796,8 +2059,10 @@ func (m *Manager) rollbackInstanceCutover(cutover *instanceCutover) error {
 	); err != nil {
 		problems = append(problems, fmt.Sprintf("remove replacement containers: %v", err))
 	}
-	if err := m.resumeContainerProcesses(cutover.old.Containers); err != nil {
-		problems = append(problems, fmt.Sprintf("restart old containers: %v", err))
+	if !cutover.old.Paused {
+		if err := m.resumeContainerProcesses(cutover.old.Containers); err != nil {
+			problems = append(problems, fmt.Sprintf("restart old containers: %v", err))
+		}
 	}
 	if len(problems) != 0 {
 		return errors.New(strings.Join(problems, "; "))
@@ -1805,23 +2070,39 @@ func (m *Manager) rollbackInstanceCutover(cutover *instanceCutover) error {
 	return nil
 }
 
+// The replacement containers of a paused instance run while the update is
+// validated and are stopped again once it is committed.
 func (m *Manager) finishInstanceCutover(cutover *instanceCutover) error {
-	return m.removeRetiredContainerIDs(cutover.old.Containers)
+	err := m.removeRetiredContainerIDs(cutover.old.Containers)
+	if cutover.old.Paused {
+		if _, pauseErr := m.pauseContainerProcesses(
+			cutover.candidate.Containers,
+		); pauseErr != nil {
+			err = errors.Join(err, fmt.Errorf("re-pause instance %d: %w", cutover.old.Id, pauseErr))
+		}
+	}
+	return err
 }
 
 // pauseContainerProcesses stops containers without removing them or their
-// database rows. Staged challenge updates retain them for rollback.
+// database rows. Staged challenge updates retain them for rollback.  Their
+// restart policy is disabled first so that a restart of the Docker daemon or
+// host does not start them again behind cmgr's back.
 func (m *Manager) pauseContainerProcesses(
 	containerIDs []string,
 ) ([]string, error) {
 	timeout := 10
 	paused := make([]string, 0, len(containerIDs))
 	for _, containerID := range containerIDs {
+		if err := m.setRestartPolicy(containerID, container.RestartPolicyDisabled); err != nil {
+			return paused, fmt.Errorf("could not stop container %s: %v", containerID, err)
+		}
 		if _, err := m.cli.ContainerStop(
 			m.ctx,
 			containerID,
 			client.ContainerStopOptions{Timeout: &timeout},
 		); err != nil {
+			err = errors.Join(err, m.setRestartPolicy(containerID, container.RestartPolicyAlways))
 			return paused, fmt.Errorf("could not stop container %s: %v", containerID, err)
 		}
 		paused = append(paused, containerID)
@@ -1829,9 +2110,25 @@ func (m *Manager) pauseContainerProcesses(
 	return paused, nil
 }
 
+func (m *Manager) setRestartPolicy(containerID string, policy container.RestartPolicyMode) error {
+	_, err := m.cli.ContainerUpdate(
+		m.ctx,
+		containerID,
+		client.ContainerUpdateOptions{RestartPolicy: &container.RestartPolicy{Name: policy}},
+	)
+	return err
+}
+
 func (m *Manager) resumeContainerProcesses(containerIDs []string) error {
 	var problems []string
 	for _, containerID := range containerIDs {
+		if err := m.setRestartPolicy(containerID, container.RestartPolicyAlways); err != nil {
+			problems = append(
+				problems,
+				fmt.Sprintf("could not restart container %s: %v", containerID, err),
+			)
+			continue
+		}
 		if _, err := m.cli.ContainerStart(
 			m.ctx,
 			containerID,
@@ -1919,7 +2216,11 @@ func (m *Manager) removeBrokenInstance(instance *InstanceMetadata) error {
 			instance.getNetworkName(),
 		)
 	}
-	return m.removeInstanceMetadata(instance.Id)
+	if err := m.removeInstanceMetadata(instance.Id); err != nil {
+		return err
+	}
+	m.removeInstanceLogs(instance.Id)
+	return nil
 }
 
 func (m *Manager) reconcileBrokenInstancesWith(
@@ -2003,7 +2304,13 @@ func (m *Manager) reconcileBrokenInstancesWith(
 }
 
 func (m *Manager) reconcileBrokenInstances(instanceIDs []InstanceId) error {
-	return m.reconcileBrokenInstancesWith(instanceIDs, m.newInstance)
+	// Only instances of fixed builds are replaced, and those never expire.
+	return m.reconcileBrokenInstancesWith(
+		instanceIDs,
+		func(build *BuildMetadata) (InstanceId, error) {
+			return m.newInstance(build, 0)
+		},
+	)
 }
 
 func (m *Manager) retryRetiredResources() error {
@@ -2031,6 +2338,13 @@ func (m *Manager) retryRetiredResources() error {
 				)
 				continue
 			}
+			if ruleErr := m.stopEgressRules(networkName); ruleErr != nil {
+				errs = append(
+					errs,
+					fmt.Errorf("remove egress rules of retired network %s: %w", networkName, ruleErr),
+				)
+				continue
+			}
 			if forgetErr := m.forgetRetiredNetwork(networkName); forgetErr != nil {
 				errs = append(errs, forgetErr)
 			}
@@ -2089,7 +2403,8 @@ func (m *Manager) retryRetiredResources() error {
 				)
 				continue
 			}
-			if !inspection.Container.State.Running {
+			// Paused instances keep their containers stopped until `Resume`.
+			if !inspection.Container.State.Running && !tracked.Paused {
 				if _, startErr := m.cli.ContainerStart(
 					m.ctx,
 					tracked.ID,
@@ -2217,7 +2532,11 @@ func (m *Manager) destroyImages(build BuildId) error {
 		}
 	}
 
-	return m.removeBuildMetadata(build)
+	if err := m.removeBuildMetadata(build); err != nil {
+		return err
+	}
+	m.removeBuildLog(build)
+	return nil
 }
 
 func configureContainerSeccomp(
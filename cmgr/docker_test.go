@@ -1,10 +1,13 @@
 package cmgr
 
 import (
+	"bytes"
 	"errors"
 	"io"
 	"net/http"
+	"net/netip"
 	"os"
+	"reflect"
 	"strings"
 	"testing"
 
@@ -232,6 +235,36 @@ func TestInspectedPortAssignmentsRequiresRunningContainerAndEveryPort(
 			},
 			wantReady: true,
 		},
+		{
+			name: "running with assignment on every address",
+			inspection: container.InspectResponse{
+				State: running,
+				NetworkSettings: &container.NetworkSettings{
+					Ports: network.PortMap{
+						httpPort: {
+							{HostIP: netip.MustParseAddr("127.0.0.1"), HostPort: "31007"},
+							{HostIP: netip.MustParseAddr("::1"), HostPort: "31007"},
+						},
+					},
+				},
+			},
+			wantReady: true,
+		},
+		{
+			name: "different ports per address",
+			inspection: container.InspectResponse{
+				State: running,
+				NetworkSettings: &container.NetworkSettings{
+					Ports: network.PortMap{
+						httpPort: {
+							{HostIP: netip.MustParseAddr("127.0.0.1"), HostPort: "31007"},
+							{HostIP: netip.MustParseAddr("::1"), HostPort: "31008"},
+						},
+					},
+				},
+			},
+			wantErr: true,
+		},
 		{
 			name: "running before assignment",
 			inspection: container.InspectResponse{
@@ -369,6 +402,39 @@ func TestStartupRecoveryRemovesMissingDynamicInstance(t *testing.T) {
 	requireRowCount(t, manager.db, "portAssignments", 0)
 }
 
+func TestStartupRecoveryLeavesPausedInstancesStopped(t *testing.T) {
+	manager := newSchemaTestManager(t)
+	insertCompleteConstraintFixture(t, manager.db)
+	requireExec(t, manager.db, "UPDATE instances SET paused=1 WHERE id=1;")
+
+	manager.ctx = t.Context()
+	manager.cli = newDockerTestClient(t, func(
+		request *http.Request,
+	) (*http.Response, error) {
+		if request.Method == http.MethodGet &&
+			strings.HasSuffix(request.URL.Path, "/containers/container/json") {
+			return dockerTestResponse(
+				request,
+				http.StatusOK,
+				`{"Id":"container","State":{"Status":"exited","Running":false}}`,
+			)
+		}
+		if strings.Contains(request.URL.Path, "/containers/") {
+			t.Errorf("unexpected Docker request %s %s", request.Method, request.URL.Path)
+		}
+		return dockerTestResponse(
+			request,
+			http.StatusInternalServerError,
+			`{"message":"unexpected test request"}`,
+		)
+	})
+
+	if err := manager.retryRetiredResources(); err != nil {
+		t.Fatal(err)
+	}
+	requireRowCount(t, manager.db, "containers", 1)
+}
+
 func TestStartupRecoveryRestoresFixedBuildCapacity(t *testing.T) {
 	manager := newSchemaTestManager(t)
 	insertConstraintChallenge(t, manager.db)
@@ -542,17 +608,76 @@ func TestStartupRecoveryRejectsInspectionWithoutState(t *testing.T) {
 
 func TestChallengeNetworkDefaultsToNoEgress(t *testing.T) {
 	const masqueradeOption = "com.docker.network.bridge.enable_ip_masquerade"
-	options := challengeNetworkCreateOptions(NetworkOptions{})
+	options := challengeNetworkCreateOptions("cmgr-1", NetworkOptions{}, false)
 	if options.Internal {
 		t.Fatal("default challenge network suppresses published ingress")
 	}
 	if options.Options[masqueradeOption] != "false" {
 		t.Fatal("default challenge network enables outbound masquerading")
 	}
-	options = challengeNetworkCreateOptions(NetworkOptions{AllowEgress: true})
+	options = challengeNetworkCreateOptions(
+		"cmgr-1",
+		NetworkOptions{AllowEgress: EgressPolicy{All: true}},
+		false,
+	)
 	if _, disabled := options.Options[masqueradeOption]; disabled {
 		t.Fatal("allow_egress challenge network disables masquerading")
 	}
+	if options.EnableIPv6 != nil {
+		t.Fatal("challenge network requested IPv6 without CMGR_ENABLE_IPV6")
+	}
+}
+
+func TestChallengeNetworkEnablesIPv6(t *testing.T) {
+	options := challengeNetworkCreateOptions("cmgr-1", NetworkOptions{}, true)
+	if options.EnableIPv6 == nil || !*options.EnableIPv6 {
+		t.Fatal("challenge network did not enable IPv6")
+	}
+}
+
+func TestParseBindAddresses(t *testing.T) {
+	addresses, err := parseBindAddresses(" 192.0.2.10, 2001:db8::10 ,::")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []netip.Addr{
+		netip.MustParseAddr("192.0.2.10"),
+		netip.MustParseAddr("2001:db8::10"),
+		netip.MustParseAddr("::"),
+	}
+	if !reflect.DeepEqual(addresses, want) {
+		t.Fatalf("parsed %v, expected %v", addresses, want)
+	}
+
+	for _, value := range []string{
+		"",
+		"192.0.2.10,",
+		"localhost",
+		"fe80::1%eth0",
+		"192.0.2.10,::ffff:192.0.2.10",
+	} {
+		if _, err := parseBindAddresses(value); err == nil {
+			t.Errorf("accepted bind addresses %q", value)
+		}
+	}
+}
+
+func TestInstanceMetadataListsEveryBindAddress(t *testing.T) {
+	manager := newDynamicInstanceTestManager(t)
+	manager.challengeAddresses = []netip.Addr{
+		netip.MustParseAddr("192.0.2.10"),
+		netip.MustParseAddr("2001:db8::10"),
+	}
+	meta, err := manager.lookupInstanceMetadata(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{
+		"http": {"192.0.2.10:30000", "[2001:db8::10]:30000"},
+	}
+	if !reflect.DeepEqual(meta.Addresses, want) {
+		t.Fatalf("listed addresses %v, expected %v", meta.Addresses, want)
+	}
 }
 
 func TestConsumeDockerProgressAcceptsSuccessfulStream(t *testing.T) {
@@ -560,7 +685,7 @@ func TestConsumeDockerProgressAcceptsSuccessfulStream(t *testing.T) {
 		"{\"stream\":\"step one\\n\"}\n" +
 			"{\"aux\":{\"ID\":\"sha256:abc\"}}\n",
 	))
-	if err := consumeDockerProgress(response, "test build"); err != nil {
+	if err := consumeDockerProgress(response, "test build", nil); err != nil {
 		t.Fatalf("successful Docker response was rejected: %s", err)
 	}
 }
@@ -570,7 +695,7 @@ func TestConsumeDockerProgressReturnsStructuredError(t *testing.T) {
 		"{\"stream\":\"Traceback: useful context\\n\"}\n" +
 			"{\"error\":\"executor failed\",\"errorDetail\":{\"message\":\"specific failure\"}}\n",
 	))
-	err := consumeDockerProgress(response, "test build")
+	err := consumeDockerProgress(response, "test build", nil)
 	if err == nil ||
 		!strings.Contains(err.Error(), "specific failure") ||
 		!strings.Contains(err.Error(), "Traceback: useful context") {
@@ -580,8 +705,25 @@ func TestConsumeDockerProgressReturnsStructuredError(t *testing.T) {
 
 func TestConsumeDockerProgressRejectsMalformedResponse(t *testing.T) {
 	response := io.NopCloser(strings.NewReader("{not-json}\n"))
-	err := consumeDockerProgress(response, "test build")
+	err := consumeDockerProgress(response, "test build", nil)
 	if err == nil || !strings.Contains(err.Error(), "failed to decode") {
 		t.Fatalf("expected malformed-response error, got %v", err)
 	}
 }
+
+func TestConsumeDockerProgressCopiesOutputWithoutProgressBars(t *testing.T) {
+	response := io.NopCloser(strings.NewReader(
+		"{\"stream\":\"Step 1/2 : FROM base\\n\"}\n" +
+			"{\"status\":\"Downloading\",\"progress\":\"[==>   ]\"}\n" +
+			"{\"status\":\"Pull complete\"}\n" +
+			"{\"error\":\"executor failed\"}\n",
+	))
+	var output bytes.Buffer
+	if err := consumeDockerProgress(response, "test build", &output); err == nil {
+		t.Fatal("failed Docker response was accepted")
+	}
+	expected := "Step 1/2 : FROM base\nPull complete\nexecutor failed\n"
+	if output.String() != expected {
+		t.Fatalf("unexpected captured output %q", output.String())
+	}
+}
@@ -0,0 +1,323 @@
+package cmgr
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// The nftables table holding the egress rules of every allowlisted challenge
+// network.
+const nftEgressTable = "inet cmgr_egress"
+
+// Docker's option naming the bridge interface of a network.
+const bridgeNameOption = "com.docker.network.bridge.name"
+
+// Docker's option that lets a bridge network reach beyond the host.
+const masqueradeOption = "com.docker.network.bridge.enable_ip_masquerade"
+
+func (p EgressPolicy) IsZero() bool {
+	return !p.All && len(p.Destinations) == 0
+}
+
+// Whether only the listed destinations are reachable.
+func (p EgressPolicy) restricted() bool {
+	return !p.All && len(p.Destinations) != 0
+}
+
+func (p EgressPolicy) MarshalJSON() ([]byte, error) {
+	if p.restricted() {
+		return json.Marshal(p.Destinations)
+	}
+	return json.Marshal(p.All)
+}
+
+func (p *EgressPolicy) UnmarshalJSON(data []byte) error {
+	*p = EgressPolicy{}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		return json.Unmarshal(data, &p.Destinations)
+	}
+	return json.Unmarshal(data, &p.All)
+}
+
+func (p *EgressPolicy) UnmarshalYAML(node *yaml.Node) error {
+	*p = EgressPolicy{}
+	if node.Kind == yaml.SequenceNode {
+		return node.Decode(&p.Destinations)
+	}
+	return node.Decode(&p.All)
+}
+
+// Validates the destinations of the policy and rewrites them in their
+// canonical form.
+func normalizeEgressPolicy(policy *EgressPolicy) error {
+	if policy.All && len(policy.Destinations) != 0 {
+		return errors.New("cannot allow all egress and list destinations")
+	}
+	seen := make(map[string]bool, len(policy.Destinations))
+	for i, value := range policy.Destinations {
+		dest, err := parseEgressDestination(value)
+		if err != nil {
+			return err
+		}
+		canonical := dest.String()
+		if seen[canonical] {
+			return fmt.Errorf("duplicate egress destination %q", value)
+		}
+		seen[canonical] = true
+		policy.Destinations[i] = canonical
+	}
+	return nil
+}
+
+// An address range that a challenge network may reach, optionally limited to
+// a range of ports.
+type egressDestination struct {
+	prefix netip.Prefix
+	// Both are zero when every port is allowed.
+	lowPort  uint16
+	highPort uint16
+	// "tcp", "udp", or empty for both.
+	protocol string
+}
+
+// Parses a destination such as "10.0.5.0/24", "10.0.5.10:8080/tcp",
+// "10.0.5.10:8000-8100", or "[2001:db8::/64]:53/udp".
+func parseEgressDestination(value string) (egressDestination, error) {
+	var dest egressDestination
+	address, ports := value, ""
+	if rest, bracketed := strings.CutPrefix(value, "["); bracketed {
+		var closed bool
+		address, ports, closed = strings.Cut(rest, "]")
+		if !closed || (ports != "" && !strings.HasPrefix(ports, ":")) {
+			return dest, fmt.Errorf("malformed egress destination %q", value)
+		}
+		ports = strings.TrimPrefix(ports, ":")
+	} else if strings.Count(value, ":") == 1 {
+		address, ports, _ = strings.Cut(value, ":")
+	}
+
+	if strings.Contains(address, "/") {
+		prefix, err := netip.ParsePrefix(address)
+		if err != nil {
+			return dest, fmt.Errorf("invalid egress destination %q: %v", value, err)
+		}
+		dest.prefix = prefix.Masked()
+	} else {
+		addr, err := netip.ParseAddr(address)
+		if err != nil || addr.Zone() != "" {
+			return dest, fmt.Errorf("invalid egress destination address %q", value)
+		}
+		addr = addr.Unmap()
+		dest.prefix = netip.PrefixFrom(addr, addr.BitLen())
+	}
+	if dest.prefix.Addr().Is4In6() {
+		return dest, fmt.Errorf("invalid egress destination %q: use the IPv4 form", value)
+	}
+
+	if ports == "" {
+		return dest, nil
+	}
+	ports, dest.protocol, _ = strings.Cut(ports, "/")
+	switch dest.protocol {
+	case "", "tcp", "udp":
+	default:
+		return dest, fmt.Errorf("unsupported protocol in egress destination %q", value)
+	}
+	low, high, isRange := strings.Cut(ports, "-")
+	if !isRange {
+		high = low
+	}
+	var err error
+	if dest.lowPort, err = parseEgressPort(low); err == nil {
+		dest.highPort, err = parseEgressPort(high)
+	}
+	if err != nil || dest.highPort < dest.lowPort {
+		return dest, fmt.Errorf("invalid ports in egress destination %q", value)
+	}
+	return dest, nil
+}
+
+func parseEgressPort(value string) (uint16, error) {
+	port, err := strconv.ParseUint(value, 10, 16)
+	if err == nil && port == 0 {
+		err = errors.New("port 0 is not a valid destination")
+	}
+	return uint16(port), err
+}
+
+func (d egressDestination) ports() string {
+	if d.lowPort == d.highPort {
+		return strconv.Itoa(int(d.lowPort))
+	}
+	return fmt.Sprintf("%d-%d", d.lowPort, d.highPort)
+}
+
+func (d egressDestination) String() string {
+	address := d.prefix.String()
+	if d.lowPort == 0 {
+		return address
+	}
+	if d.prefix.Addr().Is6() {
+		address = "[" + address + "]"
+	}
+	ports := d.ports()
+	if d.protocol != "" {
+		ports += "/" + d.protocol
+	}
+	return address + ":" + ports
+}
+
+// The nftables expression matching packets sent to the destination.
+func (d egressDestination) nftMatch() string {
+	family := "ip"
+	if d.prefix.Addr().Is6() {
+		family = "ip6"
+	}
+	match := fmt.Sprintf("%s daddr %s", family, d.prefix)
+	switch {
+	case d.lowPort == 0:
+	case d.protocol == "":
+		match += " meta l4proto { tcp, udp } th dport " + d.ports()
+	default:
+		match += fmt.Sprintf(" %s dport %s", d.protocol, d.ports())
+	}
+	return match
+}
+
+// Installs and removes the rules that limit what a challenge network's
+// bridge may forward to its allowlisted destinations.
+type egressFirewall interface {
+	allowEgress(bridge string, destinations []egressDestination) error
+	removeEgress(bridge string) error
+}
+
+// Applies egress rules with the nft command, which must run on the Docker
+// host with CAP_NET_ADMIN.
+type nftablesFirewall struct {
+	command string
+}
+
+func (f nftablesFirewall) allowEgress(bridge string, destinations []egressDestination) error {
+	return f.apply(nftAllowEgressScript(bridge, destinations))
+}
+
+func (f nftablesFirewall) removeEgress(bridge string) error {
+	return f.apply(nftRemoveEgressScript(bridge))
+}
+
+func (f nftablesFirewall) apply(script string) error {
+	cmd := exec.Command(f.command, "-f", "-")
+	cmd.Stdin = strings.NewReader(script)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf(
+			"could not update egress rules: %w: %s",
+			err,
+			strings.TrimSpace(string(output)),
+		)
+	}
+	return nil
+}
+
+func nftEgressChain(bridge string) string {
+	return "egress_" + strings.ReplaceAll(bridge, "-", "_")
+}
+
+// Every forwarded packet from an allowlisted bridge jumps through the
+// `bridges` map to the bridge's chain, which drops whatever its destinations
+// do not allow.  The chain runs just before Docker's own forwarding rules,
+// which still have to accept what it lets through.  The script is applied
+// as one transaction and may be reapplied.
+func nftAllowEgressScript(bridge string, destinations []egressDestination) string {
+	chain := nftEgressChain(bridge)
+	var script strings.Builder
+	fmt.Fprintf(&script, "add table %s\n", nftEgressTable)
+	fmt.Fprintf(&script, "add map %s bridges { type ifname : verdict; }\n", nftEgressTable)
+	fmt.Fprintf(
+		&script,
+		"add chain %s forward { type filter hook forward priority filter - 1; policy accept; }\n",
+		nftEgressTable,
+	)
+	fmt.Fprintf(&script, "flush chain %s forward\n", nftEgressTable)
+	fmt.Fprintf(&script, "add rule %s forward iifname vmap @bridges\n", nftEgressTable)
+	fmt.Fprintf(&script, "add chain %s %s\n", nftEgressTable, chain)
+	fmt.Fprintf(&script, "flush chain %s %s\n", nftEgressTable, chain)
+	// Replies to published ports and traffic between the network's own
+	// containers are never egress.
+	fmt.Fprintf(&script, "add rule %s %s ct state established,related accept\n", nftEgressTable, chain)
+	fmt.Fprintf(&script, "add rule %s %s oifname %q accept\n", nftEgressTable, chain, bridge)
+	for _, dest := range destinations {
+		fmt.Fprintf(&script, "add rule %s %s %s accept\n", nftEgressTable, chain, dest.nftMatch())
+	}
+	fmt.Fprintf(&script, "add rule %s %s drop\n", nftEgressTable, chain)
+	fmt.Fprintf(&script, "add element %s bridges { %q : jump %s }\n", nftEgressTable, bridge, chain)
+	return script.String()
+}
+
+// Adding everything before deleting it lets the removal succeed whether or
+// not the rules still exist.
+func nftRemoveEgressScript(bridge string) string {
+	chain := nftEgressChain(bridge)
+	var script strings.Builder
+	fmt.Fprintf(&script, "add table %s\n", nftEgressTable)
+	fmt.Fprintf(&script, "add map %s bridges { type ifname : verdict; }\n", nftEgressTable)
+	fmt.Fprintf(&script, "add chain %s %s\n", nftEgressTable, chain)
+	fmt.Fprintf(&script, "add element %s bridges { %q : jump %s }\n", nftEgressTable, bridge, chain)
+	fmt.Fprintf(&script, "delete element %s bridges { %q }\n", nftEgressTable, bridge)
+	fmt.Fprintf(&script, "delete chain %s %s\n", nftEgressTable, chain)
+	return script.String()
+}
+
+// Restricts the egress of a newly created challenge network, whose bridge
+// has the network's name, to its allowlist.  The network is recorded first
+// so that the rules are removed with it even if installing them fails
+// partway.
+func (m *Manager) startEgressRules(network string, policy EgressPolicy) error {
+	destinations := make([]egressDestination, 0, len(policy.Destinations))
+	for _, value := range policy.Destinations {
+		dest, err := parseEgressDestination(value)
+		if err != nil {
+			return err
+		}
+		destinations = append(destinations, dest)
+	}
+	if m.firewall == nil {
+		return errors.New("egress allowlists require a firewall on the Docker host")
+	}
+	if _, err := m.db.Exec(
+		"INSERT OR IGNORE INTO egressRules(network) VALUES (?);",
+		network,
+	); err != nil {
+		return err
+	}
+	return m.firewall.allowEgress(network, destinations)
+}
+
+// Removes the egress rules of a challenge network, if it has any, once the
+// network itself is gone.
+func (m *Manager) stopEgressRules(network string) error {
+	var count int
+	if err := m.db.Get(
+		&count,
+		"SELECT COUNT(*) FROM egressRules WHERE network=?;",
+		network,
+	); err != nil || count == 0 {
+		return err
+	}
+	if m.firewall == nil {
+		return fmt.Errorf("cannot remove the egress rules of %s without a firewall", network)
+	}
+	if err := m.firewall.removeEgress(network); err != nil {
+		return err
+	}
+	_, err := m.db.Exec("DELETE FROM egressRules WHERE network=?;", network)
+	return err
+}
@@ -0,0 +1,236 @@
+package cmgr
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type fakeEgressFirewall struct {
+	allowed map[string][]egressDestination
+	removed []string
+	err     error
+}
+
+func (f *fakeEgressFirewall) allowEgress(bridge string, destinations []egressDestination) error {
+	if f.err != nil {
+		return f.err
+	}
+	if f.allowed == nil {
+		f.allowed = make(map[string][]egressDestination)
+	}
+	f.allowed[bridge] = destinations
+	return nil
+}
+
+func (f *fakeEgressFirewall) removeEgress(bridge string) error {
+	f.removed = append(f.removed, bridge)
+	delete(f.allowed, bridge)
+	return nil
+}
+
+func TestNormalizeEgressPolicy(t *testing.T) {
+	policy := EgressPolicy{Destinations: []string{
+		"10.0.5.10",
+		"10.0.5.77/24",
+		"10.0.5.10:8080/tcp",
+		"192.0.2.1:8000-8100",
+		"[2001:db8::1/64]:53/udp",
+		"2001:db8::10",
+	}}
+	if err := normalizeEgressPolicy(&policy); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"10.0.5.10/32",
+		"10.0.5.0/24",
+		"10.0.5.10/32:8080/tcp",
+		"192.0.2.1/32:8000-8100",
+		"[2001:db8::/64]:53/udp",
+		"2001:db8::10/128",
+	}
+	if !reflect.DeepEqual(policy.Destinations, want) {
+		t.Fatalf("normalized %v, expected %v", policy.Destinations, want)
+	}
+
+	for _, value := range []string{
+		"",
+		"example.com",
+		"10.0.5.10:0",
+		"10.0.5.10:8080/sctp",
+		"10.0.5.10:9000-8000",
+		"10.0.5.10:http",
+		"[2001:db8::1",
+		"[2001:db8::1]53",
+		"fe80::1%eth0",
+		"::ffff:10.0.5.0/120",
+	} {
+		policy := EgressPolicy{Destinations: []string{value}}
+		if err := normalizeEgressPolicy(&policy); err == nil {
+			t.Errorf("accepted egress destination %q", value)
+		}
+	}
+
+	duplicate := EgressPolicy{Destinations: []string{"10.0.5.10", "10.0.5.10/32"}}
+	if err := normalizeEgressPolicy(&duplicate); err == nil {
+		t.Error("accepted duplicate egress destinations")
+	}
+}
+
+func TestEgressPolicyDecodesBooleansAndLists(t *testing.T) {
+	for _, test := range []struct {
+		yaml string
+		want EgressPolicy
+	}{
+		{"allow_egress: true", EgressPolicy{All: true}},
+		{"allow_egress: false", EgressPolicy{}},
+		{
+			"allow_egress:\n  - 10.0.5.10:8080",
+			EgressPolicy{Destinations: []string{"10.0.5.10:8080"}},
+		},
+	} {
+		opts, err := decodeChallengeOptions(test.yaml)
+		if err != nil {
+			t.Fatalf("could not decode %q: %s", test.yaml, err)
+		}
+		if !reflect.DeepEqual(opts.AllowEgress, test.want) {
+			t.Fatalf("decoded %q as %#v, expected %#v", test.yaml, opts.AllowEgress, test.want)
+		}
+
+		encoded, err := json.Marshal(opts.NetworkOptions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded NetworkOptions
+		if err := json.Unmarshal(encoded, &decoded); err != nil {
+			t.Fatalf("could not decode %s: %s", encoded, err)
+		}
+		if !reflect.DeepEqual(decoded.AllowEgress, test.want) {
+			t.Fatalf("JSON %s decoded as %#v, expected %#v", encoded, decoded.AllowEgress, test.want)
+		}
+	}
+
+	encoded, err := json.Marshal(NetworkOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(encoded) != "{}" {
+		t.Fatalf("default egress policy was encoded: %s", encoded)
+	}
+}
+
+func TestNftAllowEgressScript(t *testing.T) {
+	var destinations []egressDestination
+	for _, value := range []string{"10.0.5.0/24", "10.0.5.10:8080/tcp", "[2001:db8::/64]:8000-8100"} {
+		dest, err := parseEgressDestination(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		destinations = append(destinations, dest)
+	}
+	script := nftAllowEgressScript("cmgr-7", destinations)
+	for _, line := range []string{
+		"add rule inet cmgr_egress forward iifname vmap @bridges\n",
+		"flush chain inet cmgr_egress egress_cmgr_7\n",
+		"add rule inet cmgr_egress egress_cmgr_7 ct state established,related accept\n",
+		"add rule inet cmgr_egress egress_cmgr_7 oifname \"cmgr-7\" accept\n",
+		"add rule inet cmgr_egress egress_cmgr_7 ip daddr 10.0.5.0/24 accept\n",
+		"add rule inet cmgr_egress egress_cmgr_7 ip daddr 10.0.5.10/32 tcp dport 8080 accept\n",
+		"add rule inet cmgr_egress egress_cmgr_7 ip6 daddr 2001:db8::/64 meta l4proto { tcp, udp } th dport 8000-8100 accept\n",
+		"add rule inet cmgr_egress egress_cmgr_7 drop\n",
+		"add element inet cmgr_egress bridges { \"cmgr-7\" : jump egress_cmgr_7 }\n",
+	} {
+		if !strings.Contains(script, line) {
+			t.Errorf("egress script is missing %q:\n%s", line, script)
+		}
+	}
+	if strings.Index(script, "accept\n") > strings.Index(script, "drop\n") {
+		t.Fatalf("egress script drops before accepting:\n%s", script)
+	}
+
+	removal := nftRemoveEgressScript("cmgr-7")
+	if !strings.HasSuffix(
+		removal,
+		"delete element inet cmgr_egress bridges { \"cmgr-7\" }\n"+
+			"delete chain inet cmgr_egress egress_cmgr_7\n",
+	) {
+		t.Fatalf("egress rules are not removed:\n%s", removal)
+	}
+}
+
+func TestChallengeNetworkNamesBridgeForEgressAllowlist(t *testing.T) {
+	options := challengeNetworkCreateOptions(
+		"cmgr-7",
+		NetworkOptions{AllowEgress: EgressPolicy{Destinations: []string{"10.0.5.0/24"}}},
+		false,
+	)
+	if options.Options[bridgeNameOption] != "cmgr-7" {
+		t.Fatalf("allowlisted network bridge is not named: %v", options.Options)
+	}
+	if _, disabled := options.Options["com.docker.network.bridge.enable_ip_masquerade"]; disabled {
+		t.Fatal("allowlisted network cannot reach its destinations")
+	}
+}
+
+func TestEgressRulesFollowChallengeNetwork(t *testing.T) {
+	manager := newSchemaTestManager(t)
+	manager.ctx = t.Context()
+	manager.cli = newDockerTestClient(t, func(request *http.Request) (*http.Response, error) {
+		if request.Method == http.MethodPost {
+			return dockerTestResponse(request, http.StatusCreated, `{"Id":"network"}`)
+		}
+		return dockerTestResponse(request, http.StatusNoContent, "")
+	})
+	firewall := &fakeEgressFirewall{}
+	manager.firewall = firewall
+
+	instance := &InstanceMetadata{Id: 7}
+	opts := NetworkOptions{AllowEgress: EgressPolicy{Destinations: []string{"10.0.5.10/32:8080/tcp"}}}
+	if err := manager.startNetwork(instance, opts); err != nil {
+		t.Fatal(err)
+	}
+	allowed := firewall.allowed["cmgr-7"]
+	if len(allowed) != 1 || allowed[0].String() != "10.0.5.10/32:8080/tcp" {
+		t.Fatalf("unexpected egress rules: %v", firewall.allowed)
+	}
+	requireRowCount(t, manager.db, "egressRules", 1)
+
+	if err := manager.stopNetwork(instance); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(firewall.removed, []string{"cmgr-7"}) {
+		t.Fatalf("egress rules were not removed with the network: %v", firewall.removed)
+	}
+	requireRowCount(t, manager.db, "egressRules", 0)
+
+	// Networks without an allowlist never touch the firewall.
+	if err := manager.startNetwork(instance, NetworkOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.stopNetwork(instance); err != nil {
+		t.Fatal(err)
+	}
+	if len(firewall.removed) != 1 {
+		t.Fatalf("firewall was used without an allowlist: %v", firewall.removed)
+	}
+}
+
+func TestEgressRuleFailureFailsNetworkStart(t *testing.T) {
+	manager := newSchemaTestManager(t)
+	manager.ctx = t.Context()
+	manager.cli = newDockerTestClient(t, func(request *http.Request) (*http.Response, error) {
+		return dockerTestResponse(request, http.StatusCreated, `{"Id":"network"}`)
+	})
+	manager.firewall = &fakeEgressFirewall{err: errors.New("nft failed")}
+
+	instance := &InstanceMetadata{Id: 7}
+	opts := NetworkOptions{AllowEgress: EgressPolicy{Destinations: []string{"10.0.5.0/24"}}}
+	if err := manager.startNetwork(instance, opts); err == nil {
+		t.Fatal("network started without its egress rules")
+	}
+	// The network is still recorded so that stopping it retries the removal.
+	requireRowCount(t, manager.db, "egressRules", 1)
+}
@@ -62,6 +62,32 @@ func unknownSchemaIdError(id string) error {
 	return &UnknownIdentifierError{Type: "schema", Name: id}
 }
 
+func unknownJobIdError(id JobId) error {
+	return &UnknownIdentifierError{Type: "job", Name: strconv.FormatInt(int64(id), 10)}
+}
+
+func unknownSchemaChallengeError(schema string, challenge ChallengeId) error {
+	return &UnknownIdentifierError{
+		Type: "schema challenge",
+		Name: fmt.Sprintf("%s/%s", schema, challenge),
+	}
+}
+
+func unknownAssignmentError(schema string, challenge ChallengeId, user string) error {
+	return &UnknownIdentifierError{
+		Type: "assignment",
+		Name: fmt.Sprintf("%s/%s/%s", schema, challenge, user),
+	}
+}
+
+func unknownProxyHostError(host string) error {
+	return &UnknownIdentifierError{Type: "proxied host", Name: host}
+}
+
+func unknownGatewayHostError(host string) error {
+	return &UnknownIdentifierError{Type: "gateway host", Name: host}
+}
+
 func (e *UnknownIdentifierError) Error() string {
 	return fmt.Sprintf("unknown %s identifier: %s", e.Type, e.Name)
 }
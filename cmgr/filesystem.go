@@ -75,6 +75,33 @@ func (m *Manager) setDirectories() error {
 		return errors.New(m.artifactsDir + " is not a directory")
 	}
 
+	// The log directory is created on first use so that commands which never
+	// build or check anything leave nothing behind.
+	logDir, isSet := os.LookupEnv(LOG_DIR_ENV)
+	if !isSet {
+		logDir = filepath.Join(m.artifactsDir, "logs")
+	}
+
+	m.logDir, err = filepath.Abs(logDir)
+	if err != nil {
+		m.log.errorf("could not resolve log directory: %s", err)
+		return err
+	}
+
+	m.log.infof("log directory: %s", m.logDir)
+
+	// Packet captures are written by a container, so the directory must be
+	// on the Docker host.
+	captureDir, isSet := os.LookupEnv(CAPTURE_DIR_ENV)
+	if !isSet {
+		captureDir = filepath.Join(m.artifactsDir, "captures")
+	}
+	m.captureDir, err = filepath.Abs(captureDir)
+	if err != nil {
+		m.log.errorf("could not resolve capture directory: %s", err)
+		return err
+	}
+
 	return nil
 }
 
@@ -0,0 +1,160 @@
+package cmgr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/client"
+)
+
+// A container that Docker has restarted at least crashLoopRestarts times is
+// crash looping while it waits to be restarted again or until it has stayed
+// up for crashLoopWindow since its last start.
+const (
+	crashLoopRestarts = 3
+	crashLoopWindow   = time.Minute
+)
+
+// Docker rejects health check durations shorter than a millisecond; zero
+// selects its default.
+const minHealthCheckDuration = time.Millisecond
+
+func (opts *HealthCheckOptions) validate() error {
+	if strings.TrimSpace(opts.Command) == "" {
+		return errors.New("command cannot be empty")
+	}
+	for _, duration := range []struct {
+		name  string
+		value string
+	}{
+		{"interval", opts.Interval},
+		{"timeout", opts.Timeout},
+		{"start_period", opts.StartPeriod},
+	} {
+		if _, err := parseHealthCheckDuration(duration.name, duration.value); err != nil {
+			return err
+		}
+	}
+	if opts.Retries < 0 {
+		return fmt.Errorf("retries cannot be negative: %d", opts.Retries)
+	}
+	return nil
+}
+
+func parseHealthCheckDuration(name string, value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %v", name, err)
+	}
+	if duration < minHealthCheckDuration {
+		return 0, fmt.Errorf("%s must be at least %s: %s", name, minHealthCheckDuration, value)
+	}
+	return duration, nil
+}
+
+// Converts the options into Docker's form.  The command is run with the
+// container's shell, like the shell form of a Dockerfile HEALTHCHECK.
+func (opts *HealthCheckOptions) healthConfig() (*container.HealthConfig, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	config := &container.HealthConfig{
+		Test:    []string{"CMD-SHELL", opts.Command},
+		Retries: opts.Retries,
+	}
+	config.Interval, _ = parseHealthCheckDuration("interval", opts.Interval)
+	config.Timeout, _ = parseHealthCheckDuration("timeout", opts.Timeout)
+	config.StartPeriod, _ = parseHealthCheckDuration("start_period", opts.StartPeriod)
+	return config, nil
+}
+
+func marshalHealthCheckOptions(options *HealthCheckOptions) (string, error) {
+	if options == nil {
+		return "", nil
+	}
+	data, err := json.Marshal(options)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func unmarshalHealthCheckOptions(data string) (*HealthCheckOptions, error) {
+	if data == "" {
+		return nil, nil
+	}
+	options := new(HealthCheckOptions)
+	if err := json.Unmarshal([]byte(data), options); err != nil {
+		return nil, err
+	}
+	if err := options.validate(); err != nil {
+		return nil, fmt.Errorf("invalid persisted health check: %v", err)
+	}
+	return options, nil
+}
+
+// Orders health statuses from best to worst so that an instance reports the
+// least healthy of its containers.
+var healthSeverity = map[string]int{
+	"":                          0,
+	string(container.Healthy):   1,
+	string(container.Starting):  2,
+	string(container.Unhealthy): 3,
+}
+
+// Fills in the runtime state that Docker reports for the instance's
+// containers.  A container that cannot be inspected is logged and skipped so
+// that metadata stays readable while Docker is unavailable.  The containers
+// of a paused instance are stopped, so only their restart counts are kept.
+func (m *Manager) inspectInstanceRuntime(iMeta *InstanceMetadata) {
+	now := time.Now()
+	for _, containerID := range iMeta.Containers {
+		result, err := m.cli.ContainerInspect(
+			m.ctx,
+			containerID,
+			client.ContainerInspectOptions{},
+		)
+		if err != nil {
+			m.log.warnf(
+				"could not inspect container %s of instance %d: %v",
+				containerID,
+				iMeta.Id,
+				err,
+			)
+			continue
+		}
+		inspection := result.Container
+		iMeta.RestartCount += inspection.RestartCount
+		if iMeta.Paused || inspection.State == nil {
+			continue
+		}
+		if containerCrashLooping(inspection, now) {
+			iMeta.CrashLooping = true
+		}
+		if inspection.State.Health != nil {
+			status := string(inspection.State.Health.Status)
+			if healthSeverity[status] > healthSeverity[iMeta.Health] {
+				iMeta.Health = status
+			}
+		}
+	}
+}
+
+func containerCrashLooping(inspection container.InspectResponse, now time.Time) bool {
+	state := inspection.State
+	if state == nil || inspection.RestartCount < crashLoopRestarts {
+		return false
+	}
+	if state.Restarting {
+		return true
+	}
+	started, err := time.Parse(time.RFC3339Nano, state.StartedAt)
+	return err == nil && state.Running && now.Sub(started) < crashLoopWindow
+}
@@ -0,0 +1,201 @@
+package cmgr
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moby/moby/api/types/container"
+	"go.yaml.in/yaml/v3"
+)
+
+func TestHealthCheckOptionsYAMLAndDockerConfig(t *testing.T) {
+	var options ChallengeOptions
+	err := yaml.Unmarshal([]byte(`
+healthcheck:
+    command: nc -z localhost 5000
+    interval: 10s
+    timeout: 2s
+    start_period: 1m
+    retries: 4
+`), &options)
+	if err != nil {
+		t.Fatalf("failed to decode health check options: %s", err)
+	}
+	if options.HealthCheck == nil {
+		t.Fatal("health check options were not decoded")
+	}
+
+	config, err := options.HealthCheck.healthConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &container.HealthConfig{
+		Test:        []string{"CMD-SHELL", "nc -z localhost 5000"},
+		Interval:    10 * time.Second,
+		Timeout:     2 * time.Second,
+		StartPeriod: time.Minute,
+		Retries:     4,
+	}
+	if !reflect.DeepEqual(config, expected) {
+		t.Fatalf("unexpected Docker health check: %#v", config)
+	}
+}
+
+func TestHealthCheckOptionsValidation(t *testing.T) {
+	tests := map[string]HealthCheckOptions{
+		"empty command":    {Command: " "},
+		"invalid interval": {Command: "true", Interval: "often"},
+		"tiny timeout":     {Command: "true", Timeout: "1us"},
+		"negative period":  {Command: "true", StartPeriod: "-1s"},
+		"negative retries": {Command: "true", Retries: -1},
+	}
+	for name, options := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := options.validate(); err == nil {
+				t.Fatal("invalid health check was accepted")
+			}
+		})
+	}
+
+	valid := HealthCheckOptions{Command: "true"}
+	if err := valid.validate(); err != nil {
+		t.Fatalf("health check with Docker defaults was rejected: %s", err)
+	}
+}
+
+func TestHealthCheckOptionsPersistWithContainerOptions(t *testing.T) {
+	options := ContainerOptions{HealthCheck: &HealthCheckOptions{
+		Command:  "true",
+		Interval: "5s",
+		Retries:  2,
+	}}
+	dbOpts, err := options.toDbContainerOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := newFromDbContainerOptions(dbOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(restored.HealthCheck, options.HealthCheck) {
+		t.Fatalf("health check changed in the database: %#v", restored.HealthCheck)
+	}
+
+	dbOpts, err = ContainerOptions{}.toDbContainerOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dbOpts.HealthCheck != "" {
+		t.Fatalf("missing health check was stored as %q", dbOpts.HealthCheck)
+	}
+}
+
+func TestContainerCrashLooping(t *testing.T) {
+	now := time.Now()
+	inspection := func(restarts int, state container.State) container.InspectResponse {
+		return container.InspectResponse{RestartCount: restarts, State: &state}
+	}
+	tests := []struct {
+		name       string
+		inspection container.InspectResponse
+		looping    bool
+	}{
+		{
+			name:       "waiting to restart",
+			inspection: inspection(crashLoopRestarts, container.State{Restarting: true}),
+			looping:    true,
+		},
+		{
+			name: "restarted moments ago",
+			inspection: inspection(crashLoopRestarts, container.State{
+				Running:   true,
+				StartedAt: now.Add(-time.Second).Format(time.RFC3339Nano),
+			}),
+			looping: true,
+		},
+		{
+			name: "stable since its last restart",
+			inspection: inspection(crashLoopRestarts, container.State{
+				Running:   true,
+				StartedAt: now.Add(-2 * crashLoopWindow).Format(time.RFC3339Nano),
+			}),
+		},
+		{
+			name:       "few restarts",
+			inspection: inspection(crashLoopRestarts-1, container.State{Restarting: true}),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if looping := containerCrashLooping(test.inspection, now); looping != test.looping {
+				t.Fatalf("crash looping is %t, expected %t", looping, test.looping)
+			}
+		})
+	}
+}
+
+func TestInspectInstanceRuntimeReportsContainerHealth(t *testing.T) {
+	manager := newDynamicInstanceTestManager(t)
+	requireExec(t, manager.db, "INSERT INTO containers(instance, id) VALUES (1, 'looping');")
+	requireExec(t, manager.db, "INSERT INTO containers(instance, id) VALUES (1, 'missing');")
+
+	manager.ctx = t.Context()
+	inspections := 0
+	manager.cli = newDockerTestClient(t, func(
+		request *http.Request,
+	) (*http.Response, error) {
+		if strings.HasSuffix(request.URL.Path, "/json") {
+			inspections++
+		}
+		switch {
+		case strings.HasSuffix(request.URL.Path, "/containers/container/json"):
+			return dockerTestResponse(request, http.StatusOK, `{
+				"Id": "container",
+				"RestartCount": 1,
+				"State": {"Running": true, "Health": {"Status": "healthy"}}
+			}`)
+		case strings.HasSuffix(request.URL.Path, "/containers/looping/json"):
+			return dockerTestResponse(request, http.StatusOK, `{
+				"Id": "looping",
+				"RestartCount": 5,
+				"State": {"Restarting": true, "Health": {"Status": "unhealthy"}}
+			}`)
+		default:
+			return dockerTestResponse(
+				request,
+				http.StatusNotFound,
+				`{"message":"no such container"}`,
+			)
+		}
+	})
+
+	meta, err := manager.GetInstanceMetadata(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inspections != 0 || meta.Health != "" || meta.RestartCount != 0 {
+		t.Fatalf("reading metadata inspected %d containers", inspections)
+	}
+
+	manager.InspectInstanceRuntime(meta)
+	if meta.Health != "unhealthy" || meta.RestartCount != 6 || !meta.CrashLooping {
+		t.Fatalf(
+			"unexpected runtime state: health=%q restarts=%d crash looping=%t",
+			meta.Health,
+			meta.RestartCount,
+			meta.CrashLooping,
+		)
+	}
+
+	requireExec(t, manager.db, "UPDATE instances SET paused=1 WHERE id=1;")
+	if meta, err = manager.GetInstanceMetadata(1); err != nil {
+		t.Fatal(err)
+	}
+	manager.InspectInstanceRuntime(meta)
+	if meta.Health != "" || meta.CrashLooping || meta.RestartCount != 6 {
+		t.Fatalf("paused instance reported stale health: %#v", meta)
+	}
+}
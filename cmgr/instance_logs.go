@@ -0,0 +1,226 @@
+package cmgr
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/moby/moby/api/pkg/stdcopy"
+	"github.com/moby/moby/client"
+)
+
+// Streams the output that Docker recorded for the instance's containers.
+// When host is empty, every container is read and each line is prefixed with
+// the name of its host; otherwise only that host's container is read.  A
+// positive tail limits each container to its last lines, and a non-zero since
+// skips earlier output.  Without follow, containers are read one after
+// another and the stream ends with their current output.  With follow, their
+// output is interleaved as it arrives until ctx is cancelled or the
+// containers exit.  At most `CMGR_MAX_CONTAINER_LOG_BYTES` are returned.
+func (m *Manager) InstanceLogs(
+	ctx context.Context,
+	instance InstanceId,
+	host string,
+	tail int,
+	since time.Time,
+	follow bool,
+) (io.ReadCloser, error) {
+	if tail < 0 {
+		return nil, invalidInput(fmt.Errorf("tail cannot be negative: %d", tail))
+	}
+	iMeta, err := m.lookupInstanceMetadata(instance)
+	if err != nil {
+		return nil, err
+	}
+	containers, err := m.instanceContainerHosts(iMeta, host)
+	if err != nil {
+		return nil, err
+	}
+
+	options := client.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     follow,
+	}
+	if tail > 0 {
+		options.Tail = strconv.Itoa(tail)
+	}
+	if !since.IsZero() {
+		options.Since = since.Format(time.RFC3339Nano)
+	}
+
+	limit := m.policy.MaxContainerLogBytes
+	if limit == 0 {
+		limit = 1024 * 1024
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	reader, writer := io.Pipe()
+	output := &instanceLogWriter{
+		pipe:      writer,
+		remaining: limit,
+		limit:     limit,
+		cancel:    cancel,
+	}
+	go func() {
+		var readers sync.WaitGroup
+		errs := make([]error, len(containers))
+		for i, container := range containers {
+			prefix := ""
+			if host == "" {
+				prefix = container.host + " | "
+			}
+			read := func() {
+				errs[i] = m.copyContainerLog(ctx, container, options, prefix, output)
+			}
+			if follow {
+				readers.Go(read)
+			} else {
+				read()
+			}
+		}
+		readers.Wait()
+		err := errors.Join(errs...)
+		if errors.Is(err, errLogLimitReached) || (follow && ctx.Err() != nil) {
+			err = nil
+		}
+		cancel()
+		writer.CloseWithError(err)
+	}()
+	return &instanceLogReader{PipeReader: reader, cancel: cancel}, nil
+}
+
+type instanceContainer struct {
+	id   string
+	host string
+	tty  bool
+}
+
+// Finds the host that each of the instance's containers runs, which is also
+// the container's hostname.  Only the container for the host is returned when
+// one is named.
+func (m *Manager) instanceContainerHosts(
+	iMeta *InstanceMetadata,
+	host string,
+) ([]instanceContainer, error) {
+	containers := []instanceContainer{}
+	for _, containerID := range iMeta.Containers {
+		result, err := m.cli.ContainerInspect(
+			m.ctx,
+			containerID,
+			client.ContainerInspectOptions{},
+		)
+		if err != nil {
+			return nil, fmt.Errorf("could not inspect container %s: %w", containerID, err)
+		}
+		container := instanceContainer{id: containerID}
+		if config := result.Container.Config; config != nil {
+			container.host = config.Hostname
+			container.tty = config.Tty
+		}
+		if host == "" || strings.EqualFold(host, container.host) {
+			containers = append(containers, container)
+		}
+	}
+	if host != "" && len(containers) == 0 {
+		return nil, &UnknownIdentifierError{
+			Type: "instance host",
+			Name: fmt.Sprintf("%d/%s", iMeta.Id, host),
+		}
+	}
+	return containers, nil
+}
+
+func (m *Manager) copyContainerLog(
+	ctx context.Context,
+	container instanceContainer,
+	options client.ContainerLogsOptions,
+	prefix string,
+	output *instanceLogWriter,
+) error {
+	logs, err := m.cli.ContainerLogs(ctx, container.id, options)
+	if err != nil {
+		return fmt.Errorf("could not read logs of container %s: %w", container.id, err)
+	}
+	defer logs.Close()
+
+	// Docker multiplexes stdout and stderr unless the container has a TTY.
+	var stream io.Reader = logs
+	if !container.tty {
+		demuxReader, demuxWriter := io.Pipe()
+		go func() {
+			_, err := stdcopy.StdCopy(demuxWriter, demuxWriter, logs)
+			demuxWriter.CloseWithError(err)
+		}()
+		defer demuxReader.Close()
+		stream = demuxReader
+	}
+
+	lines := bufio.NewReader(stream)
+	for {
+		line, err := lines.ReadString('\n')
+		if line != "" {
+			if !strings.HasSuffix(line, "\n") {
+				line += "\n"
+			}
+			if writeErr := output.writeLine(prefix + line); writeErr != nil {
+				return writeErr
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("could not read logs of container %s: %w", container.id, err)
+		}
+	}
+}
+
+var errLogLimitReached = errors.New("log limit reached")
+
+// Writes whole lines from each container to the stream so that lines of
+// followed containers do not interleave, stopping every reader once the
+// limit is reached.
+type instanceLogWriter struct {
+	lock      sync.Mutex
+	pipe      *io.PipeWriter
+	remaining int64
+	limit     int64
+	cancel    context.CancelFunc
+}
+
+func (w *instanceLogWriter) writeLine(line string) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.remaining <= 0 {
+		return errLogLimitReached
+	}
+	if int64(len(line)) <= w.remaining {
+		w.remaining -= int64(len(line))
+		_, err := io.WriteString(w.pipe, line)
+		return err
+	}
+	line = line[:w.remaining]
+	w.remaining = 0
+	_, err := io.WriteString(w.pipe, line+fmt.Sprintf(logTruncatedNote, w.limit))
+	w.cancel()
+	if err != nil {
+		return err
+	}
+	return errLogLimitReached
+}
+
+type instanceLogReader struct {
+	*io.PipeReader
+	cancel context.CancelFunc
+}
+
+func (r *instanceLogReader) Close() error {
+	r.cancel()
+	return r.PipeReader.Close()
+}
@@ -0,0 +1,157 @@
+package cmgr
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Frames the output the way Docker multiplexes the logs of a container
+// without a TTY.
+func multiplexedLog(frames ...string) string {
+	var stream strings.Builder
+	for i, frame := range frames {
+		header := make([]byte, 8)
+		header[0] = byte(1 + i%2) // alternate between stdout and stderr
+		binary.BigEndian.PutUint32(header[4:], uint32(len(frame)))
+		stream.Write(header)
+		stream.WriteString(frame)
+	}
+	return stream.String()
+}
+
+func newInstanceLogTestManager(t *testing.T) (*Manager, *[]string) {
+	t.Helper()
+	manager := newDynamicInstanceTestManager(t)
+	requireExec(t, manager.db, "INSERT INTO containers(instance, id) VALUES (1, 'db');")
+
+	queries := []string{}
+	manager.ctx = t.Context()
+	manager.cli = newDockerTestClient(t, func(
+		request *http.Request,
+	) (*http.Response, error) {
+		switch {
+		case strings.HasSuffix(request.URL.Path, "/containers/container/json"):
+			return dockerTestResponse(request, http.StatusOK, `{
+				"Id": "container",
+				"Config": {"Hostname": "web"}
+			}`)
+		case strings.HasSuffix(request.URL.Path, "/containers/db/json"):
+			return dockerTestResponse(request, http.StatusOK, `{
+				"Id": "db",
+				"Config": {"Hostname": "db", "Tty": true}
+			}`)
+		case strings.HasSuffix(request.URL.Path, "/containers/container/logs"):
+			queries = append(queries, request.URL.RawQuery)
+			return dockerTestResponse(
+				request,
+				http.StatusOK,
+				multiplexedLog("listening\n", "warning: ", "slow request\n"),
+			)
+		case strings.HasSuffix(request.URL.Path, "/containers/db/logs"):
+			queries = append(queries, request.URL.RawQuery)
+			return dockerTestResponse(request, http.StatusOK, "ready\r\nno newline")
+		default:
+			return dockerTestResponse(
+				request,
+				http.StatusNotFound,
+				`{"message":"no such container"}`,
+			)
+		}
+	})
+	return manager, &queries
+}
+
+func readInstanceLogs(
+	t *testing.T,
+	manager *Manager,
+	host string,
+	tail int,
+	since time.Time,
+) string {
+	t.Helper()
+	output, err := manager.InstanceLogs(t.Context(), 1, host, tail, since, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer output.Close()
+	data, err := io.ReadAll(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestInstanceLogsReadsEachHost(t *testing.T) {
+	manager, queries := newInstanceLogTestManager(t)
+
+	since := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	logs := readInstanceLogs(t, manager, "", 20, since)
+	expected := "web | listening\n" +
+		"web | warning: slow request\n" +
+		"db | ready\r\n" +
+		"db | no newline\n"
+	if logs != expected {
+		t.Fatalf("unexpected logs %q", logs)
+	}
+	for _, query := range *queries {
+		if !strings.Contains(query, "tail=20") ||
+			!strings.Contains(query, "since=1714564800.") ||
+			!strings.Contains(query, "stdout=1") ||
+			!strings.Contains(query, "stderr=1") ||
+			strings.Contains(query, "follow=1") {
+			t.Fatalf("unexpected log query %q", query)
+		}
+	}
+
+	if logs := readInstanceLogs(t, manager, "DB", 0, time.Time{}); logs != "ready\r\nno newline\n" {
+		t.Fatalf("unexpected logs of one host %q", logs)
+	}
+	if query := (*queries)[len(*queries)-1]; strings.Contains(query, "tail=") ||
+		strings.Contains(query, "since=") {
+		t.Fatalf("unexpected log query %q", query)
+	}
+
+	output, err := manager.InstanceLogs(t.Context(), 1, "db", 0, time.Time{}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer output.Close()
+	if data, err := io.ReadAll(output); err != nil || string(data) != "ready\r\nno newline\n" {
+		t.Fatalf("unexpected followed logs %q: %v", data, err)
+	}
+	if query := (*queries)[len(*queries)-1]; !strings.Contains(query, "follow=1") {
+		t.Fatalf("logs were not followed: %q", query)
+	}
+}
+
+func TestInstanceLogsAreBounded(t *testing.T) {
+	manager, _ := newInstanceLogTestManager(t)
+	manager.policy.MaxContainerLogBytes = 20
+
+	logs := readInstanceLogs(t, manager, "", 0, time.Time{})
+	expected := "web | listening\nweb " + fmt.Sprintf(logTruncatedNote, 20)
+	if logs != expected {
+		t.Fatalf("unexpected bounded logs %q", logs)
+	}
+}
+
+func TestInstanceLogsRejectUnknownHostsAndInstances(t *testing.T) {
+	manager, _ := newInstanceLogTestManager(t)
+	var unknown *UnknownIdentifierError
+	if _, err := manager.InstanceLogs(t.Context(), 1, "cache", 0, time.Time{}, false); !errors.As(err, &unknown) {
+		t.Fatalf("unknown host returned %v", err)
+	}
+	if _, err := manager.InstanceLogs(t.Context(), 2, "", 0, time.Time{}, false); !errors.As(err, &unknown) {
+		t.Fatalf("unknown instance returned %v", err)
+	}
+	var invalid *InvalidInputError
+	if _, err := manager.InstanceLogs(t.Context(), 1, "", -1, time.Time{}, false); !errors.As(err, &invalid) {
+		t.Fatalf("negative tail returned %v", err)
+	}
+}
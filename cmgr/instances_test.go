@@ -0,0 +1,321 @@
+package cmgr
+
+import (
+	"errors"
+	"net/http"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newDynamicInstanceTestManager(t *testing.T) *Manager {
+	t.Helper()
+	manager := newSchemaTestManager(t)
+	insertCompleteConstraintFixture(t, manager.db)
+	requireExec(t, manager.db, "UPDATE builds SET instancecount=? WHERE id=1;", DYNAMIC_INSTANCES)
+	return manager
+}
+
+func TestExtendInstanceSetsAndOnlyPushesBackExpiry(t *testing.T) {
+	manager := newDynamicInstanceTestManager(t)
+
+	expired, err := manager.ExpiredInstances()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expired) != 0 {
+		t.Fatalf("instance without a TTL expired: %v", expired)
+	}
+
+	before := time.Now().Unix()
+	if err := manager.ExtendInstance(1, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := manager.lookupInstanceMetadata(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Expires < before+3600 || meta.Expires > time.Now().Unix()+3600 {
+		t.Fatalf("unexpected expiry %d", meta.Expires)
+	}
+
+	extended := meta.Expires
+	if err := manager.ExtendInstance(1, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if meta, err = manager.lookupInstanceMetadata(1); err != nil {
+		t.Fatal(err)
+	}
+	if meta.Expires != extended {
+		t.Fatalf("a shorter TTL moved the expiry from %d to %d", extended, meta.Expires)
+	}
+}
+
+func TestExpiredInstancesListsOnlyPastExpiries(t *testing.T) {
+	manager := newDynamicInstanceTestManager(t)
+	insert := "INSERT INTO instances(id, lastsolved, build, expires) VALUES (?, 0, 1, ?);"
+	requireExec(t, manager.db, insert, 2, time.Now().Add(-time.Minute).Unix())
+	requireExec(t, manager.db, insert, 3, time.Now().Add(time.Hour).Unix())
+	requireExec(
+		t,
+		manager.db,
+		"UPDATE instances SET expires=? WHERE id=1;",
+		time.Now().Add(-time.Hour).Unix(),
+	)
+
+	expired, err := manager.ExpiredInstances()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expired, []InstanceId{1, 2}) {
+		t.Fatalf("unexpected expired instances %v", expired)
+	}
+}
+
+func TestInstanceTTLValidation(t *testing.T) {
+	manager := newDynamicInstanceTestManager(t)
+
+	var invalid *InvalidInputError
+	if _, err := manager.Start(1, 0); !errors.As(err, &invalid) {
+		t.Fatalf("zero TTL was not rejected: %v", err)
+	}
+	if _, err := manager.Start(1, time.Hour, time.Hour); !errors.As(err, &invalid) {
+		t.Fatalf("second TTL was not rejected: %v", err)
+	}
+	if err := manager.ExtendInstance(1, -time.Hour); !errors.As(err, &invalid) {
+		t.Fatalf("negative TTL was not rejected: %v", err)
+	}
+
+	var unknown *UnknownIdentifierError
+	if err := manager.ExtendInstance(2, time.Hour); !errors.As(err, &unknown) {
+		t.Fatalf("unknown instance was not reported: %v", err)
+	}
+
+	requireExec(t, manager.db, "UPDATE builds SET instancecount=1 WHERE id=1;")
+	var conflict *ConflictError
+	if err := manager.ExtendInstance(1, time.Hour); !errors.As(err, &conflict) {
+		t.Fatalf("schema-controlled instance was given an expiry: %v", err)
+	}
+}
+
+func newPauseTestManager(t *testing.T, stopStatus int) (*Manager, *[]string) {
+	t.Helper()
+	manager := newDynamicInstanceTestManager(t)
+	requests := []string{}
+	manager.ctx = t.Context()
+	manager.cli = newDockerTestClient(t, func(
+		request *http.Request,
+	) (*http.Response, error) {
+		if _, container, ok := strings.Cut(request.URL.Path, "/containers/"); ok {
+			requests = append(requests, request.Method+" "+container)
+		}
+		switch {
+		case request.Method == http.MethodPost &&
+			strings.HasSuffix(request.URL.Path, "/containers/container/stop"):
+			return dockerTestResponse(request, stopStatus, `{"message":"stop"}`)
+		case request.Method == http.MethodPost &&
+			strings.HasSuffix(request.URL.Path, "/containers/container/start"):
+			return dockerTestResponse(request, http.StatusNoContent, "")
+		case request.Method == http.MethodPost &&
+			strings.HasSuffix(request.URL.Path, "/containers/container/update"):
+			return dockerTestResponse(request, http.StatusOK, `{"Warnings":[]}`)
+		default:
+			return dockerTestResponse(
+				request,
+				http.StatusInternalServerError,
+				`{"message":"unexpected test request"}`,
+			)
+		}
+	})
+	return manager, &requests
+}
+
+func requireInstancePaused(t *testing.T, manager *Manager, want bool) {
+	t.Helper()
+	meta, err := manager.lookupInstanceMetadata(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Paused != want {
+		t.Fatalf("instance paused is %t, expected %t", meta.Paused, want)
+	}
+	if meta.Ports["http"] != 30000 || len(meta.Containers) != 1 {
+		t.Fatalf("paused instance lost its resources: %#v", meta)
+	}
+}
+
+func TestPauseAndResumeKeepInstanceResources(t *testing.T) {
+	manager, requests := newPauseTestManager(t, http.StatusNoContent)
+
+	if err := manager.Pause(1); err != nil {
+		t.Fatal(err)
+	}
+	requireInstancePaused(t, manager, true)
+
+	var conflict *ConflictError
+	if err := manager.Pause(1); !errors.As(err, &conflict) {
+		t.Fatalf("paused instance was paused again: %v", err)
+	}
+	if err := manager.CheckInstance(1); !errors.As(err, &conflict) {
+		t.Fatalf("paused instance was checked: %v", err)
+	}
+
+	if err := manager.Resume(1); err != nil {
+		t.Fatal(err)
+	}
+	requireInstancePaused(t, manager, false)
+	if err := manager.Resume(1); !errors.As(err, &conflict) {
+		t.Fatalf("running instance was resumed: %v", err)
+	}
+
+	want := []string{
+		"POST container/update",
+		"POST container/stop",
+		"POST container/update",
+		"POST container/start",
+	}
+	if !reflect.DeepEqual(*requests, want) {
+		t.Fatalf("unexpected Docker requests %v", *requests)
+	}
+}
+
+func TestPauseClearsStateWhenContainersCannotStop(t *testing.T) {
+	manager, _ := newPauseTestManager(t, http.StatusInternalServerError)
+
+	if err := manager.Pause(1); err == nil {
+		t.Fatal("pause succeeded although the container could not stop")
+	}
+	requireInstancePaused(t, manager, false)
+}
+
+func TestPauseRejectsLockedAndUnknownInstances(t *testing.T) {
+	manager, requests := newPauseTestManager(t, http.StatusNoContent)
+
+	var unknown *UnknownIdentifierError
+	if err := manager.Pause(2); !errors.As(err, &unknown) {
+		t.Fatalf("unknown instance was not reported: %v", err)
+	}
+
+	requireExec(t, manager.db, "UPDATE builds SET instancecount=1 WHERE id=1;")
+	var conflict *ConflictError
+	if err := manager.Pause(1); !errors.As(err, &conflict) {
+		t.Fatalf("schema-controlled instance was paused: %v", err)
+	}
+	if err := manager.Resume(1); !errors.As(err, &conflict) {
+		t.Fatalf("schema-controlled instance was resumed: %v", err)
+	}
+	if len(*requests) != 0 {
+		t.Fatalf("rejected requests reached Docker: %v", *requests)
+	}
+}
+
+func TestResetKeepsOldContainersWhenReplacementFails(t *testing.T) {
+	manager, requests := newPauseTestManager(t, http.StatusNoContent)
+	manager.challengeAddresses = []netip.Addr{netip.MustParseAddr("127.0.0.1")}
+	requireExec(t, manager.db, "DELETE FROM containerOptions;")
+
+	if err := manager.Reset(1); err == nil {
+		t.Fatal("reset succeeded although no replacement could be created")
+	}
+	requireInstancePaused(t, manager, false)
+	meta, err := manager.lookupInstanceMetadata(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(meta.Containers, []string{"container"}) {
+		t.Fatalf("reset replaced the instance's containers: %v", meta.Containers)
+	}
+	want := []string{
+		"POST container/update",
+		"POST container/stop",
+		"POST create",
+		"POST container/update",
+		"POST container/start",
+	}
+	if !reflect.DeepEqual(*requests, want) {
+		t.Fatalf("unexpected Docker requests %v", *requests)
+	}
+}
+
+func TestResetRejectsUnknownInstances(t *testing.T) {
+	manager, requests := newPauseTestManager(t, http.StatusNoContent)
+
+	var unknown *UnknownIdentifierError
+	if err := manager.Reset(2); !errors.As(err, &unknown) {
+		t.Fatalf("unknown instance was not reported: %v", err)
+	}
+	if len(*requests) != 0 {
+		t.Fatalf("rejected request reached Docker: %v", *requests)
+	}
+}
+
+func TestChallengeSolvabilitySummarizesLatestChecks(t *testing.T) {
+	manager := newDynamicInstanceTestManager(t)
+	requireExec(t, manager.db, "DELETE FROM containerOptions;")
+	requireExec(t, manager.db, "INSERT INTO instances(id, lastsolved, build) VALUES (2, 0, 1);")
+
+	requireSolvability := func(want SolvabilityStatus, lastChecked int64) *ChallengeSolvability {
+		t.Helper()
+		status, err := manager.GetChallengeSolvability("challenge")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.Status != want || status.LastChecked != lastChecked || len(status.Instances) != 2 {
+			t.Fatalf("unexpected solvability, expected %s at %d: %#v", want, lastChecked, status)
+		}
+		return status
+	}
+	requireCheckable := func(want ...InstanceId) {
+		t.Helper()
+		instances, err := manager.CheckableInstances()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(instances) != len(want) || (len(want) > 0 && !reflect.DeepEqual(instances, want)) {
+			t.Fatalf("checkable instances are %v, expected %v", instances, want)
+		}
+	}
+
+	requireSolvability(CHALLENGE_NO_SOLVER, 0)
+	requireCheckable()
+
+	requireExec(t, manager.db, "UPDATE challenges SET solvescript=1;")
+	requireSolvability(CHALLENGE_UNCHECKED, 0)
+	requireCheckable(1, 2)
+
+	if err := manager.recordCheck(1, 100, nil); err != nil {
+		t.Fatal(err)
+	}
+	requireSolvability(CHALLENGE_SOLVABLE, 100)
+
+	if err := manager.recordCheck(2, 200, errors.New("wrong flag")); err != nil {
+		t.Fatal(err)
+	}
+	status := requireSolvability(CHALLENGE_UNSOLVABLE, 200)
+	if status.Instances[1].LastCheckError != "wrong flag" {
+		t.Fatalf("check error was not recorded: %#v", status.Instances[1])
+	}
+	meta, err := manager.lookupInstanceMetadata(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.LastChecked != 200 || meta.LastCheckError != "wrong flag" {
+		t.Fatalf("instance metadata lost the check result: %#v", meta)
+	}
+
+	if err := manager.recordCheck(2, 300, nil); err != nil {
+		t.Fatal(err)
+	}
+	requireSolvability(CHALLENGE_SOLVABLE, 300)
+
+	requireExec(t, manager.db, "UPDATE instances SET paused=1 WHERE id=2;")
+	requireCheckable(1)
+
+	_, err = manager.GetChallengeSolvability("missing")
+	var unknown *UnknownIdentifierError
+	if !errors.As(err, &unknown) {
+		t.Fatalf("unknown challenge returned %v", err)
+	}
+}
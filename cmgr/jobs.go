@@ -0,0 +1,346 @@
+package cmgr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+const jobLeaseSuffix = ".jobs-"
+
+type buildJobRequest struct {
+	Challenge  ChallengeId `json:"challenge"`
+	Seeds      []int       `json:"seeds"`
+	FlagFormat string      `json:"flag_format"`
+}
+
+// A manager with unfinished jobs owns them through an exclusive lock on a
+// lease file next to the database.  The lock disappears with the process, so
+// another manager can tell that a job was abandoned rather than still queued
+// behind an operation lock.
+func (m *Manager) jobLeasePath(owner string) string {
+	if m.operationLockPath == "" {
+		return ""
+	}
+	return m.operationLockPath + jobLeaseSuffix + owner
+}
+
+func (m *Manager) initJobs() error {
+	owner, err := randomIdentifier()
+	if err != nil {
+		return err
+	}
+	m.jobOwner = owner
+	return nil
+}
+
+// Takes a reference on this manager's job lease, acquiring the lease file if
+// no job currently holds it.  The lease must be held before a job row naming
+// this owner is written.
+func (m *Manager) holdJobLease() error {
+	m.jobMu.Lock()
+	defer m.jobMu.Unlock()
+	if m.jobLeaseRefs == 0 {
+		if path := m.jobLeasePath(m.jobOwner); path != "" {
+			lease, acquired, err := acquireFileLock(path, "job lease", syscall.LOCK_EX, true)
+			if err != nil {
+				return err
+			}
+			if !acquired {
+				return fmt.Errorf("job lease %s is already held", path)
+			}
+			m.jobLease = lease
+		}
+	}
+	m.jobLeaseRefs++
+	return nil
+}
+
+// Drops a reference on the job lease.  The lease file is removed once this
+// manager has no unfinished jobs so that idle processes leave nothing behind.
+func (m *Manager) releaseJobLease() {
+	m.jobMu.Lock()
+	defer m.jobMu.Unlock()
+	m.jobLeaseRefs--
+	if m.jobLeaseRefs == 0 && m.jobLease != nil {
+		_ = os.Remove(m.jobLease.Name())
+		releaseFileLock(m.jobLease)
+		m.jobLease = nil
+	}
+}
+
+// Settles the unfinished jobs whose owning process has exited.  When resume
+// is set they are adopted and run again in the background; builds and schema
+// convergence are idempotent, so seeds completed before the interruption are
+// reused rather than rebuilt.  Otherwise they are marked failed, since only a
+// long-lived process such as cmgrd can see them through; a one-shot command
+// would exit in the middle of them.
+func (m *Manager) recoverJobs(resume bool) error {
+	owners, err := m.unfinishedJobOwners()
+	if err != nil {
+		return fmt.Errorf("could not list unfinished jobs: %w", err)
+	}
+	for _, owner := range owners {
+		var lease *os.File
+		path := m.jobLeasePath(owner)
+		if path != "" {
+			var acquired bool
+			lease, acquired, err = acquireFileLock(path, "job lease", syscall.LOCK_EX, true)
+			if err != nil {
+				m.log.warnf("could not inspect job owner %s: %v", owner, err)
+				continue
+			}
+			if !acquired {
+				continue
+			}
+		}
+
+		if !resume {
+			err := m.failAbandonedJobs(owner)
+			if lease != nil {
+				if err == nil {
+					_ = os.Remove(path)
+				}
+				releaseFileLock(lease)
+			}
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := m.holdJobLease(); err != nil {
+			releaseFileLock(lease)
+			return err
+		}
+		jobs, err := m.adoptJobs(owner)
+		if lease != nil {
+			if err == nil {
+				_ = os.Remove(path)
+			}
+			releaseFileLock(lease)
+		}
+		if err != nil {
+			m.releaseJobLease()
+			return err
+		}
+		for _, job := range jobs {
+			// The lease is already held, so taking another reference
+			// cannot fail.
+			_ = m.holdJobLease()
+			m.log.infof("resuming interrupted job %d", job)
+			m.startJob(job)
+		}
+		m.releaseJobLease()
+	}
+	return nil
+}
+
+// Runs the job in the background.  The caller must already hold a reference
+// on the job lease, which is released when the job finishes.
+func (m *Manager) startJob(job JobId) {
+	m.jobs.Add(1)
+	go func() {
+		defer m.jobs.Done()
+		defer m.releaseJobLease()
+		m.runJob(job)
+	}()
+}
+
+func (m *Manager) runJob(id JobId) {
+	job, request, err := m.lookupJobRequest(id)
+	if err != nil {
+		m.log.errorf("could not load job %d: %v", id, err)
+		return
+	}
+
+	progress := func(build *BuildMetadata, status JobSeedStatus, buildErr error) {
+		message := ""
+		if buildErr != nil {
+			message = buildErr.Error()
+		}
+		if err := m.setJobSeedStatus(job.Id, build, status, message); err != nil {
+			m.log.warnf("could not record progress of job %d: %v", job.Id, err)
+		}
+	}
+
+	var errs []error
+	switch job.Kind {
+	case BUILD_JOB:
+		var req buildJobRequest
+		if err := json.Unmarshal(request, &req); err != nil {
+			errs = append(errs, fmt.Errorf("could not decode build request: %w", err))
+			break
+		}
+		release, err := m.acquireOperationLock(false)
+		if err != nil {
+			errs = append(errs, err)
+			break
+		}
+		m.markJobRunning(job.Id)
+		_, err = m.buildInSchema(job.Schema, req.Challenge, req.Seeds, req.FlagFormat, progress)
+		release()
+		if err != nil {
+			errs = append(errs, err)
+		}
+	case CREATE_SCHEMA_JOB, UPDATE_SCHEMA_JOB:
+		var schema Schema
+		if err := json.Unmarshal(request, &schema); err != nil {
+			errs = append(errs, fmt.Errorf("could not decode schema request: %w", err))
+			break
+		}
+		release, err := m.acquireOperationLock(true)
+		if err != nil {
+			errs = append(errs, err)
+			break
+		}
+		m.markJobRunning(job.Id)
+		if job.Kind == CREATE_SCHEMA_JOB {
+			errs = m.convergeNewSchema(&schema, progress)
+		} else {
+			errs = m.convergeSchema(&schema, progress)
+		}
+		release()
+	default:
+		errs = append(errs, fmt.Errorf("unknown job kind %q", job.Kind))
+	}
+
+	if len(errs) == 0 {
+		m.log.infof("job %d succeeded", job.Id)
+		if err := m.setJobStatus(job.Id, JOB_SUCCEEDED, ""); err != nil {
+			m.log.error(err)
+		}
+		return
+	}
+
+	err = errors.Join(errs...)
+	m.log.errorf("job %d failed: %v", job.Id, err)
+	if seedErr := m.failInterruptedJobSeeds(job.Id, err.Error()); seedErr != nil {
+		m.log.error(seedErr)
+	}
+	if statusErr := m.setJobStatus(job.Id, JOB_FAILED, err.Error()); statusErr != nil {
+		m.log.error(statusErr)
+	}
+}
+
+func (m *Manager) markJobRunning(job JobId) {
+	if err := m.setJobStatus(job, JOB_RUNNING, ""); err != nil {
+		m.log.warnf("could not mark job %d running: %v", job, err)
+	}
+}
+
+// Queues a build of the challenge that runs in the background and returns
+// the identifier of the job tracking it.  The request is validated before it
+// is queued.  Builds are placed in a manual schema reserved by the job and
+// their identifiers are reported per seed once they complete.
+func (m *Manager) SubmitBuild(challenge ChallengeId, seeds []int, flagFormat string) (JobId, error) {
+	if err := m.validateBuildRequest(seeds, flagFormat); err != nil {
+		return 0, err
+	}
+	if _, err := m.lookupChallengeMetadata(challenge); err != nil {
+		return 0, err
+	}
+	randomSuffix, err := randomIdentifier()
+	if err != nil {
+		return 0, err
+	}
+	request, err := json.Marshal(buildJobRequest{
+		Challenge:  challenge,
+		Seeds:      seeds,
+		FlagFormat: flagFormat,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	keys := make([]jobSeedKey, len(seeds))
+	for i, seed := range seeds {
+		keys[i] = jobSeedKey{Challenge: challenge, Seed: seed}
+	}
+	job := &JobMetadata{Kind: BUILD_JOB, Schema: manualSchemaPrefix + randomSuffix}
+	if err := m.holdJobLease(); err != nil {
+		return 0, err
+	}
+	if err := m.openJob(job, request, keys); err != nil {
+		m.releaseJobLease()
+		return 0, err
+	}
+	m.startJob(job.Id)
+	return job.Id, nil
+}
+
+// Queues creation of the schema as a background job.  The schema name is
+// reserved immediately, under the exclusive operation lock like
+// `CreateSchema`, so a conflicting definition is rejected up front.
+func (m *Manager) SubmitCreateSchema(schema *Schema) (JobId, error) {
+	if err := m.validateSchemaRequest(schema); err != nil {
+		return 0, err
+	}
+	release, err := m.acquireOperationLock(true)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+	exists, err := m.schemaExists(schema.Name)
+	if err != nil {
+		return 0, err
+	} else if exists {
+		return 0, &ConflictError{Err: fmt.Errorf("schema '%s' already exists", schema.Name)}
+	}
+	if err := m.createSchemaRecord(schema.Name, false); err != nil {
+		return 0, err
+	}
+	job, err := m.submitSchemaJob(CREATE_SCHEMA_JOB, schema)
+	if err != nil {
+		if cleanupErr := m.deleteSchemaRecord(schema.Name); cleanupErr != nil {
+			err = errors.Join(err, cleanupErr)
+		}
+	}
+	return job, err
+}
+
+// Queues convergence of an existing schema to a new definition as a
+// background job.
+func (m *Manager) SubmitUpdateSchema(schema *Schema) (JobId, error) {
+	if err := m.validateSchemaRequest(schema); err != nil {
+		return 0, err
+	}
+	exists, err := m.schemaExists(schema.Name)
+	if err != nil {
+		return 0, err
+	} else if !exists {
+		return 0, unknownSchemaIdError(schema.Name)
+	}
+	return m.submitSchemaJob(UPDATE_SCHEMA_JOB, schema)
+}
+
+func (m *Manager) submitSchemaJob(kind JobKind, schema *Schema) (JobId, error) {
+	request, err := json.Marshal(schema)
+	if err != nil {
+		return 0, err
+	}
+	keys := []jobSeedKey{}
+	for challenge, spec := range schema.Challenges {
+		for _, seed := range spec.Seeds {
+			keys = append(keys, jobSeedKey{Challenge: challenge, Seed: seed})
+		}
+	}
+	job := &JobMetadata{Kind: kind, Schema: schema.Name}
+	if err := m.holdJobLease(); err != nil {
+		return 0, err
+	}
+	if err := m.openJob(job, request, keys); err != nil {
+		m.releaseJobLease()
+		return 0, err
+	}
+	m.startJob(job.Id)
+	return job.Id, nil
+}
+
+// Returns the status of the job along with the progress of every seed it
+// builds.
+func (m *Manager) GetJob(job JobId) (*JobMetadata, error) {
+	return m.lookupJobMetadata(job)
+}
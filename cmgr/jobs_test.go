@@ -0,0 +1,195 @@
+package cmgr
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func newJobTestManager(t *testing.T) *Manager {
+	t.Helper()
+	manager := newSchemaTestManager(t)
+	if err := manager.initOperationLock(); err != nil {
+		t.Fatal(err)
+	}
+	manager.jobOwner = "self"
+	return manager
+}
+
+func insertTestJob(t *testing.T, manager *Manager, owner string, status JobStatus) JobId {
+	t.Helper()
+	result, err := manager.db.Exec(
+		`INSERT INTO jobs(kind, status, owner, schema, request, created, updated)
+		 VALUES ('build', ?, ?, 'manual-job', ?, 0, 0);`,
+		status,
+		owner,
+		`{"challenge":"missing","seeds":[1],"flag_format":"flag{%s}"}`,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	requireExec(
+		t,
+		manager.db,
+		"INSERT INTO jobSeeds(job, challenge, seed, status) VALUES (?, 'missing', 1, 'building');",
+		id,
+	)
+	return JobId(id)
+}
+
+func TestRecoverJobsResumesOnlyAbandonedJobs(t *testing.T) {
+	manager := newJobTestManager(t)
+	abandoned := insertTestJob(t, manager, "departed", JOB_RUNNING)
+	active := insertTestJob(t, manager, "alive", JOB_QUEUED)
+
+	liveLease, acquired, err := acquireFileLock(
+		manager.jobLeasePath("alive"),
+		"job lease",
+		syscall.LOCK_EX,
+		true,
+	)
+	if err != nil || !acquired {
+		t.Fatalf("could not hold live lease: acquired=%v err=%v", acquired, err)
+	}
+	defer releaseFileLock(liveLease)
+
+	if err := manager.recoverJobs(true); err != nil {
+		t.Fatal(err)
+	}
+	manager.jobs.Wait()
+
+	job, err := manager.GetJob(abandoned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The resumed build fails because its challenge does not exist, which
+	// shows that it was actually rerun rather than left queued.
+	if job.Status != JOB_FAILED || job.Error == "" {
+		t.Fatalf("abandoned job was not resumed to completion: %#v", job)
+	}
+	if len(job.Seeds) != 1 || job.Seeds[0].Status != SEED_PENDING {
+		t.Fatalf("interrupted seed was not reset: %#v", job.Seeds)
+	}
+	var owner string
+	if err := manager.db.Get(&owner, "SELECT owner FROM jobs WHERE id=?;", abandoned); err != nil {
+		t.Fatal(err)
+	}
+	if owner != manager.jobOwner {
+		t.Fatalf("abandoned job is owned by %q", owner)
+	}
+	if _, err := os.Stat(manager.jobLeasePath("departed")); !os.IsNotExist(err) {
+		t.Fatalf("abandoned lease file was not removed: %v", err)
+	}
+	if _, err := os.Stat(manager.jobLeasePath(manager.jobOwner)); !os.IsNotExist(err) {
+		t.Fatalf("idle manager kept its lease file: %v", err)
+	}
+
+	job, err = manager.GetJob(active)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != JOB_QUEUED {
+		t.Fatalf("job of a live owner was adopted: %#v", job)
+	}
+}
+
+func TestSubmitBuildValidatesBeforeQueueing(t *testing.T) {
+	manager := newJobTestManager(t)
+
+	_, err := manager.SubmitBuild("challenge", nil, "flag{%s}")
+	var invalid *InvalidInputError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("empty seed list was not rejected as invalid input: %v", err)
+	}
+	_, err = manager.SubmitBuild("missing", []int{1}, "flag{%s}")
+	var unknown *UnknownIdentifierError
+	if !errors.As(err, &unknown) {
+		t.Fatalf("unknown challenge was not rejected: %v", err)
+	}
+	requireRowCount(t, manager.db, "jobs", 0)
+
+	if _, err := manager.GetJob(1); !errors.As(err, &unknown) {
+		t.Fatalf("unknown job was not reported as an unknown identifier: %v", err)
+	}
+}
+
+func TestSubmitCreateSchemaReservesName(t *testing.T) {
+	manager := newJobTestManager(t)
+	schema := &Schema{
+		Name:       "event",
+		FlagFormat: "flag{%s}",
+		Challenges: map[ChallengeId]BuildSpecification{
+			"missing": {Seeds: []int{1, 2}, InstanceCount: 1},
+		},
+	}
+	job, err := manager.SubmitCreateSchema(schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := manager.SubmitCreateSchema(schema); err == nil {
+		t.Fatal("duplicate schema submission was accepted")
+	}
+	manager.jobs.Wait()
+
+	metadata, err := manager.GetJob(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.Kind != CREATE_SCHEMA_JOB || metadata.Status != JOB_FAILED {
+		t.Fatalf("unexpected job state: %#v", metadata)
+	}
+	if len(metadata.Seeds) != 2 {
+		t.Fatalf("job does not track every seed: %#v", metadata.Seeds)
+	}
+	exists, err := manager.schemaExists("event")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Fatal("failed schema creation left the schema reserved")
+	}
+}
+
+func TestNewManagerFailsAbandonedJobs(t *testing.T) {
+	runtime := newFakeRuntime()
+	manager := newFakeRuntimeManager(t, runtime)
+	abandoned := insertTestJob(t, manager, "departed", JOB_RUNNING)
+	active := insertTestJob(t, manager, "alive", JOB_QUEUED)
+	liveLease, acquired, err := acquireFileLock(
+		manager.jobLeasePath("alive"),
+		"job lease",
+		syscall.LOCK_EX,
+		true,
+	)
+	if err != nil || !acquired {
+		t.Fatalf("could not hold live lease: acquired=%v err=%v", acquired, err)
+	}
+	defer releaseFileLock(liveLease)
+
+	reopened := reopenFakeRuntimeManager(t, runtime)
+	job, err := reopened.GetJob(abandoned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != JOB_FAILED || job.Error == "" {
+		t.Fatalf("abandoned job was not failed: %#v", job)
+	}
+	if len(job.Seeds) != 1 || job.Seeds[0].Status != SEED_FAILED {
+		t.Fatalf("interrupted seed was not failed: %#v", job.Seeds)
+	}
+	if _, err := os.Stat(reopened.jobLeasePath("departed")); !os.IsNotExist(err) {
+		t.Fatalf("abandoned lease file was not removed: %v", err)
+	}
+	job, err = reopened.GetJob(active)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != JOB_QUEUED {
+		t.Fatalf("job of a live owner was failed: %#v", job)
+	}
+}
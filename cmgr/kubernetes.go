@@ -0,0 +1,931 @@
+package cmgr
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/containerd/errdefs"
+	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/api/types/network"
+	"github.com/moby/moby/client"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	// Prefixes the "namespace/name" of a Pod to form its container ID.
+	kubernetesIDPrefix = "k8s:"
+	// Labels every object belonging to a Pod with the Pod's name.
+	kubernetesPodLabel = "cmgr.container"
+	// The policy that every instance namespace starts with.
+	kubernetesNetworkPolicy = "cmgr-network"
+	// Records the host name, as the challenge spells it, that a host's
+	// Service resolves.
+	kubernetesHostAnnotation = "cmgr.host"
+	// Leaves room in a Pod's Service names for its random suffix and port.
+	kubernetesMaxHostName = 40
+)
+
+var kubernetesManagedLabels = map[string]string{"app.kubernetes.io/managed-by": "cmgr"}
+
+// Runs challenge instances as Pods on a Kubernetes cluster.  Each instance
+// network is a namespace whose NetworkPolicy enforces the instance's egress
+// policy, and each container is a Pod with a headless Service for its host
+// name and a NodePort Service for each published port.  Images are still
+// built on the Docker host, which also runs the containers that artifacts
+// are copied out of, and are pushed to the challenge registry for the
+// cluster's nodes to pull.
+type kubernetesRuntime struct {
+	// The Docker host that builds images.
+	ContainerRuntime
+	client       kubernetes.Interface
+	registry     string
+	registryAuth string
+}
+
+var (
+	_ ContainerRuntime = (*kubernetesRuntime)(nil)
+	_ egressFirewall   = (*kubernetesRuntime)(nil)
+)
+
+// Connects to the cluster described by the kubeconfig file, or to the
+// cluster cmgr is running in when the path is empty.
+func newKubernetesRuntime(
+	docker ContainerRuntime,
+	kubeconfig string,
+	registry string,
+	registryAuth string,
+) (*kubernetesRuntime, error) {
+	if registry == "" {
+		return nil, fmt.Errorf(
+			"%s must be set so that the cluster can pull challenge images",
+			REGISTRY_ENV,
+		)
+	}
+	var config *rest.Config
+	var err error
+	if kubeconfig == "" {
+		config, err = rest.InClusterConfig()
+	} else {
+		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not load Kubernetes configuration: %w", err)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("could not create Kubernetes client: %w", err)
+	}
+	return &kubernetesRuntime{
+		ContainerRuntime: docker,
+		client:           clientset,
+		registry:         registry,
+		registryAuth:     registryAuth,
+	}, nil
+}
+
+func kubernetesPodID(namespace string, pod string) string {
+	return kubernetesIDPrefix + namespace + "/" + pod
+}
+
+// Splits a container ID into the namespace and name of its Pod.  The ID
+// belongs to a Docker container when it has no Pod.
+func parseKubernetesPodID(id string) (string, string, bool) {
+	rest, isPod := strings.CutPrefix(id, kubernetesIDPrefix)
+	if !isPod {
+		return "", "", false
+	}
+	return strings.Cut(rest, "/")
+}
+
+// Classifies API errors the way the Docker client does.
+func kubernetesError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case apierrors.IsNotFound(err):
+		return fmt.Errorf("%w: %w", err, errdefs.ErrNotFound)
+	case apierrors.IsAlreadyExists(err), apierrors.IsConflict(err):
+		return fmt.Errorf("%w: %w", err, errdefs.ErrConflict)
+	}
+	return err
+}
+
+func kubernetesUnsupported(format string, args ...any) error {
+	return fmt.Errorf(format+" on Kubernetes: %w", append(args, errdefs.ErrNotImplemented)...)
+}
+
+// Returns the name of the Service that resolves the host name for the
+// challenge's other containers.  DNS ignores case, so the name only differs
+// from the host name in case, and host names that are not already DNS labels
+// are refused rather than rewritten into names that would not resolve.
+func kubernetesHostName(host string) (string, error) {
+	name := strings.ToLower(host)
+	if len(name) > kubernetesMaxHostName || len(validation.IsDNS1035Label(name)) != 0 {
+		return "", kubernetesUnsupported(
+			"containers whose host name %q is not a DNS label of at most %d characters cannot run",
+			host,
+			kubernetesMaxHostName,
+		)
+	}
+	return name, nil
+}
+
+func podLabels(pod string) map[string]string {
+	labels := map[string]string{kubernetesPodLabel: pod}
+	for key, value := range kubernetesManagedLabels {
+		labels[key] = value
+	}
+	return labels
+}
+
+func (k *kubernetesRuntime) Ping(
+	ctx context.Context,
+	options client.PingOptions,
+) (client.PingResult, error) {
+	result, err := k.ContainerRuntime.Ping(ctx, options)
+	if err != nil {
+		return result, err
+	}
+	if _, err := k.client.Discovery().ServerVersion(); err != nil {
+		return result, fmt.Errorf("could not connect to Kubernetes: %w", err)
+	}
+	return result, nil
+}
+
+// Creates the namespace of an instance network.  Pods in the namespace can
+// reach each other and, unless the network is created without masquerading,
+// anything outside of it.
+func (k *kubernetesRuntime) NetworkCreate(
+	ctx context.Context,
+	name string,
+	options client.NetworkCreateOptions,
+) (client.NetworkCreateResult, error) {
+	namespace := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: kubernetesManagedLabels},
+	}
+	if _, err := k.client.CoreV1().Namespaces().Create(
+		ctx,
+		namespace,
+		metav1.CreateOptions{},
+	); err != nil {
+		return client.NetworkCreateResult{}, kubernetesError(err)
+	}
+
+	egress := []networkingv1.NetworkPolicyEgressRule{{}}
+	if options.Options[masqueradeOption] == "false" {
+		egress = kubernetesEgressRules(nil)
+	}
+	policy := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: kubernetesNetworkPolicy, Labels: kubernetesManagedLabels},
+		Spec: networkingv1.NetworkPolicySpec{
+			PolicyTypes: []networkingv1.PolicyType{
+				networkingv1.PolicyTypeIngress,
+				networkingv1.PolicyTypeEgress,
+			},
+			Ingress: []networkingv1.NetworkPolicyIngressRule{{
+				From: []networkingv1.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{}}},
+			}},
+			Egress: egress,
+		},
+	}
+	if _, err := k.client.NetworkingV1().NetworkPolicies(name).Create(
+		ctx,
+		policy,
+		metav1.CreateOptions{},
+	); err != nil {
+		_, removeErr := k.NetworkRemove(ctx, name, client.NetworkRemoveOptions{})
+		return client.NetworkCreateResult{}, errors.Join(kubernetesError(err), removeErr)
+	}
+	return client.NetworkCreateResult{ID: name}, nil
+}
+
+// Deletes the namespace of an instance network along with everything left
+// in it.
+func (k *kubernetesRuntime) NetworkRemove(
+	ctx context.Context,
+	name string,
+	options client.NetworkRemoveOptions,
+) (client.NetworkRemoveResult, error) {
+	propagation := metav1.DeletePropagationBackground
+	err := k.client.CoreV1().Namespaces().Delete(
+		ctx,
+		name,
+		metav1.DeleteOptions{PropagationPolicy: &propagation},
+	)
+	return client.NetworkRemoveResult{}, kubernetesError(err)
+}
+
+// Limits the egress of an instance namespace to its own Pods, the cluster's
+// DNS, and the destinations.
+func (k *kubernetesRuntime) allowEgress(namespace string, destinations []egressDestination) error {
+	policies := k.client.NetworkingV1().NetworkPolicies(namespace)
+	policy, err := policies.Get(context.Background(), kubernetesNetworkPolicy, metav1.GetOptions{})
+	if err != nil {
+		return kubernetesError(err)
+	}
+	policy.Spec.Egress = kubernetesEgressRules(destinations)
+	_, err = policies.Update(context.Background(), policy, metav1.UpdateOptions{})
+	return kubernetesError(err)
+}
+
+// The egress rules are part of the namespace's policy, so they are deleted
+// with the namespace.
+func (k *kubernetesRuntime) removeEgress(namespace string) error {
+	return nil
+}
+
+func kubernetesEgressRules(destinations []egressDestination) []networkingv1.NetworkPolicyEgressRule {
+	tcp, udp := corev1.ProtocolTCP, corev1.ProtocolUDP
+	dns := intstr.FromInt32(53)
+	rules := []networkingv1.NetworkPolicyEgressRule{
+		{To: []networkingv1.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{}}}},
+		{
+			To: []networkingv1.NetworkPolicyPeer{{
+				NamespaceSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{"kubernetes.io/metadata.name": "kube-system"},
+				},
+			}},
+			Ports: []networkingv1.NetworkPolicyPort{
+				{Protocol: &udp, Port: &dns},
+				{Protocol: &tcp, Port: &dns},
+			},
+		},
+	}
+	for _, dest := range destinations {
+		rule := networkingv1.NetworkPolicyEgressRule{
+			To: []networkingv1.NetworkPolicyPeer{{
+				IPBlock: &networkingv1.IPBlock{CIDR: dest.prefix.String()},
+			}},
+		}
+		if dest.lowPort != 0 || dest.protocol != "" {
+			protocols := []corev1.Protocol{tcp, udp}
+			if dest.protocol != "" {
+				protocols = []corev1.Protocol{corev1.Protocol(strings.ToUpper(dest.protocol))}
+			}
+			for _, protocol := range protocols {
+				port := networkingv1.NetworkPolicyPort{Protocol: &protocol}
+				if dest.lowPort != 0 {
+					low := intstr.FromInt32(int32(dest.lowPort))
+					port.Port = &low
+					if dest.highPort > dest.lowPort {
+						high := int32(dest.highPort)
+						port.EndPort = &high
+					}
+				}
+				rule.Ports = append(rule.Ports, port)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// Creates a Pod for a container that joins an instance network.  Containers
+// without a network, which only have files copied out of them, are created
+// on the Docker host.
+func (k *kubernetesRuntime) ContainerCreate(
+	ctx context.Context,
+	options client.ContainerCreateOptions,
+) (client.ContainerCreateResult, error) {
+	config := options.Config
+	if config == nil {
+		config = &container.Config{}
+	}
+	hostConfig := options.HostConfig
+	if hostConfig == nil {
+		hostConfig = &container.HostConfig{}
+	}
+	if hostConfig.NetworkMode.IsHost() || len(hostConfig.Binds) != 0 {
+		return client.ContainerCreateResult{}, kubernetesUnsupported(
+			"containers with host networking or bind mounts cannot run",
+		)
+	}
+	var namespace string
+	if options.NetworkingConfig != nil {
+		for name := range options.NetworkingConfig.EndpointsConfig {
+			namespace = name
+		}
+	}
+	if namespace == "" {
+		return k.ContainerRuntime.ContainerCreate(ctx, options)
+	}
+	if hostConfig.RestartPolicy.Name != container.RestartPolicyAlways {
+		return client.ContainerCreateResult{}, kubernetesUnsupported(
+			"solvers and other short-lived containers cannot run",
+		)
+	}
+	if hostConfig.Runtime != "" {
+		return client.ContainerCreateResult{}, kubernetesUnsupported(
+			"containers that need the %s runtime cannot run",
+			hostConfig.Runtime,
+		)
+	}
+	if hostConfig.PidsLimit != nil && *hostConfig.PidsLimit > 0 {
+		// The kubelet only limits the processes of every Pod on a node
+		// alike.
+		return client.ContainerCreateResult{}, kubernetesUnsupported(
+			"containers with a PID limit cannot run",
+		)
+	}
+	hostName, err := kubernetesHostName(config.Hostname)
+	if err != nil {
+		return client.ContainerCreateResult{}, err
+	}
+
+	image, err := k.pushImage(ctx, config.Image)
+	if err != nil {
+		return client.ContainerCreateResult{}, err
+	}
+	suffix, err := randomIdentifier()
+	if err != nil {
+		return client.ContainerCreateResult{}, err
+	}
+	name := hostName + "-" + suffix[:8]
+	pod := kubernetesPod(name, image, config, hostConfig)
+	if _, err := k.client.CoreV1().Pods(namespace).Create(
+		ctx,
+		pod,
+		metav1.CreateOptions{},
+	); err != nil {
+		return client.ContainerCreateResult{}, kubernetesError(err)
+	}
+	if err := k.createPodServices(ctx, namespace, pod, config.Hostname, hostConfig.PortBindings); err != nil {
+		return client.ContainerCreateResult{}, errors.Join(
+			err,
+			k.removePod(ctx, namespace, name, metav1.DeleteOptions{}),
+		)
+	}
+	return client.ContainerCreateResult{ID: kubernetesPodID(namespace, name)}, nil
+}
+
+// Pushes an image to the challenge registry under a tag naming the image's
+// ID, so that a node never runs an image it cached for an older build.
+func (k *kubernetesRuntime) pushImage(ctx context.Context, name string) (string, error) {
+	inspection, err := k.ContainerRuntime.ImageInspect(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	repository := name
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		repository = name[:i]
+	}
+	target := fmt.Sprintf(
+		"%s/%s:%s",
+		k.registry,
+		repository,
+		strings.TrimPrefix(inspection.ID, "sha256:"),
+	)
+	if _, err := k.ContainerRuntime.ImageTag(
+		ctx,
+		client.ImageTagOptions{Source: name, Target: target},
+	); err != nil {
+		return "", err
+	}
+	push, err := k.ContainerRuntime.ImagePush(
+		ctx,
+		target,
+		client.ImagePushOptions{RegistryAuth: k.registryAuth},
+	)
+	if err == nil {
+		err = consumeDockerProgress(push, "image push", nil)
+	}
+	// Once the registry has the image, the Docker host no longer needs the
+	// tag.
+	_, removeErr := k.ContainerRuntime.ImageRemove(ctx, target, client.ImageRemoveOptions{})
+	if err = errors.Join(err, removeErr); err != nil {
+		return "", fmt.Errorf("could not push %s: %w", target, err)
+	}
+	return target, nil
+}
+
+// Translates a container's configuration into a Pod running the image.
+func kubernetesPod(
+	name string,
+	image string,
+	config *container.Config,
+	hostConfig *container.HostConfig,
+) *corev1.Pod {
+	challenge := corev1.Container{
+		Name:            "challenge",
+		Image:           image,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Command:         config.Entrypoint,
+		Args:            config.Cmd,
+		WorkingDir:      config.WorkingDir,
+		Ports:           kubernetesContainerPorts(config.ExposedPorts),
+		SecurityContext: kubernetesSecurityContext(hostConfig),
+	}
+	for _, variable := range config.Env {
+		key, value, _ := strings.Cut(variable, "=")
+		challenge.Env = append(challenge.Env, corev1.EnvVar{Name: key, Value: value})
+	}
+	limits := corev1.ResourceList{}
+	if hostConfig.NanoCPUs != 0 {
+		limits[corev1.ResourceCPU] = *resource.NewMilliQuantity(
+			hostConfig.NanoCPUs/1_000_000,
+			resource.DecimalSI,
+		)
+	}
+	if hostConfig.Memory != 0 {
+		limits[corev1.ResourceMemory] = *resource.NewQuantity(
+			hostConfig.Memory,
+			resource.BinarySI,
+		)
+	}
+	if len(limits) != 0 {
+		// Requests default to the limits, so the scheduler reserves them.
+		challenge.Resources.Limits = limits
+	}
+
+	disabled := false
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Labels:      podLabels(name),
+			Annotations: config.Labels,
+		},
+		Spec: corev1.PodSpec{
+			Hostname:                     strings.ToLower(config.Hostname),
+			Containers:                   []corev1.Container{challenge},
+			RestartPolicy:                corev1.RestartPolicyAlways,
+			AutomountServiceAccountToken: &disabled,
+			EnableServiceLinks:           &disabled,
+		},
+	}
+}
+
+func kubernetesContainerPorts(exposed network.PortSet) []corev1.ContainerPort {
+	var ports []corev1.ContainerPort
+	for port := range exposed {
+		ports = append(ports, corev1.ContainerPort{
+			ContainerPort: int32(port.Num()),
+			Protocol:      corev1.Protocol(strings.ToUpper(string(port.Proto()))),
+		})
+	}
+	sort.Slice(ports, func(i, j int) bool {
+		if ports[i].ContainerPort != ports[j].ContainerPort {
+			return ports[i].ContainerPort < ports[j].ContainerPort
+		}
+		return ports[i].Protocol < ports[j].Protocol
+	})
+	return ports
+}
+
+// Carries over the read-only root filesystem, capabilities, privilege
+// escalation, and seccomp settings of a container.  A custom seccomp profile
+// must be installed on every node as "cmgr/<hash>.json" in the kubelet's
+// seccomp directory, where the hash is the profile's SHA-256 digest.
+func kubernetesSecurityContext(hostConfig *container.HostConfig) *corev1.SecurityContext {
+	security := &corev1.SecurityContext{
+		SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+	}
+	if hostConfig.ReadonlyRootfs {
+		readOnly := true
+		security.ReadOnlyRootFilesystem = &readOnly
+	}
+	if len(hostConfig.CapDrop) != 0 || len(hostConfig.CapAdd) != 0 {
+		security.Capabilities = &corev1.Capabilities{
+			Drop: kubernetesCapabilities(hostConfig.CapDrop),
+			Add:  kubernetesCapabilities(hostConfig.CapAdd),
+		}
+	}
+	for _, option := range hostConfig.SecurityOpt {
+		switch {
+		case option == "no-new-privileges" || option == "no-new-privileges:true":
+			escalation := false
+			security.AllowPrivilegeEscalation = &escalation
+		case option == "seccomp=unconfined":
+			security.SeccompProfile = &corev1.SeccompProfile{
+				Type: corev1.SeccompProfileTypeUnconfined,
+			}
+		case strings.HasPrefix(option, "seccomp="):
+			sum := sha256.Sum256([]byte(strings.TrimPrefix(option, "seccomp=")))
+			profile := fmt.Sprintf("cmgr/%x.json", sum)
+			security.SeccompProfile = &corev1.SeccompProfile{
+				Type:             corev1.SeccompProfileTypeLocalhost,
+				LocalhostProfile: &profile,
+			}
+		}
+	}
+	return security
+}
+
+func kubernetesCapabilities(capabilities []string) []corev1.Capability {
+	var converted []corev1.Capability
+	for _, capability := range capabilities {
+		capability = strings.TrimPrefix(strings.ToUpper(capability), "CAP_")
+		converted = append(converted, corev1.Capability(capability))
+	}
+	return converted
+}
+
+// Creates the Services of a Pod: a headless Service that gives the Pod its
+// host name within the namespace, a NodePort Service for each published
+// port, and a NetworkPolicy that admits traffic from outside the namespace
+// to its exposed ports.
+func (k *kubernetesRuntime) createPodServices(
+	ctx context.Context,
+	namespace string,
+	pod *corev1.Pod,
+	hostName string,
+	published network.PortMap,
+) error {
+	services := k.client.CoreV1().Services(namespace)
+	ports := pod.Spec.Containers[0].Ports
+	host := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        pod.Spec.Hostname,
+			Labels:      podLabels(pod.Name),
+			Annotations: map[string]string{kubernetesHostAnnotation: hostName},
+		},
+		Spec: corev1.ServiceSpec{
+			ClusterIP: corev1.ClusterIPNone,
+			Selector:  map[string]string{kubernetesPodLabel: pod.Name},
+		},
+	}
+	for _, port := range ports {
+		host.Spec.Ports = append(host.Spec.Ports, corev1.ServicePort{
+			Name:     kubernetesPortName(port.ContainerPort, port.Protocol),
+			Protocol: port.Protocol,
+			Port:     port.ContainerPort,
+		})
+	}
+	_, err := services.Create(ctx, host, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		// The host's previous Pod is being replaced.
+		var existing *corev1.Service
+		existing, err = services.Get(ctx, host.Name, metav1.GetOptions{})
+		if err == nil {
+			// Another host of the instance may only differ in case.
+			if recorded, ok := existing.Annotations[kubernetesHostAnnotation]; ok && recorded != hostName {
+				return fmt.Errorf(
+					"host names %q and %q resolve to the same Service: %w",
+					hostName,
+					recorded,
+					errdefs.ErrConflict,
+				)
+			}
+			existing.Labels = host.Labels
+			existing.Annotations = host.Annotations
+			existing.Spec.Selector = host.Spec.Selector
+			existing.Spec.Ports = host.Spec.Ports
+			_, err = services.Update(ctx, existing, metav1.UpdateOptions{})
+		}
+	}
+	if err != nil {
+		return kubernetesError(err)
+	}
+
+	var numbers []network.Port
+	for port := range published {
+		numbers = append(numbers, port)
+	}
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i].String() < numbers[j].String() })
+	for _, port := range numbers {
+		servicePort := corev1.ServicePort{
+			Protocol:   corev1.Protocol(strings.ToUpper(string(port.Proto()))),
+			Port:       int32(port.Num()),
+			TargetPort: intstr.FromInt32(int32(port.Num())),
+		}
+		for _, binding := range published[port] {
+			if nodePort, err := strconv.Atoi(binding.HostPort); err == nil {
+				servicePort.NodePort = int32(nodePort)
+			}
+		}
+		service := &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   pod.Name + "-" + kubernetesPortName(servicePort.Port, servicePort.Protocol),
+				Labels: podLabels(pod.Name),
+			},
+			Spec: corev1.ServiceSpec{
+				Type:     corev1.ServiceTypeNodePort,
+				Selector: map[string]string{kubernetesPodLabel: pod.Name},
+				Ports:    []corev1.ServicePort{servicePort},
+			},
+		}
+		if _, err := services.Create(ctx, service, metav1.CreateOptions{}); err != nil {
+			return kubernetesError(err)
+		}
+	}
+
+	if len(ports) == 0 {
+		return nil
+	}
+	ingress := networkingv1.NetworkPolicyIngressRule{}
+	for _, port := range ports {
+		number := intstr.FromInt32(port.ContainerPort)
+		ingress.Ports = append(ingress.Ports, networkingv1.NetworkPolicyPort{
+			Protocol: &port.Protocol,
+			Port:     &number,
+		})
+	}
+	policy := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Labels: podLabels(pod.Name)},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: map[string]string{kubernetesPodLabel: pod.Name},
+			},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			Ingress:     []networkingv1.NetworkPolicyIngressRule{ingress},
+		},
+	}
+	_, err = k.client.NetworkingV1().NetworkPolicies(namespace).Create(
+		ctx,
+		policy,
+		metav1.CreateOptions{},
+	)
+	return kubernetesError(err)
+}
+
+func kubernetesPortName(port int32, protocol corev1.Protocol) string {
+	return fmt.Sprintf("%d-%s", port, strings.ToLower(string(protocol)))
+}
+
+// Deletes a Pod along with its Services and NetworkPolicy.
+func (k *kubernetesRuntime) removePod(
+	ctx context.Context,
+	namespace string,
+	name string,
+	options metav1.DeleteOptions,
+) error {
+	err := kubernetesError(k.client.CoreV1().Pods(namespace).Delete(ctx, name, options))
+	selector := metav1.ListOptions{LabelSelector: kubernetesPodLabel + "=" + name}
+	services, listErr := k.client.CoreV1().Services(namespace).List(ctx, selector)
+	if listErr != nil && !apierrors.IsNotFound(listErr) {
+		return errors.Join(err, listErr)
+	}
+	errs := []error{err}
+	if services != nil {
+		for _, service := range services.Items {
+			deleteErr := k.client.CoreV1().Services(namespace).Delete(
+				ctx,
+				service.Name,
+				metav1.DeleteOptions{},
+			)
+			if !apierrors.IsNotFound(deleteErr) {
+				errs = append(errs, deleteErr)
+			}
+		}
+	}
+	policyErr := k.client.NetworkingV1().NetworkPolicies(namespace).Delete(
+		ctx,
+		name,
+		metav1.DeleteOptions{},
+	)
+	if !apierrors.IsNotFound(policyErr) {
+		errs = append(errs, policyErr)
+	}
+	return errors.Join(errs...)
+}
+
+func (k *kubernetesRuntime) ContainerRemove(
+	ctx context.Context,
+	id string,
+	options client.ContainerRemoveOptions,
+) (client.ContainerRemoveResult, error) {
+	namespace, name, isPod := parseKubernetesPodID(id)
+	if !isPod {
+		return k.ContainerRuntime.ContainerRemove(ctx, id, options)
+	}
+	deleteOptions := metav1.DeleteOptions{}
+	if options.Force {
+		var immediately int64
+		deleteOptions.GracePeriodSeconds = &immediately
+	}
+	return client.ContainerRemoveResult{}, k.removePod(ctx, namespace, name, deleteOptions)
+}
+
+// Pods start as soon as they are created and are restarted by the kubelet,
+// so starting one only checks that it can still run.
+func (k *kubernetesRuntime) ContainerStart(
+	ctx context.Context,
+	id string,
+	options client.ContainerStartOptions,
+) (client.ContainerStartResult, error) {
+	namespace, name, isPod := parseKubernetesPodID(id)
+	if !isPod {
+		return k.ContainerRuntime.ContainerStart(ctx, id, options)
+	}
+	pod, err := k.client.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return client.ContainerStartResult{}, kubernetesError(err)
+	}
+	if pod.Status.Phase == corev1.PodFailed || pod.Status.Phase == corev1.PodSucceeded {
+		return client.ContainerStartResult{}, fmt.Errorf(
+			"pod %s/%s has finished (%s): %w",
+			namespace,
+			name,
+			pod.Status.Reason,
+			errdefs.ErrFailedPrecondition,
+		)
+	}
+	return client.ContainerStartResult{}, nil
+}
+
+func (k *kubernetesRuntime) ContainerStop(
+	ctx context.Context,
+	id string,
+	options client.ContainerStopOptions,
+) (client.ContainerStopResult, error) {
+	if _, _, isPod := parseKubernetesPodID(id); isPod {
+		return client.ContainerStopResult{}, kubernetesUnsupported(
+			"instances cannot be paused",
+		)
+	}
+	return k.ContainerRuntime.ContainerStop(ctx, id, options)
+}
+
+func (k *kubernetesRuntime) ContainerUpdate(
+	ctx context.Context,
+	id string,
+	options client.ContainerUpdateOptions,
+) (client.ContainerUpdateResult, error) {
+	if _, _, isPod := parseKubernetesPodID(id); isPod {
+		return client.ContainerUpdateResult{}, kubernetesUnsupported(
+			"instances cannot be paused",
+		)
+	}
+	return k.ContainerRuntime.ContainerUpdate(ctx, id, options)
+}
+
+func (k *kubernetesRuntime) ContainerWait(
+	ctx context.Context,
+	id string,
+	options client.ContainerWaitOptions,
+) client.ContainerWaitResult {
+	if _, _, isPod := parseKubernetesPodID(id); isPod {
+		errs := make(chan error, 1)
+		errs <- kubernetesUnsupported("containers cannot be waited on")
+		return client.ContainerWaitResult{Error: errs}
+	}
+	return k.ContainerRuntime.ContainerWait(ctx, id, options)
+}
+
+func (k *kubernetesRuntime) ContainerStats(
+	ctx context.Context,
+	id string,
+	options client.ContainerStatsOptions,
+) (client.ContainerStatsResult, error) {
+	if _, _, isPod := parseKubernetesPodID(id); isPod {
+		return client.ContainerStatsResult{}, kubernetesUnsupported(
+			"container statistics are unavailable",
+		)
+	}
+	return k.ContainerRuntime.ContainerStats(ctx, id, options)
+}
+
+func (k *kubernetesRuntime) CopyFromContainer(
+	ctx context.Context,
+	id string,
+	options client.CopyFromContainerOptions,
+) (client.CopyFromContainerResult, error) {
+	if _, _, isPod := parseKubernetesPodID(id); isPod {
+		return client.CopyFromContainerResult{}, kubernetesUnsupported(
+			"files cannot be copied out of containers",
+		)
+	}
+	return k.ContainerRuntime.CopyFromContainer(ctx, id, options)
+}
+
+// Reports a Pod the way Docker reports a container.  Its published ports are
+// the node ports of its Services, and a Pod that is still being scheduled or
+// pulling its image counts as running so that its ports are reported right
+// away.  Pod logs are never multiplexed, so the Pod is reported as having a
+// TTY.
+func (k *kubernetesRuntime) ContainerInspect(
+	ctx context.Context,
+	id string,
+	options client.ContainerInspectOptions,
+) (client.ContainerInspectResult, error) {
+	namespace, name, isPod := parseKubernetesPodID(id)
+	if !isPod {
+		return k.ContainerRuntime.ContainerInspect(ctx, id, options)
+	}
+	pod, err := k.client.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return client.ContainerInspectResult{}, kubernetesError(err)
+	}
+	services, err := k.client.CoreV1().Services(namespace).List(
+		ctx,
+		metav1.ListOptions{LabelSelector: kubernetesPodLabel + "=" + name},
+	)
+	if err != nil {
+		return client.ContainerInspectResult{}, kubernetesError(err)
+	}
+
+	ports := make(network.PortMap)
+	for _, service := range services.Items {
+		if service.Spec.Type != corev1.ServiceTypeNodePort {
+			continue
+		}
+		for _, servicePort := range service.Spec.Ports {
+			port, valid := network.PortFrom(
+				uint16(servicePort.Port),
+				network.IPProtocol(strings.ToLower(string(servicePort.Protocol))),
+			)
+			if !valid || servicePort.NodePort == 0 {
+				continue
+			}
+			ports[port] = append(ports[port], network.PortBinding{
+				HostPort: strconv.Itoa(int(servicePort.NodePort)),
+			})
+		}
+	}
+
+	endpoint := &network.EndpointSettings{NetworkID: namespace}
+	if address, err := netip.ParseAddr(pod.Status.PodIP); err == nil {
+		endpoint.IPAddress = address
+	}
+	config := &container.Config{Hostname: pod.Spec.Hostname, Tty: true}
+	if len(pod.Spec.Containers) != 0 {
+		config.Image = pod.Spec.Containers[0].Image
+	}
+	inspection := container.InspectResponse{
+		ID:     id,
+		Name:   "/" + name,
+		Image:  config.Image,
+		State:  kubernetesPodState(pod),
+		Config: config,
+		NetworkSettings: &container.NetworkSettings{
+			Ports:    ports,
+			Networks: map[string]*network.EndpointSettings{namespace: endpoint},
+		},
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		inspection.RestartCount += int(status.RestartCount)
+	}
+	return client.ContainerInspectResult{Container: inspection}, nil
+}
+
+func kubernetesPodState(pod *corev1.Pod) *container.State {
+	state := &container.State{Status: container.StateRunning, Running: true}
+	switch pod.Status.Phase {
+	case corev1.PodSucceeded, corev1.PodFailed:
+		state = &container.State{Status: container.StateExited, Error: pod.Status.Message}
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		switch {
+		case status.State.Running != nil:
+			state.StartedAt = status.State.Running.StartedAt.UTC().Format(time.RFC3339Nano)
+		case status.State.Terminated != nil:
+			state.ExitCode = int(status.State.Terminated.ExitCode)
+		case status.State.Waiting != nil:
+			switch status.State.Waiting.Reason {
+			case "", "ContainerCreating", "PodInitializing":
+			case "CrashLoopBackOff":
+				state = &container.State{Status: container.StateRestarting, Restarting: true}
+			default:
+				// The image cannot be pulled or the container cannot be created.
+				state = &container.State{
+					Status: container.StateCreated,
+					Error:  status.State.Waiting.Reason + ": " + status.State.Waiting.Message,
+				}
+			}
+		}
+	}
+	return state
+}
+
+func (k *kubernetesRuntime) ContainerLogs(
+	ctx context.Context,
+	id string,
+	options client.ContainerLogsOptions,
+) (client.ContainerLogsResult, error) {
+	namespace, name, isPod := parseKubernetesPodID(id)
+	if !isPod {
+		return k.ContainerRuntime.ContainerLogs(ctx, id, options)
+	}
+	logOptions := &corev1.PodLogOptions{
+		Follow:     options.Follow,
+		Timestamps: options.Timestamps,
+	}
+	if tail, err := strconv.ParseInt(options.Tail, 10, 64); err == nil {
+		logOptions.TailLines = &tail
+	}
+	if options.Since != "" {
+		since, err := time.Parse(time.RFC3339Nano, options.Since)
+		if err != nil {
+			return nil, err
+		}
+		sinceTime := metav1.NewTime(since)
+		logOptions.SinceTime = &sinceTime
+	}
+	logs, err := k.client.CoreV1().Pods(namespace).GetLogs(name, logOptions).Stream(ctx)
+	return logs, kubernetesError(err)
+}
@@ -0,0 +1,431 @@
+package cmgr
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containerd/errdefs"
+	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/api/types/network"
+	"github.com/moby/moby/client"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// Runs Pods on a fake cluster that assigns node ports the way the API server
+// does, with images built on a fake Docker host.
+func newFakeKubernetesRuntime() (*kubernetesRuntime, *fakeRuntime, *fake.Clientset) {
+	docker := newFakeRuntime()
+	clientset := fake.NewClientset()
+	nextPort := int32(30000)
+	clientset.PrependReactor("create", "services", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		service := action.(k8stesting.CreateAction).GetObject().(*corev1.Service)
+		for i := range service.Spec.Ports {
+			if service.Spec.Type == corev1.ServiceTypeNodePort && service.Spec.Ports[i].NodePort == 0 {
+				service.Spec.Ports[i].NodePort = nextPort
+				nextPort++
+			}
+		}
+		return false, nil, nil
+	})
+	return &kubernetesRuntime{
+		ContainerRuntime: docker,
+		client:           clientset,
+		registry:         "registry.test",
+	}, docker, clientset
+}
+
+func TestKubernetesRuntimeInstanceLifecycle(t *testing.T) {
+	runtime, docker, clientset := newFakeKubernetesRuntime()
+	manager := newFakeRuntimeManager(t, runtime)
+	build, instance := startFakeRuntimeInstance(t, manager)
+	ctx := t.Context()
+
+	if build.Flag == "" {
+		t.Fatal("build flag was not read from the image")
+	}
+	namespace := instance.getNetworkName()
+	if len(instance.Containers) != 1 ||
+		!strings.HasPrefix(instance.Containers[0], kubernetesIDPrefix+namespace+"/") {
+		t.Fatalf("instance container is not a pod: %v", instance.Containers)
+	}
+	if docker.containerCount() != 0 {
+		t.Fatalf("%d containers remain on the Docker host", docker.containerCount())
+	}
+	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pods.Items) != 1 {
+		t.Fatalf("expected one pod, found %d", len(pods.Items))
+	}
+	pod := pods.Items[0]
+	if image := pod.Spec.Containers[0].Image; !strings.HasPrefix(
+		image,
+		"registry.test/"+string(fakeRuntimeChallenge)+":",
+	) || len(docker.pushed) != 1 || docker.pushed[0] != image {
+		t.Fatalf("pod image %s was not pushed (pushed %v)", image, docker.pushed)
+	}
+	if pod.Spec.Hostname != "challenge" {
+		t.Fatalf("unexpected pod hostname %q", pod.Spec.Hostname)
+	}
+	if port := instance.Ports["socat"]; port != 30000 {
+		t.Fatalf("instance port is not the node port: %v", instance.Ports)
+	}
+	if _, err := clientset.CoreV1().Services(namespace).Get(
+		ctx,
+		"challenge",
+		metav1.GetOptions{},
+	); err != nil {
+		t.Fatalf("host service was not created: %v", err)
+	}
+	policy, err := clientset.NetworkingV1().NetworkPolicies(namespace).Get(
+		ctx,
+		kubernetesNetworkPolicy,
+		metav1.GetOptions{},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(policy.Spec.Egress) != 2 {
+		t.Fatalf("egress is not limited to the namespace and DNS: %v", policy.Spec.Egress)
+	}
+
+	// Solvers cannot run on the cluster.
+	if err := manager.CheckInstance(instance.Id); !errdefs.IsNotImplemented(err) {
+		t.Fatalf("unexpected check error: %v", err)
+	}
+
+	if err := manager.Stop(instance.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.Destroy(build.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clientset.CoreV1().Namespaces().Get(
+		ctx,
+		namespace,
+		metav1.GetOptions{},
+	); err == nil {
+		t.Fatalf("namespace %s remains", namespace)
+	}
+	pods, err = clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	services, err := clientset.CoreV1().Services(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pods.Items) != 0 || len(services.Items) != 0 {
+		t.Fatalf("%d pods and %d services remain", len(pods.Items), len(services.Items))
+	}
+	if docker.containerCount() != 0 {
+		t.Fatalf("%d containers remain on the Docker host", docker.containerCount())
+	}
+	requireRowCount(t, manager.db, "retiredContainers", 0)
+}
+
+func TestKubernetesNetworkPolicyFollowsEgress(t *testing.T) {
+	runtime, _, clientset := newFakeKubernetesRuntime()
+	ctx := t.Context()
+	egressOf := func(namespace string) []networkingv1.NetworkPolicyEgressRule {
+		t.Helper()
+		policy, err := clientset.NetworkingV1().NetworkPolicies(namespace).Get(
+			ctx,
+			kubernetesNetworkPolicy,
+			metav1.GetOptions{},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return policy.Spec.Egress
+	}
+
+	for name, opts := range map[string]NetworkOptions{
+		"cmgr-1": {},
+		"cmgr-2": {AllowEgress: EgressPolicy{All: true}},
+	} {
+		options := challengeNetworkCreateOptions(name, opts, false)
+		if _, err := runtime.NetworkCreate(ctx, name, options); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if egress := egressOf("cmgr-1"); len(egress) != 2 {
+		t.Fatalf("isolated namespace has egress rules %v", egress)
+	}
+	if egress := egressOf("cmgr-2"); len(egress) != 1 || len(egress[0].To) != 0 {
+		t.Fatalf("open namespace has egress rules %v", egress)
+	}
+
+	destination, err := parseEgressDestination("10.0.5.10:8000-8100/tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := runtime.allowEgress("cmgr-2", []egressDestination{destination}); err != nil {
+		t.Fatal(err)
+	}
+	egress := egressOf("cmgr-2")
+	if len(egress) != 3 {
+		t.Fatalf("allowlisted namespace has egress rules %v", egress)
+	}
+	allowed := egress[2]
+	if len(allowed.To) != 1 || allowed.To[0].IPBlock == nil ||
+		allowed.To[0].IPBlock.CIDR != "10.0.5.10/32" {
+		t.Fatalf("unexpected destination %v", allowed.To)
+	}
+	if len(allowed.Ports) != 1 ||
+		*allowed.Ports[0].Protocol != corev1.ProtocolTCP ||
+		allowed.Ports[0].Port.IntValue() != 8000 ||
+		*allowed.Ports[0].EndPort != 8100 {
+		t.Fatalf("unexpected destination ports %v", allowed.Ports)
+	}
+
+	if _, err := runtime.NetworkRemove(ctx, "cmgr-1", client.NetworkRemoveOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runtime.NetworkRemove(
+		ctx,
+		"cmgr-1",
+		client.NetworkRemoveOptions{},
+	); !errdefs.IsNotFound(err) {
+		t.Fatalf("removing a missing namespace returned %v", err)
+	}
+}
+
+func TestKubernetesPodCarriesContainerOptions(t *testing.T) {
+	profile := `{"defaultAction":"SCMP_ACT_ALLOW"}`
+	pod := kubernetesPod(
+		"web-1234abcd",
+		"registry.test/web:1",
+		&container.Config{
+			Hostname:     "Web",
+			Env:          []string{"MODE=ctf"},
+			ExposedPorts: network.PortSet{network.MustParsePort("53/udp"): {}},
+		},
+		&container.HostConfig{
+			Resources: container.Resources{
+				NanoCPUs: 500_000_000,
+				Memory:   64 * 1024 * 1024,
+			},
+			ReadonlyRootfs: true,
+			CapDrop:        []string{"ALL"},
+			CapAdd:         []string{"CAP_NET_BIND_SERVICE"},
+			SecurityOpt:    []string{"no-new-privileges:true", "seccomp=" + profile},
+		},
+	)
+
+	if pod.Spec.Hostname != "web" || *pod.Spec.AutomountServiceAccountToken {
+		t.Fatalf("unexpected pod spec %+v", pod.Spec)
+	}
+	challenge := pod.Spec.Containers[0]
+	if cpu := challenge.Resources.Limits[corev1.ResourceCPU]; cpu.String() != "500m" {
+		t.Fatalf("unexpected CPU limit %s", cpu.String())
+	}
+	if memory := challenge.Resources.Limits[corev1.ResourceMemory]; memory.String() != "64Mi" {
+		t.Fatalf("unexpected memory limit %s", memory.String())
+	}
+	if len(challenge.Env) != 1 || challenge.Env[0].Name != "MODE" || challenge.Env[0].Value != "ctf" {
+		t.Fatalf("unexpected environment %v", challenge.Env)
+	}
+	if len(challenge.Ports) != 1 ||
+		challenge.Ports[0].ContainerPort != 53 ||
+		challenge.Ports[0].Protocol != corev1.ProtocolUDP {
+		t.Fatalf("unexpected ports %v", challenge.Ports)
+	}
+
+	security := challenge.SecurityContext
+	if !*security.ReadOnlyRootFilesystem || *security.AllowPrivilegeEscalation {
+		t.Fatalf("unexpected security context %+v", security)
+	}
+	if len(security.Capabilities.Drop) != 1 || security.Capabilities.Drop[0] != "ALL" ||
+		len(security.Capabilities.Add) != 1 || security.Capabilities.Add[0] != "NET_BIND_SERVICE" {
+		t.Fatalf("unexpected capabilities %+v", security.Capabilities)
+	}
+	expected := fmt.Sprintf("cmgr/%x.json", sha256.Sum256([]byte(profile)))
+	if security.SeccompProfile.Type != corev1.SeccompProfileTypeLocalhost ||
+		*security.SeccompProfile.LocalhostProfile != expected {
+		t.Fatalf("unexpected seccomp profile %+v", security.SeccompProfile)
+	}
+
+	unconfined := kubernetesSecurityContext(&container.HostConfig{
+		SecurityOpt: []string{"seccomp=unconfined"},
+	})
+	if unconfined.SeccompProfile.Type != corev1.SeccompProfileTypeUnconfined {
+		t.Fatalf("unexpected seccomp profile %+v", unconfined.SeccompProfile)
+	}
+	if standard := kubernetesSecurityContext(&container.HostConfig{}); standard.SeccompProfile.Type !=
+		corev1.SeccompProfileTypeRuntimeDefault {
+		t.Fatalf("unexpected seccomp profile %+v", standard.SeccompProfile)
+	}
+}
+
+func TestKubernetesRuntimeRejectsUnsupportedContainers(t *testing.T) {
+	runtime, _, _ := newFakeKubernetesRuntime()
+	ctx := t.Context()
+	if _, err := runtime.NetworkCreate(ctx, "cmgr-1", client.NetworkCreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	joined := &network.NetworkingConfig{
+		EndpointsConfig: map[string]*network.EndpointSettings{"cmgr-1": {}},
+	}
+	pidsLimit := int64(256)
+	for name, options := range map[string]client.ContainerCreateOptions{
+		"capture": {
+			Config:     &container.Config{Image: "capture"},
+			HostConfig: &container.HostConfig{NetworkMode: "host"},
+		},
+		"solver": {
+			Config:           &container.Config{Image: "solver"},
+			HostConfig:       &container.HostConfig{},
+			NetworkingConfig: joined,
+		},
+		"pids": {
+			Config: &container.Config{Image: "limited", Hostname: "limited"},
+			HostConfig: &container.HostConfig{
+				RestartPolicy: container.RestartPolicy{Name: container.RestartPolicyAlways},
+				Resources:     container.Resources{PidsLimit: &pidsLimit},
+			},
+			NetworkingConfig: joined,
+		},
+		"underscore": {
+			Config: &container.Config{Image: "web", Hostname: "web_1"},
+			HostConfig: &container.HostConfig{
+				RestartPolicy: container.RestartPolicy{Name: container.RestartPolicyAlways},
+			},
+			NetworkingConfig: joined,
+		},
+		"long": {
+			Config: &container.Config{Image: "web", Hostname: strings.Repeat("w", kubernetesMaxHostName+1)},
+			HostConfig: &container.HostConfig{
+				RestartPolicy: container.RestartPolicy{Name: container.RestartPolicyAlways},
+			},
+			NetworkingConfig: joined,
+		},
+		"tweaks": {
+			Config: &container.Config{Image: "tweaked"},
+			HostConfig: &container.HostConfig{
+				RestartPolicy: container.RestartPolicy{Name: container.RestartPolicyAlways},
+				Runtime:       "cmgr-seccomp",
+			},
+			NetworkingConfig: joined,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := runtime.ContainerCreate(ctx, options); !errdefs.IsNotImplemented(err) {
+				t.Fatalf("unexpected error %v", err)
+			}
+		})
+	}
+
+	if _, err := runtime.ContainerStop(
+		ctx,
+		kubernetesPodID("cmgr-1", "challenge-1"),
+		client.ContainerStopOptions{},
+	); !errdefs.IsNotImplemented(err) {
+		t.Fatalf("stopping a pod returned %v", err)
+	}
+	if _, err := runtime.ContainerInspect(
+		ctx,
+		kubernetesPodID("cmgr-1", "challenge-1"),
+		client.ContainerInspectOptions{},
+	); !errdefs.IsNotFound(err) {
+		t.Fatalf("inspecting a missing pod returned %v", err)
+	}
+}
+
+func TestKubernetesRuntimeRejectsHostsSharingAService(t *testing.T) {
+	runtime, docker, clientset := newFakeKubernetesRuntime()
+	docker.images["web"] = &fakeImage{id: "sha256:web"}
+	ctx := t.Context()
+	if _, err := runtime.NetworkCreate(ctx, "cmgr-1", client.NetworkCreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	create := func(host string) error {
+		_, err := runtime.ContainerCreate(ctx, client.ContainerCreateOptions{
+			Config: &container.Config{Image: "web", Hostname: host},
+			HostConfig: &container.HostConfig{
+				RestartPolicy: container.RestartPolicy{Name: container.RestartPolicyAlways},
+			},
+			NetworkingConfig: &network.NetworkingConfig{
+				EndpointsConfig: map[string]*network.EndpointSettings{"cmgr-1": {}},
+			},
+		})
+		return err
+	}
+
+	if err := create("Web"); err != nil {
+		t.Fatal(err)
+	}
+	if err := create("web"); !errdefs.IsConflict(err) {
+		t.Fatalf("a second host resolving to the same Service returned %v", err)
+	}
+	pods, err := clientset.CoreV1().Pods("cmgr-1").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pods.Items) != 1 {
+		t.Fatalf("expected one pod, found %d", len(pods.Items))
+	}
+	service, err := clientset.CoreV1().Services("cmgr-1").Get(ctx, "web", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if selected := service.Spec.Selector[kubernetesPodLabel]; selected != pods.Items[0].Name {
+		t.Fatalf("host service selects %q, want %q", selected, pods.Items[0].Name)
+	}
+}
+
+func TestKubernetesRuntimeRefusesPidLimitSettings(t *testing.T) {
+	for _, env := range []string{defaultPidsLimitEnv, hostPidsEnv} {
+		t.Run(env, func(t *testing.T) {
+			runtime, _, _ := newFakeKubernetesRuntime()
+			t.Setenv(env, "512")
+			t.Setenv(DB_ENV, filepath.Join(t.TempDir(), "cmgr.db"))
+			if manager := NewManagerWithRuntime(DISABLED, runtime); manager != nil {
+				manager.db.Close()
+				t.Fatalf("%s was accepted on Kubernetes", env)
+			}
+		})
+	}
+}
+
+func TestKubernetesRuntimeRefusesChecksAndCaptures(t *testing.T) {
+	runtime, _, _ := newFakeKubernetesRuntime()
+	manager := newFakeRuntimeManager(t, runtime)
+	_, instance := startFakeRuntimeInstance(t, manager)
+
+	var conflict *ConflictError
+	if err := manager.CheckInstance(instance.Id); !errors.As(err, &conflict) ||
+		!errdefs.IsNotImplemented(err) {
+		t.Fatalf("checking an instance returned %v", err)
+	}
+	if checkable, err := manager.CheckableInstances(); err != nil || len(checkable) != 0 {
+		t.Fatalf("listed checkable instances %v, %v", checkable, err)
+	}
+	if manager.ChecksAvailable() {
+		t.Fatal("checks were reported as available")
+	}
+
+	problem := filepath.Join(os.Getenv(DIR_ENV), "problem.md")
+	contents, err := os.ReadFile(problem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents = append(contents, "\n## Challenge Options\n\n```yaml\npacket_capture: true\n```\n"...)
+	if err := os.WriteFile(problem, contents, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if updates := manager.Update(""); len(updates.Errors) == 0 {
+		t.Fatal("a challenge with packet_capture was loaded")
+	}
+}
@@ -70,6 +70,18 @@ func (m *Manager) loadChallenge(path string, info os.FileInfo) (*ChallengeMetada
 	return md, err
 }
 
+// Accepts "tcp", "udp", or an empty protocol and stores TCP as empty so that
+// equivalent port maps compare equal.
+func normalizePortProtocol(protocol string) (string, error) {
+	switch strings.ToLower(protocol) {
+	case "", "tcp":
+		return "", nil
+	case "udp":
+		return "udp", nil
+	}
+	return "", fmt.Errorf("unsupported port protocol %q: expected 'tcp' or 'udp'", protocol)
+}
+
 var templateRe *regexp.Regexp = regexp.MustCompile(`\{\{[^}]*\}\}`)
 
 const filenamePattern string = "[a-zA-Z0-9_.-]+"
@@ -110,6 +122,18 @@ var serverRe *regexp.Regexp = regexp.MustCompile(`\{\{\s*server\(["'](\w+)["']\)
 // {{server}}
 var shortServerRe *regexp.Regexp = regexp.MustCompile(`\{\{\s*server\s*\}\}`)
 
+// {{openssl("port_name")}}
+var opensslRe *regexp.Regexp = regexp.MustCompile(`\{\{\s*openssl\(["'](\w+)["']\)\s*\}\}`)
+
+// {{openssl}}
+var shortOpensslRe *regexp.Regexp = regexp.MustCompile(`\{\{\s*openssl\s*\}\}`)
+
+// {{ncat("port_name")}}
+var ncatRe *regexp.Regexp = regexp.MustCompile(`\{\{\s*ncat\(["'](\w+)["']\)\s*\}\}`)
+
+// {{ncat}}
+var shortNcatRe *regexp.Regexp = regexp.MustCompile(`\{\{\s*ncat\s*\}\}`)
+
 // {{lookup("key")}}
 var lookupRe *regexp.Regexp = regexp.MustCompile(`\{\{\s*lookup\(["'](\w+)["']\)\s*\}\}`)
 
@@ -204,6 +228,12 @@ func (m *Manager) validateMetadata(md *ChallengeMetadata) error {
 			r = fmt.Sprintf(`{{server("%s")}}`, portName)
 			s = shortServerRe.ReplaceAllString(s, r)
 
+			r = fmt.Sprintf(`{{openssl("%s")}}`, portName)
+			s = shortOpensslRe.ReplaceAllString(s, r)
+
+			r = fmt.Sprintf(`{{ncat("%s")}}`, portName)
+			s = shortNcatRe.ReplaceAllString(s, r)
+
 			r = fmt.Sprintf(`{{link("%s", "${1}")}}`, portName)
 			s = shortLinkRe.ReplaceAllString(s, r)
 
@@ -236,6 +266,18 @@ func (m *Manager) validateMetadata(md *ChallengeMetadata) error {
 				m.log.error(err)
 			}
 
+			matches = shortOpensslRe.FindAllString(s, -1)
+			for _, match := range matches {
+				err = fmt.Errorf(base_msg, match)
+				m.log.error(err)
+			}
+
+			matches = shortNcatRe.FindAllString(s, -1)
+			for _, match := range matches {
+				err = fmt.Errorf(base_msg, match)
+				m.log.error(err)
+			}
+
 			matches = shortLinkRe.FindAllString(s, -1)
 			for _, match := range matches {
 				err = fmt.Errorf(base_msg, match)
@@ -275,6 +317,14 @@ func (m *Manager) validateMetadata(md *ChallengeMetadata) error {
 			if res == nil {
 				res = serverRe.FindStringSubmatch(tmpl)
 			}
+			if res == nil {
+				// Connection strings include the port.
+				res = opensslRe.FindStringSubmatch(tmpl)
+				if res == nil {
+					res = ncatRe.FindStringSubmatch(tmpl)
+				}
+				isPortRef = res != nil
+			}
 
 			if res == nil || len(res) < 2 || len(md.PortMap) == 0 {
 				err = fmt.Errorf("unrecognized template string of '%s': %s", tmpl, md.Path)
@@ -323,6 +373,13 @@ func (m *Manager) validateMetadata(md *ChallengeMetadata) error {
 		}
 	}
 
+	// Validate (& normalize) the egress allowlist
+	if err := normalizeEgressPolicy(&md.ChallengeOptions.AllowEgress); err != nil {
+		lastErr = fmt.Errorf("error parsing allow_egress option: %v: %s", err, md.Path)
+		m.log.error(lastErr)
+		record(lastErr)
+	}
+
 	// Validate ContainerOptions
 	for host, opts := range md.ChallengeOptions.Overrides {
 		hostStr := ""
@@ -387,6 +444,14 @@ func (m *Manager) validateMetadata(md *ChallengeMetadata) error {
 			record(lastErr)
 		}
 
+		if opts.HealthCheck != nil {
+			if err := opts.HealthCheck.validate(); err != nil {
+				lastErr = fmt.Errorf("%sinvalid healthcheck container option: %v", hostStr, err)
+				m.log.error(lastErr)
+				record(lastErr)
+			}
+		}
+
 		droppable_capabilities := map[string]struct{}{
 			"CAP_ALL":              {},
 			"CAP_AUDIT_WRITE":      {},
@@ -593,8 +658,9 @@ func (m *Manager) processDockerfile(md *ChallengeMetadata) error {
 		return err
 	}
 
-	// Search for PUBLISH directives and associate with targets
-	re := regexp.MustCompile(`# *PUBLISH +(\d+) +AS +(\w+)\s*`)
+	// Search for PUBLISH directives, such as "# PUBLISH 53/udp AS dns", and
+	// associate with targets
+	re := regexp.MustCompile(`# *PUBLISH +(\d+)(?:/(\w+))? +AS +(\w+)\s*`)
 	publishedPorts := re.FindAllStringSubmatchIndex(dockerfile, -1)
 
 	hasBuilder := false
@@ -668,6 +734,10 @@ func (m *Manager) processDockerfile(md *ChallengeMetadata) error {
 	}
 	endpointNames := make(map[PortInfo]string, len(md.PortMap)+len(publishedPorts))
 	for portName, endpoint := range md.PortMap {
+		if endpoint.Protocol, err = normalizePortProtocol(endpoint.Protocol); err != nil {
+			return fmt.Errorf("published port %q: %w", portName, err)
+		}
+		md.PortMap[portName] = endpoint
 		if endpoint.Port <= 0 || endpoint.Port >= 65536 {
 			return fmt.Errorf(
 				"published port %q has invalid container port %d",
@@ -709,7 +779,14 @@ func (m *Manager) processDockerfile(md *ChallengeMetadata) error {
 			m.log.errorf("could not convert Dockerfile port to int: %s", err)
 			return err
 		}
-		portName := dockerfile[portMatch[4]:portMatch[5]]
+		portName := dockerfile[portMatch[6]:portMatch[7]]
+		protocol := ""
+		if portMatch[4] != -1 {
+			protocol = dockerfile[portMatch[4]:portMatch[5]]
+		}
+		if protocol, err = normalizePortProtocol(protocol); err != nil {
+			return fmt.Errorf("published port %q: %w", portName, err)
+		}
 		if port <= 0 || port >= 65536 {
 			return fmt.Errorf(
 				"published port %q has invalid container port %d",
@@ -735,7 +812,7 @@ func (m *Manager) processDockerfile(md *ChallengeMetadata) error {
 			return err
 		}
 
-		endpoint := PortInfo{Host: host.Name, Port: port}
+		endpoint := PortInfo{Host: host.Name, Port: port, Protocol: protocol}
 		if existingEndpoint, exists := md.PortMap[portName]; exists {
 			err = fmt.Errorf(
 				"published port name '%s' is declared more than once (%s:%d and %s:%d)",
@@ -779,6 +856,17 @@ func (m *Manager) processDockerfile(md *ChallengeMetadata) error {
 		}
 	}
 
+	if err = validateRoutedPorts(md); err != nil {
+		m.log.error(err)
+		return err
+	}
+
+	if md.ChallengeOptions.PacketCapture && m.kubernetes {
+		err = kubernetesUnsupported("challenges with packet_capture cannot run")
+		m.log.error(err)
+		return err
+	}
+
 	return err
 }
 
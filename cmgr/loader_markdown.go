@@ -255,10 +255,9 @@ func (m *Manager) processMarkdownSection(md *ChallengeMetadata, section string,
 
 		opts := ChallengeOptions{}
 		if yamlStart <= yamlEnd {
-			yamlData := strings.Join(lines[yamlStart:yamlEnd], "\n")
-			decoder := yaml.NewDecoder(strings.NewReader(yamlData))
-			decoder.KnownFields(true)
-			if err := decoder.Decode(&opts); err != nil {
+			var err error
+			opts, err = decodeChallengeOptions(strings.Join(lines[yamlStart:yamlEnd], "\n"))
+			if err != nil {
 				m.log.error(err)
 				sectionErrors = append(sectionErrors, err)
 			}
@@ -278,6 +277,16 @@ func (m *Manager) processMarkdownSection(md *ChallengeMetadata, section string,
 	return errors.Join(sectionErrors...)
 }
 
+// Decodes the YAML of a "Challenge Options" section, rejecting unknown
+// options.
+func decodeChallengeOptions(data string) (ChallengeOptions, error) {
+	opts := ChallengeOptions{}
+	decoder := yaml.NewDecoder(strings.NewReader(data))
+	decoder.KnownFields(true)
+	err := decoder.Decode(&opts)
+	return opts, err
+}
+
 var lineStartRe *regexp.Regexp = regexp.MustCompile(`^    |^\t`)
 
 func (m *Manager) parseHints(lines []string) ([]string, error) {
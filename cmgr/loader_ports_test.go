@@ -3,6 +3,7 @@ package cmgr
 import (
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -133,6 +134,52 @@ func TestProcessDockerfileRejectsInvalidPublishDirectivePort(t *testing.T) {
 	}
 }
 
+func TestProcessDockerfilePublishesUDPPorts(t *testing.T) {
+	manager, metadata := newPublishedPortTestChallenge(
+		t,
+		`FROM alpine AS web
+# PUBLISH 53/udp AS dns
+# PUBLISH 53/TCP AS dns_tcp
+# LAUNCH web
+`,
+	)
+
+	if err := manager.processDockerfile(metadata); err != nil {
+		t.Fatalf("UDP port was rejected: %s", err)
+	}
+	expected := map[string]PortInfo{
+		"dns":     {Host: "web", Port: 53, Protocol: "udp"},
+		"dns_tcp": {Host: "web", Port: 53},
+	}
+	if !reflect.DeepEqual(metadata.PortMap, expected) {
+		t.Fatalf("unexpected port map: %#v", metadata.PortMap)
+	}
+	if docker := metadata.PortMap["dns"].dockerPort(); docker != "53/udp" {
+		t.Fatalf("UDP port became %q", docker)
+	}
+}
+
+func TestProcessDockerfileRejectsUnsupportedProtocols(t *testing.T) {
+	manager, metadata := newPublishedPortTestChallenge(
+		t,
+		"FROM alpine AS web\n# PUBLISH 9000/sctp AS signal\n# LAUNCH web\n",
+	)
+	err := manager.processDockerfile(metadata)
+	if err == nil || !strings.Contains(err.Error(), "unsupported port protocol") {
+		t.Fatalf("unexpected protocol error: %v", err)
+	}
+
+	manager, metadata = newPublishedPortTestChallenge(
+		t,
+		"FROM alpine AS web\n# PUBLISH 53/udp AS dns\n# LAUNCH web\n",
+	)
+	metadata.ChallengeOptions.HTTPPorts = []string{"dns"}
+	err = manager.processDockerfile(metadata)
+	if err == nil || !strings.Contains(err.Error(), "not a TCP port") {
+		t.Fatalf("UDP port was accepted as an HTTP port: %v", err)
+	}
+}
+
 func TestAddChallengesPreservesConstraintErrorAndSuccessfulResults(t *testing.T) {
 	manager := newSchemaTestManager(t)
 	invalid := newAddChallengeTestMetadata(
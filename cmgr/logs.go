@@ -0,0 +1,385 @@
+package cmgr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	logFileSuffix     = ".log"
+	partialLogSuffix  = ".partial"
+	logPollInterval   = 250 * time.Millisecond
+	logTruncatedNote  = "\n[log truncated at %d bytes]\n"
+	logDirectoryMode  = 0700
+	logFilePermission = 0600
+)
+
+// A capturedLog records the output of a single build or solver check.  It is
+// written under a temporary name while the operation runs and renamed into
+// place when it finishes, which is how readers know that no more output will
+// arrive.  Output past the size limit is dropped and failures to write are
+// only logged so that capturing output can never fail the operation itself.
+type capturedLog struct {
+	file      *os.File
+	path      string
+	remaining int64
+	limit     int64
+	truncated bool
+	failed    bool
+	log       *logger
+}
+
+func (m *Manager) buildLogPath(build BuildId) string {
+	return filepath.Join(m.logDir, "builds", strconv.FormatInt(int64(build), 10)+logFileSuffix)
+}
+
+func (m *Manager) checkLogDir(instance InstanceId) string {
+	return filepath.Join(
+		m.logDir,
+		"instances",
+		strconv.FormatInt(int64(instance), 10),
+		"checks",
+	)
+}
+
+func (m *Manager) checkLogPath(instance InstanceId, check int) string {
+	return filepath.Join(m.checkLogDir(instance), strconv.Itoa(check)+logFileSuffix)
+}
+
+// Starts capturing the output of an attempt at the build, replacing the log
+// of any earlier attempt.  Returns nil, which discards output, if the log
+// cannot be created.
+func (m *Manager) openBuildLog(bMeta *BuildMetadata) *capturedLog {
+	if m.logDir == "" {
+		return nil
+	}
+	path := m.buildLogPath(bMeta.Id)
+	if err := os.MkdirAll(filepath.Dir(path), logDirectoryMode); err != nil {
+		m.log.warnf("could not create build log directory: %v", err)
+		return nil
+	}
+	// Build identifiers are reused once a failed build is removed, so the
+	// stale output of a previous attempt is discarded before starting anew.
+	_ = os.Remove(path + partialLogSuffix)
+	file, err := os.OpenFile(
+		path+partialLogSuffix,
+		os.O_WRONLY|os.O_CREATE|os.O_EXCL,
+		logFilePermission,
+	)
+	if err != nil {
+		m.log.warnf("could not create log for build %d: %v", bMeta.Id, err)
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		m.log.warnf("could not remove previous log for build %d: %v", bMeta.Id, err)
+	}
+	log := m.newCapturedLog(file, path, m.policy.MaxBuildLogBytes)
+	fmt.Fprintf(
+		log,
+		"build %d of %s (seed %d, schema %s) started at %s\n",
+		bMeta.Id,
+		bMeta.Challenge,
+		bMeta.Seed,
+		bMeta.Schema,
+		time.Now().UTC().Format(time.RFC3339),
+	)
+	return log
+}
+
+// Starts capturing the output of a new solver check of the instance and
+// returns the log along with the number identifying the check.  Only the
+// most recent checks are kept, so the logs of older finished checks are
+// removed.
+func (m *Manager) openCheckLog(instance InstanceId) (*capturedLog, int) {
+	if m.logDir == "" {
+		return nil, 0
+	}
+	dir := m.checkLogDir(instance)
+	if err := os.MkdirAll(dir, logDirectoryMode); err != nil {
+		m.log.warnf("could not create check log directory: %v", err)
+		return nil, 0
+	}
+	for {
+		checks, err := m.listCheckLogs(instance)
+		if err != nil {
+			m.log.warnf("could not list checks of instance %d: %v", instance, err)
+			return nil, 0
+		}
+		check := 1
+		if len(checks) > 0 {
+			check = checks[len(checks)-1].Number + 1
+		}
+		path := m.checkLogPath(instance, check)
+		// Concurrent checks race for the next number; the loser rescans.
+		file, err := os.OpenFile(
+			path+partialLogSuffix,
+			os.O_WRONLY|os.O_CREATE|os.O_EXCL,
+			logFilePermission,
+		)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		if err != nil {
+			m.log.warnf("could not create log for check of instance %d: %v", instance, err)
+			return nil, 0
+		}
+		maxLogBytes := m.policy.MaxSolverLogBytes
+		if maxLogBytes == 0 {
+			maxLogBytes = 1024 * 1024
+		}
+		m.pruneCheckLogs(instance, checks, check)
+		log := m.newCapturedLog(file, path, maxLogBytes)
+		fmt.Fprintf(
+			log,
+			"check %d of instance %d started at %s\n",
+			check,
+			instance,
+			time.Now().UTC().Format(time.RFC3339),
+		)
+		return log, check
+	}
+}
+
+// Removes the finished checks that fall outside of the most recent checks
+// once the new check is counted.  Running checks are left to finish.
+func (m *Manager) pruneCheckLogs(instance InstanceId, checks []InstanceCheck, newest int) {
+	keep := m.policy.MaxCheckLogs
+	if keep == 0 {
+		keep = 20
+	}
+	for _, check := range checks {
+		if check.Running || check.Number > newest-keep {
+			continue
+		}
+		path := m.checkLogPath(instance, check.Number)
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			m.log.warnf("could not remove log of check %d of instance %d: %v", check.Number, instance, err)
+		}
+	}
+}
+
+func (m *Manager) newCapturedLog(file *os.File, path string, limit int64) *capturedLog {
+	if limit == 0 {
+		limit = 4 * 1024 * 1024
+	}
+	return &capturedLog{
+		file:      file,
+		path:      path,
+		remaining: limit,
+		limit:     limit,
+		log:       m.log,
+	}
+}
+
+func (l *capturedLog) Write(p []byte) (int, error) {
+	if l == nil || l.failed || l.truncated {
+		return len(p), nil
+	}
+	data := p
+	if int64(len(data)) > l.remaining {
+		data = data[:l.remaining]
+		l.truncated = true
+	}
+	if _, err := l.file.Write(data); err != nil {
+		l.log.warnf("could not write to %s: %v", l.file.Name(), err)
+		l.failed = true
+		return len(p), nil
+	}
+	l.remaining -= int64(len(data))
+	if l.truncated {
+		fmt.Fprintf(l.file, logTruncatedNote, l.limit)
+	}
+	return len(p), nil
+}
+
+// Records the outcome of the operation and publishes the completed log.  The
+// trailer is written even when the output was truncated.
+func (l *capturedLog) finish(err error) {
+	if l == nil {
+		return
+	}
+	if !l.failed {
+		if err != nil {
+			fmt.Fprintf(l.file, "\nfailed: %v\n", err)
+		} else {
+			fmt.Fprintln(l.file, "\nsucceeded")
+		}
+	}
+	if closeErr := l.file.Close(); closeErr != nil {
+		l.log.warnf("could not close %s: %v", l.file.Name(), closeErr)
+	}
+	if renameErr := os.Rename(l.file.Name(), l.path); renameErr != nil {
+		l.log.warnf("could not publish %s: %v", l.path, renameErr)
+	}
+}
+
+// Removes the captured check logs of the instance.  Instance identifiers are
+// reused, so the history of a stopped instance must not carry over to the
+// next one.
+func (m *Manager) removeInstanceLogs(instance InstanceId) {
+	if m.logDir == "" {
+		return
+	}
+	dir := filepath.Dir(m.checkLogDir(instance))
+	if err := os.RemoveAll(dir); err != nil {
+		m.log.warnf("could not remove logs of instance %d: %v", instance, err)
+	}
+}
+
+func (m *Manager) removeBuildLog(build BuildId) {
+	if m.logDir == "" {
+		return
+	}
+	if err := os.Remove(m.buildLogPath(build)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		m.log.warnf("could not remove log of build %d: %v", build, err)
+	}
+}
+
+func (m *Manager) listCheckLogs(instance InstanceId) ([]InstanceCheck, error) {
+	checks := []InstanceCheck{}
+	if m.logDir == "" {
+		return checks, nil
+	}
+	entries, err := os.ReadDir(m.checkLogDir(instance))
+	if errors.Is(err, os.ErrNotExist) {
+		return checks, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		running := strings.HasSuffix(name, partialLogSuffix)
+		name = strings.TrimSuffix(name, partialLogSuffix)
+		if !strings.HasSuffix(name, logFileSuffix) {
+			continue
+		}
+		check, err := strconv.Atoi(strings.TrimSuffix(name, logFileSuffix))
+		if err != nil || check <= 0 {
+			continue
+		}
+		checks = append(checks, InstanceCheck{Number: check, Running: running})
+	}
+	sort.Slice(checks, func(i, j int) bool {
+		return checks[i].Number < checks[j].Number
+	})
+	return checks, nil
+}
+
+// Opens the captured log at the path, preferring the copy that is still
+// being written.
+func openCapturedLog(
+	ctx context.Context,
+	path string,
+	follow bool,
+) (io.ReadCloser, error) {
+	file, err := os.Open(path + partialLogSuffix)
+	if errors.Is(err, os.ErrNotExist) {
+		file, err = os.Open(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &logFollower{ctx: ctx, file: file, partial: path + partialLogSuffix, follow: follow}, nil
+}
+
+// A logFollower reads a captured log and, when following, waits for more
+// output until the writer publishes the completed log.
+type logFollower struct {
+	ctx     context.Context
+	file    *os.File
+	partial string
+	follow  bool
+}
+
+func (f *logFollower) Read(p []byte) (int, error) {
+	for {
+		n, err := f.file.Read(p)
+		if n > 0 || !errors.Is(err, io.EOF) {
+			return n, err
+		}
+		if !f.follow || !f.stillWriting() {
+			// Output written before the log was published is still
+			// readable through the open file.
+			n, err = f.file.Read(p)
+			if n > 0 {
+				return n, nil
+			}
+			return 0, err
+		}
+		select {
+		case <-f.ctx.Done():
+			return 0, f.ctx.Err()
+		case <-time.After(logPollInterval):
+		}
+	}
+}
+
+// Reports whether the open file is still the one being written.  A log that
+// was replaced by a newer attempt is treated as finished.
+func (f *logFollower) stillWriting() bool {
+	current, err := os.Stat(f.partial)
+	if err != nil {
+		return false
+	}
+	open, err := f.file.Stat()
+	if err != nil {
+		return false
+	}
+	return os.SameFile(current, open)
+}
+
+func (f *logFollower) Close() error {
+	return f.file.Close()
+}
+
+// Opens the output captured during the most recent attempt at the build,
+// including attempts that failed.  When follow is set, reads of a build that
+// is still running wait for more output and end once it completes or ctx is
+// cancelled.
+func (m *Manager) BuildLog(ctx context.Context, build BuildId, follow bool) (io.ReadCloser, error) {
+	if m.logDir == "" {
+		return nil, &UnknownIdentifierError{Type: "build log", Name: strconv.FormatInt(int64(build), 10)}
+	}
+	log, err := openCapturedLog(ctx, m.buildLogPath(build), follow)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, &UnknownIdentifierError{Type: "build log", Name: strconv.FormatInt(int64(build), 10)}
+	}
+	return log, err
+}
+
+// Lists the solver checks whose output was captured for the instance, oldest
+// first.
+func (m *Manager) ListInstanceChecks(instance InstanceId) ([]InstanceCheck, error) {
+	if _, err := m.lookupInstanceMetadata(instance); err != nil {
+		return nil, err
+	}
+	return m.listCheckLogs(instance)
+}
+
+// Opens the output captured during a solver check of the instance.  Follow
+// behaves as it does for BuildLog.
+func (m *Manager) CheckLog(
+	ctx context.Context,
+	instance InstanceId,
+	check int,
+	follow bool,
+) (io.ReadCloser, error) {
+	name := fmt.Sprintf("%d/%d", instance, check)
+	if m.logDir == "" || check <= 0 {
+		return nil, &UnknownIdentifierError{Type: "check log", Name: name}
+	}
+	log, err := openCapturedLog(ctx, m.checkLogPath(instance, check), follow)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, &UnknownIdentifierError{Type: "check log", Name: name}
+	}
+	return log, err
+}
@@ -0,0 +1,149 @@
+package cmgr
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newLogTestManager(t *testing.T) *Manager {
+	t.Helper()
+	return &Manager{log: newLogger(DISABLED), logDir: t.TempDir()}
+}
+
+func TestBuildLogFollowsOutputUntilPublished(t *testing.T) {
+	manager := newLogTestManager(t)
+	build := &BuildMetadata{Id: 7, Challenge: "example", Seed: 3, Schema: "event"}
+	output := manager.openBuildLog(build)
+	if output == nil {
+		t.Fatal("build log was not created")
+	}
+	io.WriteString(output, "step one\n")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	reader, err := manager.BuildLog(ctx, build.Id, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	received := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(reader)
+		received <- string(data)
+	}()
+
+	io.WriteString(output, "step two\n")
+	output.finish(errors.New("step three failed"))
+
+	data := <-received
+	for _, expected := range []string{"build 7 of example", "step one", "step two", "failed: step three failed"} {
+		if !strings.Contains(data, expected) {
+			t.Fatalf("followed log is missing %q:\n%s", expected, data)
+		}
+	}
+
+	// A later attempt replaces the log of the failed one.
+	output = manager.openBuildLog(build)
+	output.finish(nil)
+	reader, err = manager.BuildLog(ctx, build.Id, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	replaced, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(replaced, []byte("step one")) || !bytes.Contains(replaced, []byte("succeeded")) {
+		t.Fatalf("rebuild did not replace the previous log:\n%s", replaced)
+	}
+
+	var unknown *UnknownIdentifierError
+	if _, err := manager.BuildLog(ctx, 8, false); !errors.As(err, &unknown) {
+		t.Fatalf("missing build log was not reported as unknown: %v", err)
+	}
+}
+
+func TestCapturedLogEnforcesSizeLimit(t *testing.T) {
+	manager := newLogTestManager(t)
+	manager.policy.MaxBuildLogBytes = 64
+	output := manager.openBuildLog(&BuildMetadata{Id: 1})
+	io.WriteString(output, strings.Repeat("x", 100))
+	io.WriteString(output, "dropped")
+	output.finish(nil)
+
+	reader, err := manager.BuildLog(context.Background(), 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "dropped") ||
+		!strings.Contains(string(data), "[log truncated at 64 bytes]") ||
+		!strings.HasSuffix(string(data), "succeeded\n") {
+		t.Fatalf("unexpected truncated log:\n%s", data)
+	}
+}
+
+func TestCheckLogsAreNumberedPerInstance(t *testing.T) {
+	manager := newLogTestManager(t)
+	first, firstCheck := manager.openCheckLog(4)
+	first.finish(nil)
+	second, secondCheck := manager.openCheckLog(4)
+	if firstCheck != 1 || secondCheck != 2 {
+		t.Fatalf("checks were numbered %d and %d", firstCheck, secondCheck)
+	}
+
+	checks, err := manager.listCheckLogs(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []InstanceCheck{{Number: 1}, {Number: 2, Running: true}}
+	if len(checks) != len(expected) || checks[0] != expected[0] || checks[1] != expected[1] {
+		t.Fatalf("unexpected checks %#v", checks)
+	}
+	second.finish(nil)
+
+	manager.removeInstanceLogs(4)
+	checks, err = manager.listCheckLogs(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checks) != 0 {
+		t.Fatalf("checks of a removed instance remain: %#v", checks)
+	}
+	var unknown *UnknownIdentifierError
+	if _, err := manager.CheckLog(context.Background(), 4, 1, false); !errors.As(err, &unknown) {
+		t.Fatalf("removed check log was not reported as unknown: %v", err)
+	}
+}
+
+func TestCheckLogsKeepOnlyTheMostRecent(t *testing.T) {
+	manager := newLogTestManager(t)
+	manager.policy.MaxCheckLogs = 3
+	running, _ := manager.openCheckLog(9)
+	for range 5 {
+		output, _ := manager.openCheckLog(9)
+		output.finish(nil)
+	}
+
+	checks, err := manager.listCheckLogs(9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The check that is still running is kept along with the newest three.
+	expected := []InstanceCheck{{Number: 1, Running: true}, {Number: 4}, {Number: 5}, {Number: 6}}
+	if !reflect.DeepEqual(checks, expected) {
+		t.Fatalf("unexpected checks %#v", checks)
+	}
+	running.finish(nil)
+}
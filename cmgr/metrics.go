@@ -0,0 +1,199 @@
+package cmgr
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ArmyCyberInstitute/cmgr/internal/metrics"
+)
+
+// Metrics are kept per manager, so they describe the work done by this
+// process rather than every process sharing the database.  Gauges derived
+// from the database are the exception and are recomputed on every scrape.
+type managerMetrics struct {
+	registry         *metrics.Registry
+	builds           *metrics.CounterVec
+	buildDuration    *metrics.HistogramVec
+	instanceStart    *metrics.HistogramVec
+	instanceStop     *metrics.HistogramVec
+	solverChecks     *metrics.CounterVec
+	operationLock    *metrics.HistogramVec
+	activeInstances  *metrics.GaugeVec
+	portPoolSize     *metrics.GaugeVec
+	portPoolAssigned *metrics.GaugeVec
+}
+
+func newManagerMetrics() *managerMetrics {
+	r := metrics.NewRegistry()
+	return &managerMetrics{
+		registry: r,
+		builds: r.NewCounterVec(
+			"cmgr_builds_total",
+			"Image builds attempted by this process, by challenge and result.",
+			"challenge",
+			"result",
+		),
+		buildDuration: r.NewHistogramVec(
+			"cmgr_build_duration_seconds",
+			"Time taken to build the images of a challenge, by challenge and result.",
+			metrics.DefaultBuckets,
+			"challenge",
+			"result",
+		),
+		instanceStart: r.NewHistogramVec(
+			"cmgr_instance_start_duration_seconds",
+			"Time taken to start an instance, by challenge and result.",
+			metrics.DefaultBuckets,
+			"challenge",
+			"result",
+		),
+		instanceStop: r.NewHistogramVec(
+			"cmgr_instance_stop_duration_seconds",
+			"Time taken to stop an instance, by challenge and result.",
+			metrics.DefaultBuckets,
+			"challenge",
+			"result",
+		),
+		solverChecks: r.NewCounterVec(
+			"cmgr_solver_checks_total",
+			"Solver checks run by this process, by challenge and result.",
+			"challenge",
+			"result",
+		),
+		operationLock: r.NewHistogramVec(
+			"cmgr_operation_lock_wait_seconds",
+			"Time spent waiting to acquire the operation lock, by mode.",
+			metrics.DefaultBuckets,
+			"mode",
+		),
+		activeInstances: r.NewGaugeVec(
+			"cmgr_active_instances",
+			"Instances currently recorded in the database, by challenge and schema.",
+			"challenge",
+			"schema",
+		),
+		portPoolSize: r.NewGaugeVec(
+			"cmgr_port_pool_size",
+			"Ports in the CMGR_PORTS range, or zero when ephemeral ports are used.",
+		),
+		portPoolAssigned: r.NewGaugeVec(
+			"cmgr_port_pool_assigned",
+			"Ports in the CMGR_PORTS range currently assigned to instances.",
+		),
+	}
+}
+
+func outcome(err error, success, failure string) string {
+	if err != nil {
+		return failure
+	}
+	return success
+}
+
+func (mm *managerMetrics) observeBuild(challenge ChallengeId, started time.Time, err error) {
+	if mm == nil {
+		return
+	}
+	result := outcome(err, "success", "failure")
+	mm.builds.Inc(string(challenge), result)
+	mm.buildDuration.Observe(time.Since(started).Seconds(), string(challenge), result)
+}
+
+func (mm *managerMetrics) observeInstanceStart(challenge ChallengeId, started time.Time, err error) {
+	if mm == nil {
+		return
+	}
+	mm.instanceStart.Observe(
+		time.Since(started).Seconds(),
+		string(challenge),
+		outcome(err, "success", "failure"),
+	)
+}
+
+func (mm *managerMetrics) observeInstanceStop(challenge ChallengeId, started time.Time, err error) {
+	if mm == nil {
+		return
+	}
+	mm.instanceStop.Observe(
+		time.Since(started).Seconds(),
+		string(challenge),
+		outcome(err, "success", "failure"),
+	)
+}
+
+func (mm *managerMetrics) observeSolverCheck(challenge ChallengeId, err error) {
+	if mm == nil {
+		return
+	}
+	mm.solverChecks.Inc(string(challenge), outcome(err, "pass", "fail"))
+}
+
+func (mm *managerMetrics) observeOperationLockWait(exclusive bool, started time.Time) {
+	if mm == nil {
+		return
+	}
+	mode := "shared"
+	if exclusive {
+		mode = "exclusive"
+	}
+	mm.operationLock.Observe(time.Since(started).Seconds(), mode)
+}
+
+type activeInstanceCount struct {
+	Challenge ChallengeId `db:"challenge"`
+	Schema    string      `db:"schema"`
+	Count     int         `db:"count"`
+}
+
+// Refreshes the gauges that are derived from the database.
+func (m *Manager) refreshMetrics() error {
+	counts := []activeInstanceCount{}
+	err := m.db.Select(
+		&counts,
+		`SELECT builds.challenge AS challenge, builds.schema AS schema, COUNT(*) AS count
+		 FROM instances JOIN builds ON instances.build = builds.id
+		 GROUP BY builds.challenge, builds.schema;`,
+	)
+	if err != nil {
+		return fmt.Errorf("could not count active instances: %w", err)
+	}
+	samples := make([]metrics.Sample, 0, len(counts))
+	for _, count := range counts {
+		samples = append(samples, metrics.Sample{
+			LabelValues: []string{string(count.Challenge), count.Schema},
+			Value:       float64(count.Count),
+		})
+	}
+	m.metrics.activeInstances.Replace(samples)
+
+	poolSize := 0
+	assigned := 0
+	if m.portLow != 0 {
+		poolSize = m.portHigh - m.portLow + 1
+		ports, err := m.usedPortSet()
+		if err != nil {
+			return fmt.Errorf("could not load assigned ports: %w", err)
+		}
+		for port := range ports {
+			if port >= m.portLow && port <= m.portHigh {
+				assigned++
+			}
+		}
+	}
+	m.metrics.portPoolSize.Set(float64(poolSize))
+	m.metrics.portPoolAssigned.Set(float64(assigned))
+	return nil
+}
+
+// Writes the metrics of this manager in the Prometheus text exposition
+// format.
+func (m *Manager) WriteMetrics(w io.Writer) error {
+	if m.metrics == nil {
+		return nil
+	}
+	if err := m.refreshMetrics(); err != nil {
+		return err
+	}
+	return m.metrics.registry.WriteText(w)
+}
@@ -0,0 +1,60 @@
+package cmgr
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteMetricsReportsDatabaseGaugesAndObservations(t *testing.T) {
+	manager := newSchemaTestManager(t)
+	manager.metrics = newManagerMetrics()
+	manager.portLow = 30000
+	manager.portHigh = 30009
+	insertCompleteConstraintFixture(t, manager.db)
+	// Ports outside of the range are not part of the pool.
+	requireExec(
+		t,
+		manager.db,
+		"INSERT INTO portAssignments(instance, name, port) VALUES (1, 'other', 40000);",
+	)
+
+	started := time.Now()
+	manager.metrics.observeBuild("challenge", started, nil)
+	manager.metrics.observeBuild("challenge", started, errors.New("failed"))
+	manager.metrics.observeSolverCheck("challenge", nil)
+	manager.metrics.observeOperationLockWait(true, started)
+
+	var output strings.Builder
+	if err := manager.WriteMetrics(&output); err != nil {
+		t.Fatal(err)
+	}
+	text := output.String()
+	for _, expected := range []string{
+		"# TYPE cmgr_builds_total counter\n",
+		`cmgr_builds_total{challenge="challenge",result="failure"} 1` + "\n",
+		`cmgr_builds_total{challenge="challenge",result="success"} 1` + "\n",
+		`cmgr_build_duration_seconds_count{challenge="challenge",result="success"} 1` + "\n",
+		`cmgr_solver_checks_total{challenge="challenge",result="pass"} 1` + "\n",
+		`cmgr_operation_lock_wait_seconds_count{mode="exclusive"} 1` + "\n",
+		`cmgr_active_instances{challenge="challenge",schema="schema"} 1` + "\n",
+		"cmgr_port_pool_size 10\n",
+		"cmgr_port_pool_assigned 1\n",
+	} {
+		if !strings.Contains(text, expected) {
+			t.Fatalf("metrics are missing %q:\n%s", expected, text)
+		}
+	}
+
+	requireExec(t, manager.db, "DELETE FROM portAssignments;")
+	requireExec(t, manager.db, "DELETE FROM containers;")
+	requireExec(t, manager.db, "DELETE FROM instances;")
+	output.Reset()
+	if err := manager.WriteMetrics(&output); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(output.String(), "cmgr_active_instances{") {
+		t.Fatalf("stopped instances are still reported:\n%s", output.String())
+	}
+}
@@ -0,0 +1,573 @@
+package cmgr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/containerd/errdefs"
+	"github.com/moby/moby/client"
+)
+
+// Labels the networks and containers of an instance with the name of the
+// node that runs it.
+const nodeLabel = "cmgr.node"
+
+var nodeNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// One of the Docker daemons in a pool.
+type dockerNode struct {
+	ContainerRuntime
+	name string
+	// The address players reach the node's published ports at, or empty to
+	// report the addresses in CMGR_INTERFACE.
+	address string
+}
+
+// Spreads instances across several Docker daemons.  The first node builds
+// every image, which is copied to another node the first time one of its
+// containers is created there: through the challenge registry when one is
+// configured and by saving and loading the image otherwise.  Each container
+// ID is prefixed with the name of its node, so operations on a container
+// reach the node that runs it, and the networks and containers of an
+// instance are created on the node named by their `cmgr.node` label.
+type dockerPool struct {
+	nodes        []*dockerNode
+	registry     string
+	registryAuth string
+
+	mu sync.Mutex
+	// The node of each network created since cmgr started.
+	networks map[string]*dockerNode
+}
+
+var _ ContainerRuntime = (*dockerPool)(nil)
+
+func newDockerPool(nodes []*dockerNode, registry string, registryAuth string) *dockerPool {
+	return &dockerPool{
+		nodes:        nodes,
+		registry:     registry,
+		registryAuth: registryAuth,
+		networks:     make(map[string]*dockerNode),
+	}
+}
+
+// Parses a comma-separated list of "name=host" entries naming each node and
+// the address of its Docker daemon, such as "a=tcp://10.0.0.2:2376".  An
+// entry may add "=address" for the public address of the node; otherwise the
+// daemon's host is used unless it is a local socket.
+func parseDockerNodes(value string) ([]*dockerNode, []string, error) {
+	var nodes []*dockerNode
+	var hosts []string
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(value, ",") {
+		fields := strings.SplitN(strings.TrimSpace(entry), "=", 3)
+		if len(fields) < 2 || !nodeNameRe.MatchString(fields[0]) || fields[1] == "" {
+			return nil, nil, fmt.Errorf("invalid Docker node %q in %s", entry, DOCKER_NODES_ENV)
+		}
+		if seen[fields[0]] {
+			return nil, nil, fmt.Errorf("duplicate Docker node %q in %s", fields[0], DOCKER_NODES_ENV)
+		}
+		seen[fields[0]] = true
+		node := &dockerNode{name: fields[0]}
+		if len(fields) == 3 {
+			node.address = fields[2]
+		} else if host, err := url.Parse(fields[1]); err == nil && host.Scheme != "unix" && host.Scheme != "npipe" {
+			node.address = host.Hostname()
+		}
+		nodes = append(nodes, node)
+		hosts = append(hosts, fields[1])
+	}
+	return nodes, hosts, nil
+}
+
+// Connects to each Docker daemon in CMGR_DOCKER_NODES.  Apart from the host,
+// every client is configured by Docker's standard environment variables.
+func newDockerPoolFromEnv(value string, registry string, registryAuth string) (*dockerPool, error) {
+	nodes, hosts, err := parseDockerNodes(value)
+	if err != nil {
+		return nil, err
+	}
+	for i, node := range nodes {
+		node.ContainerRuntime, err = client.New(client.FromEnv, client.WithHost(hosts[i]))
+		if err != nil {
+			return nil, fmt.Errorf("could not create docker client for node %s: %w", node.name, err)
+		}
+	}
+	return newDockerPool(nodes, registry, registryAuth), nil
+}
+
+func (p *dockerPool) primary() *dockerNode {
+	return p.nodes[0]
+}
+
+func (p *dockerPool) node(name string) *dockerNode {
+	for _, node := range p.nodes {
+		if node.name == name {
+			return node
+		}
+	}
+	return nil
+}
+
+// Finds the node named by the labels, which is the first node when they name
+// none.
+func (p *dockerPool) labelledNode(labels map[string]string) (*dockerNode, error) {
+	name, labelled := labels[nodeLabel]
+	if !labelled {
+		return p.primary(), nil
+	}
+	node := p.node(name)
+	if node == nil {
+		return nil, fmt.Errorf("unknown Docker node %q: %w", name, errdefs.ErrNotFound)
+	}
+	return node, nil
+}
+
+// Splits a container ID into its node and the ID the node knows it by.  IDs
+// without a node are from before the pool was configured and belong to the
+// first node.
+func (p *dockerPool) container(id string) (*dockerNode, string, error) {
+	name, nodeID, found := strings.Cut(id, "/")
+	if !found {
+		return p.primary(), id, nil
+	}
+	node := p.node(name)
+	if node == nil {
+		return nil, "", fmt.Errorf(
+			"container %s is on unknown Docker node %q: %w",
+			id,
+			name,
+			errdefs.ErrNotFound,
+		)
+	}
+	return node, nodeID, nil
+}
+
+// Copies an image from the first node to another node that does not have it.
+func (p *dockerPool) ensureImage(ctx context.Context, node *dockerNode, image string) error {
+	if node == p.primary() {
+		return nil
+	}
+	if _, err := node.ImageInspect(ctx, image); !errdefs.IsNotFound(err) {
+		return err
+	}
+	source := p.primary()
+	if _, err := source.ImageInspect(ctx, image); err != nil {
+		return err
+	}
+	if p.registry == "" {
+		saved, err := source.ImageSave(ctx, []string{image})
+		if err != nil {
+			return fmt.Errorf("could not save image %s: %w", image, err)
+		}
+		defer saved.Close()
+		loaded, err := node.ImageLoad(ctx, saved)
+		if err != nil {
+			return fmt.Errorf("could not load image %s on node %s: %w", image, node.name, err)
+		}
+		return consumeDockerProgress(loaded, "image load", nil)
+	}
+
+	remote := p.registry + "/" + image
+	if _, err := source.ImageTag(ctx, client.ImageTagOptions{Source: image, Target: remote}); err != nil {
+		return err
+	}
+	push, err := source.ImagePush(ctx, remote, client.ImagePushOptions{RegistryAuth: p.registryAuth})
+	if err == nil {
+		err = consumeDockerProgress(push, "image push", nil)
+	}
+	if err == nil {
+		var pull client.ImagePullResponse
+		pull, err = node.ImagePull(ctx, remote, client.ImagePullOptions{RegistryAuth: p.registryAuth})
+		if err == nil {
+			err = consumeDockerProgress(pull, "image pull", nil)
+		}
+	}
+	if err == nil {
+		_, err = node.ImageTag(ctx, client.ImageTagOptions{Source: remote, Target: image})
+	}
+	// Only the image's own name is kept on either node.
+	_, sourceErr := source.ImageRemove(ctx, remote, client.ImageRemoveOptions{})
+	_, nodeErr := node.ImageRemove(ctx, remote, client.ImageRemoveOptions{})
+	if errdefs.IsNotFound(nodeErr) {
+		nodeErr = nil
+	}
+	if err = errors.Join(err, sourceErr, nodeErr); err != nil {
+		return fmt.Errorf("could not copy image %s to node %s: %w", image, node.name, err)
+	}
+	return nil
+}
+
+func (p *dockerPool) Ping(ctx context.Context, options client.PingOptions) (client.PingResult, error) {
+	var result client.PingResult
+	for i, node := range p.nodes {
+		ping, err := node.Ping(ctx, options)
+		if err != nil {
+			return result, fmt.Errorf("could not reach Docker node %s: %w", node.name, err)
+		}
+		if i == 0 {
+			result = ping
+		}
+	}
+	return result, nil
+}
+
+func (p *dockerPool) Info(ctx context.Context, options client.InfoOptions) (client.SystemInfoResult, error) {
+	return p.primary().Info(ctx, options)
+}
+
+func (p *dockerPool) ImageBuild(
+	ctx context.Context,
+	buildContext io.Reader,
+	options client.ImageBuildOptions,
+) (client.ImageBuildResult, error) {
+	return p.primary().ImageBuild(ctx, buildContext, options)
+}
+
+func (p *dockerPool) ImagePull(
+	ctx context.Context,
+	ref string,
+	options client.ImagePullOptions,
+) (client.ImagePullResponse, error) {
+	return p.primary().ImagePull(ctx, ref, options)
+}
+
+func (p *dockerPool) ImagePush(
+	ctx context.Context,
+	image string,
+	options client.ImagePushOptions,
+) (client.ImagePushResponse, error) {
+	return p.primary().ImagePush(ctx, image, options)
+}
+
+func (p *dockerPool) ImageInspect(
+	ctx context.Context,
+	image string,
+	options ...client.ImageInspectOption,
+) (client.ImageInspectResult, error) {
+	return p.primary().ImageInspect(ctx, image, options...)
+}
+
+func (p *dockerPool) ImageList(ctx context.Context, options client.ImageListOptions) (client.ImageListResult, error) {
+	return p.primary().ImageList(ctx, options)
+}
+
+func (p *dockerPool) ImageTag(ctx context.Context, options client.ImageTagOptions) (client.ImageTagResult, error) {
+	return p.primary().ImageTag(ctx, options)
+}
+
+// Removes the image from every node that has a copy of it.
+func (p *dockerPool) ImageRemove(
+	ctx context.Context,
+	image string,
+	options client.ImageRemoveOptions,
+) (client.ImageRemoveResult, error) {
+	result, err := p.primary().ImageRemove(ctx, image, options)
+	errs := []error{err}
+	for _, node := range p.nodes[1:] {
+		if _, err := node.ImageRemove(ctx, image, options); err != nil && !errdefs.IsNotFound(err) {
+			errs = append(errs, fmt.Errorf("node %s: %w", node.name, err))
+		}
+	}
+	return result, errors.Join(errs...)
+}
+
+func (p *dockerPool) ImageSave(
+	ctx context.Context,
+	images []string,
+	options ...client.ImageSaveOption,
+) (client.ImageSaveResult, error) {
+	return p.primary().ImageSave(ctx, images, options...)
+}
+
+func (p *dockerPool) ImageLoad(
+	ctx context.Context,
+	input io.Reader,
+	options ...client.ImageLoadOption,
+) (client.ImageLoadResult, error) {
+	return p.primary().ImageLoad(ctx, input, options...)
+}
+
+func (p *dockerPool) ContainerCreate(
+	ctx context.Context,
+	options client.ContainerCreateOptions,
+) (client.ContainerCreateResult, error) {
+	var labels map[string]string
+	if options.Config != nil {
+		labels = options.Config.Labels
+	}
+	node, err := p.labelledNode(labels)
+	if err != nil {
+		return client.ContainerCreateResult{}, err
+	}
+	if options.Config != nil {
+		if err := p.ensureImage(ctx, node, options.Config.Image); err != nil {
+			return client.ContainerCreateResult{}, err
+		}
+	}
+	result, err := node.ContainerCreate(ctx, options)
+	if err != nil {
+		return result, err
+	}
+	result.ID = node.name + "/" + result.ID
+	return result, nil
+}
+
+func (p *dockerPool) ContainerStart(
+	ctx context.Context,
+	id string,
+	options client.ContainerStartOptions,
+) (client.ContainerStartResult, error) {
+	node, nodeID, err := p.container(id)
+	if err != nil {
+		return client.ContainerStartResult{}, err
+	}
+	return node.ContainerStart(ctx, nodeID, options)
+}
+
+func (p *dockerPool) ContainerStop(
+	ctx context.Context,
+	id string,
+	options client.ContainerStopOptions,
+) (client.ContainerStopResult, error) {
+	node, nodeID, err := p.container(id)
+	if err != nil {
+		return client.ContainerStopResult{}, err
+	}
+	return node.ContainerStop(ctx, nodeID, options)
+}
+
+func (p *dockerPool) ContainerUpdate(
+	ctx context.Context,
+	id string,
+	options client.ContainerUpdateOptions,
+) (client.ContainerUpdateResult, error) {
+	node, nodeID, err := p.container(id)
+	if err != nil {
+		return client.ContainerUpdateResult{}, err
+	}
+	return node.ContainerUpdate(ctx, nodeID, options)
+}
+
+func (p *dockerPool) ContainerWait(
+	ctx context.Context,
+	id string,
+	options client.ContainerWaitOptions,
+) client.ContainerWaitResult {
+	node, nodeID, err := p.container(id)
+	if err != nil {
+		errs := make(chan error, 1)
+		errs <- err
+		return client.ContainerWaitResult{Error: errs}
+	}
+	return node.ContainerWait(ctx, nodeID, options)
+}
+
+// Reports the container under the ID the pool knows it by.
+func (p *dockerPool) ContainerInspect(
+	ctx context.Context,
+	id string,
+	options client.ContainerInspectOptions,
+) (client.ContainerInspectResult, error) {
+	node, nodeID, err := p.container(id)
+	if err != nil {
+		return client.ContainerInspectResult{}, err
+	}
+	result, err := node.ContainerInspect(ctx, nodeID, options)
+	if err == nil {
+		result.Container.ID = id
+	}
+	return result, err
+}
+
+func (p *dockerPool) ContainerLogs(
+	ctx context.Context,
+	id string,
+	options client.ContainerLogsOptions,
+) (client.ContainerLogsResult, error) {
+	node, nodeID, err := p.container(id)
+	if err != nil {
+		return nil, err
+	}
+	return node.ContainerLogs(ctx, nodeID, options)
+}
+
+func (p *dockerPool) ContainerStats(
+	ctx context.Context,
+	id string,
+	options client.ContainerStatsOptions,
+) (client.ContainerStatsResult, error) {
+	node, nodeID, err := p.container(id)
+	if err != nil {
+		return client.ContainerStatsResult{}, err
+	}
+	return node.ContainerStats(ctx, nodeID, options)
+}
+
+func (p *dockerPool) ContainerRemove(
+	ctx context.Context,
+	id string,
+	options client.ContainerRemoveOptions,
+) (client.ContainerRemoveResult, error) {
+	node, nodeID, err := p.container(id)
+	if err != nil {
+		return client.ContainerRemoveResult{}, err
+	}
+	return node.ContainerRemove(ctx, nodeID, options)
+}
+
+func (p *dockerPool) CopyFromContainer(
+	ctx context.Context,
+	id string,
+	options client.CopyFromContainerOptions,
+) (client.CopyFromContainerResult, error) {
+	node, nodeID, err := p.container(id)
+	if err != nil {
+		return client.CopyFromContainerResult{}, err
+	}
+	return node.CopyFromContainer(ctx, nodeID, options)
+}
+
+func (p *dockerPool) NetworkCreate(
+	ctx context.Context,
+	name string,
+	options client.NetworkCreateOptions,
+) (client.NetworkCreateResult, error) {
+	node, err := p.labelledNode(options.Labels)
+	if err != nil {
+		return client.NetworkCreateResult{}, err
+	}
+	result, err := node.NetworkCreate(ctx, name, options)
+	if err == nil {
+		p.mu.Lock()
+		p.networks[name] = node
+		p.mu.Unlock()
+	}
+	return result, err
+}
+
+// Removes the network from its node.  A network created before cmgr started
+// is removed from whichever node has it.
+func (p *dockerPool) NetworkRemove(
+	ctx context.Context,
+	name string,
+	options client.NetworkRemoveOptions,
+) (client.NetworkRemoveResult, error) {
+	p.mu.Lock()
+	node, known := p.networks[name]
+	p.mu.Unlock()
+	nodes := p.nodes
+	if known {
+		nodes = []*dockerNode{node}
+	}
+	var result client.NetworkRemoveResult
+	err := fmt.Errorf("network %s not found on any Docker node: %w", name, errdefs.ErrNotFound)
+	for _, node := range nodes {
+		result, err = node.NetworkRemove(ctx, name, options)
+		if !errdefs.IsNotFound(err) {
+			break
+		}
+	}
+	if err == nil {
+		p.mu.Lock()
+		delete(p.networks, name)
+		p.mu.Unlock()
+	}
+	return result, err
+}
+
+// The labels that place an instance's networks and containers on its node.
+func (i *InstanceMetadata) nodeLabels() map[string]string {
+	if i.Node == "" {
+		return nil
+	}
+	return map[string]string{nodeLabel: i.Node}
+}
+
+// Whether an instance of the challenge relies on the host cmgr runs on, which
+// the first node of a pool must share: its captures are written to cmgr's
+// disk, its egress allowlist is enforced by cmgr's firewall, or cmgrd's
+// reverse proxy or TLS gateway dials its bridge.
+func (m *Manager) needsPrimaryNode(cMeta *ChallengeMetadata) (bool, error) {
+	options := cMeta.ChallengeOptions
+	if options.PacketCapture || options.AllowEgress.restricted() {
+		return true, nil
+	}
+	routed, err := m.routedPorts(cMeta.Id)
+	return len(routed) != 0, err
+}
+
+// Whether the instance runs on the host cmgr runs on.
+func (m *Manager) onPrimaryNode(instance *InstanceMetadata) bool {
+	return m.pool == nil || instance.Node == "" || instance.Node == m.pool.primary().name
+}
+
+// Returns the public address of the node, if it has one.
+func (m *Manager) nodeAddress(name string) string {
+	if m.pool == nil {
+		return ""
+	}
+	if node := m.pool.node(name); node != nil {
+		return node.address
+	}
+	return ""
+}
+
+// Picks the node with the most free capacity for an instance that reserves
+// the given resources: the node whose scarcer of CPUs and memory would have
+// the largest share left once the instance and the instances already on the
+// node are counted, with ties going to the node with fewer instances.  Nodes
+// that cannot report their resources are skipped.
+func (m *Manager) placeInstance(need resourceReservation) (string, error) {
+	counts, err := m.queryInstanceCounts()
+	if err != nil {
+		return "", err
+	}
+	reserved := make(map[string]resourceReservation)
+	instances := make(map[string]int)
+	for _, count := range counts {
+		node := count.Node
+		if node == "" {
+			node = m.pool.primary().name
+		}
+		reservation, err := m.buildReservation(count.Build)
+		if err != nil {
+			return "", err
+		}
+		reserved[node] = reserved[node].plus(reservation.times(count.Count))
+		instances[node] += count.Count
+	}
+
+	best := ""
+	bestFree := 0.0
+	for _, node := range m.pool.nodes {
+		info, err := node.Info(m.ctx, client.InfoOptions{})
+		if err != nil {
+			m.log.warnf("skipping unreachable Docker node %s: %s", node.name, err)
+			continue
+		}
+		used := reserved[node.name].plus(need)
+		free := 1.0
+		if cpus := int64(info.Info.NCPU) * 1_000_000_000; cpus > 0 {
+			free = min(free, 1-float64(used.nanoCPUs)/float64(cpus))
+		}
+		if memory := info.Info.MemTotal; memory > 0 {
+			free = min(free, 1-float64(used.memoryBytes)/float64(memory))
+		}
+		if best == "" || free > bestFree ||
+			(free == bestFree && instances[node.name] < instances[best]) {
+			best = node.name
+			bestFree = free
+		}
+	}
+	if best == "" {
+		return "", errors.New("no Docker node is reachable")
+	}
+	return best, nil
+}
@@ -0,0 +1,229 @@
+package cmgr
+
+import (
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseDockerNodes(t *testing.T) {
+	nodes, hosts, err := parseDockerNodes(
+		"a=tcp://10.0.0.2:2376, b=ssh://docker@node-b=203.0.113.9,local=unix:///var/run/docker.sock",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got [][2]string
+	for _, node := range nodes {
+		got = append(got, [2]string{node.name, node.address})
+	}
+	want := [][2]string{{"a", "10.0.0.2"}, {"b", "203.0.113.9"}, {"local", ""}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("parsed nodes %v, want %v", got, want)
+	}
+	wantHosts := []string{"tcp://10.0.0.2:2376", "ssh://docker@node-b", "unix:///var/run/docker.sock"}
+	if !reflect.DeepEqual(hosts, wantHosts) {
+		t.Fatalf("parsed hosts %v, want %v", hosts, wantHosts)
+	}
+
+	for _, value := range []string{"", "a", "a=", "A=tcp://host:2376", "a=tcp://x:1,a=tcp://y:1"} {
+		if _, _, err := parseDockerNodes(value); err == nil {
+			t.Errorf("%q was accepted", value)
+		}
+	}
+}
+
+func TestDockerPoolSpreadsInstances(t *testing.T) {
+	first := newFakeRuntime()
+	second := newFakeRuntime()
+	pool := newDockerPool([]*dockerNode{
+		{ContainerRuntime: first, name: "a", address: "198.51.100.1"},
+		{ContainerRuntime: second, name: "b", address: "198.51.100.2"},
+	}, "", "")
+	t.Setenv(PORTS_ENV, "50000-50999")
+	manager := newFakeRuntimeManager(t, pool)
+	build, onFirst := startFakeRuntimeInstance(t, manager)
+	secondID, err := manager.Start(build.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	onSecond, err := manager.GetInstanceMetadata(secondID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if onFirst.Node != "a" || onSecond.Node != "b" {
+		t.Fatalf("instances were placed on %q and %q", onFirst.Node, onSecond.Node)
+	}
+	if !strings.HasPrefix(onSecond.Containers[0], "b/") ||
+		!second.isRunning(strings.TrimPrefix(onSecond.Containers[0], "b/")) {
+		t.Fatalf("container %s is not running on node b", onSecond.Containers[0])
+	}
+	if !second.hasNetwork(onSecond.getNetworkName()) || first.hasNetwork(onSecond.getNetworkName()) {
+		t.Fatal("network was not created on node b only")
+	}
+	name := buildImageName(build.Challenge, build, build.Images[0], "")
+	if !second.hasImage(name) {
+		t.Fatalf("image %s was not copied to node b", name)
+	}
+	if onSecond.NodeAddress != "198.51.100.2" {
+		t.Fatalf("node address is %q", onSecond.NodeAddress)
+	}
+	port := onSecond.Ports["socat"]
+	want := []string{"198.51.100.2:" + strconv.Itoa(port)}
+	if got := onSecond.Addresses["socat"]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("addresses are %v, want %v", got, want)
+	}
+
+	if err := manager.Stop(onSecond.Id); err != nil {
+		t.Fatal(err)
+	}
+	if second.hasNetwork(onSecond.getNetworkName()) || second.containerCount() != 0 {
+		t.Fatal("instance on node b was not removed")
+	}
+	if err := manager.Stop(onFirst.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.Destroy(build.Id); err != nil {
+		t.Fatal(err)
+	}
+	if first.hasImage(name) || second.hasImage(name) {
+		t.Fatalf("image %s remains on a node", name)
+	}
+}
+
+func TestDockerPoolCopiesImagesThroughRegistry(t *testing.T) {
+	first := newFakeRuntime()
+	second := newFakeRuntime()
+	// Both nodes reach the same registry.
+	second.registry = first.registry
+	pool := newDockerPool([]*dockerNode{
+		{ContainerRuntime: first, name: "a"},
+		{ContainerRuntime: second, name: "b"},
+	}, "registry.example.com/cmgr", "")
+	t.Setenv(PORTS_ENV, "50000-50999")
+	manager := newFakeRuntimeManager(t, pool)
+	build, _ := startFakeRuntimeInstance(t, manager)
+	if _, err := manager.Start(build.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	name := buildImageName(build.Challenge, build, build.Images[0], "")
+	remote := "registry.example.com/cmgr/" + name
+	if !reflect.DeepEqual(first.pushed, []string{remote}) {
+		t.Fatalf("pushed %v, want %v", first.pushed, []string{remote})
+	}
+	if !second.hasImage(name) || second.hasImage(remote) || first.hasImage(remote) {
+		t.Fatal("image was not copied under its own name only")
+	}
+}
+
+func TestDockerPoolRequiresPortRange(t *testing.T) {
+	pool := newDockerPool([]*dockerNode{
+		{ContainerRuntime: newFakeRuntime(), name: "a"},
+		{ContainerRuntime: newFakeRuntime(), name: "b"},
+	}, "", "")
+	t.Setenv(PORTS_ENV, "")
+	t.Setenv(DB_ENV, filepath.Join(t.TempDir(), "cmgr.db"))
+	if manager := NewManagerWithRuntime(DISABLED, pool); manager != nil {
+		manager.db.Close()
+		t.Fatal("a pool of Docker nodes was accepted without a port range")
+	}
+}
+
+// Starts two instances of the test challenge on a pool of two nodes after
+// configure has changed the challenge's stored network options, and checks
+// that both stay on the first node.
+func startPinnedPoolInstances(
+	t *testing.T,
+	configure func(*Manager),
+) (*Manager, *fakeRuntime, []*InstanceMetadata) {
+	t.Helper()
+	first := newFakeRuntime()
+	first.registry[defaultCaptureImage] = true
+	second := newFakeRuntime()
+	pool := newDockerPool([]*dockerNode{
+		{ContainerRuntime: first, name: "a"},
+		{ContainerRuntime: second, name: "b"},
+	}, "", "")
+	t.Setenv(PORTS_ENV, "50000-50999")
+	manager := newFakeRuntimeManager(t, pool)
+	if updates := manager.Update(""); len(updates.Errors) != 0 {
+		t.Fatalf("could not load challenges: %v", updates.Errors)
+	}
+	configure(manager)
+	builds, err := manager.Build(fakeRuntimeChallenge, []int{7}, "flag{%s}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var instances []*InstanceMetadata
+	for range 2 {
+		instanceID, err := manager.Start(builds[0].Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		instance, err := manager.GetInstanceMetadata(instanceID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if instance.Node != "a" {
+			t.Fatalf("instance %d was placed on %q", instance.Id, instance.Node)
+		}
+		instances = append(instances, instance)
+	}
+	if second.containerCount() != 0 {
+		t.Fatalf("%d containers run on node b", second.containerCount())
+	}
+	return manager, first, instances
+}
+
+func TestDockerPoolKeepsCapturedInstancesOnFirstNode(t *testing.T) {
+	manager, first, instances := startPinnedPoolInstances(t, func(manager *Manager) {
+		requireExec(t, manager.db, "UPDATE networkOptions SET capture = 1;")
+	})
+	var binds []string
+	for _, created := range first.containers {
+		binds = append(binds, created.hostConfig.Binds...)
+	}
+	for _, instance := range instances {
+		bind := manager.instanceCaptureDir(instance.Id) + ":/captures"
+		if !slices.Contains(binds, bind) {
+			t.Fatalf("no capture sidecar on node a writes to %s: %v", bind, binds)
+		}
+	}
+
+	onSecond := &InstanceMetadata{Id: instances[0].Id, Node: "b"}
+	if err := manager.startCapture(onSecond, onSecond.getNetworkName()); err == nil {
+		t.Fatal("traffic of an instance on node b was captured")
+	}
+}
+
+func TestDockerPoolKeepsAllowlistedInstancesOnFirstNode(t *testing.T) {
+	firewall := &fakeEgressFirewall{}
+	manager, _, instances := startPinnedPoolInstances(t, func(manager *Manager) {
+		manager.firewall = firewall
+		requireExec(t, manager.db, `UPDATE networkOptions SET allowegress = 0, egress = '["10.0.5.10:443/tcp"]';`)
+	})
+	for _, instance := range instances {
+		if _, allowed := firewall.allowed[instance.getNetworkName()]; !allowed {
+			t.Fatalf("egress of instance %d was not restricted: %v", instance.Id, firewall.allowed)
+		}
+	}
+
+	onSecond := &InstanceMetadata{Id: 3, Node: "b"}
+	options := NetworkOptions{AllowEgress: EgressPolicy{Destinations: []string{"10.0.5.10:443/tcp"}}}
+	if err := manager.startNetwork(onSecond, options); err == nil {
+		t.Fatal("an allowlisted network was created on node b")
+	}
+}
+
+func TestDockerPoolKeepsRoutedInstancesOnFirstNode(t *testing.T) {
+	startPinnedPoolInstances(t, func(manager *Manager) {
+		manager.policy.TLSGateway = "tcp.example.com:443"
+		requireExec(t, manager.db, `UPDATE networkOptions SET tlsports = '["socat"]';`)
+	})
+}
@@ -7,22 +7,24 @@ import (
 	"path/filepath"
 	"sync"
 	"syscall"
+	"time"
 )
 
 const operationLockSuffix = ".cmgr.lock"
 const operationGateSuffix = ".gate"
 const portLockSuffix = ".ports"
+const capacityLockSuffix = ".capacity"
 
-type localPortLock struct {
+type localLock struct {
 	mu   sync.Mutex
 	refs int
 }
 
-var localPortLocks = struct {
+var localLocks = struct {
 	sync.Mutex
-	locks map[string]*localPortLock
+	locks map[string]*localLock
 }{
-	locks: make(map[string]*localPortLock),
+	locks: make(map[string]*localLock),
 }
 
 func canonicalDatabasePath(databasePath string) (string, error) {
@@ -71,6 +73,7 @@ func (m *Manager) initOperationLock() error {
 	if path != "" {
 		m.operationGatePath = path + operationGateSuffix
 		m.portLockPath = path + portLockSuffix
+		m.capacityLockPath = path + capacityLockSuffix
 	}
 	return nil
 }
@@ -95,28 +98,35 @@ func portAllocationLockPath(m *Manager) string {
 	return m.operationLockPath + portLockSuffix
 }
 
-func acquireLocalPortLock(path string) func() {
-	if path == "" {
-		path = ":memory:"
+func capacityLockPath(m *Manager) string {
+	if m.capacityLockPath != "" {
+		return m.capacityLockPath
 	}
-	localPortLocks.Lock()
-	lock := localPortLocks.locks[path]
+	if m.operationLockPath == "" {
+		return ""
+	}
+	return m.operationLockPath + capacityLockSuffix
+}
+
+func acquireLocalLock(key string) func() {
+	localLocks.Lock()
+	lock := localLocks.locks[key]
 	if lock == nil {
-		lock = new(localPortLock)
-		localPortLocks.locks[path] = lock
+		lock = new(localLock)
+		localLocks.locks[key] = lock
 	}
 	lock.refs++
-	localPortLocks.Unlock()
+	localLocks.Unlock()
 
 	lock.mu.Lock()
 	return func() {
 		lock.mu.Unlock()
-		localPortLocks.Lock()
+		localLocks.Lock()
 		lock.refs--
 		if lock.refs == 0 {
-			delete(localPortLocks.locks, path)
+			delete(localLocks.locks, key)
 		}
-		localPortLocks.Unlock()
+		localLocks.Unlock()
 	}
 }
 
@@ -208,8 +218,33 @@ func releaseFileLock(file *os.File) {
 // layer (and the fallback for in-memory databases); flock coordinates separate
 // cmgr processes using the same database.
 func (m *Manager) acquirePortAllocationLock() (func(), error) {
-	path := portAllocationLockPath(m)
-	releaseLocal := acquireLocalPortLock(path)
+	return acquireAllocationLock(
+		portAllocationLockPath(m),
+		portLockSuffix,
+		"port allocation lock",
+	)
+}
+
+// acquireCapacityLock serializes host capacity admission from totalling the
+// existing reservations through recording the admitted instance, so that
+// concurrent starts cannot each claim the same headroom.
+func (m *Manager) acquireCapacityLock() (func(), error) {
+	return acquireAllocationLock(
+		capacityLockPath(m),
+		capacityLockSuffix,
+		"capacity lock",
+	)
+}
+
+// acquireAllocationLock takes an exclusive lock on the file at path.  A
+// process-local mutex is taken first; it is the only layer when the database
+// is in memory, in which case the suffix keeps unrelated locks apart.
+func acquireAllocationLock(path, suffix, description string) (func(), error) {
+	key := path
+	if key == "" {
+		key = ":memory:" + suffix
+	}
+	releaseLocal := acquireLocalLock(key)
 	if path == "" {
 		var once sync.Once
 		return func() {
@@ -219,7 +254,7 @@ func (m *Manager) acquirePortAllocationLock() (func(), error) {
 
 	file, acquired, err := acquireFileLock(
 		path,
-		"port allocation lock",
+		description,
 		syscall.LOCK_EX,
 		false,
 	)
@@ -229,7 +264,7 @@ func (m *Manager) acquirePortAllocationLock() (func(), error) {
 	}
 	if !acquired {
 		releaseLocal()
-		return nil, errors.New("could not acquire blocking port allocation lock")
+		return nil, fmt.Errorf("could not acquire blocking %s", description)
 	}
 
 	var once sync.Once
@@ -281,6 +316,13 @@ func (m *Manager) acquireOperationLockMode(
 	exclusive bool,
 	nonblocking bool,
 ) (release func(), acquired bool, err error) {
+	started := time.Now()
+	defer func() {
+		if acquired && !nonblocking {
+			m.metrics.observeOperationLockWait(exclusive, started)
+		}
+	}()
+
 	unlockLocal := m.operationMu.RUnlock
 	if exclusive {
 		if nonblocking {
@@ -2,6 +2,8 @@ package cmgr
 
 import (
 	"fmt"
+	"net"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
@@ -25,7 +27,17 @@ const (
 	maxRequestBytesEnv      = "CMGR_MAX_REQUEST_BYTES"
 	solverTimeoutEnv        = "CMGR_SOLVER_TIMEOUT"
 	maxSolverLogBytesEnv    = "CMGR_MAX_SOLVER_LOG_BYTES"
+	maxCheckLogsEnv         = "CMGR_MAX_CHECK_LOGS"
 	maxSolverFlagBytesEnv   = "CMGR_MAX_SOLVER_FLAG_BYTES"
+	maxBuildLogBytesEnv     = "CMGR_MAX_BUILD_LOG_BYTES"
+	maxContainerLogBytesEnv = "CMGR_MAX_CONTAINER_LOG_BYTES"
+	hostCPUsEnv             = "CMGR_HOST_CPUS"
+	hostMemoryEnv           = "CMGR_HOST_MEMORY"
+	hostPidsEnv             = "CMGR_HOST_PIDS"
+	proxyURLEnv             = "CMGR_PROXY_URL"
+	tlsGatewayEnv           = "CMGR_TLS_GATEWAY"
+	maxCaptureFileBytesEnv  = "CMGR_MAX_CAPTURE_FILE_BYTES"
+	maxCaptureFilesEnv      = "CMGR_MAX_CAPTURE_FILES"
 )
 
 type managerPolicy struct {
@@ -39,7 +51,24 @@ type managerPolicy struct {
 	MaxRequestBytes      int64
 	SolverTimeout        time.Duration
 	MaxSolverLogBytes    int64
+	// How many of the most recent check logs are kept for each instance.
+	MaxCheckLogs         int
 	MaxSolverFlagBytes   int64
+	MaxBuildLogBytes     int64
+	MaxContainerLogBytes int64
+	// The size at which a packet capture moves on to its next file and how
+	// many files it rotates through.
+	MaxCaptureFileBytes int64
+	MaxCaptureFiles     int
+	// The host-wide budget shared by every instance.  A zero field places no
+	// limit on that resource.
+	HostBudget resourceReservation
+	// The public base URL of cmgrd's HTTP reverse proxy, or nil when every
+	// port is published on the host.
+	ProxyURL *url.URL
+	// The public "host:port" of cmgrd's TLS gateway, or empty when every
+	// port is published on the host.
+	TLSGateway string
 }
 
 func envString(name, fallback string) string {
@@ -127,13 +156,114 @@ func (m *Manager) initPolicy() error {
 	if m.policy.MaxSolverLogBytes, err = positiveEnvBytes(maxSolverLogBytesEnv, "1m"); err != nil {
 		return err
 	}
+	if m.policy.MaxCheckLogs, err = positiveEnvInt(maxCheckLogsEnv, 20); err != nil {
+		return err
+	}
 	if m.policy.MaxSolverFlagBytes, err = positiveEnvBytes(maxSolverFlagBytesEnv, "4k"); err != nil {
 		return err
 	}
+	if m.policy.MaxBuildLogBytes, err = positiveEnvBytes(maxBuildLogBytesEnv, "4m"); err != nil {
+		return err
+	}
+	if m.policy.MaxContainerLogBytes, err = positiveEnvBytes(maxContainerLogBytesEnv, "1m"); err != nil {
+		return err
+	}
+	if m.policy.MaxCaptureFileBytes, err = positiveEnvBytes(maxCaptureFileBytesEnv, "10m"); err != nil {
+		return err
+	}
+	if m.policy.MaxCaptureFiles, err = positiveEnvInt(maxCaptureFilesEnv, 10); err != nil {
+		return err
+	}
+	if m.policy.HostBudget, err = hostBudgetFromEnv(); err != nil {
+		return err
+	}
+	if m.policy.ProxyURL, err = proxyURLFromEnv(); err != nil {
+		return err
+	}
+	if m.policy.TLSGateway, err = tlsGatewayFromEnv(); err != nil {
+		return err
+	}
 	m.buildSlots = make(chan struct{}, m.policy.MaxConcurrentBuilds)
 	return nil
 }
 
+// Reads the optional host capacity budget.  An unset or empty variable leaves
+// that resource unlimited.
+func hostBudgetFromEnv() (resourceReservation, error) {
+	var budget resourceReservation
+	if cpus := envString(hostCPUsEnv, ""); cpus != "" {
+		nanoCPUs, err := parseNanoCPUs(cpus)
+		if err != nil || nanoCPUs <= 0 {
+			return budget, fmt.Errorf("%s must be greater than zero, got %q", hostCPUsEnv, cpus)
+		}
+		budget.nanoCPUs = nanoCPUs
+	}
+	if envString(hostMemoryEnv, "") != "" {
+		memoryBytes, err := positiveEnvBytes(hostMemoryEnv, "")
+		if err != nil {
+			return budget, err
+		}
+		budget.memoryBytes = memoryBytes
+	}
+	if envString(hostPidsEnv, "") != "" {
+		pids, err := positiveEnvInt(hostPidsEnv, 0)
+		if err != nil {
+			return budget, err
+		}
+		budget.pids = int64(pids)
+	}
+	return budget, nil
+}
+
+// Reads the optional base URL of the reverse proxy, such as
+// "https://ctf.example.com".  Its scheme and port are those players use, and
+// its host is the domain below which each proxied port gets a hostname.
+func proxyURLFromEnv() (*url.URL, error) {
+	value := envString(proxyURLEnv, "")
+	if value == "" {
+		return nil, nil
+	}
+	proxyURL, err := url.Parse(value)
+	if err != nil ||
+		(proxyURL.Scheme != "http" && proxyURL.Scheme != "https") ||
+		proxyURL.Hostname() == "" ||
+		proxyURL.User != nil ||
+		(proxyURL.Path != "" && proxyURL.Path != "/") ||
+		proxyURL.RawQuery != "" ||
+		proxyURL.Fragment != "" {
+		return nil, fmt.Errorf(
+			"%s must be an http or https URL with only a host and optional port, got %q",
+			proxyURLEnv,
+			value,
+		)
+	}
+	return proxyURL, nil
+}
+
+// Reads the optional public address of the TLS gateway, such as
+// "ctf.example.com:443".  Its host is the domain below which each gateway
+// port gets a hostname, and the port defaults to 443.
+func tlsGatewayFromEnv() (string, error) {
+	value := envString(tlsGatewayEnv, "")
+	if value == "" {
+		return "", nil
+	}
+	host, port, err := net.SplitHostPort(value)
+	if err != nil {
+		host, port = value, "443"
+	}
+	portNum, portErr := strconv.Atoi(port)
+	if host == "" || strings.ContainsAny(host, ":/") || net.ParseIP(host) != nil ||
+		portErr != nil || portNum < 1 || portNum > 65535 {
+		return "", fmt.Errorf(
+			"%s must be a DNS name with an optional port, such as 'ctf.example.com:443', got %q",
+			tlsGatewayEnv,
+			value,
+		)
+	}
+	return net.JoinHostPort(strings.ToLower(host), strconv.Itoa(portNum)), nil
+}
+
 func mergeRuntimeDefaults(
 	defaults ContainerOptions,
 	challenge ContainerOptions,
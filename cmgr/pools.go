@@ -0,0 +1,115 @@
+package cmgr
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Starts or stops pooled instances of the build until its warm pool holds the
+// number of idle instances that its schema asks for.  Only one refill of a
+// pool runs at a time in a process; a request made while one is running makes
+// it count the pool again when it finishes so that instances claimed in the
+// meantime are still replaced.  The caller must hold the operation lock.
+func (m *Manager) convergeWarmPool(build *BuildMetadata) error {
+	if !m.beginPoolRefill(build.Id) {
+		return nil
+	}
+	for {
+		if err := m.convergeWarmPoolOnce(build); err != nil {
+			m.abandonPoolRefill(build.Id)
+			return err
+		}
+		if !m.endPoolRefill(build.Id) {
+			return nil
+		}
+	}
+}
+
+func (m *Manager) convergeWarmPoolOnce(build *BuildMetadata) error {
+	target := build.WarmPool
+	if build.InstanceCount != DYNAMIC_INSTANCES {
+		target = 0
+	}
+	pooled, err := m.getPooledInstances(build.Id)
+	if err != nil {
+		return err
+	}
+	for count := len(pooled); count < target; count++ {
+		instance, err := m.newPooledInstance(build)
+		if err != nil {
+			return fmt.Errorf("could not start pooled instance of build %d: %w", build.Id, err)
+		}
+		m.log.debugf("added instance %d to the warm pool of build %d", instance, build.Id)
+	}
+
+	var errs []error
+	for _, instance := range pooled[min(target, len(pooled)):] {
+		iMeta, err := m.lookupInstanceMetadata(instance)
+		if err == nil {
+			err = m.stopInstance(iMeta)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not shrink warm pool of build %d: %w", build.Id, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m *Manager) newPooledInstance(build *BuildMetadata) (InstanceId, error) {
+	return m.launchInstance(build, &InstanceMetadata{Pooled: true})
+}
+
+// Refills the build's warm pool without holding up the caller.
+func (m *Manager) refillWarmPoolInBackground(build BuildId) {
+	m.poolWorkers.Go(func() {
+		release, err := m.acquireOperationLock(false)
+		if err != nil {
+			m.log.warnf("could not refill warm pool of build %d: %v", build, err)
+			return
+		}
+		defer release()
+
+		bMeta, err := m.lookupBuildMetadata(build)
+		if err == nil {
+			err = m.convergeWarmPool(bMeta)
+		}
+		if err != nil {
+			m.log.warnf("could not refill warm pool of build %d: %v", build, err)
+		}
+	})
+}
+
+// Reports whether the caller should refill the pool, or records that the
+// refill already running must count the pool again.
+func (m *Manager) beginPoolRefill(build BuildId) bool {
+	m.poolMu.Lock()
+	defer m.poolMu.Unlock()
+	if m.poolRefills == nil {
+		m.poolRefills = make(map[BuildId]bool)
+	}
+	if _, running := m.poolRefills[build]; running {
+		m.poolRefills[build] = true
+		return false
+	}
+	m.poolRefills[build] = false
+	return true
+}
+
+// Reports whether another refill was requested while this one ran, in which
+// case the caller keeps the pool and must count it again.
+func (m *Manager) endPoolRefill(build BuildId) bool {
+	m.poolMu.Lock()
+	defer m.poolMu.Unlock()
+	if m.poolRefills[build] {
+		m.poolRefills[build] = false
+		return true
+	}
+	delete(m.poolRefills, build)
+	return false
+}
+
+func (m *Manager) abandonPoolRefill(build BuildId) {
+	m.poolMu.Lock()
+	defer m.poolMu.Unlock()
+	delete(m.poolRefills, build)
+}
@@ -0,0 +1,185 @@
+package cmgr
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newWarmPoolTestManager(t *testing.T) (*Manager, *[]string) {
+	t.Helper()
+	manager := newDynamicInstanceTestManager(t)
+	requireExec(t, manager.db, "UPDATE instances SET pooled=1 WHERE id=1;")
+	requests := []string{}
+	manager.ctx = t.Context()
+	manager.cli = newDockerTestClient(t, func(
+		request *http.Request,
+	) (*http.Response, error) {
+		// Record the path without the API version that prefixes it.
+		versioned := strings.TrimPrefix(request.URL.Path, "/")
+		if _, path, ok := strings.Cut(versioned, "/"); ok {
+			requests = append(requests, request.Method+" "+path)
+		}
+		if request.Method == http.MethodDelete {
+			return dockerTestResponse(request, http.StatusNoContent, "")
+		}
+		return dockerTestResponse(
+			request,
+			http.StatusInternalServerError,
+			`{"message":"unexpected test request"}`,
+		)
+	})
+	return manager, &requests
+}
+
+func requirePooledInstances(t *testing.T, manager *Manager, want []InstanceId) {
+	t.Helper()
+	pooled, err := manager.getPooledInstances(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(pooled, want) {
+		t.Fatalf("pooled instances are %v, expected %v", pooled, want)
+	}
+}
+
+func TestClaimHandsOutReadyPooledInstances(t *testing.T) {
+	manager, requests := newWarmPoolTestManager(t)
+	// An instance whose containers have not been recorded is still starting.
+	requireExec(
+		t,
+		manager.db,
+		"INSERT INTO instances(id, lastsolved, build, pooled) VALUES (2, 0, 1, 1);",
+	)
+
+	before := time.Now().Unix()
+	instance, err := manager.Claim(1, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if instance != 1 {
+		t.Fatalf("claimed instance %d instead of the ready pooled instance", instance)
+	}
+	meta, err := manager.lookupInstanceMetadata(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Pooled || meta.Expires < before+3600 {
+		t.Fatalf("claimed instance was not handed out: %#v", meta)
+	}
+	requirePooledInstances(t, manager, []InstanceId{2})
+
+	if instance, err = manager.claimPooledInstance(1, 0); err != nil || instance != 0 {
+		t.Fatalf("starting instance was claimed: instance=%d err=%v", instance, err)
+	}
+	manager.poolWorkers.Wait()
+	if len(*requests) != 0 {
+		t.Fatalf("a pool without a target was refilled: %v", *requests)
+	}
+}
+
+func TestClaimRefillsPoolInBackground(t *testing.T) {
+	manager, _ := newWarmPoolTestManager(t)
+	requireExec(t, manager.db, "UPDATE builds SET warmpool=1 WHERE id=1;")
+	// The fixture's container options cannot be decoded, so every refill
+	// fails after its instance has been recorded.
+	instance, err := manager.Claim(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if instance != 1 {
+		t.Fatalf("claimed instance %d instead of the pooled instance", instance)
+	}
+	manager.poolWorkers.Wait()
+
+	requirePooledInstances(t, manager, []InstanceId{})
+	if len(manager.poolRefills) != 0 {
+		t.Fatalf("failed refill was left running: %v", manager.poolRefills)
+	}
+}
+
+func TestClaimRejectsLockedBuilds(t *testing.T) {
+	manager, requests := newWarmPoolTestManager(t)
+	requireExec(t, manager.db, "UPDATE builds SET instancecount=1 WHERE id=1;")
+
+	var conflict *ConflictError
+	if _, err := manager.Claim(1); !errors.As(err, &conflict) {
+		t.Fatalf("claim from a locked build was not a conflict: %v", err)
+	}
+	var unknown *UnknownIdentifierError
+	if _, err := manager.Claim(2); !errors.As(err, &unknown) {
+		t.Fatalf("unknown build was not reported: %v", err)
+	}
+	var invalid *InvalidInputError
+	if _, err := manager.Claim(1, time.Millisecond); !errors.As(err, &invalid) {
+		t.Fatalf("short TTL was not rejected: %v", err)
+	}
+	requirePooledInstances(t, manager, []InstanceId{1})
+	if len(*requests) != 0 {
+		t.Fatalf("rejected claims reached Docker: %v", *requests)
+	}
+}
+
+func TestRefillWarmPoolsShrinksOversizedPools(t *testing.T) {
+	manager, requests := newWarmPoolTestManager(t)
+
+	if err := manager.RefillWarmPools(); err != nil {
+		t.Fatal(err)
+	}
+	requirePooledInstances(t, manager, []InstanceId{})
+	if _, err := manager.lookupInstanceMetadata(1); err == nil {
+		t.Fatal("surplus pooled instance was not stopped")
+	}
+	want := []string{"DELETE containers/container", "DELETE networks/cmgr-1"}
+	if !reflect.DeepEqual(*requests, want) {
+		t.Fatalf("unexpected Docker requests %v", *requests)
+	}
+
+	builds, err := manager.queryWarmPoolBuilds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(builds) != 0 {
+		t.Fatalf("empty pool without a target is still listed: %v", builds)
+	}
+}
+
+func TestPoolRefillsRecountAfterConcurrentRequests(t *testing.T) {
+	manager := &Manager{}
+	if !manager.beginPoolRefill(1) {
+		t.Fatal("first refill did not start")
+	}
+	if manager.beginPoolRefill(1) {
+		t.Fatal("second refill of the same pool started")
+	}
+	if !manager.beginPoolRefill(2) {
+		t.Fatal("refill of another pool did not start")
+	}
+	if !manager.endPoolRefill(1) {
+		t.Fatal("refill did not count the pool again after a request")
+	}
+	if manager.endPoolRefill(1) {
+		t.Fatal("refill counted the pool again without a request")
+	}
+	if !manager.beginPoolRefill(1) {
+		t.Fatal("finished refill still blocks the pool")
+	}
+}
+
+func TestCloseWaitsForPoolRefill(t *testing.T) {
+	manager, _ := newWarmPoolTestManager(t)
+	requireExec(t, manager.db, "UPDATE builds SET warmpool=1 WHERE id=1;")
+	if _, err := manager.Claim(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// The refill ran to completion and gave up its claim on the pool.
+	if len(manager.poolRefills) != 0 {
+		t.Fatalf("refill was still running after Close: %v", manager.poolRefills)
+	}
+}
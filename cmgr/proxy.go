@@ -0,0 +1,313 @@
+package cmgr
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/moby/moby/client"
+)
+
+// Returns the label that names the port in proxied hostnames.  Hostnames are
+// case-insensitive and cannot contain underscores, so "Web_Admin" becomes
+// "web-admin".
+func proxyHostLabel(portName string) string {
+	return strings.ReplaceAll(strings.ToLower(portName), "_", "-")
+}
+
+// Checks that every HTTP and TLS port is published, is routed only one way,
+// and can be told apart from the others by its hostname label.
+func validateRoutedPorts(md *ChallengeMetadata) error {
+	if len(md.ChallengeOptions.HTTPPorts) == 0 {
+		md.ChallengeOptions.HTTPPorts = nil
+	}
+	if len(md.ChallengeOptions.TLSPorts) == 0 {
+		md.ChallengeOptions.TLSPorts = nil
+	}
+	labels := make(map[string]string)
+	check := func(kind string, portNames []string) error {
+		for _, portName := range portNames {
+			endpoint, published := md.PortMap[portName]
+			if !published {
+				return fmt.Errorf("%s port '%s' is not a published port", kind, portName)
+			}
+			if endpoint.protocol() != "tcp" {
+				return fmt.Errorf("%s port '%s' is not a TCP port", kind, portName)
+			}
+			label := proxyHostLabel(portName)
+			if other, exists := labels[label]; exists {
+				if other == portName {
+					return fmt.Errorf("%s port '%s' is listed more than once", kind, portName)
+				}
+				return fmt.Errorf(
+					"%s ports '%s' and '%s' would share the hostname label '%s'",
+					kind,
+					other,
+					portName,
+					label,
+				)
+			}
+			labels[label] = portName
+		}
+		return nil
+	}
+	if err := check("HTTP", md.ChallengeOptions.HTTPPorts); err != nil {
+		return err
+	}
+	return check("TLS", md.ChallengeOptions.TLSPorts)
+}
+
+func encodePortNames(portNames []string) (string, error) {
+	if len(portNames) == 0 {
+		return "[]", nil
+	}
+	encoded, err := json.Marshal(portNames)
+	return string(encoded), err
+}
+
+func decodePortNames(encoded string) ([]string, error) {
+	var portNames []string
+	if err := json.Unmarshal([]byte(encoded), &portNames); err != nil {
+		return nil, fmt.Errorf("could not decode port names: %w", err)
+	}
+	return portNames, nil
+}
+
+// The HTTP and TLS ports of a challenge as stored in networkOptions.
+type dbRoutedPorts struct {
+	HTTPPorts string
+	TLSPorts  string
+}
+
+// Returns the challenge's ports that are reached through the reverse proxy or
+// the TLS gateway, which is none when neither is configured.
+func (m *Manager) routedPorts(challenge ChallengeId) (map[string]bool, error) {
+	if m.policy.ProxyURL == nil && m.policy.TLSGateway == "" {
+		return nil, nil
+	}
+	var stored dbRoutedPorts
+	err := m.db.Get(
+		&stored,
+		"SELECT httpports, tlsports FROM networkOptions WHERE challenge=?;",
+		challenge,
+	)
+	if isEmptyQueryError(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not look up routed ports of %s: %w", challenge, err)
+	}
+	routed := make(map[string]bool)
+	err = m.forEachRoutedPort(stored, func(portName string, _ bool) {
+		routed[portName] = true
+	})
+	return routed, err
+}
+
+// Calls visit with each of the ports that the configured reverse proxy and
+// TLS gateway serve and whether it is served by the gateway.
+func (m *Manager) forEachRoutedPort(stored dbRoutedPorts, visit func(string, bool)) error {
+	if m.policy.ProxyURL != nil {
+		httpPorts, err := decodePortNames(stored.HTTPPorts)
+		if err != nil {
+			return err
+		}
+		for _, portName := range httpPorts {
+			visit(portName, false)
+		}
+	}
+	if m.policy.TLSGateway != "" {
+		tlsPorts, err := decodePortNames(stored.TLSPorts)
+		if err != nil {
+			return err
+		}
+		for _, portName := range tlsPorts {
+			visit(portName, true)
+		}
+	}
+	return nil
+}
+
+// Records the proxied URL of each of the instance's HTTP ports and the
+// gateway address of each of its TLS ports.
+func (m *Manager) addRoutedEndpoints(txn *sqlx.Tx, metadata *InstanceMetadata) error {
+	if m.policy.ProxyURL == nil && m.policy.TLSGateway == "" {
+		return nil
+	}
+	var stored dbRoutedPorts
+	err := txn.Get(
+		&stored,
+		`SELECT networkOptions.httpports, networkOptions.tlsports
+		FROM instances
+		JOIN builds ON builds.id = instances.build
+		JOIN networkOptions ON networkOptions.challenge = builds.challenge
+		WHERE instances.id=?;`,
+		metadata.Id,
+	)
+	if isEmptyQueryError(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return m.forEachRoutedPort(stored, func(portName string, gateway bool) {
+		if gateway {
+			if metadata.TLSEndpoints == nil {
+				metadata.TLSEndpoints = make(map[string]string)
+			}
+			metadata.TLSEndpoints[portName] = m.gatewayEndpoint(metadata.Id, portName)
+			return
+		}
+		if metadata.URLs == nil {
+			metadata.URLs = make(map[string]string)
+		}
+		metadata.URLs[portName] = m.proxyURL(metadata.Id, portName)
+	})
+}
+
+func routedHostname(instance InstanceId, portName string, domain string) string {
+	return fmt.Sprintf("%d-%s.%s", instance, proxyHostLabel(portName), strings.ToLower(domain))
+}
+
+func (m *Manager) proxyURL(instance InstanceId, portName string) string {
+	host := routedHostname(instance, portName, m.policy.ProxyURL.Hostname())
+	if port := m.policy.ProxyURL.Port(); port != "" {
+		host = net.JoinHostPort(host, port)
+	}
+	return (&url.URL{Scheme: m.policy.ProxyURL.Scheme, Host: host}).String()
+}
+
+func (m *Manager) gatewayEndpoint(instance InstanceId, portName string) string {
+	domain, port, _ := net.SplitHostPort(m.policy.TLSGateway)
+	return net.JoinHostPort(routedHostname(instance, portName, domain), port)
+}
+
+// Resolves a hostname of the form `<instance>-<port>.<domain>`, as the
+// reverse proxy receives it in the Host header, to the address of that
+// container port on the instance's network.  Hostnames that do not name an
+// HTTP port of a running instance are reported as an UnknownIdentifierError.
+func (m *Manager) ProxyTarget(hostname string) (string, error) {
+	if m.policy.ProxyURL == nil {
+		return "", fmt.Errorf("the reverse proxy is not configured (%s)", proxyURLEnv)
+	}
+	return m.routeTarget(hostname, m.policy.ProxyURL.Hostname(), false)
+}
+
+// Resolves an SNI hostname of the form `<instance>-<port>.<domain>`, as the
+// TLS gateway receives it, to the address of that container port on the
+// instance's network.  Hostnames that do not name a TLS port of a running
+// instance are reported as an UnknownIdentifierError.
+func (m *Manager) GatewayTarget(hostname string) (string, error) {
+	if m.policy.TLSGateway == "" {
+		return "", fmt.Errorf("the TLS gateway is not configured (%s)", tlsGatewayEnv)
+	}
+	domain, _, _ := net.SplitHostPort(m.policy.TLSGateway)
+	return m.routeTarget(hostname, domain, true)
+}
+
+func (m *Manager) routeTarget(hostname string, domain string, gateway bool) (string, error) {
+	unknownHost := unknownProxyHostError
+	if gateway {
+		unknownHost = unknownGatewayHostError
+	}
+	host := hostname
+	if withoutPort, _, err := net.SplitHostPort(host); err == nil {
+		host = withoutPort
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	label, inDomain := strings.CutSuffix(host, "."+strings.ToLower(domain))
+	idStr, portLabel, hasPort := strings.Cut(label, "-")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if !inDomain || !hasPort || err != nil || id <= 0 || strings.Contains(label, ".") {
+		return "", unknownHost(hostname)
+	}
+
+	var target struct {
+		Paused bool
+		dbRoutedPorts
+	}
+	err = m.db.Get(
+		&target,
+		`SELECT instances.paused, networkOptions.httpports, networkOptions.tlsports
+		FROM instances
+		JOIN builds ON builds.id = instances.build
+		JOIN networkOptions ON networkOptions.challenge = builds.challenge
+		WHERE instances.id=?;`,
+		id,
+	)
+	if isEmptyQueryError(err) {
+		return "", unknownHost(hostname)
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not look up routed instance %d: %w", id, err)
+	}
+	portName := ""
+	err = m.forEachRoutedPort(target.dbRoutedPorts, func(name string, viaGateway bool) {
+		if viaGateway == gateway && proxyHostLabel(name) == portLabel {
+			portName = name
+		}
+	})
+	if err != nil {
+		return "", err
+	}
+	if portName == "" {
+		return "", unknownHost(hostname)
+	}
+	if target.Paused {
+		return "", &ConflictError{Err: fmt.Errorf("instance %d is paused", id)}
+	}
+
+	iMeta, err := m.lookupInstanceMetadata(InstanceId(id))
+	if err != nil {
+		return "", err
+	}
+	var endpoint PortInfo
+	err = m.db.Get(
+		&endpoint,
+		`SELECT portNames.host, portNames.port
+		FROM builds
+		JOIN portNames ON portNames.challenge = builds.challenge
+		WHERE builds.id=? AND portNames.name=?;`,
+		iMeta.Build,
+		portName,
+	)
+	if isEmptyQueryError(err) {
+		return "", unknownHost(hostname)
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not look up port %q: %w", portName, err)
+	}
+	address, err := m.containerAddress(iMeta, endpoint.Host)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(address, strconv.Itoa(endpoint.Port)), nil
+}
+
+// Returns the address of the instance's container for the host on the
+// instance's network.
+func (m *Manager) containerAddress(iMeta *InstanceMetadata, host string) (string, error) {
+	netname := iMeta.getNetworkName()
+	for _, cid := range iMeta.Containers {
+		inspection, err := m.cli.ContainerInspect(m.ctx, cid, client.ContainerInspectOptions{})
+		if err != nil {
+			return "", fmt.Errorf("could not inspect container %s: %w", cid, err)
+		}
+		info := inspection.Container
+		if info.Config == nil || info.Config.Hostname != host {
+			continue
+		}
+		if info.NetworkSettings != nil {
+			if endpoint := info.NetworkSettings.Networks[netname]; endpoint != nil &&
+				endpoint.IPAddress.IsValid() {
+				return endpoint.IPAddress.String(), nil
+			}
+		}
+		return "", fmt.Errorf("container %s has no address on network %s", cid, netname)
+	}
+	return "", fmt.Errorf("instance %d has no container for host %s", iMeta.Id, host)
+}
@@ -0,0 +1,236 @@
+package cmgr
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newProxyTestManager(t *testing.T, proxyURL string) *Manager {
+	t.Helper()
+	manager := newDynamicInstanceTestManager(t)
+	requireExec(
+		t,
+		manager.db,
+		`INSERT INTO networkOptions(challenge, allowegress, httpports) VALUES ('challenge', 0, '["http"]');`,
+	)
+	parsed, err := url.Parse(proxyURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.policy.ProxyURL = parsed
+	return manager
+}
+
+func TestValidateRoutedPorts(t *testing.T) {
+	tests := []struct {
+		ports    []string
+		tlsPorts []string
+		err      string
+	}{
+		{ports: []string{}},
+		{ports: []string{"Web_Admin", "http"}},
+		{ports: []string{"http"}, tlsPorts: []string{"web-admin"}},
+		{ports: []string{"ssh"}, err: "not a published port"},
+		{ports: []string{"http", "http"}, err: "more than once"},
+		{ports: []string{"web-admin", "Web_Admin"}, err: "share the hostname label"},
+		{ports: []string{"http"}, tlsPorts: []string{"http"}, err: "listed more than once"},
+		{tlsPorts: []string{"ssh"}, err: "TLS port 'ssh' is not a published port"},
+	}
+	for _, test := range tests {
+		md := &ChallengeMetadata{
+			ChallengeOptions: ChallengeOptions{NetworkOptions: NetworkOptions{
+				HTTPPorts: test.ports,
+				TLSPorts:  test.tlsPorts,
+			}},
+			PortMap: map[string]PortInfo{
+				"http":      {Host: "web", Port: 80},
+				"web-admin": {Host: "web", Port: 8080},
+				"Web_Admin": {Host: "web", Port: 8081},
+			},
+		}
+		err := validateRoutedPorts(md)
+		if len(test.ports) == 0 && md.ChallengeOptions.HTTPPorts != nil {
+			t.Errorf("empty HTTP ports were not normalized")
+		}
+		if test.err == "" && err != nil {
+			t.Errorf("%v: unexpected error %v", test.ports, err)
+		}
+		if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%v: error %v, expected %q", test.ports, err, test.err)
+		}
+	}
+}
+
+func TestInstanceMetadataListsProxiedURLs(t *testing.T) {
+	manager := newProxyTestManager(t, "https://CTF.example.com:8443")
+	meta, err := manager.lookupInstanceMetadata(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"http": "https://1-http.ctf.example.com:8443"}
+	if !reflect.DeepEqual(meta.URLs, want) {
+		t.Fatalf("listed URLs %v, expected %v", meta.URLs, want)
+	}
+
+	manager.policy.ProxyURL = nil
+	if meta, err = manager.lookupInstanceMetadata(1); err != nil {
+		t.Fatal(err)
+	}
+	if meta.URLs != nil {
+		t.Fatalf("URLs were listed without a proxy: %v", meta.URLs)
+	}
+}
+
+func TestProxyTargetResolvesContainerAddress(t *testing.T) {
+	manager := newProxyTestManager(t, "http://ctf.example.com")
+	manager.ctx = t.Context()
+	manager.cli = newDockerTestClient(t, func(
+		request *http.Request,
+	) (*http.Response, error) {
+		if request.Method == http.MethodGet &&
+			strings.HasSuffix(request.URL.Path, "/containers/container/json") {
+			return dockerTestResponse(
+				request,
+				http.StatusOK,
+				`{"Id":"container","Config":{"Hostname":"web"},
+				"NetworkSettings":{"Networks":{"cmgr-1":{"IPAddress":"172.18.0.2"}}}}`,
+			)
+		}
+		return dockerTestResponse(
+			request,
+			http.StatusInternalServerError,
+			`{"message":"unexpected test request"}`,
+		)
+	})
+
+	address, err := manager.ProxyTarget("1-HTTP.ctf.example.com:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if address != "172.18.0.2:80" {
+		t.Fatalf("resolved %q", address)
+	}
+
+	var unknown *UnknownIdentifierError
+	for _, host := range []string{
+		"2-http.ctf.example.com",
+		"1-ssh.ctf.example.com",
+		"1-http.other.example.com",
+		"x.1-http.ctf.example.com",
+		"http.ctf.example.com",
+	} {
+		if _, err := manager.ProxyTarget(host); !errors.As(err, &unknown) {
+			t.Errorf("%s: expected an unknown host, got %v", host, err)
+		}
+	}
+
+	requireExec(t, manager.db, "UPDATE instances SET paused=1 WHERE id=1;")
+	var conflict *ConflictError
+	if _, err := manager.ProxyTarget("1-http.ctf.example.com"); !errors.As(err, &conflict) {
+		t.Fatalf("paused instance was resolved: %v", err)
+	}
+}
+
+func TestInstanceMetadataListsGatewayEndpoints(t *testing.T) {
+	manager := newProxyTestManager(t, "https://ctf.example.com")
+	requireExec(t, manager.db, `UPDATE networkOptions SET httpports='[]', tlsports='["http"]';`)
+	manager.policy.TLSGateway = "tcp.example.com:443"
+	meta, err := manager.lookupInstanceMetadata(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"http": "1-http.tcp.example.com:443"}
+	if meta.URLs != nil || !reflect.DeepEqual(meta.TLSEndpoints, want) {
+		t.Fatalf("listed URLs %v and endpoints %v, expected endpoints %v", meta.URLs, meta.TLSEndpoints, want)
+	}
+
+	// Routed ports must not be published on the host.
+	routed, err := manager.routedPorts("challenge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(routed, map[string]bool{"http": true}) {
+		t.Fatalf("routed ports %v", routed)
+	}
+	manager.policy.TLSGateway = ""
+	if routed, err = manager.routedPorts("challenge"); err != nil || len(routed) != 0 {
+		t.Fatalf("ports were routed without a gateway: %v, %v", routed, err)
+	}
+}
+
+func TestGatewayTargetOnlyResolvesTLSPorts(t *testing.T) {
+	manager := newProxyTestManager(t, "http://ctf.example.com")
+	manager.policy.TLSGateway = "ctf.example.com:443"
+
+	var unknown *UnknownIdentifierError
+	if _, err := manager.GatewayTarget("1-http.ctf.example.com"); !errors.As(err, &unknown) {
+		t.Fatalf("HTTP port was resolved by the gateway: %v", err)
+	}
+	requireExec(t, manager.db, `UPDATE networkOptions SET httpports='[]', tlsports='["http"]';`)
+	if _, err := manager.ProxyTarget("1-http.ctf.example.com"); !errors.As(err, &unknown) {
+		t.Fatalf("TLS port was resolved by the proxy: %v", err)
+	}
+	requireExec(t, manager.db, "UPDATE instances SET paused=1 WHERE id=1;")
+	var conflict *ConflictError
+	if _, err := manager.GatewayTarget("1-http.ctf.example.com"); !errors.As(err, &conflict) {
+		t.Fatalf("paused instance was resolved: %v", err)
+	}
+}
+
+func TestTLSGatewayFromEnv(t *testing.T) {
+	tests := map[string]string{
+		"":                     "",
+		"CTF.example.com":      "ctf.example.com:443",
+		"ctf.example.com:9999": "ctf.example.com:9999",
+	}
+	for value, want := range tests {
+		t.Setenv(tlsGatewayEnv, value)
+		if gateway, err := tlsGatewayFromEnv(); err != nil || gateway != want {
+			t.Errorf("%q read as %q, %v", value, gateway, err)
+		}
+	}
+	for _, value := range []string{"10.0.0.1:443", "ctf.example.com:0", "https://ctf.example.com"} {
+		t.Setenv(tlsGatewayEnv, value)
+		if _, err := tlsGatewayFromEnv(); err == nil {
+			t.Errorf("invalid gateway %q was accepted", value)
+		}
+	}
+}
+
+func TestProxyURLFromEnv(t *testing.T) {
+	t.Setenv(proxyURLEnv, "")
+	if proxyURL, err := proxyURLFromEnv(); err != nil || proxyURL != nil {
+		t.Fatalf("unset proxy URL read as %v, %v", proxyURL, err)
+	}
+	t.Setenv(proxyURLEnv, "https://ctf.example.com")
+	if proxyURL, err := proxyURLFromEnv(); err != nil || proxyURL.Host != "ctf.example.com" {
+		t.Fatalf("read proxy URL %v, %v", proxyURL, err)
+	}
+	for _, value := range []string{"ctf.example.com", "ftp://ctf.example.com", "https://ctf.example.com/path"} {
+		t.Setenv(proxyURLEnv, value)
+		if _, err := proxyURLFromEnv(); err == nil {
+			t.Errorf("invalid proxy URL %q was accepted", value)
+		}
+	}
+}
+
+func TestConnectionTemplatesAreNormalized(t *testing.T) {
+	manager := newSchemaTestManager(t)
+	md := &ChallengeMetadata{
+		Name:          "Gateway",
+		ChallengeType: "custom",
+		Details:       "Run `{{openssl}}` or `{{ ncat }}`.",
+		PortMap:       map[string]PortInfo{"pwn": {Host: "challenge", Port: 5000}},
+	}
+	if err := manager.validateMetadata(md); err != nil {
+		t.Fatal(err)
+	}
+	want := "Run `{{openssl(\"pwn\")}}` or `{{ncat(\"pwn\")}}`."
+	if md.Details != want {
+		t.Fatalf("normalized details to %q, expected %q", md.Details, want)
+	}
+}
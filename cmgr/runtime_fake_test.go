@@ -0,0 +1,646 @@
+package cmgr
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"iter"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/containerd/errdefs"
+	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/api/types/image"
+	"github.com/moby/moby/api/types/jsonstream"
+	"github.com/moby/moby/api/types/network"
+	"github.com/moby/moby/api/types/system"
+	"github.com/moby/moby/client"
+)
+
+// An in-memory container runtime.  Images are sets of files, containers
+// copy the files of their image, and nothing is ever executed: containers
+// run until they are stopped and exit successfully when waited on.
+type fakeRuntime struct {
+	mu sync.Mutex
+	// Returns the files, by path without a leading slash, of an image built
+	// with the options.  By default a build with a FLAG argument produces
+	// the metadata.json that cmgr's Dockerfiles write.
+	build      func(options client.ImageBuildOptions) map[string]string
+	images     map[string]*fakeImage
+	containers map[string]*fakeContainer
+	networks   map[string]client.NetworkCreateOptions
+	nextID     int
+	nextPort   int
+	// Image references that ImagePull succeeds for.
+	registry map[string]bool
+	pushed   []string
+}
+
+type fakeImage struct {
+	id    string
+	files map[string]string
+}
+
+type fakeContainer struct {
+	id         string
+	name       string
+	image      *fakeImage
+	config     container.Config
+	hostConfig container.HostConfig
+	networks   []string
+	ports      network.PortMap
+	running    bool
+}
+
+func newFakeRuntime() *fakeRuntime {
+	return &fakeRuntime{
+		images:     make(map[string]*fakeImage),
+		containers: make(map[string]*fakeContainer),
+		networks:   make(map[string]client.NetworkCreateOptions),
+		registry:   make(map[string]bool),
+		nextPort:   40000,
+	}
+}
+
+var _ ContainerRuntime = (*fakeRuntime)(nil)
+
+func fakeNotFound(kind string, name string) error {
+	return fmt.Errorf("no such %s: %s: %w", kind, name, errdefs.ErrNotFound)
+}
+
+func (f *fakeRuntime) newID(prefix string) string {
+	f.nextID++
+	return fmt.Sprintf("%s%062d", prefix, f.nextID)
+}
+
+// The number of containers that exist, running or not.
+func (f *fakeRuntime) containerCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.containers)
+}
+
+func (f *fakeRuntime) hasNetwork(name string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, exists := f.networks[name]
+	return exists
+}
+
+func (f *fakeRuntime) hasImage(name string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, exists := f.images[name]
+	return exists
+}
+
+// Removes a container behind cmgr's back, as a crashed daemon or an operator
+// might.
+func (f *fakeRuntime) loseContainer(id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.containers, id)
+}
+
+// Stops a container without cmgr's involvement, as a daemon restart would.
+func (f *fakeRuntime) killContainer(id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if c, exists := f.containers[id]; exists {
+		c.running = false
+	}
+}
+
+// Restarts the daemon, which starts every container whose restart policy is
+// "always" whether or not it was stopped.
+func (f *fakeRuntime) restartDaemon() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, c := range f.containers {
+		c.running = c.hostConfig.RestartPolicy.Name == container.RestartPolicyAlways
+	}
+}
+
+func (f *fakeRuntime) isRunning(id string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, exists := f.containers[id]
+	return exists && c.running
+}
+
+func (f *fakeRuntime) Ping(ctx context.Context, options client.PingOptions) (client.PingResult, error) {
+	return client.PingResult{APIVersion: "fake", OSType: "linux"}, nil
+}
+
+func (f *fakeRuntime) Info(ctx context.Context, options client.InfoOptions) (client.SystemInfoResult, error) {
+	return client.SystemInfoResult{Info: system.Info{
+		Driver:   "fake",
+		OSType:   "linux",
+		NCPU:     4,
+		MemTotal: 8 << 30,
+	}}, nil
+}
+
+func (f *fakeRuntime) ImageBuild(
+	ctx context.Context,
+	buildContext io.Reader,
+	options client.ImageBuildOptions,
+) (client.ImageBuildResult, error) {
+	if _, err := io.Copy(io.Discard, buildContext); err != nil {
+		return client.ImageBuildResult{}, err
+	}
+	var files map[string]string
+	if f.build != nil {
+		files = f.build(options)
+	} else if flag := options.BuildArgs["FLAG"]; flag != nil {
+		metadata, _ := json.Marshal(map[string]string{"flag": *flag})
+		files = map[string]string{"challenge/metadata.json": string(metadata)}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	built := &fakeImage{id: f.newID("sha256:"), files: files}
+	f.images[built.id] = built
+	for _, tag := range options.Tags {
+		f.images[tag] = built
+	}
+	return client.ImageBuildResult{Body: fakeProgress("built " + built.id)}, nil
+}
+
+func (f *fakeRuntime) ImagePull(
+	ctx context.Context,
+	ref string,
+	options client.ImagePullOptions,
+) (client.ImagePullResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.registry[ref] {
+		return nil, fakeNotFound("image", ref)
+	}
+	if _, exists := f.images[ref]; !exists {
+		pulled := &fakeImage{id: f.newID("sha256:")}
+		f.images[pulled.id] = pulled
+		f.images[ref] = pulled
+	}
+	return fakeProgress("pulled " + ref), nil
+}
+
+func (f *fakeRuntime) ImagePush(
+	ctx context.Context,
+	name string,
+	options client.ImagePushOptions,
+) (client.ImagePushResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, exists := f.images[name]; !exists {
+		return nil, fakeNotFound("image", name)
+	}
+	f.pushed = append(f.pushed, name)
+	f.registry[name] = true
+	return fakeProgress("pushed " + name), nil
+}
+
+func (f *fakeRuntime) ImageInspect(
+	ctx context.Context,
+	name string,
+	options ...client.ImageInspectOption,
+) (client.ImageInspectResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	found, exists := f.images[name]
+	if !exists {
+		return client.ImageInspectResult{}, fakeNotFound("image", name)
+	}
+	return client.ImageInspectResult{
+		InspectResponse: image.InspectResponse{ID: found.id, RepoTags: f.tagsOf(found)},
+	}, nil
+}
+
+func (f *fakeRuntime) tagsOf(target *fakeImage) []string {
+	var tags []string
+	for name, candidate := range f.images {
+		if candidate == target && name != target.id {
+			tags = append(tags, name)
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+func (f *fakeRuntime) ImageList(ctx context.Context, options client.ImageListOptions) (client.ImageListResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var result client.ImageListResult
+	for name, listed := range f.images {
+		if name == listed.id {
+			result.Items = append(result.Items, image.Summary{ID: listed.id, RepoTags: f.tagsOf(listed)})
+		}
+	}
+	sort.Slice(result.Items, func(i, j int) bool { return result.Items[i].ID < result.Items[j].ID })
+	return result, nil
+}
+
+func (f *fakeRuntime) ImageTag(ctx context.Context, options client.ImageTagOptions) (client.ImageTagResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	source, exists := f.images[options.Source]
+	if !exists {
+		return client.ImageTagResult{}, fakeNotFound("image", options.Source)
+	}
+	f.images[options.Target] = source
+	return client.ImageTagResult{}, nil
+}
+
+func (f *fakeRuntime) ImageRemove(
+	ctx context.Context,
+	name string,
+	options client.ImageRemoveOptions,
+) (client.ImageRemoveResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	removed, exists := f.images[name]
+	if !exists {
+		return client.ImageRemoveResult{}, fakeNotFound("image", name)
+	}
+	delete(f.images, name)
+	if len(f.tagsOf(removed)) == 0 {
+		delete(f.images, removed.id)
+	}
+	return client.ImageRemoveResult{Items: []image.DeleteResponse{{Untagged: name}}}, nil
+}
+
+// Saves the images as a JSON object of their files by name rather than as
+// a tar archive.
+func (f *fakeRuntime) ImageSave(
+	ctx context.Context,
+	names []string,
+	options ...client.ImageSaveOption,
+) (client.ImageSaveResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	saved := make(map[string]map[string]string, len(names))
+	for _, name := range names {
+		found, exists := f.images[name]
+		if !exists {
+			return nil, fakeNotFound("image", name)
+		}
+		saved[name] = found.files
+	}
+	archive, err := json.Marshal(saved)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(archive)), nil
+}
+
+func (f *fakeRuntime) ImageLoad(
+	ctx context.Context,
+	input io.Reader,
+	options ...client.ImageLoadOption,
+) (client.ImageLoadResult, error) {
+	var saved map[string]map[string]string
+	if err := json.NewDecoder(input).Decode(&saved); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for name, files := range saved {
+		loaded := &fakeImage{id: f.newID("sha256:"), files: files}
+		f.images[loaded.id] = loaded
+		f.images[name] = loaded
+	}
+	return fakeProgress("loaded"), nil
+}
+
+func (f *fakeRuntime) ContainerCreate(
+	ctx context.Context,
+	options client.ContainerCreateOptions,
+) (client.ContainerCreateResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	created := &fakeContainer{name: options.Name, ports: make(network.PortMap)}
+	if options.Config != nil {
+		created.config = *options.Config
+	}
+	if options.HostConfig != nil {
+		created.hostConfig = *options.HostConfig
+	}
+	var exists bool
+	if created.image, exists = f.images[created.config.Image]; !exists {
+		return client.ContainerCreateResult{}, fakeNotFound("image", created.config.Image)
+	}
+	if created.name != "" {
+		for _, other := range f.containers {
+			if other.name == created.name {
+				return client.ContainerCreateResult{}, fmt.Errorf(
+					"container name %q is in use: %w",
+					created.name,
+					errdefs.ErrConflict,
+				)
+			}
+		}
+	}
+	if options.NetworkingConfig != nil {
+		for name := range options.NetworkingConfig.EndpointsConfig {
+			if _, exists := f.networks[name]; !exists {
+				return client.ContainerCreateResult{}, fakeNotFound("network", name)
+			}
+			created.networks = append(created.networks, name)
+		}
+	}
+	for port, bindings := range created.hostConfig.PortBindings {
+		hostPort := ""
+		for _, binding := range bindings {
+			if binding.HostPort != "" {
+				hostPort = binding.HostPort
+			}
+		}
+		if hostPort == "" {
+			hostPort = strconv.Itoa(f.nextPort)
+			f.nextPort++
+		}
+		for _, binding := range bindings {
+			binding.HostPort = hostPort
+			created.ports[port] = append(created.ports[port], binding)
+		}
+	}
+	created.id = f.newID("")
+	f.containers[created.id] = created
+	return client.ContainerCreateResult{ID: created.id}, nil
+}
+
+func (f *fakeRuntime) container(id string) (*fakeContainer, error) {
+	found, exists := f.containers[id]
+	if !exists {
+		return nil, fakeNotFound("container", id)
+	}
+	return found, nil
+}
+
+func (f *fakeRuntime) ContainerStart(
+	ctx context.Context,
+	id string,
+	options client.ContainerStartOptions,
+) (client.ContainerStartResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	started, err := f.container(id)
+	if err != nil {
+		return client.ContainerStartResult{}, err
+	}
+	started.running = true
+	return client.ContainerStartResult{}, nil
+}
+
+func (f *fakeRuntime) ContainerStop(
+	ctx context.Context,
+	id string,
+	options client.ContainerStopOptions,
+) (client.ContainerStopResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	stopped, err := f.container(id)
+	if err != nil {
+		return client.ContainerStopResult{}, err
+	}
+	stopped.running = false
+	return client.ContainerStopResult{}, nil
+}
+
+func (f *fakeRuntime) ContainerUpdate(
+	ctx context.Context,
+	id string,
+	options client.ContainerUpdateOptions,
+) (client.ContainerUpdateResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	updated, err := f.container(id)
+	if err != nil {
+		return client.ContainerUpdateResult{}, err
+	}
+	if options.RestartPolicy != nil {
+		updated.hostConfig.RestartPolicy = *options.RestartPolicy
+	}
+	return client.ContainerUpdateResult{}, nil
+}
+
+func (f *fakeRuntime) ContainerWait(
+	ctx context.Context,
+	id string,
+	options client.ContainerWaitOptions,
+) client.ContainerWaitResult {
+	results := make(chan container.WaitResponse, 1)
+	errs := make(chan error, 1)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if waited, err := f.container(id); err != nil {
+		errs <- err
+	} else {
+		waited.running = false
+		results <- container.WaitResponse{}
+	}
+	return client.ContainerWaitResult{Result: results, Error: errs}
+}
+
+func (f *fakeRuntime) ContainerInspect(
+	ctx context.Context,
+	id string,
+	options client.ContainerInspectOptions,
+) (client.ContainerInspectResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	inspected, err := f.container(id)
+	if err != nil {
+		return client.ContainerInspectResult{}, err
+	}
+	state := &container.State{Status: container.StateExited}
+	if inspected.running {
+		state = &container.State{Status: container.StateRunning, Running: true}
+	}
+	config := inspected.config
+	hostConfig := inspected.hostConfig
+	endpoints := make(map[string]*network.EndpointSettings, len(inspected.networks))
+	for _, name := range inspected.networks {
+		endpoints[name] = &network.EndpointSettings{NetworkID: name}
+	}
+	return client.ContainerInspectResult{Container: container.InspectResponse{
+		ID:         inspected.id,
+		Name:       "/" + inspected.name,
+		Image:      inspected.image.id,
+		State:      state,
+		Config:     &config,
+		HostConfig: &hostConfig,
+		NetworkSettings: &container.NetworkSettings{
+			Ports:    inspected.ports,
+			Networks: endpoints,
+		},
+	}}, nil
+}
+
+func (f *fakeRuntime) ContainerLogs(
+	ctx context.Context,
+	id string,
+	options client.ContainerLogsOptions,
+) (client.ContainerLogsResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, err := f.container(id); err != nil {
+		return nil, err
+	}
+	return io.NopCloser(strings.NewReader("")), nil
+}
+
+func (f *fakeRuntime) ContainerStats(
+	ctx context.Context,
+	id string,
+	options client.ContainerStatsOptions,
+) (client.ContainerStatsResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, err := f.container(id); err != nil {
+		return client.ContainerStatsResult{}, err
+	}
+	stats, _ := json.Marshal(container.StatsResponse{ID: id})
+	return client.ContainerStatsResult{Body: io.NopCloser(bytes.NewReader(stats))}, nil
+}
+
+func (f *fakeRuntime) ContainerRemove(
+	ctx context.Context,
+	id string,
+	options client.ContainerRemoveOptions,
+) (client.ContainerRemoveResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	removed, err := f.container(id)
+	if err != nil {
+		return client.ContainerRemoveResult{}, err
+	}
+	if removed.running && !options.Force {
+		return client.ContainerRemoveResult{}, fmt.Errorf(
+			"container %s is running: %w",
+			id,
+			errdefs.ErrConflict,
+		)
+	}
+	delete(f.containers, id)
+	return client.ContainerRemoveResult{}, nil
+}
+
+// Archives the file or directory at the source path the way Docker does,
+// with entry names relative to the parent of the source.
+func (f *fakeRuntime) CopyFromContainer(
+	ctx context.Context,
+	id string,
+	options client.CopyFromContainerOptions,
+) (client.CopyFromContainerResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	copied, err := f.container(id)
+	if err != nil {
+		return client.CopyFromContainerResult{}, err
+	}
+	source := strings.Trim(options.SourcePath, "/")
+	base := path.Base(source)
+	var names []string
+	for name := range copied.image.files {
+		if name == source || strings.HasPrefix(name, source+"/") {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return client.CopyFromContainerResult{}, fakeNotFound("path", options.SourcePath)
+	}
+	sort.Strings(names)
+
+	var archive bytes.Buffer
+	writer := tar.NewWriter(&archive)
+	for _, name := range names {
+		contents := copied.image.files[name]
+		if err := writer.WriteHeader(&tar.Header{
+			Name:     base + strings.TrimPrefix(name, source),
+			Mode:     0644,
+			Size:     int64(len(contents)),
+			Typeflag: tar.TypeReg,
+		}); err != nil {
+			return client.CopyFromContainerResult{}, err
+		}
+		if _, err := io.WriteString(writer, contents); err != nil {
+			return client.CopyFromContainerResult{}, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return client.CopyFromContainerResult{}, err
+	}
+	return client.CopyFromContainerResult{Content: io.NopCloser(&archive)}, nil
+}
+
+func (f *fakeRuntime) NetworkCreate(
+	ctx context.Context,
+	name string,
+	options client.NetworkCreateOptions,
+) (client.NetworkCreateResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, exists := f.networks[name]; exists {
+		return client.NetworkCreateResult{}, fmt.Errorf(
+			"network %s already exists: %w",
+			name,
+			errdefs.ErrConflict,
+		)
+	}
+	f.networks[name] = options
+	return client.NetworkCreateResult{ID: name}, nil
+}
+
+func (f *fakeRuntime) NetworkRemove(
+	ctx context.Context,
+	name string,
+	options client.NetworkRemoveOptions,
+) (client.NetworkRemoveResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, exists := f.networks[name]; !exists {
+		return client.NetworkRemoveResult{}, fakeNotFound("network", name)
+	}
+	for _, attached := range f.containers {
+		for _, network := range attached.networks {
+			if network == name {
+				return client.NetworkRemoveResult{}, fmt.Errorf(
+					"network %s has active endpoints: %w",
+					name,
+					errdefs.ErrConflict,
+				)
+			}
+		}
+	}
+	delete(f.networks, name)
+	return client.NetworkRemoveResult{}, nil
+}
+
+// The progress stream of a build, pull, or push that succeeded.
+type fakeProgressResponse struct {
+	io.Reader
+}
+
+func fakeProgress(status string) *fakeProgressResponse {
+	message, _ := json.Marshal(map[string]string{"status": status})
+	return &fakeProgressResponse{Reader: bytes.NewReader(message)}
+}
+
+func (r *fakeProgressResponse) Close() error {
+	return nil
+}
+
+func (r *fakeProgressResponse) JSONMessages(ctx context.Context) iter.Seq2[jsonstream.Message, error] {
+	return func(yield func(jsonstream.Message, error) bool) {}
+}
+
+func (r *fakeProgressResponse) Wait(ctx context.Context) error {
+	return nil
+}
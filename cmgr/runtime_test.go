@@ -0,0 +1,228 @@
+package cmgr
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/client"
+)
+
+const fakeRuntimeChallenge ChallengeId = "tests/runtime-lifecycle"
+
+// Starts a manager backed by the runtime with a fresh database and a single
+// custom challenge that publishes one port and has a solve script.
+func newFakeRuntimeManager(t *testing.T, runtime ContainerRuntime) *Manager {
+	t.Helper()
+	challengeDir := t.TempDir()
+	artifactsDir := t.TempDir()
+	files := map[string]string{
+		"problem.md": `# Runtime Lifecycle
+
+- Namespace: tests
+- Type: custom
+
+## Description
+
+Runtime lifecycle test.
+
+## Details
+
+Connect to port {{port}}.
+`,
+		"Dockerfile":      "FROM scratch AS challenge\nEXPOSE 4242\n# PUBLISH 4242 AS socat\n",
+		"solver/solve.py": "print('solved')\n",
+	}
+	for name, contents := range files {
+		path := filepath.Join(challengeDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv(DIR_ENV, challengeDir)
+	t.Setenv(ARTIFACT_DIR_ENV, artifactsDir)
+	t.Setenv(DB_ENV, filepath.Join(artifactsDir, "cmgr.db"))
+	return reopenFakeRuntimeManager(t, runtime)
+}
+
+// Starts another manager on the same database, as a restarted cmgr would.
+func reopenFakeRuntimeManager(t *testing.T, runtime ContainerRuntime) *Manager {
+	t.Helper()
+	manager := NewManagerWithRuntime(DISABLED, runtime)
+	if manager == nil {
+		t.Fatal("could not create manager")
+	}
+	t.Cleanup(func() { manager.db.Close() })
+	return manager
+}
+
+// Builds the test challenge and starts a dynamic instance of it.
+func startFakeRuntimeInstance(t *testing.T, manager *Manager) (*BuildMetadata, *InstanceMetadata) {
+	t.Helper()
+	if updates := manager.Update(""); len(updates.Errors) != 0 {
+		t.Fatalf("could not load challenges: %v", updates.Errors)
+	}
+	builds, err := manager.Build(fakeRuntimeChallenge, []int{7}, "flag{%s}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(builds) != 1 {
+		t.Fatalf("built %d builds", len(builds))
+	}
+	instanceID, err := manager.Start(builds[0].Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance, err := manager.GetInstanceMetadata(instanceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return builds[0], instance
+}
+
+func TestFakeRuntimeInstanceLifecycle(t *testing.T) {
+	runtime := newFakeRuntime()
+	manager := newFakeRuntimeManager(t, runtime)
+	build, instance := startFakeRuntimeInstance(t, manager)
+
+	if build.Flag == "" {
+		t.Fatal("build flag was not read from the image")
+	}
+	if len(instance.Containers) != 1 || !runtime.isRunning(instance.Containers[0]) {
+		t.Fatalf("instance container is not running: %v", instance.Containers)
+	}
+	if port := instance.Ports["socat"]; port < 40000 {
+		t.Fatalf("instance port was not published: %v", instance.Ports)
+	}
+	if !runtime.hasNetwork(instance.getNetworkName()) {
+		t.Fatalf("network %s was not created", instance.getNetworkName())
+	}
+
+	// The fake solver finds the build's flag.
+	runtime.build = func(options client.ImageBuildOptions) map[string]string {
+		return map[string]string{"solve/flag": build.Flag + "\n"}
+	}
+	if err := manager.CheckInstance(instance.Id); err != nil {
+		t.Fatalf("check failed: %v", err)
+	}
+	checked, err := manager.GetInstanceMetadata(instance.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checked.LastSolved == 0 {
+		t.Fatal("successful check was not recorded")
+	}
+
+	if err := manager.Stop(instance.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.Destroy(build.Id); err != nil {
+		t.Fatal(err)
+	}
+	if count := runtime.containerCount(); count != 0 {
+		t.Fatalf("%d containers remain", count)
+	}
+	if runtime.hasNetwork(instance.getNetworkName()) {
+		t.Fatal("instance network remains")
+	}
+	for _, image := range build.Images {
+		name := buildImageName(build.Challenge, build, image, "")
+		if runtime.hasImage(name) {
+			t.Fatalf("image %s remains", name)
+		}
+	}
+	requireRowCount(t, manager.db, "retiredContainers", 0)
+}
+
+func TestFakeRuntimeRecoversAfterRestart(t *testing.T) {
+	runtime := newFakeRuntime()
+	manager := newFakeRuntimeManager(t, runtime)
+	_, stopped := startFakeRuntimeInstance(t, manager)
+	lost, err := manager.Start(stopped.Build)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lostInstance, err := manager.GetInstanceMetadata(lost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// While cmgr is down, the daemon restarts without one container and a
+	// temporary container that cmgr never got to clean up is left behind.
+	runtime.killContainer(stopped.Containers[0])
+	runtime.loseContainer(lostInstance.Containers[0])
+	inspection, err := runtime.ContainerInspect(
+		t.Context(),
+		stopped.Containers[0],
+		client.ContainerInspectOptions{},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leftover, err := runtime.ContainerCreate(t.Context(), client.ContainerCreateOptions{
+		Config: &container.Config{Image: inspection.Container.Image},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.retireContainer(leftover.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := reopenFakeRuntimeManager(t, runtime)
+	if !runtime.isRunning(stopped.Containers[0]) {
+		t.Fatal("stopped container was not restarted")
+	}
+	if _, err := restarted.GetInstanceMetadata(lost); err == nil {
+		t.Fatal("instance without its container was not removed")
+	}
+	if runtime.hasNetwork(lostInstance.getNetworkName()) {
+		t.Fatal("network of the removed instance remains")
+	}
+	if runtime.isRunning(leftover.ID) || runtime.containerCount() != 1 {
+		t.Fatalf("leftover container was not removed (%d containers)", runtime.containerCount())
+	}
+	requireRowCount(t, restarted.db, "retiredContainers", 0)
+}
+
+func TestFakeRuntimeKeepsPausedInstancesStoppedAcrossRestart(t *testing.T) {
+	runtime := newFakeRuntime()
+	manager := newFakeRuntimeManager(t, runtime)
+	build, paused := startFakeRuntimeInstance(t, manager)
+	running, err := manager.Start(build.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runningInstance, err := manager.GetInstanceMetadata(running)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.Pause(paused.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	runtime.restartDaemon()
+	restarted := reopenFakeRuntimeManager(t, runtime)
+	if runtime.isRunning(paused.Containers[0]) {
+		t.Fatal("paused container was started by the restart")
+	}
+	if !runtime.isRunning(runningInstance.Containers[0]) {
+		t.Fatal("running container was not started by the restart")
+	}
+	if meta, err := restarted.GetInstanceMetadata(paused.Id); err != nil || !meta.Paused {
+		t.Fatalf("instance is no longer paused: %v", err)
+	}
+
+	if err := restarted.Resume(paused.Id); err != nil {
+		t.Fatal(err)
+	}
+	runtime.killContainer(paused.Containers[0])
+	runtime.restartDaemon()
+	if !runtime.isRunning(paused.Containers[0]) {
+		t.Fatal("resumed container was not started by the restart")
+	}
+}
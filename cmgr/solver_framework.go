@@ -20,7 +20,7 @@ import (
 	"github.com/moby/moby/client"
 )
 
-func (m *Manager) runSolver(instance InstanceId) error {
+func (m *Manager) runSolver(instance InstanceId) (err error) {
 	solverTimeout := m.policy.SolverTimeout
 	if solverTimeout == 0 {
 		solverTimeout = 5 * time.Minute
@@ -40,11 +40,19 @@ func (m *Manager) runSolver(instance InstanceId) error {
 	if err != nil {
 		return err
 	}
+	defer func() {
+		if recordErr := m.recordCheck(instance, time.Now().Unix(), err); recordErr != nil {
+			m.log.warn(recordErr)
+		}
+	}()
+	output, _ := m.openCheckLog(instance)
+	defer func() { output.finish(err) }()
 
 	bMeta, err := m.lookupBuildMetadata(iMeta.Build)
 	if err != nil {
 		return err
 	}
+	defer func() { m.metrics.observeSolverCheck(bMeta.Challenge, err) }()
 
 	cMeta, err := m.lookupChallengeMetadata(bMeta.Challenge)
 	if err != nil {
@@ -71,7 +79,7 @@ func (m *Manager) runSolver(instance InstanceId) error {
 		return err
 	}
 
-	if err := consumeDockerProgress(resp.Body, "solver image build"); err != nil {
+	if err := consumeDockerProgress(resp.Body, "solver image build", output); err != nil {
 		m.log.error(err)
 		return err
 	}
@@ -85,6 +93,7 @@ func (m *Manager) runSolver(instance InstanceId) error {
 		Image:    imageName,
 		Hostname: "solve",
 		Tty:      true,
+		Labels:   iMeta.nodeLabels(),
 	}
 
 	hConfig := container.HostConfig{}
@@ -158,6 +167,7 @@ func (m *Manager) runSolver(instance InstanceId) error {
 	case <-operationContext.Done():
 		return fmt.Errorf("solver exceeded %s: %w", solverTimeout, operationContext.Err())
 	}
+	m.captureSolverOutput(operationContext, cid, output)
 
 	// Copy out the flag & compare
 	copyResult, err := m.cli.CopyFromContainer(
@@ -236,6 +246,28 @@ func (m *Manager) runSolver(instance InstanceId) error {
 	return errors.New("failed to process flag results properly")
 }
 
+// Copies the output of the solver container into the check log.  The
+// container runs with a TTY, so its log stream is not multiplexed.
+func (m *Manager) captureSolverOutput(ctx context.Context, cid string, output *capturedLog) {
+	if output == nil {
+		return
+	}
+	clo := client.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+	}
+	logs, err := m.cli.ContainerLogs(ctx, cid, clo)
+	if err != nil {
+		m.log.warnf("could not capture solver output: %s", err)
+		return
+	}
+	defer logs.Close()
+	io.WriteString(output, "solver output:\n")
+	if _, err := io.Copy(output, io.LimitReader(logs, output.remaining+1)); err != nil {
+		m.log.warnf("could not capture solver output: %s", err)
+	}
+}
+
 func (m *Manager) createSolveContext(meta *BuildMetadata) io.Reader {
 	r, w := io.Pipe()
 	maxContextBytes := m.policy.MaxBuildContextBytes + m.policy.MaxArtifactBytes
@@ -0,0 +1,141 @@
+package cmgr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/client"
+)
+
+// Samples the resource usage of each of the instance's containers.  Docker
+// takes two samples a second apart in order to measure CPU usage, so the
+// containers are sampled concurrently.
+func (m *Manager) collectInstanceStats(iMeta *InstanceMetadata) ([]ContainerStats, error) {
+	containers := make([]ContainerStats, len(iMeta.Containers))
+	errs := make([]error, len(iMeta.Containers))
+	var samplers sync.WaitGroup
+	for i, containerID := range iMeta.Containers {
+		samplers.Go(func() {
+			containers[i], errs[i] = m.containerStats(containerID)
+		})
+	}
+	samplers.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return containers, nil
+}
+
+func (m *Manager) containerStats(containerID string) (ContainerStats, error) {
+	result, err := m.cli.ContainerStats(
+		m.ctx,
+		containerID,
+		client.ContainerStatsOptions{IncludePreviousSample: true},
+	)
+	if err != nil {
+		return ContainerStats{}, fmt.Errorf("could not sample container %s: %w", containerID, err)
+	}
+	defer result.Body.Close()
+
+	var sample container.StatsResponse
+	if err := json.NewDecoder(result.Body).Decode(&sample); err != nil {
+		return ContainerStats{}, fmt.Errorf("could not decode stats of container %s: %w", containerID, err)
+	}
+	return ContainerStats{
+		Id:            containerID,
+		Name:          strings.TrimPrefix(sample.Name, "/"),
+		ResourceUsage: resourceUsage(&sample),
+	}, nil
+}
+
+func resourceUsage(sample *container.StatsResponse) ResourceUsage {
+	usage := ResourceUsage{
+		CPUPercent:       cpuPercent(sample),
+		MemoryBytes:      memoryUsage(&sample.MemoryStats),
+		MemoryLimitBytes: sample.MemoryStats.Limit,
+		Pids:             sample.PidsStats.Current,
+		PidsLimit:        sample.PidsStats.Limit,
+	}
+	usage.MemoryPercent = percentOf(usage.MemoryBytes, usage.MemoryLimitBytes)
+	for _, network := range sample.Networks {
+		usage.NetworkRxBytes += network.RxBytes
+		usage.NetworkTxBytes += network.TxBytes
+	}
+	for _, entry := range sample.BlkioStats.IoServiceBytesRecursive {
+		switch strings.ToLower(entry.Op) {
+		case "read":
+			usage.BlockReadBytes += entry.Value
+		case "write":
+			usage.BlockWriteBytes += entry.Value
+		}
+	}
+	return usage
+}
+
+// Computes CPU usage the way `docker stats` does: the share of the host's CPU
+// time used between the two samples, scaled so that one fully used CPU is
+// 100 percent.
+func cpuPercent(sample *container.StatsResponse) float64 {
+	cpuDelta := float64(sample.CPUStats.CPUUsage.TotalUsage) -
+		float64(sample.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(sample.CPUStats.SystemUsage) -
+		float64(sample.PreCPUStats.SystemUsage)
+	if cpuDelta <= 0 || systemDelta <= 0 {
+		return 0
+	}
+	cpus := float64(sample.CPUStats.OnlineCPUs)
+	if cpus == 0 {
+		cpus = float64(len(sample.CPUStats.CPUUsage.PercpuUsage))
+	}
+	return cpuDelta / systemDelta * cpus * 100
+}
+
+// Excludes the page cache, which the kernel reclaims under memory pressure,
+// from the memory in use.  cgroup v1 reports it as "total_inactive_file" and
+// cgroup v2 as "inactive_file".
+func memoryUsage(stats *container.MemoryStats) uint64 {
+	cache, ok := stats.Stats["total_inactive_file"]
+	if !ok {
+		cache = stats.Stats["inactive_file"]
+	}
+	if cache > stats.Usage {
+		return 0
+	}
+	return stats.Usage - cache
+}
+
+func percentOf(value uint64, limit uint64) float64 {
+	if limit == 0 {
+		return 0
+	}
+	return float64(value) / float64(limit) * 100
+}
+
+// Totals the usage of every container.  Limits are summed as well, so the
+// total memory percentage is measured against the combined limit; the total
+// PID limit is omitted if any container has none.
+func totalResourceUsage(containers []ContainerStats) ResourceUsage {
+	var total ResourceUsage
+	pidsLimited := true
+	for _, stats := range containers {
+		pidsLimited = pidsLimited && stats.PidsLimit > 0
+		total.CPUPercent += stats.CPUPercent
+		total.MemoryBytes += stats.MemoryBytes
+		total.MemoryLimitBytes += stats.MemoryLimitBytes
+		total.Pids += stats.Pids
+		total.PidsLimit += stats.PidsLimit
+		total.NetworkRxBytes += stats.NetworkRxBytes
+		total.NetworkTxBytes += stats.NetworkTxBytes
+		total.BlockReadBytes += stats.BlockReadBytes
+		total.BlockWriteBytes += stats.BlockWriteBytes
+	}
+	if !pidsLimited {
+		total.PidsLimit = 0
+	}
+	total.MemoryPercent = percentOf(total.MemoryBytes, total.MemoryLimitBytes)
+	return total
+}
@@ -0,0 +1,137 @@
+package cmgr
+
+import (
+	"math"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+const webStatsResponse = `{
+	"name": "/web",
+	"cpu_stats": {
+		"cpu_usage": {"total_usage": 3000},
+		"system_cpu_usage": 20000,
+		"online_cpus": 2
+	},
+	"precpu_stats": {
+		"cpu_usage": {"total_usage": 1000},
+		"system_cpu_usage": 10000
+	},
+	"memory_stats": {
+		"usage": 300,
+		"limit": 1000,
+		"stats": {"inactive_file": 100}
+	},
+	"pids_stats": {"current": 3, "limit": 10},
+	"networks": {
+		"eth0": {"rx_bytes": 10, "tx_bytes": 20},
+		"eth1": {"rx_bytes": 1, "tx_bytes": 2}
+	},
+	"blkio_stats": {
+		"io_service_bytes_recursive": [
+			{"op": "read", "value": 4096},
+			{"op": "Write", "value": 512},
+			{"op": "total", "value": 4608}
+		]
+	}
+}`
+
+const dbStatsResponse = `{
+	"name": "/db",
+	"memory_stats": {
+		"usage": 700,
+		"limit": 1000,
+		"stats": {"total_inactive_file": 100}
+	},
+	"pids_stats": {"current": 2}
+}`
+
+func TestInstanceStatsAggregatesContainers(t *testing.T) {
+	manager := newDynamicInstanceTestManager(t)
+	requireExec(t, manager.db, "INSERT INTO containers(instance, id) VALUES (1, 'db');")
+
+	manager.ctx = t.Context()
+	manager.cli = newDockerTestClient(t, func(
+		request *http.Request,
+	) (*http.Response, error) {
+		switch {
+		case strings.HasSuffix(request.URL.Path, "/containers/container/stats"):
+			if request.URL.Query().Get("one-shot") == "true" {
+				t.Error("stats were requested without a previous CPU sample")
+			}
+			return dockerTestResponse(request, http.StatusOK, webStatsResponse)
+		case strings.HasSuffix(request.URL.Path, "/containers/db/stats"):
+			return dockerTestResponse(request, http.StatusOK, dbStatsResponse)
+		default:
+			return dockerTestResponse(
+				request,
+				http.StatusNotFound,
+				`{"message":"no such container"}`,
+			)
+		}
+	})
+
+	stats, err := manager.InstanceStats(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Instance != 1 || stats.Build != 1 || stats.Challenge != "challenge" {
+		t.Fatalf("unexpected instance identification: %#v", stats)
+	}
+	if len(stats.Containers) != 2 {
+		t.Fatalf("expected two containers: %#v", stats.Containers)
+	}
+
+	web := stats.Containers[0]
+	if web.Id != "container" || web.Name != "web" {
+		t.Fatalf("unexpected container identification: %#v", web)
+	}
+	expected := ResourceUsage{
+		CPUPercent:       40,
+		MemoryBytes:      200,
+		MemoryLimitBytes: 1000,
+		MemoryPercent:    20,
+		Pids:             3,
+		PidsLimit:        10,
+		NetworkRxBytes:   11,
+		NetworkTxBytes:   22,
+		BlockReadBytes:   4096,
+		BlockWriteBytes:  512,
+	}
+	if web.ResourceUsage != expected {
+		t.Fatalf("unexpected container usage:\n%#v\nexpected\n%#v", web.ResourceUsage, expected)
+	}
+
+	total := stats.Total
+	if math.Abs(total.CPUPercent-40) > 1e-9 ||
+		total.MemoryBytes != 800 ||
+		total.MemoryLimitBytes != 2000 ||
+		math.Abs(total.MemoryPercent-40) > 1e-9 ||
+		total.Pids != 5 ||
+		total.PidsLimit != 0 {
+		t.Fatalf("unexpected total usage: %#v", total)
+	}
+}
+
+func TestInstanceStatsReportsUnavailableContainers(t *testing.T) {
+	manager := newDynamicInstanceTestManager(t)
+	manager.ctx = t.Context()
+	manager.cli = newDockerTestClient(t, func(
+		request *http.Request,
+	) (*http.Response, error) {
+		return dockerTestResponse(
+			request,
+			http.StatusNotFound,
+			`{"message":"no such container"}`,
+		)
+	})
+
+	if _, err := manager.InstanceStats(1); err == nil ||
+		!strings.Contains(err.Error(), "could not sample container container") {
+		t.Fatalf("missing container was not reported: %v", err)
+	}
+	if _, err := manager.InstanceStats(2); err == nil {
+		t.Fatal("unknown instance was sampled")
+	}
+}
@@ -2,17 +2,19 @@ package cmgr
 
 import (
 	"context"
+	"net/netip"
+	"os"
 	"sync"
 	"sync/atomic"
 
 	"github.com/jmoiron/sqlx"
-	"github.com/moby/moby/client"
 )
 
 const (
 	DB_ENV             string = "CMGR_DB"
 	DIR_ENV            string = "CMGR_DIR"
 	ARTIFACT_DIR_ENV   string = "CMGR_ARTIFACT_DIR"
+	LOG_DIR_ENV        string = "CMGR_LOG_DIR"
 	REGISTRY_ENV       string = "CMGR_REGISTRY"
 	REGISTRY_USER_ENV  string = "CMGR_REGISTRY_USER"
 	REGISTRY_TOKEN_ENV string = "CMGR_REGISTRY_TOKEN"
@@ -20,6 +22,11 @@ const (
 	IFACE_ENV          string = "CMGR_INTERFACE"
 	PORTS_ENV          string = "CMGR_PORTS"
 	DISK_QUOTA_ENV     string = "CMGR_ENABLE_DISK_QUOTAS"
+	IPV6_ENV           string = "CMGR_ENABLE_IPV6"
+	CAPTURE_DIR_ENV    string = "CMGR_CAPTURE_DIR"
+	CAPTURE_IMAGE_ENV  string = "CMGR_CAPTURE_IMAGE"
+	KUBERNETES_ENV     string = "CMGR_KUBERNETES_CONFIG"
+	DOCKER_NODES_ENV   string = "CMGR_DOCKER_NODES"
 
 	DYNAMIC_INSTANCES int = -1
 	LOCKED            int = -2
@@ -31,16 +38,20 @@ type UnknownIdentifierError struct {
 }
 
 type Manager struct {
-	cli                  *client.Client
+	cli                  ContainerRuntime
 	ctx                  context.Context
 	log                  *logger
 	chalDir              string
 	artifactsDir         string
+	logDir               string
+	captureDir           string
+	captureImage         string
 	db                   *sqlx.DB
 	dbPath               string
 	operationLockPath    string
 	operationGatePath    string
 	portLockPath         string
+	capacityLockPath     string
 	operationMu          sync.RWMutex
 	challengeDockerfiles map[string][]byte
 	schemaMu             sync.Mutex
@@ -49,12 +60,25 @@ type Manager struct {
 	buildSlots           chan struct{}
 	runtimeDefaults      ContainerOptions
 	policy               managerPolicy
-	challengeInterface   string
+	challengeAddresses   []netip.Addr
+	firewall             egressFirewall
+	pool                 *dockerPool
+	kubernetes           bool
+	ipv6Enabled          bool
 	challengeRegistry    string
 	authString           string
 	diskQuotasEnabled    atomic.Bool
 	portLow              int
 	portHigh             int
+	jobOwner             string
+	jobMu                sync.Mutex
+	jobLease             *os.File
+	jobLeaseRefs         int
+	jobs                 sync.WaitGroup
+	poolMu               sync.Mutex
+	poolRefills          map[BuildId]bool
+	poolWorkers          sync.WaitGroup
+	metrics              *managerMetrics
 }
 
 type buildLock struct {
@@ -65,6 +89,8 @@ type buildLock struct {
 type PortInfo struct {
 	Host string `json:"host"`
 	Port int    `json:"port"`
+	// Either "udp" or empty for TCP.
+	Protocol string `json:"protocol,omitempty"`
 }
 
 type HostInfo struct {
@@ -72,10 +98,29 @@ type HostInfo struct {
 	Target string `json:"target,omitempty"`
 }
 
+// Outbound connectivity allowed from a challenge network: all of it, or only
+// the listed destinations.  In challenge options it is written as a boolean
+// or as a list of destinations such as "10.0.5.10:8080/tcp".
+type EgressPolicy struct {
+	All          bool
+	Destinations []string
+}
+
 type NetworkOptions struct {
 	// Challenge networks are isolated from external networks by default.
 	// Challenges that intentionally need outbound connectivity must opt in.
-	AllowEgress bool `json:"allow_egress,omitempty" yaml:"allow_egress"`
+	AllowEgress EgressPolicy `json:"allow_egress,omitzero" yaml:"allow_egress"`
+	// Published ports that serve HTTP.  When cmgrd's reverse proxy is
+	// enabled, they are reached through it by hostname instead of being
+	// published on the host.
+	HTTPPorts []string `json:"http_ports,omitempty" yaml:"http_ports"`
+	// Published ports that serve raw TCP, such as netcat services.  When
+	// cmgrd's TLS gateway is enabled, they are reached through it by SNI
+	// hostname instead of being published on the host.
+	TLSPorts []string `json:"tls_ports,omitempty" yaml:"tls_ports"`
+	// Records the traffic on each instance's network in rotating pcap files
+	// under CMGR_CAPTURE_DIR.
+	PacketCapture bool `json:"packet_capture,omitempty" yaml:"packet_capture"`
 }
 
 type SeccompOptions struct {
@@ -87,18 +132,30 @@ type SeccompOptions struct {
 	effectiveProfile string
 }
 
+// A command that Docker runs inside a container to decide whether it is
+// healthy.  Durations use Go syntax (e.g., "30s"); unset values use Docker's
+// defaults.
+type HealthCheckOptions struct {
+	Command     string `json:"command"                yaml:"command"`
+	Interval    string `json:"interval,omitempty"     yaml:"interval"`
+	Timeout     string `json:"timeout,omitempty"      yaml:"timeout"`
+	StartPeriod string `json:"start_period,omitempty" yaml:"start_period"`
+	Retries     int    `json:"retries,omitempty"      yaml:"retries"`
+}
+
 type ContainerOptions struct {
-	Init            bool            `json:"init,omitempty"            yaml:"init"`
-	Cpus            string          `json:"cpus,omitempty"            yaml:"cpus"`
-	Memory          string          `json:"memory,omitempty"          yaml:"memory"`
-	Ulimits         []string        `json:"ulimits,omitempty"         yaml:"ulimits"`
-	PidsLimit       int64           `json:"pidslimit,omitempty"       yaml:"pidslimit"`
-	ReadonlyRootfs  bool            `json:"readonlyrootfs,omitempty"  yaml:"readonlyrootfs"`
-	DroppedCaps     []string        `json:"droppedcaps,omitempty"     yaml:"droppedcaps"`
-	NoNewPrivileges bool            `json:"nonewprivileges,omitempty" yaml:"nonewprivileges"`
-	DiskQuota       string          `json:"diskquota,omitempty"       yaml:"diskquota"`
-	CgroupParent    string          `json:"cgroupparent,omitempty"    yaml:"cgroupparent"`
-	Seccomp         *SeccompOptions `json:"seccomp,omitempty"   yaml:"seccomp,omitempty"`
+	Init            bool                `json:"init,omitempty"            yaml:"init"`
+	Cpus            string              `json:"cpus,omitempty"            yaml:"cpus"`
+	Memory          string              `json:"memory,omitempty"          yaml:"memory"`
+	Ulimits         []string            `json:"ulimits,omitempty"         yaml:"ulimits"`
+	PidsLimit       int64               `json:"pidslimit,omitempty"       yaml:"pidslimit"`
+	ReadonlyRootfs  bool                `json:"readonlyrootfs,omitempty"  yaml:"readonlyrootfs"`
+	DroppedCaps     []string            `json:"droppedcaps,omitempty"     yaml:"droppedcaps"`
+	NoNewPrivileges bool                `json:"nonewprivileges,omitempty" yaml:"nonewprivileges"`
+	DiskQuota       string              `json:"diskquota,omitempty"       yaml:"diskquota"`
+	CgroupParent    string              `json:"cgroupparent,omitempty"    yaml:"cgroupparent"`
+	Seccomp         *SeccompOptions     `json:"seccomp,omitempty"   yaml:"seccomp,omitempty"`
+	HealthCheck     *HealthCheckOptions `json:"healthcheck,omitempty" yaml:"healthcheck,omitempty"`
 }
 
 type ChallengeOptions struct {
@@ -161,6 +218,7 @@ type BuildMetadata struct {
 
 	Schema        string `json:"schema"`
 	InstanceCount int    `json:"instance_count"`
+	WarmPool      int    `json:"warm_pool,omitempty"`
 }
 
 type ImageId int64
@@ -173,11 +231,50 @@ type Image struct {
 
 type InstanceId int64
 type InstanceMetadata struct {
-	Id         InstanceId     `json:"id"`
-	Ports      map[string]int `json:"ports,omitempty"`
-	Containers []string       `json:"containers"`
-	LastSolved int64          `json:"last_solved"`
-	Build      BuildId        `json:"build_id"`
+	Id    InstanceId     `json:"id"`
+	Ports map[string]int `json:"ports,omitempty"`
+	// Every "address:port" on which each port in Ports is published, one per
+	// address in CMGR_INTERFACE, or on the public address of the instance's
+	// node when it has one.  An unspecified address, such as "0.0.0.0" or
+	// "::", stands for all of the host's addresses of that family.
+	Addresses  map[string][]string `json:"addresses,omitempty"`
+	Containers []string            `json:"containers"`
+	LastSolved int64               `json:"last_solved"`
+	// Unix time of the most recent solver check, or zero if the instance has
+	// never been checked, and the error that check failed with.
+	LastChecked    int64   `json:"last_checked,omitempty"`
+	LastCheckError string  `json:"last_check_error,omitempty"`
+	Build          BuildId `json:"build_id"`
+	// Unix time at which the instance is stopped, or zero if it never
+	// expires.
+	Expires int64 `json:"expires,omitempty"`
+	// Whether the instance's containers have been stopped by `Pause`.
+	Paused bool `json:"paused,omitempty"`
+	// Whether the instance is idle in its build's warm pool waiting to be
+	// handed out by `Claim`.
+	Pooled bool `json:"pooled,omitempty"`
+	// The Docker node in CMGR_DOCKER_NODES that runs the instance and the
+	// public address players reach it at.  Both are empty without a pool of
+	// nodes.
+	Node        string `json:"node,omitempty"`
+	NodeAddress string `json:"node_address,omitempty"`
+	// Runtime state reported by Docker, which is only filled in by
+	// `InspectInstanceRuntime`.  Health
+	// is the least healthy status among the containers that have a health
+	// check ("starting", "healthy", or "unhealthy"), RestartCount totals the
+	// times Docker has restarted the containers, and CrashLooping is set when
+	// a container keeps exiting soon after it is restarted.
+	Health       string `json:"health,omitempty"`
+	RestartCount int    `json:"restart_count,omitempty"`
+	CrashLooping bool   `json:"crash_looping,omitempty"`
+	// The base URL, such as "https://17-http.ctf.example.com", of each HTTP
+	// port reached through the reverse proxy.  Such ports have no entry in
+	// Ports; templates render `http_base` as the URL and `port` as its port.
+	URLs map[string]string `json:"urls,omitempty"`
+	// The address, such as "17-pwn.ctf.example.com:443", of each TCP port
+	// reached through the TLS gateway, whose hostname is also the SNI name
+	// that selects the port.  Such ports have no entry in Ports either.
+	TLSEndpoints map[string]string `json:"tls_endpoints,omitempty"`
 }
 
 type Schema struct {
@@ -188,4 +285,151 @@ type Schema struct {
 type BuildSpecification struct {
 	Seeds         []int `json:"seeds"          yaml:"seeds"`
 	InstanceCount int   `json:"instance_count" yaml:"instance_count"`
+	// The number of idle instances of each dynamic build kept running so
+	// that `Claim` can hand one out without waiting for it to start.
+	WarmPool int `json:"warm_pool,omitempty" yaml:"warm_pool"`
+}
+
+type JobId int64
+type JobKind string
+type JobStatus string
+type JobSeedStatus string
+
+const (
+	BUILD_JOB         JobKind = "build"
+	CREATE_SCHEMA_JOB JobKind = "create-schema"
+	UPDATE_SCHEMA_JOB JobKind = "update-schema"
+
+	JOB_QUEUED    JobStatus = "queued"
+	JOB_RUNNING   JobStatus = "running"
+	JOB_SUCCEEDED JobStatus = "succeeded"
+	JOB_FAILED    JobStatus = "failed"
+
+	SEED_PENDING  JobSeedStatus = "pending"
+	SEED_BUILDING JobSeedStatus = "building"
+	SEED_BUILT    JobSeedStatus = "built"
+	SEED_FAILED   JobSeedStatus = "failed"
+)
+
+type JobMetadata struct {
+	Id      JobId     `json:"id"`
+	Kind    JobKind   `json:"kind"`
+	Status  JobStatus `json:"status"`
+	Schema  string    `json:"schema"`
+	Error   string    `json:"error,omitempty"`
+	Created int64     `json:"created"`
+	Updated int64     `json:"updated"`
+	Seeds   []JobSeed `json:"seeds"`
+}
+
+type JobSeed struct {
+	Challenge ChallengeId   `json:"challenge_id"`
+	Seed      int           `json:"seed"`
+	Status    JobSeedStatus `json:"status"`
+	Build     BuildId       `json:"build_id,omitempty"`
+	Error     string        `json:"error,omitempty"`
+}
+
+type SolvabilityStatus string
+
+const (
+	CHALLENGE_SOLVABLE   SolvabilityStatus = "solvable"
+	CHALLENGE_UNSOLVABLE SolvabilityStatus = "unsolvable"
+	CHALLENGE_UNCHECKED  SolvabilityStatus = "unchecked"
+	CHALLENGE_NO_SOLVER  SolvabilityStatus = "no-solver"
+)
+
+// Summarizes the most recent solver check of each instance of a challenge.
+// The challenge is unsolvable if the latest check of any instance failed and
+// solvable if at least one instance has been checked and none failed.
+type ChallengeSolvability struct {
+	Challenge   ChallengeId           `json:"challenge_id"`
+	Status      SolvabilityStatus     `json:"status"`
+	LastChecked int64                 `json:"last_checked,omitempty"`
+	LastSolved  int64                 `json:"last_solved,omitempty"`
+	Instances   []InstanceSolvability `json:"instances"`
+}
+
+type InstanceSolvability struct {
+	Instance       InstanceId `json:"instance_id"`
+	Build          BuildId    `json:"build_id"`
+	LastChecked    int64      `json:"last_checked"`
+	LastSolved     int64      `json:"last_solved"`
+	LastCheckError string     `json:"last_check_error,omitempty"`
+}
+
+// A solver check of an instance whose output was captured.
+type InstanceCheck struct {
+	Number  int  `json:"number"`
+	Running bool `json:"running"`
+}
+
+// A pcap file recorded from an instance's network.  Files are named after
+// the Unix time at which their capture started, followed by their position
+// in its rotation.
+type InstanceCapture struct {
+	Name     string `json:"name"`
+	Size     int64  `json:"size"`
+	Modified int64  `json:"modified"`
+}
+
+// Resource usage sampled from Docker.  Memory excludes the page cache, as in
+// `docker stats`, and the limits are those Docker enforces, which default to
+// the host's memory when no limit is set.  Network and block I/O are
+// cumulative byte counts since the container started.
+type ResourceUsage struct {
+	CPUPercent       float64 `json:"cpu_percent"`
+	MemoryBytes      uint64  `json:"memory_bytes"`
+	MemoryLimitBytes uint64  `json:"memory_limit_bytes"`
+	MemoryPercent    float64 `json:"memory_percent"`
+	Pids             uint64  `json:"pids"`
+	PidsLimit        uint64  `json:"pids_limit,omitempty"`
+	NetworkRxBytes   uint64  `json:"network_rx_bytes"`
+	NetworkTxBytes   uint64  `json:"network_tx_bytes"`
+	BlockReadBytes   uint64  `json:"block_read_bytes"`
+	BlockWriteBytes  uint64  `json:"block_write_bytes"`
+}
+
+type ContainerStats struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+	ResourceUsage
+}
+
+// The resource usage of each of an instance's containers along with their
+// total.
+type InstanceStats struct {
+	Instance   InstanceId       `json:"instance_id"`
+	Build      BuildId          `json:"build_id"`
+	Challenge  ChallengeId      `json:"challenge_id"`
+	Total      ResourceUsage    `json:"total"`
+	Containers []ContainerStats `json:"containers"`
+}
+
+// Records which instance of a schema's challenge a user, or a team, was given
+// and when.
+type Assignment struct {
+	Schema    string      `json:"schema"       db:"schema"`
+	Challenge ChallengeId `json:"challenge_id" db:"challenge"`
+	User      string      `json:"user"         db:"assignee"`
+	Instance  InstanceId  `json:"instance_id"  db:"instance"`
+	Assigned  int64       `json:"assigned"     db:"assigned"`
+}
+
+// How much of the host budget the instances have reserved.  CPUs are counted
+// in billionths of a CPU, as Docker's NanoCPUs are.
+type CapacityReport struct {
+	Instances int              `json:"instances"`
+	NanoCPUs  ResourceCapacity `json:"nano_cpus"`
+	Memory    ResourceCapacity `json:"memory_bytes"`
+	Pids      ResourceCapacity `json:"pids"`
+}
+
+// The budget and headroom are omitted when the resource has no budget.  The
+// headroom is negative when the budget was lowered below what is already
+// reserved.
+type ResourceCapacity struct {
+	Budget   *int64 `json:"budget,omitempty"`
+	Used     int64  `json:"used"`
+	Headroom *int64 `json:"headroom,omitempty"`
 }
@@ -0,0 +1,289 @@
+// Package metrics records counters, gauges, and histograms and renders them in
+// the Prometheus text exposition format.
+//
+// Only the small subset of the format needed by cmgr is implemented so that
+// exposing metrics does not require a Prometheus client library.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are histogram upper bounds, in seconds, suited to operations
+// that range from a fast HTTP request to a slow image build.
+var DefaultBuckets = []float64{
+	0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5,
+	1, 2.5, 5, 10, 30, 60, 120, 300, 600, 1800,
+}
+
+// A Registry holds metric families in the order they were registered.
+type Registry struct {
+	mu       sync.Mutex
+	families []family
+}
+
+type family interface {
+	write(w *bufio.Writer)
+}
+
+func NewRegistry() *Registry {
+	return new(Registry)
+}
+
+func (r *Registry) register(f family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.families = append(r.families, f)
+}
+
+// WriteText renders every registered family in the text exposition format.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	families := append([]family(nil), r.families...)
+	r.mu.Unlock()
+
+	out := bufio.NewWriter(w)
+	for _, f := range families {
+		f.write(out)
+	}
+	return out.Flush()
+}
+
+type descriptor struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+func (d descriptor) writeHeader(w *bufio.Writer) {
+	help := strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(d.help)
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, help, d.name, d.kind)
+}
+
+// Label values are joined into a single key so that series can be stored in
+// a map; the separator cannot appear in valid UTF-8 text.
+const labelSeparator = "\xff"
+
+func (d descriptor) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf(
+			"metric %s expects %d label values, got %d",
+			d.name,
+			len(d.labels),
+			len(values),
+		))
+	}
+	return strings.Join(values, labelSeparator)
+}
+
+// Formats the label set of a series, with an optional extra label such as a
+// histogram bucket's upper bound.
+func (d descriptor) labelSet(key string, extra ...string) string {
+	pairs := []string{}
+	if len(d.labels) > 0 {
+		for i, value := range strings.Split(key, labelSeparator) {
+			pairs = append(pairs, d.labels[i]+"="+quoteLabel(value))
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+"="+quoteLabel(extra[i+1]))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func quoteLabel(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value) + `"`
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func sortedKeys[V any](series map[string]V) []string {
+	keys := make([]string, 0, len(series))
+	for key := range series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// A CounterVec is a family of monotonically increasing values.
+type CounterVec struct {
+	descriptor
+	mu     sync.Mutex
+	series map[string]float64
+}
+
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		descriptor: descriptor{name: name, help: help, kind: "counter", labels: labels},
+		series:     make(map[string]float64),
+	}
+	r.register(c)
+	return c
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) Add(value float64, labelValues ...string) {
+	if value < 0 {
+		panic(fmt.Sprintf("counter %s cannot decrease", c.name))
+	}
+	key := c.key(labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.series[key] += value
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeHeader(w)
+	for _, key := range sortedKeys(c.series) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelSet(key), formatValue(c.series[key]))
+	}
+}
+
+// A GaugeVec is a family of values that may go up and down.
+type GaugeVec struct {
+	descriptor
+	mu     sync.Mutex
+	series map[string]float64
+}
+
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{
+		descriptor: descriptor{name: name, help: help, kind: "gauge", labels: labels},
+		series:     make(map[string]float64),
+	}
+	r.register(g)
+	return g
+}
+
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	key := g.key(labelValues)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.series[key] = value
+}
+
+// A Sample is the value of a single gauge series.
+type Sample struct {
+	LabelValues []string
+	Value       float64
+}
+
+// Replace discards every series and sets the given ones.  It suits gauges
+// that are recomputed from scratch, where a series that disappears must stop
+// being reported.
+func (g *GaugeVec) Replace(samples []Sample) {
+	series := make(map[string]float64, len(samples))
+	for _, sample := range samples {
+		series[g.key(sample.LabelValues)] = sample.Value
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.series = series
+}
+
+func (g *GaugeVec) write(w *bufio.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.writeHeader(w)
+	for _, key := range sortedKeys(g.series) {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, g.labelSet(key), formatValue(g.series[key]))
+	}
+}
+
+// A HistogramVec is a family of observation distributions with cumulative
+// buckets.
+type HistogramVec struct {
+	descriptor
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (r *Registry) NewHistogramVec(
+	name string,
+	help string,
+	buckets []float64,
+	labels ...string,
+) *HistogramVec {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	h := &HistogramVec{
+		descriptor: descriptor{name: name, help: help, kind: "histogram", labels: labels},
+		buckets:    buckets,
+		series:     make(map[string]*histogram),
+	}
+	r.register(h)
+	return h
+}
+
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	key := h.key(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	series := h.series[key]
+	if series == nil {
+		series = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = series
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			series.counts[i]++
+		}
+	}
+	series.count++
+	series.sum += value
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.writeHeader(w)
+	for _, key := range sortedKeys(h.series) {
+		series := h.series[key]
+		for i, bound := range h.buckets {
+			fmt.Fprintf(
+				w,
+				"%s_bucket%s %d\n",
+				h.name,
+				h.labelSet(key, "le", formatValue(bound)),
+				series.counts[i],
+			)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelSet(key, "le", "+Inf"), series.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelSet(key), formatValue(series.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelSet(key), series.count)
+	}
+}
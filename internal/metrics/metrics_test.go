@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteTextRendersExpositionFormat(t *testing.T) {
+	registry := NewRegistry()
+	counter := registry.NewCounterVec("requests_total", "Requests\nserved.", "path")
+	gauge := registry.NewGaugeVec("temperature", "Current temperature.")
+	histogram := registry.NewHistogramVec("latency_seconds", "Latency.", []float64{1, 0.5}, "kind")
+
+	counter.Inc(`/a"b\`)
+	counter.Add(2, "/c")
+	gauge.Set(21.5)
+	histogram.Observe(0.25, "fast")
+	histogram.Observe(0.75, "fast")
+	histogram.Observe(3, "fast")
+
+	var output strings.Builder
+	if err := registry.WriteText(&output); err != nil {
+		t.Fatal(err)
+	}
+	expected := `# HELP requests_total Requests\nserved.
+# TYPE requests_total counter
+requests_total{path="/a\"b\\"} 1
+requests_total{path="/c"} 2
+# HELP temperature Current temperature.
+# TYPE temperature gauge
+temperature 21.5
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{kind="fast",le="0.5"} 1
+latency_seconds_bucket{kind="fast",le="1"} 2
+latency_seconds_bucket{kind="fast",le="+Inf"} 3
+latency_seconds_sum{kind="fast"} 4
+latency_seconds_count{kind="fast"} 3
+`
+	if output.String() != expected {
+		t.Fatalf("unexpected exposition:\n%s", output.String())
+	}
+}
+
+func TestGaugeReplaceDropsMissingSeries(t *testing.T) {
+	registry := NewRegistry()
+	gauge := registry.NewGaugeVec("active", "Active things.", "kind")
+	gauge.Set(1, "old")
+	gauge.Replace([]Sample{{LabelValues: []string{"new"}, Value: 2}})
+
+	var output strings.Builder
+	if err := registry.WriteText(&output); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(output.String(), "old") || !strings.Contains(output.String(), `active{kind="new"} 2`) {
+		t.Fatalf("unexpected gauge series:\n%s", output.String())
+	}
+}